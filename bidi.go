@@ -0,0 +1,242 @@
+package main
+
+import "unicode"
+
+// Lines holding right-to-left text (Hebrew, Arabic and their neighbours) are
+// reordered for display with a simplified form of the Unicode bidirectional
+// algorithm: the first strong letter sets the line's direction, runs of the other
+// direction are reversed in place, numbers keep reading left to right, and
+// brackets inside right-to-left runs are mirrored. The buffer itself stays in
+// logical order; only columns on screen change.
+
+// Bidi classes of a character
+const (
+	bidiNeutral = iota
+	bidiLeft
+	bidiRight
+	bidiNumber
+)
+
+// bidiMirrors maps a bracket to its mirror image, drawn in right-to-left runs
+var bidiMirrors = map[rune]rune{
+	'(': ')', ')': '(',
+	'[': ']', ']': '[',
+	'{': '}', '}': '{',
+	'<': '>', '>': '<',
+	'«': '»', '»': '«',
+}
+
+// isRTL reports whether r is a letter written right to left
+func isRTL(r rune) bool {
+	return !unicode.IsDigit(r) && unicode.In(r, unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko)
+}
+
+// hasRTL reports whether runes holds any right-to-left letter, so plain lines
+// can skip reordering
+func hasRTL(runes []rune) bool {
+	for _, r := range runes {
+		if r >= 0x0590 && isRTL(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// bidiClass returns the direction class of r
+func bidiClass(r rune) int {
+	switch {
+	case isRTL(r):
+		return bidiRight
+	case unicode.IsDigit(r):
+		return bidiNumber
+	case unicode.IsLetter(r):
+		return bidiLeft
+	}
+	return bidiNeutral
+}
+
+// lineIsRTL reports whether the first strong letter of the line is right to left
+func lineIsRTL(runes []rune) bool {
+	for _, r := range runes {
+		switch bidiClass(r) {
+		case bidiLeft:
+			return false
+		case bidiRight:
+			return true
+		}
+	}
+	return false
+}
+
+// bidiLevels returns the embedding level of each rune in runes[start:end]: even
+// levels read left to right and odd levels right to left. Neutrals between two
+// runs of the same direction take it, and otherwise the line's. Numbers in
+// right-to-left text sit one level above it so their digits stay in order.
+func bidiLevels(runes []rune, start, end int) []int {
+	base := 0
+	if lineIsRTL(runes) {
+		base = 1
+	}
+	classes := make([]int, end-start)
+	for i := start; i < end; i++ {
+		if continuesGrapheme(runes, i) && i > start {
+			classes[i-start] = classes[i-start-1]
+			continue
+		}
+		classes[i-start] = bidiClass(runes[i])
+	}
+
+	levels := make([]int, len(classes))
+	lastStrong := bidiLeft
+	if base == 1 {
+		lastStrong = bidiRight
+	}
+	for i, c := range classes {
+		switch c {
+		case bidiLeft:
+			lastStrong = bidiLeft
+			levels[i] = base + base%2
+		case bidiRight:
+			lastStrong = bidiRight
+			levels[i] = 1
+		case bidiNumber:
+			levels[i] = base + base%2
+			if lastStrong == bidiRight {
+				levels[i] = 2
+			}
+		}
+	}
+
+	// A number counts as right to left when deciding a neutral's direction
+	direction := func(c int) int {
+		if c == bidiNumber {
+			return bidiRight
+		}
+		return c
+	}
+	for i := 0; i < len(classes); {
+		if classes[i] != bidiNeutral {
+			i++
+			continue
+		}
+		j := i
+		for j < len(classes) && classes[j] == bidiNeutral {
+			j++
+		}
+		before, after := bidiLeft, bidiLeft
+		if base == 1 {
+			before, after = bidiRight, bidiRight
+		}
+		if i > 0 {
+			before = direction(classes[i-1])
+		}
+		if j < len(classes) {
+			after = direction(classes[j])
+		}
+		level := base
+		if before == after {
+			level = base + base%2
+			if before == bidiRight {
+				level = 1
+			}
+		}
+		for k := i; k < j; k++ {
+			levels[k] = level
+		}
+		i = j
+	}
+	return levels
+}
+
+// bidiLayout returns the display column of each rune in runes[start:end] when the
+// span is drawn from column base, with continuation runes sharing their
+// character's column, and the runes to draw, whose brackets are mirrored in
+// right-to-left runs. Without right-to-left text the columns simply follow the
+// widths in order and the runes are returned as they are.
+func (e *Editor) bidiLayout(runes []rune, start, end, base int) (cols []int, glyphs []rune) {
+	cols = make([]int, end-start)
+	widths := make([]int, end-start)
+	col := base
+	for i := start; i < end; i++ {
+		widths[i-start] = e.runeCells(runes, i, col)
+		cols[i-start] = col
+		col += widths[i-start]
+	}
+	if !hasRTL(runes[start:end]) {
+		return cols, runes
+	}
+
+	// Reorder the characters: from the highest level down to the lowest odd
+	// one, reverse every run at or above it
+	levels := bidiLevels(runes, start, end)
+	var order []int
+	for i := start; i < end; i++ {
+		if i == start || !continuesGrapheme(runes, i) {
+			order = append(order, i)
+		}
+	}
+	maxLevel := 0
+	for _, l := range levels {
+		maxLevel = max(maxLevel, l)
+	}
+	for level := maxLevel; level >= 1; level-- {
+		for i := 0; i < len(order); {
+			if levels[order[i]-start] < level {
+				i++
+				continue
+			}
+			j := i
+			for j < len(order) && levels[order[j]-start] >= level {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				order[a], order[b] = order[b], order[a]
+			}
+			i = j
+		}
+	}
+
+	glyphs = make([]rune, len(runes))
+	copy(glyphs, runes)
+	col = base
+	for _, i := range order {
+		stop := min(graphemeEnd(runes, i), end)
+		width := 0
+		for j := i; j < stop; j++ {
+			cols[j-start] = col
+			width += widths[j-start]
+		}
+		if mirror, ok := bidiMirrors[runes[i]]; ok && levels[i-start]%2 == 1 {
+			glyphs[i] = mirror
+		}
+		col += width
+	}
+	return cols, glyphs
+}
+
+// bidiRuneAt returns the character of runes[start:end] drawn from column base
+// whose cells hold column target, or end when no character does
+func (e *Editor) bidiRuneAt(runes []rune, start, end, base, target int) int {
+	cols, _ := e.bidiLayout(runes, start, end, base)
+	col := base
+	for i := start; i < end; i++ {
+		w := e.runeCells(runes, i, col)
+		col += w
+		if w > 0 && target >= cols[i-start] && target < cols[i-start]+w {
+			return i
+		}
+	}
+	return end
+}
+
+// visualColumn returns the screen column, before horizontal scrolling, of the
+// rune at runeIdx. In a line with right-to-left text this is where the reordered
+// character is drawn; the end of the line is just past its last column.
+func (e *Editor) visualColumn(line string, runeIdx int) int {
+	runes := []rune(line)
+	if runeIdx >= len(runes) || !hasRTL(runes) {
+		return e.displayColumn(line, runeIdx)
+	}
+	cols, _ := e.bidiLayout(runes, 0, len(runes), 0)
+	return cols[runeIdx]
+}
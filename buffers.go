@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// bufferState is everything that belongs to one open file. The active buffer
+// lives in the Editor's own fields; the others wait here until switched to.
+type bufferState struct {
+	lines           []string
+	filename        string
+	cursorX         int
+	cursorY         int
+	offsetX         int
+	offsetY         int
+	undoStack       []undoState
+	redoStack       []undoState
+	undoBytes       int
+	redoBytes       int
+	snapshots       []snapshot
+	savedLines      []string
+	modified        bool
+	truncated       bool
+	currentChunk    int
+	chunkHistories  map[int]chunkHistory
+	selectionStart  bool
+	blockSelection  bool
+	selectionStartX int
+	selectionStartY int
+	tabWidth        int
+	useTabs         bool
+	ignoredWords    *dictionary
+	readOnly        bool
+	foldedBlocks    map[int]bool
+	goalMet         bool
+	lastWords       int
+	savedWords      int
+	wordsAdded      int
+	wordsRemoved    int
+	writingTime     time.Duration
+	lastWritten     time.Time
+	logged          logEntry
+}
+
+// captureBuffer copies the active buffer out of the editor
+func (e *Editor) captureBuffer() bufferState {
+	return bufferState{
+		lines:           e.lines,
+		filename:        e.filename,
+		cursorX:         e.cursorX,
+		cursorY:         e.cursorY,
+		offsetX:         e.offsetX,
+		offsetY:         e.offsetY,
+		undoStack:       e.undoStack,
+		redoStack:       e.redoStack,
+		undoBytes:       e.undoBytes,
+		redoBytes:       e.redoBytes,
+		snapshots:       e.snapshots,
+		savedLines:      e.savedLines,
+		modified:        e.modified,
+		truncated:       e.truncated,
+		currentChunk:    e.currentChunk,
+		chunkHistories:  e.chunkHistories,
+		selectionStart:  e.selectionStart,
+		blockSelection:  e.blockSelection,
+		selectionStartX: e.selectionStartX,
+		selectionStartY: e.selectionStartY,
+		tabWidth:        e.tabWidth,
+		useTabs:         e.useTabs,
+		ignoredWords:    e.ignoredWords,
+		readOnly:        e.readOnly,
+		foldedBlocks:    e.foldedBlocks,
+		goalMet:         e.goalMet,
+		lastWords:       e.lastWords,
+		savedWords:      e.savedWords,
+		wordsAdded:      e.wordsAdded,
+		wordsRemoved:    e.wordsRemoved,
+		writingTime:     e.writingTime,
+		lastWritten:     e.lastWritten,
+		logged:          e.logged,
+	}
+}
+
+// restoreBuffer makes b the active buffer
+func (e *Editor) restoreBuffer(b bufferState) {
+	e.lines = b.lines
+	e.filename = b.filename
+	e.cursorX = b.cursorX
+	e.cursorY = b.cursorY
+	e.offsetX = b.offsetX
+	e.offsetY = b.offsetY
+	e.undoStack = b.undoStack
+	e.redoStack = b.redoStack
+	e.undoBytes = b.undoBytes
+	e.redoBytes = b.redoBytes
+	e.snapshots = b.snapshots
+	e.savedLines = b.savedLines
+	e.modified = b.modified
+	e.truncated = b.truncated
+	e.currentChunk = b.currentChunk
+	e.chunkHistories = b.chunkHistories
+	e.selectionStart = b.selectionStart
+	e.blockSelection = b.blockSelection
+	e.selectionStartX = b.selectionStartX
+	e.selectionStartY = b.selectionStartY
+	e.tabWidth = b.tabWidth
+	e.useTabs = b.useTabs
+	e.ignoredWords = b.ignoredWords
+	e.readOnly = b.readOnly
+	e.foldedBlocks = b.foldedBlocks
+	e.goalMet = b.goalMet
+	e.lastWords = b.lastWords
+	e.savedWords = b.savedWords
+	e.wordsAdded = b.wordsAdded
+	e.wordsRemoved = b.wordsRemoved
+	e.writingTime = b.writingTime
+	e.lastWritten = b.lastWritten
+	e.logged = b.logged
+	e.invalidateWordCount()
+	e.clearSearch()
+}
+
+// bufferCount returns the number of open buffers
+func (e *Editor) bufferCount() int {
+	if len(e.buffers) == 0 {
+		return 1
+	}
+	return len(e.buffers)
+}
+
+// openBuffer loads filename into a new buffer after the active one and switches to
+// it. A missing file opens empty and is created on first save.
+func (e *Editor) openBuffer(filename string) error {
+	if len(e.buffers) == 0 {
+		e.buffers = []bufferState{{}}
+	}
+	if filename != "" {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+
+	e.buffers[e.activeBuffer] = e.captureBuffer()
+	e.restoreBuffer(bufferState{
+		lines:    []string{""},
+		filename: filename,
+		tabWidth: e.config.tabWidth,
+		useTabs:  e.config.useTabs,
+		readOnly: e.openReadOnly,
+	})
+	if filename != "" {
+		if err := e.loadFile(); err != nil && !os.IsNotExist(err) {
+			e.restoreBuffer(e.buffers[e.activeBuffer])
+			return err
+		}
+	}
+
+	e.activeBuffer++
+	e.buffers = append(e.buffers[:e.activeBuffer], append([]bufferState{{}}, e.buffers[e.activeBuffer:]...)...)
+	return nil
+}
+
+// switchBuffer makes buffer index active
+func (e *Editor) switchBuffer(index int) {
+	if index < 0 || index >= len(e.buffers) || index == e.activeBuffer {
+		return
+	}
+	e.buffers[e.activeBuffer] = e.captureBuffer()
+	e.activeBuffer = index
+	e.restoreBuffer(e.buffers[index])
+}
+
+// cycleBuffer moves delta buffers forward or back, wrapping around
+func (e *Editor) cycleBuffer(delta int) {
+	if len(e.buffers) < 2 {
+		e.statusMessage = "Only one buffer open"
+		return
+	}
+	e.switchBuffer((e.activeBuffer + delta + len(e.buffers)) % len(e.buffers))
+}
+
+// bufferName is the label of a buffer in the status bar and buffer list
+func bufferName(filename string) string {
+	if filename == "" {
+		return "[No Name]"
+	}
+	return filepath.Base(filename)
+}
+
+// pickBuffer lists the open buffers and switches to the chosen one
+func (e *Editor) pickBuffer() {
+	if len(e.buffers) < 2 {
+		e.statusMessage = "Only one buffer open"
+		return
+	}
+	e.buffers[e.activeBuffer] = e.captureBuffer()
+
+	items := make([]string, len(e.buffers))
+	for i, b := range e.buffers {
+		mark := "  "
+		if b.modified {
+			mark = "* "
+		}
+		items[i] = fmt.Sprintf("%s%d  %s", mark, i+1, b.filename)
+		if b.filename == "" {
+			items[i] = fmt.Sprintf("%s%d  %s", mark, i+1, bufferName(""))
+		}
+	}
+	if choice := e.pickFromList("Buffers", items, nil); choice >= 0 {
+		e.switchBuffer(choice)
+	}
+}
+
+// forEachBuffer runs fn with each buffer active in turn, then returns to the
+// buffer that was active before. It stops at the first error.
+func (e *Editor) forEachBuffer(fn func() error) error {
+	if len(e.buffers) < 2 {
+		return fn()
+	}
+	start := e.activeBuffer
+	defer e.switchBuffer(start)
+	for i := range e.buffers {
+		e.switchBuffer(i)
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offerToSave asks about each modified buffer in turn whether to save it or
+// discard its changes, before the buffers are closed. Cancelling returns
+// errCancelled.
+func (e *Editor) offerToSave() error {
+	return e.forEachBuffer(func() error {
+		if !e.modified {
+			return nil
+		}
+		question := "Save changes?"
+		if e.bufferCount() > 1 {
+			question = fmt.Sprintf("Save changes to %s?", bufferName(e.filename))
+		}
+		switch e.confirm(question, "Save", "Discard", "Cancel") {
+		case 0:
+			return e.saveFileWithPrompt()
+		case 1:
+			return nil
+		}
+		return errCancelled
+	})
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// namedChar is a character offered by the character picker with its Unicode
+// name and, after a semicolon, other names it is known by
+type namedChar struct {
+	r    rune
+	name string
+}
+
+// namedChars are the characters prose writers reach for that keyboards lack.
+// Names follow the Unicode character names, in lower case.
+var namedChars = []namedChar{
+	// Dashes and spaces
+	{'—', "em dash"},
+	{'–', "en dash"},
+	{'\u2012', "figure dash"},
+	{'―', "horizontal bar; quotation dash"},
+	{'\u2010', "hyphen"},
+	{'\u2011', "non-breaking hyphen"},
+	{'\u00ad', "soft hyphen; shy"},
+	{'−', "minus sign"},
+	{'\u00a0', "no-break space; nbsp; non-breaking space"},
+	{'\u202f', "narrow no-break space; thin nbsp"},
+	{'\u2009', "thin space"},
+	{'\u200a', "hair space"},
+	{'\u2003', "em space"},
+	{'\u2002', "en space"},
+	{'\u200b', "zero width space"},
+
+	// Quotes and punctuation
+	{'“', "left double quotation mark; opening quote"},
+	{'”', "right double quotation mark; closing quote"},
+	{'‘', "left single quotation mark"},
+	{'’', "right single quotation mark; apostrophe"},
+	{'„', "double low-9 quotation mark; german quote"},
+	{'‚', "single low-9 quotation mark"},
+	{'«', "left-pointing double angle quotation mark; guillemet"},
+	{'»', "right-pointing double angle quotation mark; guillemet"},
+	{'‹', "single left-pointing angle quotation mark"},
+	{'›', "single right-pointing angle quotation mark"},
+	{'′', "prime; minutes; feet"},
+	{'″', "double prime; seconds; inches"},
+	{'…', "horizontal ellipsis; dots"},
+	{'·', "middle dot; interpunct"},
+	{'•', "bullet"},
+	{'‣', "triangular bullet"},
+	{'¡', "inverted exclamation mark"},
+	{'¿', "inverted question mark"},
+	{'‽', "interrobang"},
+	{'§', "section sign"},
+	{'¶', "pilcrow sign; paragraph"},
+	{'†', "dagger"},
+	{'‡', "double dagger"},
+	{'※', "reference mark"},
+	{'⁂', "asterism"},
+	{'⁄', "fraction slash"},
+	{'¦', "broken bar"},
+
+	// Symbols
+	{'©', "copyright sign"},
+	{'®', "registered sign"},
+	{'™', "trade mark sign"},
+	{'℠', "service mark"},
+	{'℗', "sound recording copyright"},
+	{'°', "degree sign"},
+	{'℃', "degree celsius"},
+	{'℉', "degree fahrenheit"},
+	{'№', "numero sign; number"},
+	{'℮', "estimated symbol"},
+	{'✓', "check mark; tick"},
+	{'✔', "heavy check mark"},
+	{'✗', "ballot x; cross"},
+	{'☐', "ballot box; checkbox"},
+	{'☑', "ballot box with check"},
+	{'★', "black star"},
+	{'☆', "white star"},
+	{'♠', "black spade suit"},
+	{'♥', "black heart suit"},
+	{'♦', "black diamond suit"},
+	{'♣', "black club suit"},
+	{'♪', "eighth note; music"},
+	{'♫', "beamed eighth notes; music"},
+	{'☺', "white smiling face; smiley"},
+	{'☹', "white frowning face"},
+	{'⌘', "place of interest sign; command key"},
+	{'⌥', "option key"},
+	{'⇧', "upwards white arrow; shift key"},
+	{'⌃', "up arrowhead; control key"},
+	{'⏎', "return symbol; enter key"},
+	{'⌫', "erase to the left; backspace key"},
+	{'⎋', "broken circle with northwest arrow; escape key"},
+	{'␣', "open box; visible space"},
+	{'¤', "currency sign"},
+
+	// Currency
+	{'€', "euro sign"},
+	{'£', "pound sign"},
+	{'¥', "yen sign; yuan"},
+	{'¢', "cent sign"},
+	{'₹', "indian rupee sign"},
+	{'₽', "ruble sign"},
+	{'₩', "won sign"},
+	{'₪', "new sheqel sign"},
+	{'₺', "turkish lira sign"},
+	{'₿', "bitcoin sign"},
+	{'฿', "thai currency symbol baht"},
+	{'₫', "dong sign"},
+
+	// Arrows
+	{'→', "rightwards arrow; right arrow"},
+	{'←', "leftwards arrow; left arrow"},
+	{'↑', "upwards arrow; up arrow"},
+	{'↓', "downwards arrow; down arrow"},
+	{'↔', "left right arrow"},
+	{'↕', "up down arrow"},
+	{'⇒', "rightwards double arrow; implies"},
+	{'⇐', "leftwards double arrow"},
+	{'⇔', "left right double arrow; if and only if"},
+	{'↗', "north east arrow"},
+	{'↘', "south east arrow"},
+	{'↩', "leftwards arrow with hook; return"},
+	{'↪', "rightwards arrow with hook"},
+	{'⟶', "long rightwards arrow"},
+	{'➜', "heavy round-tipped rightwards arrow"},
+	{'▶', "black right-pointing triangle"},
+	{'◀', "black left-pointing triangle"},
+	{'▲', "black up-pointing triangle"},
+	{'▼', "black down-pointing triangle"},
+
+	// Maths
+	{'×', "multiplication sign; times"},
+	{'÷', "division sign"},
+	{'±', "plus-minus sign"},
+	{'∓', "minus-or-plus sign"},
+	{'≈', "almost equal to; approximately"},
+	{'≠', "not equal to"},
+	{'≡', "identical to"},
+	{'≤', "less-than or equal to"},
+	{'≥', "greater-than or equal to"},
+	{'≪', "much less-than"},
+	{'≫', "much greater-than"},
+	{'∞', "infinity"},
+	{'√', "square root"},
+	{'∛', "cube root"},
+	{'∑', "n-ary summation; sum"},
+	{'∏', "n-ary product"},
+	{'∫', "integral"},
+	{'∂', "partial differential"},
+	{'∆', "increment; delta"},
+	{'∇', "nabla"},
+	{'∈', "element of"},
+	{'∉', "not an element of"},
+	{'⊂', "subset of"},
+	{'⊆', "subset of or equal to"},
+	{'∪', "union"},
+	{'∩', "intersection"},
+	{'∅', "empty set"},
+	{'∀', "for all"},
+	{'∃', "there exists"},
+	{'¬', "not sign"},
+	{'∧', "logical and"},
+	{'∨', "logical or"},
+	{'∴', "therefore"},
+	{'∵', "because"},
+	{'∝', "proportional to"},
+	{'∠', "angle"},
+	{'⊥', "up tack; perpendicular"},
+	{'‰', "per mille sign"},
+	{'‱', "per ten thousand sign; basis point"},
+	{'µ', "micro sign"},
+	{'ℕ', "double-struck capital n; natural numbers"},
+	{'ℤ', "double-struck capital z; integers"},
+	{'ℝ', "double-struck capital r; real numbers"},
+
+	// Fractions, superscripts and subscripts
+	{'½', "vulgar fraction one half"},
+	{'⅓', "vulgar fraction one third"},
+	{'⅔', "vulgar fraction two thirds"},
+	{'¼', "vulgar fraction one quarter"},
+	{'¾', "vulgar fraction three quarters"},
+	{'⅛', "vulgar fraction one eighth"},
+	{'⁰', "superscript zero"},
+	{'¹', "superscript one"},
+	{'²', "superscript two; squared"},
+	{'³', "superscript three; cubed"},
+	{'⁴', "superscript four"},
+	{'ⁿ', "superscript latin small letter n"},
+	{'₀', "subscript zero"},
+	{'₁', "subscript one"},
+	{'₂', "subscript two"},
+	{'₃', "subscript three"},
+	{'ª', "feminine ordinal indicator"},
+	{'º', "masculine ordinal indicator"},
+
+	// Greek letters
+	{'α', "greek small letter alpha"},
+	{'β', "greek small letter beta"},
+	{'γ', "greek small letter gamma"},
+	{'δ', "greek small letter delta"},
+	{'ε', "greek small letter epsilon"},
+	{'θ', "greek small letter theta"},
+	{'λ', "greek small letter lamda; lambda"},
+	{'μ', "greek small letter mu"},
+	{'π', "greek small letter pi"},
+	{'σ', "greek small letter sigma"},
+	{'τ', "greek small letter tau"},
+	{'φ', "greek small letter phi"},
+	{'ω', "greek small letter omega"},
+	{'Δ', "greek capital letter delta"},
+	{'Σ', "greek capital letter sigma"},
+	{'Ω', "greek capital letter omega; ohm"},
+
+	// Letters
+	{'æ', "latin small letter ae; ash"},
+	{'Æ', "latin capital letter ae"},
+	{'œ', "latin small ligature oe"},
+	{'Œ', "latin capital ligature oe"},
+	{'ß', "latin small letter sharp s; eszett"},
+	{'ø', "latin small letter o with stroke"},
+	{'Ø', "latin capital letter o with stroke"},
+	{'å', "latin small letter a with ring above"},
+	{'Å', "latin capital letter a with ring above"},
+	{'ç', "latin small letter c with cedilla"},
+	{'ñ', "latin small letter n with tilde"},
+	{'é', "latin small letter e with acute"},
+	{'è', "latin small letter e with grave"},
+	{'ê', "latin small letter e with circumflex"},
+	{'ë', "latin small letter e with diaeresis"},
+	{'ï', "latin small letter i with diaeresis"},
+	{'ö', "latin small letter o with diaeresis"},
+	{'ü', "latin small letter u with diaeresis"},
+	{'ð', "latin small letter eth"},
+	{'þ', "latin small letter thorn"},
+	{'ł', "latin small letter l with stroke"},
+	{'ı', "latin small letter dotless i"},
+	{'ﬁ', "latin small ligature fi"},
+	{'ﬂ', "latin small ligature fl"},
+
+	// Box drawing and shapes
+	{'─', "box drawings light horizontal"},
+	{'│', "box drawings light vertical"},
+	{'┌', "box drawings light down and right"},
+	{'┐', "box drawings light down and left"},
+	{'└', "box drawings light up and right"},
+	{'┘', "box drawings light up and left"},
+	{'├', "box drawings light vertical and right"},
+	{'■', "black square"},
+	{'□', "white square"},
+	{'●', "black circle"},
+	{'○', "white circle"},
+	{'◆', "black diamond"},
+	{'◇', "white diamond"},
+	{'█', "full block"},
+	{'░', "light shade"},
+}
+
+// charLabel is how a character is listed in the picker: the character, its
+// names and its code point. Invisible characters are shown by code point only.
+func charLabel(c namedChar) string {
+	glyph := string(c.r)
+	if !unicode.IsGraphic(c.r) || unicode.IsSpace(c.r) {
+		glyph = " "
+	}
+	return fmt.Sprintf("%s  %s  U+%04X", glyph, c.name, c.r)
+}
+
+// parseCodePoint reads a code point written as U+2014, u+2014 or 0x2014
+func parseCodePoint(s string) (rune, bool) {
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{"U+", "u+", "0x", "0X"} {
+		if hex, ok := strings.CutPrefix(s, prefix); ok {
+			n, err := strconv.ParseUint(hex, 16, 32)
+			if err != nil || n > unicode.MaxRune || (n >= 0xD800 && n <= 0xDFFF) {
+				return 0, false
+			}
+			return rune(n), true
+		}
+	}
+	return 0, false
+}
+
+// insertCharByName lets the user search the characters by name or code point and
+// inserts the chosen one. A code point typed as U+XXXX is inserted even when it
+// is not in the list.
+func (e *Editor) insertCharByName() {
+	items := make([]string, len(namedChars))
+	for i, c := range namedChars {
+		items[i] = charLabel(c)
+	}
+	choice, query := e.pickSearchable("Insert character (or U+XXXX)", items)
+	if choice >= 0 {
+		e.insertInline(string(namedChars[choice].r))
+	} else if r, ok := parseCodePoint(query); ok {
+		e.insertInline(string(r))
+	} else if query != "" {
+		e.statusMessage = fmt.Sprintf("No character named %q", query)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// compareRow is one row of a side-by-side comparison: the line shown on each
+// side, -1 where that side has a gap, and how the two differ: ' ' the same,
+// '~' changed, '-' only on the left, '+' only on the right
+type compareRow struct {
+	left, right int
+	kind        byte
+}
+
+// compareRows lines up a and b for showing side by side. Within a run of
+// changes the removed and added lines are paired off as changed rows, and the
+// side with more lines leaves gaps on the other.
+func compareRows(a, b []string) []compareRow {
+	ops := diffLines(a, b)
+	rows := make([]compareRow, 0, len(ops))
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			rows = append(rows, compareRow{ops[i].a, ops[i].b, ' '})
+			i++
+			continue
+		}
+		var removed, added []int
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				removed = append(removed, ops[i].a)
+			} else {
+				added = append(added, ops[i].b)
+			}
+		}
+		for j := 0; j < max(len(removed), len(added)); j++ {
+			row := compareRow{-1, -1, '~'}
+			if j < len(removed) {
+				row.left = removed[j]
+			} else {
+				row.kind = '+'
+			}
+			if j < len(added) {
+				row.right = added[j]
+			} else {
+				row.kind = '-'
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// differenceStarts returns the index of the first row of each run of differing
+// rows
+func differenceStarts(rows []compareRow) []int {
+	var starts []int
+	for i, row := range rows {
+		if row.kind != ' ' && (i == 0 || rows[i-1].kind == ' ') {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// compareRequest is the payload of the interrupt posted at startup by
+// --diff, so the comparison opens on the event loop
+type compareRequest struct{}
+
+// compareBuffers picks another open buffer and compares the active one with it
+func (e *Editor) compareBuffers() {
+	if len(e.buffers) < 2 {
+		e.statusMessage = "Open a second file to compare with (one buffer each)"
+		return
+	}
+	e.buffers[e.activeBuffer] = e.captureBuffer()
+	var others []int
+	var items []string
+	for i, b := range e.buffers {
+		if i != e.activeBuffer {
+			others = append(others, i)
+			items = append(items, fmt.Sprintf("%d  %s", i+1, bufferName(b.filename)))
+		}
+	}
+	choice := 0
+	if len(others) > 1 {
+		if choice = e.pickFromList("Compare with", items, nil); choice < 0 {
+			return
+		}
+	}
+	e.compareWith(others[choice])
+}
+
+// compareWith shows the active buffer on the left and buffer other on the
+// right, lined up line by line, until Escape. Up/Down and the paging keys
+// scroll, Left/Right scroll sideways, and n/p (or Tab/Shift+Tab) move to the
+// next or previous difference. The view starts at the first difference.
+func (e *Editor) compareWith(other int) {
+	b := e.buffers[other]
+	name := func(filename string) string {
+		if filename == "" {
+			return bufferName("")
+		}
+		return projectPath(filename)
+	}
+	leftName, rightName := name(e.filename), name(b.filename)
+	a, bl := e.lines, b.lines
+	rows := compareRows(a, bl)
+	starts := differenceStarts(rows)
+	if len(starts) == 0 {
+		e.statusMessage = fmt.Sprintf("%s and %s are the same", leftName, rightName)
+		return
+	}
+
+	top, left, current := 0, 0, 0
+	titleStyle := e.theme.prompt
+	expand := func(s string) string {
+		return strings.ReplaceAll(s, "\t", strings.Repeat(" ", e.tabWidth))
+	}
+	numberWidth := len(fmt.Sprint(max(len(a), len(bl)))) + 1
+
+	redraw := func() {
+		e.screen.Clear()
+		height := e.height - 2
+		top = max(0, min(top, len(rows)-height))
+		half := (e.fullWidth() - 1) / 2
+
+		e.fillRow(0, titleStyle)
+		e.drawClipped(0, 0, half, " "+leftName, titleStyle)
+		e.drawClipped(half+1, 0, half, " "+rightName, titleStyle)
+
+		side := func(x, y, line int, lines []string, style tcell.Style) {
+			if line < 0 {
+				e.drawClipped(x, y, half, "", e.theme.dim)
+				return
+			}
+			number := fmt.Sprintf("%*d ", numberWidth, line+1)
+			e.drawClipped(x, y, numberWidth+1, number, e.theme.dim)
+			e.drawClipped(x+numberWidth+1, y, max(0, half-numberWidth-1), dropColumns(expand(lines[line]), left), style)
+		}
+		for i := 0; i < height && top+i < len(rows); i++ {
+			row := rows[top+i]
+			leftStyle, rightStyle := e.theme.text, e.theme.text
+			if row.kind != ' ' {
+				leftStyle, rightStyle = e.theme.removed, e.theme.added
+			}
+			side(0, i+1, row.left, a, leftStyle)
+			e.screen.SetContent(half, i+1, '│', nil, e.theme.dim)
+			side(half+1, i+1, row.right, bl, rightStyle)
+		}
+
+		e.fillRow(e.height-1, titleStyle)
+		status := fmt.Sprintf(" Difference %d of %d | n/p: next/previous | Up/Down/PgUp/PgDn/Left/Right: scroll | Esc: close", current+1, len(starts))
+		e.drawText(0, e.height-1, status, titleStyle)
+		e.screen.HideCursor()
+		e.screen.Show()
+	}
+
+	// jump shows difference i a few rows below the top
+	jump := func(i int) {
+		current = (i + len(starts)) % len(starts)
+		top = max(0, starts[current]-3)
+	}
+	jump(0)
+
+	e.modal(redraw, func(ev *tcell.EventKey) bool {
+		switch ev.Key() {
+		case tcell.KeyEscape, tcell.KeyEnter:
+			return true
+		case tcell.KeyUp:
+			top--
+		case tcell.KeyDown:
+			top++
+		case tcell.KeyPgUp:
+			top -= e.height - 2
+		case tcell.KeyPgDn:
+			top += e.height - 2
+		case tcell.KeyHome:
+			top = 0
+		case tcell.KeyEnd:
+			top = len(rows)
+		case tcell.KeyLeft:
+			left = max(0, left-8)
+		case tcell.KeyRight:
+			left += 8
+		case tcell.KeyTab:
+			jump(current + 1)
+		case tcell.KeyBacktab:
+			jump(current - 1)
+		case tcell.KeyRune:
+			switch ev.Rune() {
+			case 'n':
+				jump(current + 1)
+			case 'p':
+				jump(current - 1)
+			case 'q':
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// config holds user settings read from the config file and overridden by flags
+type config struct {
+	chunkLines     int    // Lines loaded per chunk for large files
+	theme          string // Name of the colour theme
+	tabWidth       int    // Columns per tab stop and per indent
+	useTabs        bool   // Indent with tab characters instead of spaces
+	softWrap       bool   // Start with soft wrap on
+	showInvisibles bool   // Start with whitespace shown as symbols
+	rulerColumn    int    // Column of the vertical guide
+	showRuler      bool   // Start with the guide shown
+	rulerOverflow  bool   // Start with text past the guide highlighted
+	scrollOff      int    // Lines kept between the cursor and the window edges
+	focusWidth     int    // Width of the text column in focus mode
+	focusDim       bool   // Fade text outside the current paragraph in focus mode
+	wrapColumn     int    // Column paragraphs are reflowed to
+	hardWrap       bool   // Break lines at wrapColumn while typing
+	spellCheck     bool   // Start with spell checking on
+	spellLanguage  string // Hunspell dictionary name, such as en_US
+	dictionary     string // Dictionary file to use instead of looking one up
+	// Word list that "add to dictionary" saves to
+	personalDictionary string
+	abbreviate         bool              // Expand abbreviations while typing
+	autoPair           bool              // Close brackets and quotes while typing
+	scrollbar          bool              // Draw a scrollbar on the right edge
+	minimap            bool              // Start with the minimap shown
+	smoothScroll       bool              // Animate paging and go-to-line jumps
+	scrollMomentum     bool              // Wheel scrolling builds momentum rather than moving a fixed amount
+	scrollLines        int               // Lines per wheel tick without momentum, or with wheelMovesCursor
+	scrollMultiplier   float64           // Momentum added per wheel tick
+	scrollDecay        float64           // Share of the momentum kept from one frame to the next
+	scrollMax          float64           // Momentum cap, so fast wheeling cannot run away
+	wheelMovesCursor   bool              // The wheel moves the cursor, and the view follows
+	cursorColumn       bool              // Start with the cursor's column highlighted
+	abbreviations      map[string]string // Abbreviation table, from "abbrev teh = the" lines
+	dateFormats        []string          // strftime formats offered when inserting the date
+	templates          map[string]string // Template files by name, from "template NAME = PATH" lines
+	wordGoal           int               // Words to reach, 0 for no goal
+	sessionGoal        bool              // wordGoal counts the words added since opening
+	timerMinutes       int               // Length of a writing session unless another is given
+	timerFocus         bool              // Turn focus mode on during writing sessions
+	writingLog         bool              // Log the words written in each file on save and quit
+}
+
+func defaultConfig() config {
+	return config{
+		chunkLines:    10000,
+		theme:         "default",
+		tabWidth:      4,
+		wrapColumn:    80,
+		rulerColumn:   80,
+		focusWidth:    72,
+		focusDim:      true,
+		spellLanguage: "en_US",
+		abbreviate:    true,
+		scrollbar:     true,
+
+		scrollMomentum:   true,
+		scrollLines:      3,
+		scrollMultiplier: 15,
+		scrollDecay:      0.85,
+		scrollMax:        250,
+		timerMinutes:     25,
+		writingLog:       true,
+	}
+}
+
+// settingsFile returns the path of a file kept with the user's settings, in
+// ~/.config/mkmd (or the platform equivalent), or "" when there is no such folder
+func settingsFile(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mkmd", name)
+}
+
+// defaultConfigPath returns ~/.config/mkmd/config (or the platform equivalent)
+func defaultConfigPath() string {
+	return settingsFile("config")
+}
+
+// loadConfig reads "key = value" lines from path on top of the defaults. Blank
+// lines and lines starting with # are ignored. A missing file is an error only
+// when required is set, so the default location may simply not exist.
+func loadConfig(path string, required bool) (config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return cfg, fmt.Errorf("%s:%d: expected key = value", path, lineNum)
+		}
+		if err := cfg.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return cfg, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+	}
+
+	// Template paths are relative to the config file, and may start with ~/
+	for name, templatePath := range cfg.templates {
+		if rest, ok := strings.CutPrefix(templatePath, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				templatePath = filepath.Join(home, rest)
+			}
+		}
+		if !filepath.IsAbs(templatePath) {
+			templatePath = filepath.Join(filepath.Dir(path), templatePath)
+		}
+		cfg.templates[name] = templatePath
+	}
+	return cfg, scanner.Err()
+}
+
+// set applies one setting by name, validating its value
+func (c *config) set(key, value string) error {
+	if name, ok := strings.CutPrefix(key, "template "); ok {
+		name = strings.TrimSpace(name)
+		if name == "" || value == "" {
+			return fmt.Errorf("templates are written \"template NAME = PATH\", got %q", key+" = "+value)
+		}
+		if c.templates == nil {
+			c.templates = make(map[string]string)
+		}
+		c.templates[name] = value
+		return nil
+	}
+	if abbrev, ok := strings.CutPrefix(key, "abbrev "); ok {
+		abbrev = strings.TrimSpace(abbrev)
+		if strings.ContainsAny(abbrev, " \t") || value == "" {
+			return fmt.Errorf("abbreviations are written \"abbrev SHORT = expansion\", got %q", key+" = "+value)
+		}
+		if c.abbreviations == nil {
+			c.abbreviations = make(map[string]string)
+		}
+		c.abbreviations[abbrev] = value
+		return nil
+	}
+
+	switch key {
+	case "chunk_lines":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("chunk_lines must be a positive number, got %q", value)
+		}
+		c.chunkLines = n
+	case "theme":
+		if _, ok := themes[value]; !ok {
+			return fmt.Errorf("unknown theme %q (available: %s)", value, strings.Join(themeNames(), ", "))
+		}
+		c.theme = value
+	case "tab_width":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 16 {
+			return fmt.Errorf("tab_width must be between 1 and 16, got %q", value)
+		}
+		c.tabWidth = n
+	case "ruler_column":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("ruler_column must be a positive number, got %q", value)
+		}
+		c.rulerColumn = n
+	case "focus_width":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 20 {
+			return fmt.Errorf("focus_width must be at least 20, got %q", value)
+		}
+		c.focusWidth = n
+	case "scroll_off":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("scroll_off must be zero or more, got %q", value)
+		}
+		c.scrollOff = n
+	case "scroll_lines":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("scroll_lines must be a positive number, got %q", value)
+		}
+		c.scrollLines = n
+	case "scroll_multiplier":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 {
+			return fmt.Errorf("scroll_multiplier must be a number above 0, got %q", value)
+		}
+		c.scrollMultiplier = f
+	case "scroll_decay":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 || f >= 1 {
+			return fmt.Errorf("scroll_decay must be a number between 0 and 1, got %q", value)
+		}
+		c.scrollDecay = f
+	case "scroll_max":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 1 {
+			return fmt.Errorf("scroll_max must be at least 1, got %q", value)
+		}
+		c.scrollMax = f
+	case "wrap_column":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 10 {
+			return fmt.Errorf("wrap_column must be at least 10, got %q", value)
+		}
+		c.wrapColumn = n
+	case "timer_minutes":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("timer_minutes must be a positive number, got %q", value)
+		}
+		c.timerMinutes = n
+	case "word_goal":
+		words, session, err := parseWordGoal(value)
+		if err != nil {
+			return fmt.Errorf("word_goal: %v", err)
+		}
+		c.wordGoal, c.sessionGoal = words, session
+	case "spell_language":
+		if value == "" {
+			return fmt.Errorf("spell_language must not be empty")
+		}
+		c.spellLanguage = value
+	case "date_format":
+		// Each date_format line adds a choice
+		if value == "" {
+			return fmt.Errorf("date_format must not be empty")
+		}
+		c.dateFormats = append(c.dateFormats, value)
+	case "dictionary":
+		c.dictionary = value
+	case "personal_dictionary":
+		c.personalDictionary = value
+	case "use_tabs", "soft_wrap", "hard_wrap", "show_invisibles", "show_ruler", "ruler_overflow", "focus_dim", "spell_check", "abbreviate", "auto_pair", "scrollbar", "minimap", "smooth_scroll", "cursor_column", "scroll_momentum", "wheel_moves_cursor", "timer_focus", "writing_log":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", key, value)
+		}
+		switch key {
+		case "use_tabs":
+			c.useTabs = b
+		case "soft_wrap":
+			c.softWrap = b
+		case "hard_wrap":
+			c.hardWrap = b
+		case "show_invisibles":
+			c.showInvisibles = b
+		case "show_ruler":
+			c.showRuler = b
+		case "ruler_overflow":
+			c.rulerOverflow = b
+		case "focus_dim":
+			c.focusDim = b
+		case "spell_check":
+			c.spellCheck = b
+		case "abbreviate":
+			c.abbreviate = b
+		case "auto_pair":
+			c.autoPair = b
+		case "scrollbar":
+			c.scrollbar = b
+		case "minimap":
+			c.minimap = b
+		case "smooth_scroll":
+			c.smoothScroll = b
+		case "cursor_column":
+			c.cursorColumn = b
+		case "scroll_momentum":
+			c.scrollMomentum = b
+		case "wheel_moves_cursor":
+			c.wheelMovesCursor = b
+		case "timer_focus":
+			c.timerFocus = b
+		case "writing_log":
+			c.writingLog = b
+		}
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return nil
+}
+
+// theme holds the styles used to draw the editor
+type theme struct {
+	text      tcell.Style // Document text and empty screen
+	search    tcell.Style // Search matches
+	selection tcell.Style // Selected text
+	bracket   tcell.Style // Matching bracket pair
+	status    tcell.Style // Status bar
+	prompt    tcell.Style // Prompts and overlay title bars
+	picked    tcell.Style // Highlighted item in a list overlay
+	dim       tcell.Style // Secondary text such as previews
+	added     tcell.Style // Added lines in diffs
+	removed   tcell.Style // Removed lines in diffs
+	hunk      tcell.Style // Diff hunk headers
+	trailing  tcell.Style // Trailing whitespace when invisibles are shown
+	overflow  tcell.Style // Text past the ruler column
+	spelling  tcell.Style // Misspelled words when spell checking
+	control   tcell.Style // Placeholders for control characters
+	column    tcell.Style // The cursor's column when highlighted
+
+	// Fenced code blocks naming a known language
+	codeKeyword tcell.Style
+	codeString  tcell.Style
+	codeComment tcell.Style
+	codeNumber  tcell.Style
+
+	math tcell.Style // TeX math between $ or $$
+
+	// The two sides of a merge conflict
+	ours   tcell.Style
+	theirs tcell.Style
+}
+
+var themes = map[string]theme{
+	"default": {
+		text:      tcell.StyleDefault,
+		search:    tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack),
+		selection: tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite),
+		bracket:   tcell.StyleDefault.Background(tcell.ColorTeal).Foreground(tcell.ColorWhite),
+		status:    tcell.StyleDefault.Background(tcell.ColorGray).Foreground(tcell.ColorWhite),
+		prompt:    tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite),
+		picked:    tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorBlack),
+		dim:       tcell.StyleDefault.Foreground(tcell.ColorGray),
+		added:     tcell.StyleDefault.Foreground(tcell.ColorGreen),
+		removed:   tcell.StyleDefault.Foreground(tcell.ColorRed),
+		hunk:      tcell.StyleDefault.Foreground(tcell.ColorTeal),
+		trailing:  tcell.StyleDefault.Foreground(tcell.ColorRed),
+		overflow:  tcell.StyleDefault.Background(tcell.ColorMaroon).Foreground(tcell.ColorWhite),
+		spelling:  tcell.StyleDefault.Underline(tcell.UnderlineStyleCurly, tcell.ColorRed),
+		control:   tcell.StyleDefault.Background(tcell.ColorRed).Foreground(tcell.ColorWhite),
+		column:    tcell.StyleDefault.Background(tcell.Color236),
+
+		codeKeyword: tcell.StyleDefault.Foreground(tcell.ColorPurple),
+		codeString:  tcell.StyleDefault.Foreground(tcell.ColorGreen),
+		codeComment: tcell.StyleDefault.Foreground(tcell.ColorGray),
+		codeNumber:  tcell.StyleDefault.Foreground(tcell.ColorOlive),
+
+		math: tcell.StyleDefault.Foreground(tcell.ColorTeal),
+
+		ours:   tcell.StyleDefault.Foreground(tcell.ColorGreen),
+		theirs: tcell.StyleDefault.Foreground(tcell.ColorBlue),
+	},
+	"light": {
+		text:      tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorBlack),
+		search:    tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack),
+		selection: tcell.StyleDefault.Background(tcell.ColorLightBlue).Foreground(tcell.ColorBlack),
+		bracket:   tcell.StyleDefault.Background(tcell.ColorLightGreen).Foreground(tcell.ColorBlack),
+		status:    tcell.StyleDefault.Background(tcell.ColorSilver).Foreground(tcell.ColorBlack),
+		prompt:    tcell.StyleDefault.Background(tcell.ColorNavy).Foreground(tcell.ColorWhite),
+		picked:    tcell.StyleDefault.Background(tcell.ColorNavy).Foreground(tcell.ColorWhite),
+		dim:       tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorGray),
+		added:     tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorGreen),
+		removed:   tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorMaroon),
+		hunk:      tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorTeal),
+		trailing:  tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorRed),
+		overflow:  tcell.StyleDefault.Background(tcell.ColorPink).Foreground(tcell.ColorBlack),
+		spelling:  tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorBlack).Underline(tcell.UnderlineStyleCurly, tcell.ColorRed),
+		control:   tcell.StyleDefault.Background(tcell.ColorRed).Foreground(tcell.ColorWhite),
+		column:    tcell.StyleDefault.Background(tcell.Color254).Foreground(tcell.ColorBlack),
+
+		codeKeyword: tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorNavy),
+		codeString:  tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorGreen),
+		codeComment: tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorGray),
+		codeNumber:  tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorMaroon),
+
+		math: tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorTeal),
+
+		ours:   tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorGreen),
+		theirs: tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorNavy),
+	},
+	"dark": {
+		text:      tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorSilver),
+		search:    tcell.StyleDefault.Background(tcell.ColorOlive).Foreground(tcell.ColorWhite),
+		selection: tcell.StyleDefault.Background(tcell.ColorNavy).Foreground(tcell.ColorWhite),
+		bracket:   tcell.StyleDefault.Background(tcell.ColorTeal).Foreground(tcell.ColorWhite),
+		status:    tcell.StyleDefault.Background(tcell.ColorGray).Foreground(tcell.ColorBlack),
+		prompt:    tcell.StyleDefault.Background(tcell.ColorPurple).Foreground(tcell.ColorWhite),
+		picked:    tcell.StyleDefault.Background(tcell.ColorSilver).Foreground(tcell.ColorBlack),
+		dim:       tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorGray),
+		added:     tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorGreen),
+		removed:   tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorRed),
+		hunk:      tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorTeal),
+		trailing:  tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorRed),
+		overflow:  tcell.StyleDefault.Background(tcell.ColorMaroon).Foreground(tcell.ColorWhite),
+		spelling:  tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorSilver).Underline(tcell.UnderlineStyleCurly, tcell.ColorRed),
+		control:   tcell.StyleDefault.Background(tcell.ColorMaroon).Foreground(tcell.ColorWhite),
+		column:    tcell.StyleDefault.Background(tcell.Color235).Foreground(tcell.ColorSilver),
+
+		codeKeyword: tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorAqua),
+		codeString:  tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorLime),
+		codeComment: tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorGray),
+		codeNumber:  tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorYellow),
+
+		math: tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorFuchsia),
+
+		ours:   tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorLime),
+		theirs: tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorAqua),
+	},
+	// No colours at all, for monochrome terminals and colour-blind users
+	"mono": {
+		text:      tcell.StyleDefault,
+		search:    tcell.StyleDefault.Underline(true).Bold(true),
+		selection: tcell.StyleDefault.Reverse(true),
+		bracket:   tcell.StyleDefault.Bold(true).Underline(true),
+		status:    tcell.StyleDefault.Reverse(true),
+		prompt:    tcell.StyleDefault.Reverse(true).Bold(true),
+		picked:    tcell.StyleDefault.Reverse(true),
+		dim:       tcell.StyleDefault.Dim(true),
+		added:     tcell.StyleDefault.Bold(true),
+		removed:   tcell.StyleDefault.Dim(true),
+		hunk:      tcell.StyleDefault.Underline(true),
+		trailing:  tcell.StyleDefault.Reverse(true),
+		overflow:  tcell.StyleDefault.Underline(true),
+		spelling:  tcell.StyleDefault.Underline(true),
+		control:   tcell.StyleDefault.Reverse(true).Bold(true),
+		column:    tcell.StyleDefault.Bold(true),
+
+		codeKeyword: tcell.StyleDefault.Bold(true),
+		codeString:  tcell.StyleDefault.Italic(true),
+		codeComment: tcell.StyleDefault.Dim(true),
+		codeNumber:  tcell.StyleDefault,
+
+		math: tcell.StyleDefault.Bold(true).Italic(true),
+
+		ours:   tcell.StyleDefault.Bold(true),
+		theirs: tcell.StyleDefault.Underline(true),
+	},
+	// Solarized, in 24-bit colour, fitted to the palette on terminals with fewer
+	"solarized-dark": {
+		text:      tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.base0),
+		search:    tcell.StyleDefault.Background(solarized.yellow).Foreground(solarized.base03),
+		selection: tcell.StyleDefault.Background(solarized.base02).Foreground(solarized.base1),
+		bracket:   tcell.StyleDefault.Background(solarized.cyan).Foreground(solarized.base03),
+		status:    tcell.StyleDefault.Background(solarized.base02).Foreground(solarized.base1),
+		prompt:    tcell.StyleDefault.Background(solarized.blue).Foreground(solarized.base3),
+		picked:    tcell.StyleDefault.Background(solarized.base1).Foreground(solarized.base03),
+		dim:       tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.base01),
+		added:     tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.green),
+		removed:   tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.red),
+		hunk:      tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.cyan),
+		trailing:  tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.red),
+		overflow:  tcell.StyleDefault.Background(solarized.base02).Foreground(solarized.orange),
+		spelling:  tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.base0).Underline(tcell.UnderlineStyleCurly, solarized.red),
+		control:   tcell.StyleDefault.Background(solarized.red).Foreground(solarized.base3),
+		column:    tcell.StyleDefault.Background(solarized.base02).Foreground(solarized.base0),
+
+		codeKeyword: tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.green),
+		codeString:  tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.cyan),
+		codeComment: tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.base01).Italic(true),
+		codeNumber:  tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.magenta),
+
+		math: tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.violet),
+
+		ours:   tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.green),
+		theirs: tcell.StyleDefault.Background(solarized.base03).Foreground(solarized.blue),
+	},
+	"solarized-light": {
+		text:      tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.base00),
+		search:    tcell.StyleDefault.Background(solarized.yellow).Foreground(solarized.base3),
+		selection: tcell.StyleDefault.Background(solarized.base2).Foreground(solarized.base01),
+		bracket:   tcell.StyleDefault.Background(solarized.cyan).Foreground(solarized.base3),
+		status:    tcell.StyleDefault.Background(solarized.base2).Foreground(solarized.base01),
+		prompt:    tcell.StyleDefault.Background(solarized.blue).Foreground(solarized.base3),
+		picked:    tcell.StyleDefault.Background(solarized.base01).Foreground(solarized.base3),
+		dim:       tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.base1),
+		added:     tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.green),
+		removed:   tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.red),
+		hunk:      tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.cyan),
+		trailing:  tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.red),
+		overflow:  tcell.StyleDefault.Background(solarized.base2).Foreground(solarized.orange),
+		spelling:  tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.base00).Underline(tcell.UnderlineStyleCurly, solarized.red),
+		control:   tcell.StyleDefault.Background(solarized.red).Foreground(solarized.base3),
+		column:    tcell.StyleDefault.Background(solarized.base2).Foreground(solarized.base00),
+
+		codeKeyword: tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.green),
+		codeString:  tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.cyan),
+		codeComment: tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.base1).Italic(true),
+		codeNumber:  tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.magenta),
+
+		math: tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.violet),
+
+		ours:   tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.green),
+		theirs: tcell.StyleDefault.Background(solarized.base3).Foreground(solarized.blue),
+	},
+}
+
+// solarized is the Solarized palette
+var solarized = struct {
+	base03, base02, base01, base00, base0, base1, base2, base3 tcell.Color
+	yellow, orange, red, magenta, violet, blue, cyan, green    tcell.Color
+}{
+	base03: tcell.NewHexColor(0x002b36), base02: tcell.NewHexColor(0x073642),
+	base01: tcell.NewHexColor(0x586e75), base00: tcell.NewHexColor(0x657b83),
+	base0: tcell.NewHexColor(0x839496), base1: tcell.NewHexColor(0x93a1a1),
+	base2: tcell.NewHexColor(0xeee8d5), base3: tcell.NewHexColor(0xfdf6e3),
+	yellow: tcell.NewHexColor(0xb58900), orange: tcell.NewHexColor(0xcb4b16),
+	red: tcell.NewHexColor(0xdc322f), magenta: tcell.NewHexColor(0xd33682),
+	violet: tcell.NewHexColor(0x6c71c4), blue: tcell.NewHexColor(0x268bd2),
+	cyan: tcell.NewHexColor(0x2aa198), green: tcell.NewHexColor(0x859900),
+}
+
+// themeNames lists the built-in themes alphabetically
+func themeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fitColors returns t for a terminal showing the given number of colours. With
+// fewer than 24-bit colour, each RGB colour becomes the nearest one the terminal
+// has: from the 256-colour palette, or the 16 or 8 basic colours. Named colours,
+// and terminals without colour, are left to the terminal.
+func (t theme) fitColors(colors int) theme {
+	if colors >= 1<<24 || colors < 8 {
+		return t
+	}
+	n := min(colors, 256)
+	fit := func(s tcell.Style) tcell.Style {
+		fg, bg, _ := s.Decompose()
+		return s.Foreground(nearestColor(fg, n)).Background(nearestColor(bg, n))
+	}
+	return theme{
+		text:      fit(t.text),
+		search:    fit(t.search),
+		selection: fit(t.selection),
+		bracket:   fit(t.bracket),
+		status:    fit(t.status),
+		prompt:    fit(t.prompt),
+		picked:    fit(t.picked),
+		dim:       fit(t.dim),
+		added:     fit(t.added),
+		removed:   fit(t.removed),
+		hunk:      fit(t.hunk),
+		trailing:  fit(t.trailing),
+		overflow:  fit(t.overflow),
+		spelling:  fit(t.spelling),
+		control:   fit(t.control),
+		column:    fit(t.column),
+
+		codeKeyword: fit(t.codeKeyword),
+		codeString:  fit(t.codeString),
+		codeComment: fit(t.codeComment),
+		codeNumber:  fit(t.codeNumber),
+
+		math: fit(t.math),
+
+		ours:   fit(t.ours),
+		theirs: fit(t.theirs),
+	}
+}
+
+// nearestColor returns the closest of the first n palette colours to c, or c
+// itself when it is not an RGB colour. Distance weighs green above blue above
+// red, roughly as the eye does.
+func nearestColor(c tcell.Color, n int) tcell.Color {
+	if !c.IsRGB() {
+		return c
+	}
+	r, g, b := c.RGB()
+	best, bestDist := c, int32(-1)
+	for i := 0; i < n; i++ {
+		p := tcell.PaletteColor(i)
+		pr, pg, pb := p.RGB()
+		dist := 2*(r-pr)*(r-pr) + 4*(g-pg)*(g-pg) + 3*(b-pb)*(b-pb)
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = p, dist
+		}
+	}
+	return best
+}
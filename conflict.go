@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// conflict is a merge conflict left by git: the line numbers of its markers.
+// Ours runs from start to base (or mid), the common ancestor shown by
+// merge.conflictStyle=diff3 from base to mid, and theirs from mid to end.
+type conflict struct {
+	start int // <<<<<<<
+	base  int // |||||||, or -1 when there is none
+	mid   int // =======
+	end   int // >>>>>>>
+}
+
+// conflictMarker reports whether line is a conflict marker made of seven of c,
+// alone or followed by a space and a label
+func conflictMarker(line string, c byte) bool {
+	line = strings.TrimSuffix(line, "\r")
+	marker := strings.Repeat(string(c), 7)
+	if c == '=' {
+		return line == marker
+	}
+	return line == marker || strings.HasPrefix(line, marker+" ")
+}
+
+// findConflicts returns the complete merge conflicts in lines, in order.
+// Markers that are never closed are left alone.
+func findConflicts(lines []string) []conflict {
+	var found []conflict
+	for y := 0; y < len(lines); y++ {
+		if !conflictMarker(lines[y], '<') {
+			continue
+		}
+		c := conflict{start: y, base: -1, mid: -1, end: -1}
+		for i := y + 1; i < len(lines) && c.end < 0; i++ {
+			switch {
+			case conflictMarker(lines[i], '<'):
+				// A new conflict starts before this one ends
+				i = len(lines)
+			case c.mid < 0 && c.base < 0 && conflictMarker(lines[i], '|'):
+				c.base = i
+			case c.mid < 0 && conflictMarker(lines[i], '='):
+				c.mid = i
+			case c.mid >= 0 && conflictMarker(lines[i], '>'):
+				c.end = i
+			}
+		}
+		if c.end >= 0 {
+			found = append(found, c)
+			y = c.end
+		}
+	}
+	return found
+}
+
+// ours returns the lines of our side of c
+func (c conflict) ours(lines []string) []string {
+	end := c.mid
+	if c.base >= 0 {
+		end = c.base
+	}
+	return lines[c.start+1 : end]
+}
+
+// theirs returns the lines of their side of c
+func (c conflict) theirs(lines []string) []string {
+	return lines[c.mid+1 : c.end]
+}
+
+// drawConflicts colours the merge conflicts on screen: the markers as diff
+// hunk headers, and each side in its own colour, the common ancestor faint
+func (e *Editor) drawConflicts() {
+	conflicts := findConflicts(e.lines)
+	if len(conflicts) == 0 {
+		return
+	}
+	styles := make(map[int]tcell.Style)
+	for _, c := range conflicts {
+		for y := c.start; y <= c.end; y++ {
+			switch {
+			case y == c.start || y == c.base || y == c.mid || y == c.end:
+				styles[y] = e.theme.hunk
+			case y > c.mid:
+				styles[y] = e.theme.theirs
+			case c.base >= 0 && y > c.base:
+				styles[y] = e.theme.dim
+			default:
+				styles[y] = e.theme.ours
+			}
+		}
+	}
+	e.forEachVisibleRune(func(x, y, col, sx, sy int, runes []rune) {
+		style, ok := styles[y]
+		if !ok {
+			return
+		}
+		if _, _, current, _ := e.screen.GetContent(sx, sy); current == e.theme.text {
+			e.drawRune(sx, sy, col, runes, x, style)
+		}
+	})
+}
+
+// jumpConflict moves the cursor to the next (delta 1) or previous (delta -1)
+// merge conflict, wrapping around the document
+func (e *Editor) jumpConflict(delta int) {
+	conflicts := findConflicts(e.lines)
+	if len(conflicts) == 0 {
+		e.statusMessage = "No merge conflicts"
+		return
+	}
+	next := 0
+	if delta > 0 {
+		for i, c := range conflicts {
+			if c.start > e.cursorY {
+				next = i
+				break
+			}
+		}
+	} else {
+		next = len(conflicts) - 1
+		for i := len(conflicts) - 1; i >= 0; i-- {
+			if conflicts[i].start < e.cursorY {
+				next = i
+				break
+			}
+		}
+	}
+	e.clearSelection()
+	e.cursorY, e.cursorX = conflicts[next].start, 0
+	e.ensureCursorVisible()
+	e.statusMessage = fmt.Sprintf("Conflict %d of %d (Alt+= to resolve)", next+1, len(conflicts))
+}
+
+// resolveConflict asks whether to keep our side, their side or both of the
+// merge conflict at the cursor, and puts that in place of the whole conflict
+func (e *Editor) resolveConflict() {
+	conflicts := findConflicts(e.lines)
+	index := -1
+	for i, c := range conflicts {
+		if e.cursorY >= c.start && e.cursorY <= c.end {
+			index = i
+		}
+	}
+	if index < 0 {
+		if len(conflicts) == 0 {
+			e.statusMessage = "No merge conflicts"
+		} else {
+			e.statusMessage = "Not in a merge conflict (Alt+> jumps to the next)"
+		}
+		return
+	}
+
+	c := conflicts[index]
+	question := fmt.Sprintf("Conflict at line %d: keep which side?", c.start+1)
+	choice := e.confirm(question, "Ours", "Theirs", "Both")
+	if choice < 0 {
+		return
+	}
+	var kept []string
+	if choice != 1 {
+		kept = append(kept, c.ours(e.lines)...)
+	}
+	if choice != 0 {
+		kept = append(kept, c.theirs(e.lines)...)
+	}
+
+	e.pushUndoState()
+	e.clearSelection()
+	e.clearSearch()
+	e.invalidateWordCount()
+	resolved := append(append(append([]string{}, e.lines[:c.start]...), kept...), e.lines[c.end+1:]...)
+	if len(resolved) == 0 {
+		resolved = []string{""}
+	}
+	e.lines = resolved
+	e.modified = true
+	e.cursorY, e.cursorX = min(c.start, len(e.lines)-1), 0
+	e.ensureCursorVisible()
+	sides := []string{"ours", "theirs", "both sides"}
+	e.statusMessage = fmt.Sprintf("Kept %s; %d conflicts left", sides[choice], len(conflicts)-1)
+}
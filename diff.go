@@ -0,0 +1,213 @@
+package main
+
+import "fmt"
+
+// diffOp is one line of a line-based edit script between two versions of a buffer
+type diffOp struct {
+	kind byte // ' ' for unchanged, '-' for removed from a, '+' for added in b
+	a    int  // Line index in a (for ' ' and '-')
+	b    int  // Line index in b (for ' ' and '+')
+	text string
+}
+
+// maxDiffEdits caps the edit distance myersDiff searches to, which bounds its
+// time and memory; text changed more than that is replaced as a whole
+const maxDiffEdits = 2000
+
+// diffLines computes a minimal line edit script turning a into b. Common leading
+// and trailing lines are matched directly and only the middle goes through Myers'
+// O(ND) algorithm, so small edits to large chunks stay cheap.
+func diffLines(a, b []string) []diffOp {
+	start, endA, endB := changedRange(a, b)
+
+	ops := make([]diffOp, 0, endA+len(b)-start)
+	for i := 0; i < start; i++ {
+		ops = append(ops, diffOp{kind: ' ', a: i, b: i, text: a[i]})
+	}
+	for _, op := range myersDiff(a[start:endA], b[start:endB]) {
+		op.a += start
+		op.b += start
+		ops = append(ops, op)
+	}
+	for i := 0; endA+i < len(a); i++ {
+		ops = append(ops, diffOp{kind: ' ', a: endA + i, b: endB + i, text: a[endA+i]})
+	}
+	return ops
+}
+
+// myersDiff runs the Myers shortest edit script search on a and b. Only the part of
+// each diagonal array that step d can touch is kept, so memory grows with the square
+// of the edit distance rather than with the file size. When the distance is over
+// maxDiffEdits it gives up and removes all of a for all of b.
+func myersDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+
+	for d := 0; d <= min(max, maxDiffEdits); d++ {
+		// Diagonals -d-1..d+1 are all that step d reads
+		window := make([]int, 2*d+3)
+		copy(window, v[offset-d-1:offset+d+2])
+		trace = append(trace, window)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Move down (insertion)
+			} else {
+				x = v[offset+k-1] + 1 // Move right (deletion)
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+	return replaceDiff(a, b)
+}
+
+// replaceDiff is the edit script removing every line of a and adding every
+// line of b, as a single hunk
+func replaceDiff(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for i, line := range a {
+		ops = append(ops, diffOp{kind: '-', a: i, b: 0, text: line})
+	}
+	for j, line := range b {
+		ops = append(ops, diffOp{kind: '+', a: len(a), b: j, text: line})
+	}
+	return ops
+}
+
+// backtrackDiff walks the Myers trace backwards to recover the edit script
+func backtrackDiff(a, b []string, trace [][]int) []diffOp {
+	x, y := len(a), len(b)
+	ops := []diffOp{}
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		window := trace[d]
+		at := func(k int) int { return window[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{kind: ' ', a: x, b: y, text: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, diffOp{kind: '+', a: x, b: y, text: b[y]})
+			} else {
+				x--
+				ops = append(ops, diffOp{kind: '-', a: x, b: y, text: a[x]})
+			}
+		}
+	}
+
+	// Reverse into document order
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// diffHunk is a run of consecutive changed lines: a[aStart:aEnd] was replaced by b[bStart:bEnd]
+type diffHunk struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// diffHunks groups an edit script into hunks of adjacent changes
+func diffHunks(ops []diffOp) []diffHunk {
+	hunks := []diffHunk{}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		h := diffHunk{aStart: ops[i].a, aEnd: ops[i].a, bStart: ops[i].b, bEnd: ops[i].b}
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				h.aEnd = ops[i].a + 1
+			} else {
+				h.bEnd = ops[i].b + 1
+			}
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// unifiedDiff renders the changes from a to b as unified diff lines with the given
+// number of context lines around each hunk.
+func unifiedDiff(a, b []string, context int) []string {
+	ops := diffLines(a, b)
+	out := []string{}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Grow the hunk until there is a gap of more than 2*context unchanged lines
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			gap := end
+			for gap < len(ops) && ops[gap].kind == ' ' {
+				gap++
+			}
+			if gap == len(ops) || gap-end > 2*context {
+				end += context
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = gap
+		}
+
+		aStart, bStart, aCount, bCount := -1, -1, 0, 0
+		body := []string{}
+		for _, op := range ops[start:end] {
+			if aStart < 0 {
+				aStart, bStart = op.a, op.b
+			}
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+			body = append(body, string(op.kind)+op.text)
+		}
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@", aStart+1, aCount, bStart+1, bCount))
+		out = append(out, body...)
+		i = end
+	}
+	return out
+}
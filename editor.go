@@ -2,10 +2,17 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -13,7 +20,40 @@ import (
 	"github.com/mattn/go-runewidth"
 )
 
-const maxUndoStates = 100 // Maximum number of undo states to keep in memory
+const defaultUndoBudget = 64 * 1024 * 1024 // Bytes of undo/redo history to keep in memory (64 MB)
+
+// stringHeaderSize approximates the per-line overhead of a stored []string entry
+const stringHeaderSize = 16
+
+// undoState is one undo/redo entry: a snapshot of the lines plus the cursor and
+// scroll position at the time, so undoing jumps back to where the change happened.
+type undoState struct {
+	lines   []string
+	cursorX int
+	cursorY int
+	offsetY int
+	time    time.Time // When the entry was recorded, shown in the history browser
+	size    int       // Approximate memory used by lines, counted against the undo budget
+}
+
+// linesSize approximates the memory held by a copy of lines
+func linesSize(lines []string) int {
+	size := len(lines) * stringHeaderSize
+	for _, line := range lines {
+		size += len(line)
+	}
+	return size
+}
+
+// trimHistory drops the oldest entries until the stack fits in budget bytes, always
+// keeping the two newest so a single undo/redo step remains possible.
+func trimHistory(stack []undoState, total, budget int) ([]undoState, int) {
+	for total > budget && len(stack) > 2 {
+		total -= stack[0].size
+		stack = stack[1:]
+	}
+	return stack, total
+}
 
 type Editor struct {
 	screen      tcell.Screen
@@ -24,27 +64,106 @@ type Editor struct {
 	width       int
 	height      int
 	offsetY     int
-	offsetX     int        // Horizontal scroll offset
-	undoStack   [][]string // Stack of previous states of lines
-	redoStack   [][]string // Stack of undone states of lines
-	modified    bool       // Tracks if the file has unsaved changes
-	searchTerm  string     // Current search term
-	searchIndex int        // Current search result index
+	offsetX     int         // Horizontal scroll offset
+	undoStack   []undoState // Stack of previous states of lines
+	redoStack   []undoState // Stack of undone states of lines
+	snapshots   []snapshot  // Named buffer states, independent of the undo limit
+	savedLines  []string    // Current chunk as last loaded from or written to disk
+	undoBytes   int         // Approximate memory held by undoStack
+	redoBytes   int         // Approximate memory held by redoStack
+	undoBudget  int         // Memory budget for each of the undo and redo stacks, and for the parked chunks' history
+	modified    bool        // Tracks if the file has unsaved changes
+	editCount   int         // Bumped on every buffer change (each pushUndoState)
+	searchTerm  string      // Current search term
+	searchIndex int         // Current search result index
+	// One-off message shown in the status bar until the next key press or for
+	// statusTimeout, whichever comes first
+	statusMessage string
+	statusShown   string    // The message on screen, to notice a new one
+	statusSince   time.Time // When statusShown first appeared
 	// Chunking fields
-	truncated          bool   // Whether the file was truncated due to size
-	maxLines           int    // Maximum lines to load (10,000 by default)
-	selectionStart     bool   // Whether selection is active
-	selectionStartX    int    // Selection start X position
-	selectionStartY    int    // Selection start Y position
-	clipboard          string // Internal clipboard for cut/copy/paste
-	currentChunk       int    // Current chunk number (0-based)
-	cachedWordCount    int    // Cached word count for performance
-	wordCountValid     bool   // Whether cached word count is valid
-	scrollAcceleration int    // For smoother trackpad scrolling
+	truncated          bool                 // Whether the file was truncated due to size
+	maxLines           int                  // Maximum lines to load (10,000 by default)
+	selectionStart     bool                 // Whether selection is active
+	selectionStartX    int                  // Selection start X position
+	selectionStartY    int                  // Selection start Y position
+	clipboard          string               // Internal clipboard for cut/copy/paste
+	blockSelection     bool                 // Whether the active selection is a rectangular (column) block
+	clipboardBlock     bool                 // Whether the clipboard was filled by a block copy
+	killRing           []string             // Text recently cut or deleted by word or line, oldest first
+	killed             bool                 // The current key cut text, so a cut on the next key joins it
+	joinKill           bool                 // The previous key cut text
+	pasting            bool                 // Between the start and end of a bracketed paste
+	pasted             []rune               // Text of the bracketed paste so far
+	autoRenumber       bool                 // Renumber the ordered list around the cursor after each edit
+	smartPunctuation   bool                 // Convert quotes, dashes and ellipses while typing
+	preview            *previewServer       // Live HTML preview server (--serve), nil when off
+	buffers            []bufferState        // All open buffers; the active slot is refreshed on switch
+	activeBuffer       int                  // Index of the buffer being edited
+	readOnly           bool                 // Refuse edits and saves to the active buffer
+	openReadOnly       bool                 // Open every file read-only (--readonly)
+	draftMode          bool                 // Refuse backspace, delete and cut while freewriting
+	overtype           bool                 // Typed characters replace the one under the cursor
+	abbreviate         bool                 // Expand abbreviations from the config at word boundaries
+	keepAbbreviation   bool                 // The next word boundary leaves the abbreviation before it as typed
+	autoPair           bool                 // Close brackets and quotes as they are typed
+	theme              theme                // Colours used to draw the editor
+	config             config               // Settings new buffers start from
+	tabWidth           int                  // Tab stop and indent width for this buffer
+	useTabs            bool                 // Tab key inserts a tab character instead of spaces
+	softWrap           bool                 // Wrap long lines at the window edge instead of scrolling
+	hardWrap           bool                 // Break lines at the wrap column while typing
+	showInvisibles     bool                 // Draw spaces, tabs and trailing whitespace as symbols
+	showRuler          bool                 // Draw a vertical guide at the ruler column
+	rulerOverflow      bool                 // Highlight text past the ruler column
+	cursorColumn       bool                 // Highlight the cursor's column down the screen
+	focusMode          bool                 // Distraction-free writing: centered column, no status bar
+	focusDim           bool                 // In focus mode, fade text outside the cursor's paragraph
+	focusPad           int                  // Blank columns left of the text in focus mode
+	currentChunk       int                  // Current chunk number (0-based)
+	fileLineCount      int                  // Lines in the whole file on disk, 0 until the scrollbar counts them
+	chunkHistories     map[int]chunkHistory // Undo/redo stacks of chunks not currently loaded
+	cachedWordCount    int                  // Cached word count for performance
+	wordCountValid     bool                 // Whether cached word count is valid
+	cachedReadability  readability          // Readability counts of the document, worked out when shown
+	readabilityValid   bool                 // Whether cachedReadability is of the current text
+	cachedControlChars int                  // Control characters in the document, counted with the words
+	lastWords          int                  // Word count when the words written were last tracked
+	savedWords         int                  // Word count when the buffer last matched the file on disk
+	wordsAdded         int                  // Words written in the buffer since it was opened
+	wordsRemoved       int                  // Words deleted from the buffer since it was opened
+	writingTime        time.Duration        // Time spent writing in the buffer since it was opened, leaving out breaks
+	lastWritten        time.Time            // When an edit last changed the buffer's word count
+	logged             logEntry             // The words and time of the buffer already in the writing log
+	wordGoal           int                  // Words to reach, shown as progress in the status bar; 0 for none
+	sessionGoal        bool                 // wordGoal counts the words added since the buffer was opened
+	goalMet            bool                 // The buffer had reached wordGoal when last checked
+	scrollAcceleration int                  // For smoother trackpad scrolling
+	scrollbarDrag      bool                 // Whether the left button went down on the scrollbar
+	minimap            bool                 // Show a compressed view of the document right of the text
+	foldedBlocks       map[int]bool         // Folded code blocks, by their place among the document's blocks
 	// Momentum scrolling fields
-	scrollMomentum    float64 // Current scroll momentum
-	maxScrollMomentum float64 // Maximum momentum to prevent runaway scrolling (200-300 lines)
-	momentumDecay     float64 // Decay rate per update (0.9 means 10% decay per frame)
+	scrollMomentum    float64      // Current scroll momentum
+	glide             *scrollGlide // Smooth scroll under way, nil when none
+	maxScrollMomentum float64      // Maximum momentum to prevent runaway scrolling (200-300 lines)
+	momentumDecay     float64      // Decay rate per update (0.9 means 10% decay per frame)
+	// Spell checking fields
+	spellCheck    bool                   // Underline words the dictionaries do not know
+	dictionaries  map[string]*dictionary // By language or path, loaded on first use; nil when missing
+	personalWords *dictionary            // The user's own words, shared by all documents
+	ignoredWords  *dictionary            // Words ignored in this document, loaded on first use
+	// Earlier answers to prompts by kind ("search", "goto", "file", "commit"),
+	// oldest first, loaded on first use
+	promptHistory map[string][]string
+	// Guards exitSaving, which a signal can start from the event loop and from
+	// its own handler, and a panic from the loop
+	shutdownOnce sync.Once
+	// Lock files held on the files opened for editing, released on exit
+	locks []*os.File
+	// The file being followed as another process appends to it, nil when none
+	follow *follower
+	// The writing session being timed, nil when none
+	timer *writingTimer
 }
 
 // Unicode utility functions for rune-aware string operations
@@ -63,51 +182,24 @@ func runeSubstring(s string, start, end int) string {
 		end = start
 	}
 
-	runes := []rune(s)
-	if start >= len(runes) {
-		return ""
-	}
-	if end > len(runes) {
-		end = len(runes)
-	}
-	return string(runes[start:end])
+	return s[runeIndexToByteIndex(s, start):runeIndexToByteIndex(s, end)]
 }
 
-// runeInsert inserts a string at a specific rune position
+// runeInsert inserts a string at a specific rune position. The rest of s keeps
+// its bytes, even where they are not valid UTF-8.
 func runeInsert(s string, pos int, insert string) string {
-	runes := []rune(s)
-	if pos < 0 {
-		pos = 0
-	}
-	if pos > len(runes) {
-		pos = len(runes)
-	}
-
-	insertRunes := []rune(insert)
-	result := make([]rune, len(runes)+len(insertRunes))
-	copy(result, runes[:pos])
-	copy(result[pos:], insertRunes)
-	copy(result[pos+len(insertRunes):], runes[pos:])
-	return string(result)
+	b := runeIndexToByteIndex(s, pos)
+	return s[:b] + insert + s[b:]
 }
 
-// runeDelete deletes runes from start to end position (end exclusive)
+// runeDelete deletes runes from start to end position (end exclusive). The rest
+// of s keeps its bytes, even where they are not valid UTF-8.
 func runeDelete(s string, start, end int) string {
-	runes := []rune(s)
-	if start < 0 {
-		start = 0
-	}
-	if end > len(runes) {
-		end = len(runes)
-	}
-	if start >= end {
+	from, to := runeIndexToByteIndex(s, start), runeIndexToByteIndex(s, end)
+	if from >= to {
 		return s
 	}
-
-	result := make([]rune, len(runes)-(end-start))
-	copy(result, runes[:start])
-	copy(result[start:], runes[end:])
-	return string(result)
+	return s[:from] + s[to:]
 }
 
 // displayWidth returns the display width of a string considering CJK characters
@@ -153,12 +245,12 @@ func isWordRune(r rune) bool {
 	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
 }
 
-func NewEditor(filename string) (*Editor, error) {
+func NewEditor(filename string, cfg config) (*Editor, error) {
 	// Ensure directory exists only if filename is provided
 	if filename != "" {
 		dir := filepath.Dir(filename)
 		if err := os.MkdirAll(dir, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create directory: %v", err)
+			return nil, fmt.Errorf("failed to create directory: %w", err)
 		}
 	}
 
@@ -174,6 +266,9 @@ func NewEditor(filename string) (*Editor, error) {
 
 	// Enable mouse support
 	screen.EnableMouse()
+	screen.EnablePaste()
+	th := themes[cfg.theme].fitColors(screen.Colors())
+	screen.SetStyle(th.text)
 
 	// Get initial dimensions
 	width, height := screen.Size()
@@ -188,14 +283,15 @@ func NewEditor(filename string) (*Editor, error) {
 		height:      height,
 		offsetY:     0,
 		offsetX:     0,
-		undoStack:   make([][]string, 0),
-		redoStack:   make([][]string, 0),
+		undoStack:   make([]undoState, 0),
+		redoStack:   make([]undoState, 0),
+		undoBudget:  defaultUndoBudget,
 		modified:    false,
 		searchTerm:  "",
 		searchIndex: 0,
 		// Chunking fields
 		truncated:          false,
-		maxLines:           10000, // Default to 10,000 lines
+		maxLines:           cfg.chunkLines, // 10,000 lines unless configured
 		selectionStart:     false,
 		selectionStartX:    0,
 		selectionStartY:    0,
@@ -206,14 +302,40 @@ func NewEditor(filename string) (*Editor, error) {
 		scrollAcceleration: 0,
 		// Momentum scrolling initialization
 		scrollMomentum:    0.0,
-		maxScrollMomentum: 250.0, // Cap at 250 lines of momentum
-		momentumDecay:     0.85,  // 15% decay per frame for smooth deceleration
+		maxScrollMomentum: cfg.scrollMax,   // 250 lines of momentum unless configured
+		momentumDecay:     cfg.scrollDecay, // 15% decay per frame unless configured
+		theme:             th,
+		config:            cfg,
+		tabWidth:          cfg.tabWidth,
+		useTabs:           cfg.useTabs,
+		softWrap:          cfg.softWrap,
+		hardWrap:          cfg.hardWrap,
+		showInvisibles:    cfg.showInvisibles,
+		showRuler:         cfg.showRuler,
+		rulerOverflow:     cfg.rulerOverflow,
+		cursorColumn:      cfg.cursorColumn,
+		focusDim:          cfg.focusDim,
+		abbreviate:        cfg.abbreviate,
+		autoPair:          cfg.autoPair,
+		minimap:           cfg.minimap,
+		wordGoal:          cfg.wordGoal,
+		sessionGoal:       cfg.sessionGoal,
+	}
+	if cfg.minimap {
+		// Make room for the minimap
+		editor.layoutText()
+	}
+	if cfg.spellCheck {
+		if err := editor.setSpellCheck(true); err != nil {
+			editor.statusMessage = err.Error()
+		}
 	}
 
 	// Load existing file if filename is provided and file exists
 	if filename != "" {
-		if err := editor.loadFile(); err != nil {
-			// File doesn't exist, that's fine
+		// A file that does not exist yet is created on the first save
+		if err := editor.loadFile(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			editor.reportError("Opening "+filepath.Base(filename), err)
 		}
 	}
 
@@ -222,93 +344,151 @@ func NewEditor(filename string) (*Editor, error) {
 
 // saveFileWithPrompt handles saving the file, prompting for filename if needed
 func (e *Editor) saveFileWithPrompt() error {
+	if e.readOnly {
+		e.statusMessage = "Read-only: not saved"
+		return nil
+	}
 	if e.filename == "" {
 		filename := e.promptFilename("Save as", "")
 		if filename == "" {
-			return nil // User cancelled
+			return errCancelled
 		}
 
 		// Check if file exists and ask for confirmation
 		if _, err := os.Stat(filename); err == nil {
 			// File exists, ask for confirmation
-			if !e.promptYesNo(fmt.Sprintf("File '%s' exists. Overwrite?", filepath.Base(filename))) {
-				return nil // User chose not to overwrite
+			if e.confirm(fmt.Sprintf("File '%s' exists.", filepath.Base(filename)), "Overwrite", "Cancel") != 0 {
+				return errCancelled
 			}
 		}
 
-		e.filename = filename
-
 		// Ensure directory exists for new filename
-		dir := filepath.Dir(e.filename)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory: %v", err)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := e.claimNewName(filename); err != nil {
+			return err
 		}
 	}
-	return e.saveFile()
+	if err := e.saveFile(); err != nil {
+		if errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS) {
+			return e.saveElsewhere(err)
+		}
+		return err
+	}
+	e.statusMessage = fmt.Sprintf("Saved %s lines to %s", groupDigits(len(e.lines)), filepath.Base(e.filename))
+	e.reportError("Writing log", e.logWriting(time.Now()))
+	return nil
 }
 
+// groupDigits formats a count with commas between groups of three digits
+func groupDigits(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
 
 func (e *Editor) pushUndoState() {
 	// Make a deep copy of lines to store in undoStack
 	linesCopy := make([]string, len(e.lines))
 	copy(linesCopy, e.lines)
-	e.undoStack = append(e.undoStack, linesCopy)
-
-	// Limit undo stack size to prevent unbounded memory growth
-	if len(e.undoStack) > maxUndoStates {
-		// Remove oldest state (first element)
-		e.undoStack = e.undoStack[1:]
+	state := undoState{
+		lines:   linesCopy,
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+		offsetY: e.offsetY,
+		time:    time.Now(),
+		size:    linesSize(linesCopy),
 	}
+	e.undoStack = append(e.undoStack, state)
+	e.undoBytes += state.size
+	e.editCount++
+
+	// Limit undo history to the memory budget so large chunks don't balloon RAM
+	e.undoStack, e.undoBytes = trimHistory(e.undoStack, e.undoBytes, e.undoBudget)
 
 	// Clear redo stack when a new action is performed
-	e.redoStack = [][]string{}
+	e.redoStack = []undoState{}
+	e.redoBytes = 0
+}
+
+// stateAt copies the current lines into an undo entry carrying the given position
+func (e *Editor) stateAt(position undoState) undoState {
+	linesCopy := make([]string, len(e.lines))
+	copy(linesCopy, e.lines)
+	return undoState{
+		lines:   linesCopy,
+		cursorX: position.cursorX,
+		cursorY: position.cursorY,
+		offsetY: position.offsetY,
+		time:    time.Now(),
+		size:    linesSize(linesCopy),
+	}
 }
 
 func (e *Editor) undo() {
+	// The bottom entry is the state the buffer was loaded with and is never popped
 	if len(e.undoStack) > 1 {
+		// The top entry was pushed right before the latest change, so it holds
+		// the lines to go back to and the cursor where the change happened
+		previousState := e.undoStack[len(e.undoStack)-1]
+		e.undoStack = e.undoStack[:len(e.undoStack)-1]
+		e.undoBytes -= previousState.size
+
 		// Save current state (what we're moving away from) to redo stack
 		// This allows us to redo this change later
-		currentLines := make([]string, len(e.lines))
-		copy(currentLines, e.lines)
-		e.redoStack = append(e.redoStack, currentLines)
+		redoState := e.stateAt(previousState)
+		e.redoStack = append(e.redoStack, redoState)
+		e.redoBytes += redoState.size
 
-		// Limit redo stack size as well
-		if len(e.redoStack) > maxUndoStates {
-			e.redoStack = e.redoStack[1:]
-		}
+		// Limit redo history to the same budget
+		e.redoStack, e.redoBytes = trimHistory(e.redoStack, e.redoBytes, e.undoBudget)
 
-		// Pop and load previous state from undo stack
-		e.undoStack = e.undoStack[:len(e.undoStack)-1]
-		previousState := e.undoStack[len(e.undoStack)-1]
-		e.lines = make([]string, len(previousState))
-		copy(e.lines, previousState)
+		// Load the previous state
+		e.lines = make([]string, len(previousState.lines))
+		copy(e.lines, previousState.lines)
 		e.invalidateWordCount()
 
 		e.modified = true
-		// Adjust cursor position if necessary
-		e.adjustCursorPosition()
+		e.restoreUndoPosition(previousState)
 	}
 }
 
 func (e *Editor) redo() {
 	if len(e.redoStack) > 0 {
-		// Pop state from redo stack and move it back to undo stack
-		// This restores the state that was previously undone
+		// Pop state from redo stack and put the current state back on the undo
+		// stack, so the redone change can be undone again
 		nextState := e.redoStack[len(e.redoStack)-1]
 		e.redoStack = e.redoStack[:len(e.redoStack)-1]
-		e.undoStack = append(e.undoStack, nextState)
+		e.redoBytes -= nextState.size
+
+		undoState := e.stateAt(nextState)
+		e.undoStack = append(e.undoStack, undoState)
+		e.undoBytes += undoState.size
 
 		// Load the state
-		e.lines = make([]string, len(nextState))
-		copy(e.lines, nextState)
+		e.lines = make([]string, len(nextState.lines))
+		copy(e.lines, nextState.lines)
 		e.invalidateWordCount()
 
 		e.modified = true
-		// Adjust cursor position if necessary
-		e.adjustCursorPosition()
+		e.restoreUndoPosition(nextState)
 	}
 }
 
+// restoreUndoPosition moves the cursor and viewport back to where an undo entry was
+// recorded, then clamps everything to the restored lines.
+func (e *Editor) restoreUndoPosition(state undoState) {
+	e.clearSelection()
+	e.cursorX = state.cursorX
+	e.cursorY = state.cursorY
+	e.offsetY = state.offsetY
+	e.adjustCursorPosition()
+	e.ensureCursorVisible()
+}
+
 func (e *Editor) adjustCursorPosition() {
 	// Ensure cursorY is within bounds
 	if e.cursorY >= len(e.lines) {
@@ -340,6 +520,7 @@ func (e *Editor) scroll() {
 
 func (e *Editor) invalidateWordCount() {
 	e.wordCountValid = false
+	e.readabilityValid = false
 }
 
 func (e *Editor) wordCount() int {
@@ -347,17 +528,29 @@ func (e *Editor) wordCount() int {
 		return e.cachedWordCount
 	}
 
-	count := 0
-	for _, line := range e.lines {
-		fields := strings.Fields(line) // Splits by whitespace
-		count += len(fields)
+	count, controls := 0, 0
+	math, _, _ := scanMath(e.lines, codeLiteral(e.lines))
+	for y, line := range e.lines {
+		count += countWordsOutside(line, math[y])
+		for _, r := range line {
+			if controlPlaceholder(r) != "" {
+				controls++
+			}
+		}
 	}
 
 	e.cachedWordCount = count
+	e.cachedControlChars = controls
 	e.wordCountValid = true
 	return count
 }
 
+// controlCharCount returns how many control characters the document holds
+func (e *Editor) controlCharCount() int {
+	e.wordCount()
+	return e.cachedControlChars
+}
+
 func (e *Editor) isWordChar(ch rune) bool {
 	return isWordRune(ch)
 }
@@ -419,6 +612,258 @@ func (e *Editor) moveWordRight() {
 	}
 }
 
+// transposeChars swaps the characters before and at the cursor and moves past
+// them, or at the end of a line swaps the last two, fixing typos like "teh"
+func (e *Editor) transposeChars() {
+	runes := []rune(e.lines[e.cursorY])
+	x := e.cursorX
+	if x >= len(runes) {
+		x = prevGrapheme(runes, len(runes))
+	}
+	if x == 0 || x >= len(runes) {
+		e.statusMessage = "No characters to swap"
+		return
+	}
+	start, end := prevGrapheme(runes, x), graphemeEnd(runes, x)
+
+	e.pushUndoState()
+	e.clearSearch()
+	line := e.lines[e.cursorY]
+	e.lines[e.cursorY] = runeSubstring(line, 0, start) + runeSubstring(line, x, end) + runeSubstring(line, start, x) + runeSubstring(line, end, len(runes))
+	e.cursorX = end
+	e.modified = true
+	e.ensureCursorVisible()
+}
+
+// transposeWords swaps the word at or before the cursor with the next word on
+// the line, keeping what lies between them, and moves past both
+func (e *Editor) transposeWords() {
+	runes := []rune(e.lines[e.cursorY])
+	wordAt := func(i int) bool { return i < len(runes) && e.isWordChar(runes[i]) }
+
+	// Find the first word: the one under the cursor, or else the one before it
+	start := min(e.cursorX, len(runes))
+	if !wordAt(start) {
+		for start > 0 && !wordAt(start-1) {
+			start--
+		}
+		if start == 0 {
+			start = e.cursorX
+			for start < len(runes) && !wordAt(start) {
+				start++
+			}
+		}
+	}
+	for start > 0 && wordAt(start-1) {
+		start--
+	}
+	end := start
+	for wordAt(end) {
+		end++
+	}
+
+	// Then the word after it
+	next := end
+	for next < len(runes) && !wordAt(next) {
+		next++
+	}
+	nextEnd := next
+	for wordAt(nextEnd) {
+		nextEnd++
+	}
+	if end == start || nextEnd == next {
+		e.statusMessage = "No words to swap"
+		return
+	}
+
+	e.pushUndoState()
+	e.clearSearch()
+	line := e.lines[e.cursorY]
+	e.lines[e.cursorY] = runeSubstring(line, 0, start) + runeSubstring(line, next, nextEnd) + runeSubstring(line, end, next) + runeSubstring(line, start, end) + runeSubstring(line, nextEnd, len(runes))
+	e.cursorX = nextEnd
+	e.modified = true
+	e.ensureCursorVisible()
+}
+
+// moveParagraph moves the cursor past the next (delta 1) or previous (delta -1)
+// paragraph to the blank line beyond it, or to the end or start of the document
+// when there is none
+func (e *Editor) moveParagraph(delta int) {
+	if e.cursorY >= len(e.lines) {
+		return
+	}
+	blank := func(y int) bool {
+		return strings.TrimSpace(e.lines[y]) == ""
+	}
+
+	// Skip blank lines, then the paragraph's lines
+	y := e.cursorY
+	for y >= 0 && y < len(e.lines) && blank(y) {
+		y += delta
+	}
+	for y >= 0 && y < len(e.lines) && !blank(y) {
+		y += delta
+	}
+
+	switch {
+	case y < 0:
+		e.cursorY, e.cursorX = 0, 0
+	case y >= len(e.lines):
+		e.cursorY = len(e.lines) - 1
+		e.cursorX = runeLen(e.lines[e.cursorY])
+	default:
+		e.cursorY, e.cursorX = y, 0
+	}
+}
+
+// bracketPairs maps each bracket to its partner; backticks pair with themselves
+var bracketPairs = map[rune]rune{
+	'(': ')', ')': '(',
+	'[': ']', ']': '[',
+	'{': '}', '}': '{',
+	'`': '`',
+}
+
+// isCodeFence reports whether a line opens or closes a fenced code block
+func isCodeFence(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, " \t"), "```")
+}
+
+// bracketAtCursor returns the position of the bracket under the cursor, falling back
+// to the one just before it so a freshly typed closer still finds its partner.
+func (e *Editor) bracketAtCursor() (x, y int, ok bool) {
+	if e.cursorY >= len(e.lines) {
+		return 0, 0, false
+	}
+	runes := []rune(e.lines[e.cursorY])
+	for _, cx := range []int{e.cursorX, e.cursorX - 1} {
+		if cx >= 0 && cx < len(runes) {
+			if _, isBracket := bracketPairs[runes[cx]]; isBracket {
+				return cx, e.cursorY, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// findMatchingBracket finds the partner of the bracket at (x, y), scanning at most
+// maxLines lines away. Code fence lines match the fence that opens or closes them.
+func (e *Editor) findMatchingBracket(x, y, maxLines int) (int, int, bool) {
+	runes := []rune(e.lines[y])
+	ch := runes[x]
+
+	if ch == '`' {
+		// The three backticks of a fence match the other fence of the code block
+		indent := runeLen(e.lines[y]) - runeLen(strings.TrimLeft(e.lines[y], " \t"))
+		if isCodeFence(e.lines[y]) && x < indent+3 {
+			return e.findMatchingFence(y)
+		}
+		return findMatchingBacktick(runes, x, y)
+	}
+
+	partner := bracketPairs[ch]
+	forward := ch == '(' || ch == '[' || ch == '{'
+	depth := 0
+
+	for ly := y; ly >= 0 && ly < len(e.lines) && abs(ly-y) <= maxLines; {
+		lr := []rune(e.lines[ly])
+		start, end, step := 0, len(lr), 1
+		if !forward {
+			start, end, step = len(lr)-1, -1, -1
+		}
+		if ly == y {
+			start = x
+		}
+		for lx := start; lx != end; lx += step {
+			switch lr[lx] {
+			case ch:
+				depth++
+			case partner:
+				depth--
+				if depth == 0 {
+					return lx, ly, true
+				}
+			}
+		}
+		if forward {
+			ly++
+		} else {
+			ly--
+		}
+	}
+	return 0, 0, false
+}
+
+// findMatchingBacktick pairs inline code backticks on a single line by parity
+func findMatchingBacktick(runes []rune, x, y int) (int, int, bool) {
+	before := 0
+	for i := 0; i < x; i++ {
+		if runes[i] == '`' {
+			before++
+		}
+	}
+	if before%2 == 0 {
+		for i := x + 1; i < len(runes); i++ {
+			if runes[i] == '`' {
+				return i, y, true
+			}
+		}
+	} else {
+		for i := x - 1; i >= 0; i-- {
+			if runes[i] == '`' {
+				return i, y, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// findMatchingFence pairs code fence lines in document order (1st with 2nd, 3rd with 4th...)
+func (e *Editor) findMatchingFence(y int) (int, int, bool) {
+	fences := []int{}
+	for i, line := range e.lines {
+		if isCodeFence(line) {
+			fences = append(fences, i)
+		}
+	}
+	for i, fy := range fences {
+		if fy != y {
+			continue
+		}
+		partner := i + 1
+		if i%2 == 1 {
+			partner = i - 1
+		}
+		if partner < len(fences) {
+			py := fences[partner]
+			indent := runeLen(e.lines[py]) - runeLen(strings.TrimLeft(e.lines[py], " \t"))
+			return indent, py, true
+		}
+	}
+	return 0, 0, false
+}
+
+// jumpToMatchingBracket moves the cursor to the partner of the bracket at the cursor
+func (e *Editor) jumpToMatchingBracket() {
+	x, y, ok := e.bracketAtCursor()
+	if !ok {
+		return
+	}
+	if mx, my, found := e.findMatchingBracket(x, y, len(e.lines)); found {
+		e.clearSelection()
+		e.cursorX = mx
+		e.cursorY = my
+		e.ensureCursorVisible()
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
 func (e *Editor) clearSearch() {
 	e.searchTerm = ""
 }
@@ -481,6 +926,7 @@ func (e *Editor) findNext() {
 			}
 		}
 	}
+	e.statusMessage = fmt.Sprintf("No matches for %q", e.searchTerm)
 }
 
 // findPrev moves the cursor to the previous occurrence of the current search term,
@@ -522,8 +968,8 @@ func (e *Editor) findPrev() {
 		}
 	}
 
-	// Wrap: search from bottom up to original line
-	for y := len(e.lines) - 1; y > startY; y-- {
+	// Wrap: search from bottom up to and including the original line
+	for y := len(e.lines) - 1; y >= startY; y-- {
 		line := e.lines[y]
 		lowerLine := strings.ToLower(line)
 		lowerSearch := strings.ToLower(e.searchTerm)
@@ -534,10 +980,46 @@ func (e *Editor) findPrev() {
 			return
 		}
 	}
+	e.statusMessage = fmt.Sprintf("No matches for %q", e.searchTerm)
+}
+
+// searchWordAtCursor makes the word under (or just before) the cursor the search
+// term and moves to its next (delta 1) or previous (delta -1) occurrence, like
+// vim's * and #
+func (e *Editor) searchWordAtCursor(delta int) {
+	if e.cursorY >= len(e.lines) {
+		return
+	}
+	runes := []rune(e.lines[e.cursorY])
+	start := min(e.cursorX, len(runes))
+	if (start == len(runes) || !e.isWordChar(runes[start])) && start > 0 && e.isWordChar(runes[start-1]) {
+		start--
+	}
+	if start == len(runes) || !e.isWordChar(runes[start]) {
+		e.statusMessage = "No word at cursor"
+		return
+	}
+	for start > 0 && e.isWordChar(runes[start-1]) {
+		start--
+	}
+	end := start
+	for end < len(runes) && e.isWordChar(runes[end]) {
+		end++
+	}
+
+	e.clearSelection()
+	e.searchTerm = string(runes[start:end])
+	e.cursorX = start
+	if delta > 0 {
+		e.findNext()
+	} else {
+		e.findPrev()
+	}
+	e.statusMessage = "Search: " + e.searchTerm
 }
 
 func (e *Editor) search() {
-	searchTerm := e.prompt("Search: ")
+	searchTerm := e.promptWithHistory("search", "Search: ")
 	if searchTerm == "" {
 		return
 	}
@@ -550,105 +1032,208 @@ func (e *Editor) search() {
 // As the user types, matches are highlighted and the cursor jumps to the next match.
 func (e *Editor) searchIncremental() {
 	// Seed with the current term so F4 can refine an existing search
-	input := []rune(e.searchTerm)
-	style := tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite)
+	line := newPromptLine(e.searchTerm).withHistory(e.promptHistoryOf("search"))
+	style := e.theme.prompt
 
-	redraw := func(resetToFirst bool) {
-		e.searchTerm = string(input)
-		// When term changes, reset to first occurrence
-		if resetToFirst && e.searchTerm != "" {
+	wrapped := false
+
+	// show draws the document with its highlights and the prompt over the status
+	// bar, with the match position on the right, in red when nothing matches
+	show := func() {
+		e.draw()
+		prompt := "Search (inc): " + line.String()
+		hint, hintStyle := "", style
+		if e.searchTerm != "" {
+			current, total := e.searchMatchPosition()
+			switch {
+			case total == 0:
+				hint, hintStyle = "no match", e.theme.removed
+			case current > 0:
+				hint = fmt.Sprintf("match %d of %d", current, total)
+			default:
+				hint = fmt.Sprintf("%d matches", total)
+			}
+			if wrapped && total > 0 {
+				hint += ", wrapped"
+			}
+		}
+		e.fillRow(e.height-1, hintStyle)
+		e.drawText(0, e.height-1, prompt, hintStyle)
+		if x := e.fullWidth() - displayWidth(hint) - 1; hint != "" && x > displayWidth(prompt)+1 {
+			e.drawText(x, e.height-1, hint, hintStyle)
+		}
+		e.showPromptCursor("Search (inc): " + line.beforeCursor())
+		e.screen.Show()
+	}
+
+	// step moves to the next match, or the previous one, noting whether the
+	// search went round the end of the document
+	step := func(back bool) {
+		y, x := e.cursorY, e.cursorX
+		if back {
+			e.findPrev()
+			wrapped = e.cursorY > y || (e.cursorY == y && e.cursorX >= x)
+		} else {
+			e.findNext()
+			wrapped = e.cursorY < y || (e.cursorY == y && e.cursorX <= x)
+		}
+	}
+
+	// search jumps to the first match of the term as typed
+	search := func() {
+		e.searchTerm = line.String()
+		if e.searchTerm != "" {
 			e.cursorY = 0
 			e.cursorX = -1 // so findNext starts from index 0
 			e.findNext()
+			wrapped = false
 		}
-		// Redraw full screen to show highlights
-		e.draw()
-		// Overlay the prompt
-		prompt := "Search (inc): " + e.searchTerm
-		e.drawText(0, e.height-1, prompt, style)
-		e.screen.Show()
 	}
 
-	redraw(true)
+	search()
+	e.modal(show, func(ev *tcell.EventKey) bool {
+		switch ev.Key() {
+		case tcell.KeyTAB, tcell.KeyF3:
+			step(ev.Modifiers()&tcell.ModShift != 0)
+		case tcell.KeyBacktab:
+			// Shift+Tab often comes as KeyBacktab
+			step(true)
+		case tcell.KeyEscape:
+			// Clear highlights and exit
+			e.rememberPrompt("search", line.String())
+			e.clearSearch()
+			e.statusMessage = ""
+			e.draw()
+			return true
+		default:
+			// Editing the term searches again from the top; moving
+			// within it does not
+			before := line.String()
+			if line.edit(ev) && line.String() != before {
+				search()
+			}
+		}
+		return false
+	})
+}
 
-	for {
-		ev := e.screen.PollEvent()
-		switch tev := ev.(type) {
-		case *tcell.EventKey:
-			switch tev.Key() {
-			case tcell.KeyTAB:
-				if tev.Modifiers()&tcell.ModShift != 0 {
-					e.findPrev()
-				} else {
-					e.findNext()
-				}
-				// Keep prompt visible
-				e.draw() // redraw full screen to update highlights/cursor
-				prompt := "Search (inc): " + string(input)
-				e.drawText(0, e.height-1, prompt, style)
-				e.screen.Show()
-			case tcell.KeyBacktab:
-				// Shift+Tab often comes as KeyBacktab
-				e.findPrev()
-				e.draw()
-				prompt := "Search (inc): " + string(input)
-				e.drawText(0, e.height-1, prompt, style)
-				e.screen.Show()
-			case tcell.KeyEscape:
-				// Clear highlights and exit
-				e.clearSearch()
-				e.draw()
-				return
-			case tcell.KeyBackspace, tcell.KeyBackspace2:
-				if len(input) > 0 {
-					input = input[:len(input)-1]
-				}
-				redraw(true)
-			case tcell.KeyF3:
-				// Find next occurrence
-				e.findNext()
-				// Keep prompt visible
-				e.draw()
-				prompt := "Search (inc): " + string(input)
-				e.drawText(0, e.height-1, prompt, style)
-				e.screen.Show()
-			case tcell.KeyRune:
-				// Regular typed character extends the term
-				input = append(input, tev.Rune())
-				redraw(true)
-			default:
-				// ignore others
+// searchMatchPosition counts the occurrences of the search term in the buffer,
+// ignoring case as the search does, and returns which of them, from 1, starts at
+// the cursor (0 when none does) and how many there are
+func (e *Editor) searchMatchPosition() (current, total int) {
+	term := strings.ToLower(e.searchTerm)
+	if term == "" {
+		return 0, 0
+	}
+	for y, line := range e.lines {
+		lower := strings.ToLower(line)
+		for offset := 0; ; {
+			idx := strings.Index(lower[offset:], term)
+			if idx < 0 {
+				break
+			}
+			total++
+			offset += idx
+			if y == e.cursorY && offset <= len(line) && utf8.RuneCountInString(line[:offset]) == e.cursorX {
+				current = total
 			}
+			offset += len(term)
 		}
 	}
+	return current, total
 }
 
 func (e *Editor) goToLine() {
-	lineStr := e.prompt("Go to line: ")
+	lineStr := strings.TrimSpace(e.promptWithHistory("goto", "Go to line (or N%): "))
 	if lineStr == "" {
 		return
 	}
+	if percent, ok := strings.CutSuffix(lineStr, "%"); ok {
+		e.goToPercent(percent)
+		return
+	}
 
-	// Try to parse the line number
-	var lineNum int
-	if _, err := fmt.Sscanf(lineStr, "%d", &lineNum); err != nil {
-		return // Invalid number, just return
+	// Lines are numbered over the whole file, so a large file loads the
+	// chunk holding the line
+	_, total := e.scrollbarSpan()
+	current := e.currentChunk*e.maxLines + e.cursorY + 1
+	line, col, err := parseLineTarget(lineStr, current)
+	if err != nil {
+		e.statusMessage = "Go to line: " + err.Error()
+		return
+	}
+	if line > total {
+		e.statusMessage = fmt.Sprintf("Line %d is past the end; went to the last line, %d", line, total)
 	}
+	e.goToFileLine(max(1, min(line, total)), col)
+}
 
-	// Convert to 0-based indexing and validate
-	lineNum-- // Convert from 1-based to 0-based
-	if lineNum < 0 {
-		lineNum = 0
+// parseLineTarget reads a go-to-line answer: a line number, or +N/-N lines from
+// the current line, optionally followed by :column. The column is 1 when not
+// given.
+func parseLineTarget(text string, current int) (line, col int, err error) {
+	lineText, colText, hasCol := strings.Cut(text, ":")
+	lineText = strings.TrimSpace(lineText)
+	digits := strings.TrimLeft(lineText, "+-")
+	if len(lineText)-len(digits) > 1 {
+		return 0, 0, fmt.Errorf("not a line number: %q", lineText)
+	}
+	line, err = strconv.Atoi(digits)
+	if err != nil {
+		return 0, 0, fmt.Errorf("not a line number: %q", lineText)
 	}
-	if lineNum >= len(e.lines) {
-		lineNum = len(e.lines) - 1
+	switch lineText[0] {
+	case '+':
+		line = current + line
+	case '-':
+		line = current - line
 	}
 
-	// Move cursor to the line
-	e.cursorY = lineNum
-	e.cursorX = 0
+	col = 1
+	if hasCol {
+		col, err = strconv.Atoi(strings.TrimSpace(colText))
+		if err != nil || col < 1 {
+			return 0, 0, fmt.Errorf("not a column: %q", colText)
+		}
+	}
+	return line, col, nil
+}
+
+// goToPercent moves the cursor to a position given as a percentage of the
+// document, measured over the whole file when it is loaded in chunks: 0% is the
+// first line, 50% the middle and 100% the last. The chunk holding the line is
+// loaded if need be.
+func (e *Editor) goToPercent(text string) {
+	percent, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || math.IsNaN(percent) {
+		e.statusMessage = fmt.Sprintf("Not a percentage: %q", text+"%")
+		return
+	}
+	percent = max(0, min(percent, 100))
+	_, total := e.scrollbarSpan()
+	e.goToFileLine(1+int(math.Round(percent/100*float64(total-1))), 1)
+}
+
+// goToFileLine moves the cursor to line and col of the whole file, both counted
+// from 1, loading the chunk holding the line (offering to save the one being
+// left) when the file is loaded in chunks
+func (e *Editor) goToFileLine(line, col int) {
+	chunk := e.currentChunk
+	if target := (line - 1) / e.maxLines; target != chunk {
+		if err := e.saveBeforeLeavingChunk(); err != nil {
+			e.reportError("Save", err)
+			return
+		}
+	}
+	from := e.offsetY
 	e.clearSelection()
-	e.ensureCursorVisible()
+	if err := e.openAt(line, col); err != nil {
+		e.reportError("Loading the chunk", err)
+		return
+	}
+	if e.currentChunk == chunk {
+		e.smoothScroll(from)
+	}
 }
 
 func (e *Editor) startSelection() {
@@ -659,14 +1244,82 @@ func (e *Editor) startSelection() {
 	}
 }
 
+// startBlockSelection starts (or converts the active selection into) a rectangular
+// column selection anchored at the current selection start.
+func (e *Editor) startBlockSelection() {
+	e.startSelection()
+	e.blockSelection = true
+}
+
 func (e *Editor) clearSelection() {
 	e.selectionStart = false
+	e.blockSelection = false
+}
+
+// selectedLines returns the range of lines touched by the selection, or the
+// cursor line when nothing is selected
+func (e *Editor) selectedLines() (startY, endY int) {
+	if !e.selectionStart {
+		return e.cursorY, e.cursorY
+	}
+	startY, endY = e.selectionStartY, e.cursorY
+	if startY > endY {
+		startY, endY = endY, startY
+	}
+	return startY, endY
+}
+
+// blockBounds returns the rune column range and line range covered by a block selection
+func (e *Editor) blockBounds() (startX, endX, startY, endY int) {
+	startX, endX = e.selectionStartX, e.cursorX
+	startY, endY = e.selectionStartY, e.cursorY
+	if startX > endX {
+		startX, endX = endX, startX
+	}
+	if startY > endY {
+		startY, endY = endY, startY
+	}
+	if endY >= len(e.lines) {
+		endY = len(e.lines) - 1
+	}
+	return startX, endX, startY, endY
+}
+
+// getBlockText returns the text covered by a block selection, one line per selected row
+func (e *Editor) getBlockText() string {
+	startX, endX, startY, endY := e.blockBounds()
+
+	var result strings.Builder
+	for y := startY; y <= endY; y++ {
+		result.WriteString(runeSubstring(e.lines[y], startX, endX))
+		if y < endY {
+			result.WriteString("\n")
+		}
+	}
+	return result.String()
+}
+
+// deleteBlockSelection removes the selected columns from every line in the block
+func (e *Editor) deleteBlockSelection() {
+	startX, endX, startY, endY := e.blockBounds()
+
+	for y := startY; y <= endY; y++ {
+		e.lines[y] = runeDelete(e.lines[y], startX, endX)
+	}
+
+	e.cursorX = startX
+	e.cursorY = startY
+	e.clearSelection()
+	e.modified = true
 }
 
 func (e *Editor) getSelectedText() string {
 	if !e.selectionStart {
 		return ""
 	}
+	if e.blockSelection {
+		return e.getBlockText()
+	}
 
 	startX, startY := e.selectionStartX, e.selectionStartY
 	endX, endY := e.cursorX, e.cursorY
@@ -735,6 +1388,11 @@ func (e *Editor) deleteSelection() {
 	e.clearSearch()
 	e.invalidateWordCount()
 
+	if e.blockSelection {
+		e.deleteBlockSelection()
+		return
+	}
+
 	startX, startY := e.selectionStartX, e.selectionStartY
 	endX, endY := e.cursorX, e.cursorY
 
@@ -801,13 +1459,19 @@ func (e *Editor) copy() {
 		return
 	}
 	e.clipboard = e.getSelectedText()
+	e.clipboardBlock = e.blockSelection
 }
 
 func (e *Editor) cut() {
 	if !e.selectionStart {
 		return
 	}
-	e.clipboard = e.getSelectedText()
+	if e.blockSelection {
+		e.kill(e.getSelectedText(), 0)
+	} else {
+		e.kill(e.getSelectedText(), 1)
+	}
+	e.clipboardBlock = e.blockSelection
 	e.deleteSelection()
 }
 
@@ -824,6 +1488,11 @@ func (e *Editor) paste() {
 		e.deleteSelection()
 	}
 
+	if e.clipboardBlock {
+		e.pasteBlock()
+		return
+	}
+
 	// Insert clipboard content
 	lines := strings.Split(e.clipboard, "\n")
 	if len(lines) == 1 {
@@ -866,6 +1535,31 @@ func (e *Editor) paste() {
 	e.ensureCursorVisible()
 }
 
+// pasteBlock inserts each line of a block clipboard onto successive buffer lines
+// at the cursor column, padding short lines and appending lines past the end.
+func (e *Editor) pasteBlock() {
+	e.invalidateWordCount()
+	lines := strings.Split(e.clipboard, "\n")
+	col := e.cursorX
+
+	for i, text := range lines {
+		y := e.cursorY + i
+		if y >= len(e.lines) {
+			e.lines = append(e.lines, "")
+		}
+		line := e.lines[y]
+		if pad := col - runeLen(line); pad > 0 {
+			line += strings.Repeat(" ", pad)
+		}
+		e.lines[y] = runeInsert(line, col, text)
+	}
+
+	e.cursorY += len(lines) - 1
+	e.cursorX = col + runeLen(lines[len(lines)-1])
+	e.modified = true
+	e.ensureCursorVisible()
+}
+
 func (e *Editor) insertChar(ch rune) {
 	e.pushUndoState()
 	e.clearSearch()
@@ -888,6 +1582,61 @@ func (e *Editor) insertChar(ch rune) {
 	e.ensureCursorVisible()
 }
 
+// typeChar enters a typed character: inserted, or in overtype mode replacing the
+// character under the cursor. Brackets, quotes, emphasis and code fences are
+// closed when auto-pairing is on, smart punctuation applies either way, and a
+// word boundary expands the abbreviation before it.
+func (e *Editor) typeChar(r rune) {
+	replace := e.overtype && e.cursorY < len(e.lines) && e.cursorX < runeLen(e.lines[e.cursorY])
+	switch {
+	case e.autoPair && e.pairChar(r):
+	case e.smartPunctuation:
+		e.insertSmartChar(r)
+	default:
+		e.insertChar(r)
+	}
+	if replace {
+		// The character that was under the cursor now follows it
+		line := e.lines[e.cursorY]
+		e.lines[e.cursorY] = runeDelete(line, e.cursorX, graphemeEnd([]rune(line), e.cursorX))
+	}
+	if e.abbreviate && !isWordRune(r) {
+		e.expandAbbreviation(e.cursorY, e.cursorX-1)
+	}
+	if e.autoPair && r == '`' {
+		e.closeFence()
+	}
+}
+
+// toggleOvertype switches between inserting and overtyping, with a block cursor
+// for overtype where the terminal supports cursor shapes
+func (e *Editor) toggleOvertype() {
+	e.overtype = !e.overtype
+	if e.overtype {
+		e.screen.SetCursorStyle(tcell.CursorStyleSteadyBlock)
+		e.statusMessage = "Overtype"
+	} else {
+		e.screen.SetCursorStyle(tcell.CursorStyleDefault)
+		e.statusMessage = "Insert"
+	}
+}
+
+// insertTab indents at the cursor: a tab character when the buffer uses tabs,
+// otherwise spaces up to the next indent stop
+func (e *Editor) insertTab() {
+	if e.useTabs {
+		e.insertChar('\t')
+		return
+	}
+	col := 0
+	if e.cursorY < len(e.lines) {
+		col = e.displayColumn(e.lines[e.cursorY], e.cursorX)
+	}
+	for i := col % e.tabWidth; i < e.tabWidth; i++ {
+		e.insertChar(' ')
+	}
+}
+
 func (e *Editor) insertNewline() {
 	e.pushUndoState()
 	e.clearSearch()
@@ -940,8 +1689,9 @@ func (e *Editor) backspace() {
 	if e.cursorX > 0 {
 		// Delete character before cursor using rune-aware operation
 		line := e.lines[e.cursorY]
-		e.lines[e.cursorY] = runeDelete(line, e.cursorX-1, e.cursorX)
-		e.cursorX--
+		start := prevGrapheme([]rune(line), e.cursorX)
+		e.lines[e.cursorY] = runeDelete(line, start, e.cursorX)
+		e.cursorX = start
 		e.modified = true
 	} else if e.cursorY > 0 {
 		// Join with previous line
@@ -971,7 +1721,7 @@ func (e *Editor) delete() {
 		lineRunes := []rune(line)
 		if e.cursorX < len(lineRunes) {
 			// Delete character at cursor position using rune-aware operation
-			e.lines[e.cursorY] = runeDelete(line, e.cursorX, e.cursorX+1)
+			e.lines[e.cursorY] = runeDelete(line, e.cursorX, graphemeEnd(lineRunes, e.cursorX))
 			e.modified = true
 		} else if e.cursorY < len(e.lines)-1 {
 			// At end of line, join with next line
@@ -989,11 +1739,158 @@ func (e *Editor) delete() {
 	e.ensureCursorVisible()
 }
 
+// deleteWordLeft cuts back to where moveWordLeft would take the cursor (the
+// start of the word, or the end of the previous line) as a single undo step
+func (e *Editor) deleteWordLeft() {
+	x, y := e.cursorX, e.cursorY
+	e.moveWordLeft()
+	e.killFrom(x, y, -1)
+}
+
+// deleteWordRight cuts forward to where moveWordRight would take the cursor
+// (the start of the next word, or the start of the next line) as a single undo
+// step
+func (e *Editor) deleteWordRight() {
+	x, y := e.cursorX, e.cursorY
+	e.moveWordRight()
+	e.killFrom(x, y, 1)
+}
+
+// deleteFrom deletes the text between x, y and the cursor, if any
+func (e *Editor) deleteFrom(x, y int) {
+	if x == e.cursorX && y == e.cursorY {
+		return
+	}
+	e.selectionStart = true
+	e.blockSelection = false
+	e.selectionStartX, e.selectionStartY = x, y
+	e.deleteSelection()
+	e.ensureCursorVisible()
+}
+
+// killLineEnd moves the text from the cursor to the end of the line into the
+// clipboard, or the line break when the cursor is at the end already, so Ctrl+V
+// puts it back
+func (e *Editor) killLineEnd() {
+	x, y := e.cursorX, e.cursorY
+	switch {
+	case x < runeLen(e.lines[y]):
+		e.cursorX = runeLen(e.lines[y])
+	case y < len(e.lines)-1:
+		e.cursorY, e.cursorX = y+1, 0
+	default:
+		return
+	}
+	e.killFrom(x, y, 1)
+}
+
+// killLineStart moves the text from the start of the line to the cursor into
+// the clipboard, or the line break before it when the cursor is at the start
+func (e *Editor) killLineStart() {
+	x, y := e.cursorX, e.cursorY
+	switch {
+	case x > 0:
+		e.cursorX = 0
+	case y > 0:
+		e.cursorY, e.cursorX = y-1, runeLen(e.lines[y-1])
+	default:
+		return
+	}
+	e.killFrom(x, y, -1)
+}
+
+// killFrom cuts the text between x, y and the cursor, which moved forward
+// (direction 1) or back (-1) to mark it
+func (e *Editor) killFrom(x, y, direction int) {
+	if x == e.cursorX && y == e.cursorY {
+		return
+	}
+	e.selectionStart = true
+	e.blockSelection = false
+	e.selectionStartX, e.selectionStartY = x, y
+	e.kill(e.getSelectedText(), direction)
+	e.deleteFrom(x, y)
+}
+
+// killRingSize is how many cuts the kill ring keeps
+const killRingSize = 30
+
+// kill records text cut from the buffer in the kill ring and puts it in the
+// clipboard. Cuts on consecutive keys join into one entry, like repeated
+// Ctrl+K in emacs: text cut forward (direction 1) goes after the entry and text
+// cut backward (-1) before it. Direction 0 always starts a new entry.
+func (e *Editor) kill(text string, direction int) {
+	if e.joinKill && direction != 0 && len(e.killRing) > 0 {
+		last := &e.killRing[len(e.killRing)-1]
+		if direction < 0 {
+			*last = text + *last
+		} else {
+			*last += text
+		}
+	} else {
+		e.killRing = append(e.killRing, text)
+		if len(e.killRing) > killRingSize {
+			e.killRing = e.killRing[1:]
+		}
+	}
+	e.clipboard = e.killRing[len(e.killRing)-1]
+	e.clipboardBlock = false
+	e.killed = direction != 0
+}
+
+// yankFromRing lists the kill ring, newest first, and pastes the chosen entry,
+// which also becomes the clipboard and the newest entry
+func (e *Editor) yankFromRing() {
+	if len(e.killRing) == 0 {
+		e.statusMessage = "Nothing cut yet"
+		return
+	}
+	items := make([]string, len(e.killRing))
+	for i, text := range e.killRing {
+		items[len(items)-1-i] = strings.ReplaceAll(text, "\n", "⏎")
+	}
+	choice := e.pickFromList("Kill ring", items, nil)
+	if choice < 0 {
+		return
+	}
+	i := len(e.killRing) - 1 - choice
+	text := e.killRing[i]
+	e.killRing = append(append(e.killRing[:i:i], e.killRing[i+1:]...), text)
+	e.clipboard = text
+	e.clipboardBlock = false
+	e.paste()
+}
+
 func (e *Editor) handleResize() {
-	e.width, e.height = e.screen.Size()
+	e.layoutText()
 	e.screen.Clear()
 }
 
+// scrollWheel scrolls for one wheel tick down (dir 1) or up (-1): by adding
+// momentum that carries the view on over the next frames, or by scroll_lines
+// lines when scroll_momentum is off. With wheel_moves_cursor the cursor moves
+// scroll_lines lines instead and the view follows it.
+func (e *Editor) scrollWheel(dir int) {
+	switch {
+	case e.config.wheelMovesCursor:
+		for range e.config.scrollLines {
+			if e.softWrap {
+				e.moveVisualRow(dir)
+			} else if y := e.cursorY + dir; y >= 0 && y < len(e.lines) {
+				e.cursorY = y
+				e.cursorX = min(e.cursorX, runeLen(e.lines[y]))
+				e.snapCursorToGrapheme()
+			}
+		}
+		e.ensureCursorVisible()
+	case !e.config.scrollMomentum:
+		maxOffset := max(0, len(e.lines)-e.height+1)
+		e.offsetY = min(max(0, e.offsetY+dir*e.config.scrollLines), maxOffset)
+	default:
+		e.addScrollMomentum(float64(dir) * e.config.scrollMultiplier)
+	}
+}
+
 // addScrollMomentum adds momentum from mouse wheel events, capped to prevent runaway scrolling
 func (e *Editor) addScrollMomentum(delta float64) {
 	e.scrollMomentum += delta
@@ -1006,8 +1903,13 @@ func (e *Editor) addScrollMomentum(delta float64) {
 	}
 }
 
-// applyScrollMomentum applies accumulated scroll momentum with decay
+// applyScrollMomentum applies accumulated scroll momentum with decay, or moves a
+// smooth scroll one frame on
 func (e *Editor) applyScrollMomentum() {
+	if e.glide != nil {
+		e.glideFrame()
+		return
+	}
 	if e.scrollMomentum == 0 {
 		return
 	}
@@ -1055,57 +1957,205 @@ func (e *Editor) applyScrollMomentum() {
 	}
 }
 
+const (
+	smoothScrollFrames = 8                     // Frames drawn for an animated jump
+	smoothScrollDelay  = 12 * time.Millisecond // Time between animation frames
+)
+
+// scrollGlide is a smooth scroll on its way to the offset to
+type scrollGlide struct {
+	to        int
+	remaining float64       // Lines still to go, halved each frame
+	frames    int           // Frames shown so far
+	stop      chan struct{} // Closed to end postScrollFrames
+}
+
+// scrollFrame is the payload of the interrupt posted for each frame of a smooth
+// scroll, so the event loop draws it between other events
+type scrollFrame struct{}
+
+// postScrollFrames asks the event loop for a frame every smoothScrollDelay until
+// stop is closed
+func postScrollFrames(screen tcell.Screen, stop chan struct{}) {
+	ticker := time.NewTicker(smoothScrollDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			screen.PostEvent(tcell.NewEventInterrupt(scrollFrame{}))
+		}
+	}
+}
+
+// smoothScroll animates the view from offset from to the current offset when
+// smooth scrolling is on, easing out like the wheel's momentum, so the reader can
+// follow a page or go-to-line jump. Long jumps animate only their last three
+// screens. The frames come through the event loop like the momentum's, so keys
+// are never held up; a key or click finishes the animation at once.
+func (e *Editor) smoothScroll(from int) {
+	to := e.offsetY
+	if e.glide != nil {
+		close(e.glide.stop)
+		e.glide = nil
+	}
+	if !e.config.smoothScroll || from == to {
+		return
+	}
+	e.scrollMomentum = 0
+	if span := 3 * (e.height - 1); abs(to-from) > span {
+		if to > from {
+			from = to - span
+		} else {
+			from = to + span
+		}
+	}
+	e.offsetY = from
+	e.glide = &scrollGlide{to: to, remaining: float64(to - from), stop: make(chan struct{})}
+	go postScrollFrames(e.screen, e.glide.stop)
+}
+
+// glideFrame moves a smooth scroll one frame on, covering half the distance left
+func (e *Editor) glideFrame() {
+	g := e.glide
+	g.frames++
+	if g.remaining *= 0.5; int(g.remaining) == 0 || g.frames >= smoothScrollFrames {
+		e.finishGlide()
+		return
+	}
+	e.offsetY = g.to - int(g.remaining)
+}
+
+// finishGlide puts the view where a smooth scroll is going, at once
+func (e *Editor) finishGlide() {
+	if e.glide == nil {
+		return
+	}
+	close(e.glide.stop)
+	e.offsetY = e.glide.to
+	e.glide = nil
+}
+
 func (e *Editor) loadNextChunk() error {
 	if !e.truncated {
 		return nil // No more chunks if file wasn't truncated
 	}
 
-	// Check if current chunk has unsaved changes
+	if err := e.saveBeforeLeavingChunk(); err != nil {
+		return err
+	}
+	return e.loadChunk(e.currentChunk + 1)
+}
+
+func (e *Editor) loadPrevChunk() error {
+	if e.currentChunk == 0 {
+		return nil // Already at first chunk
+	}
+
+	if err := e.saveBeforeLeavingChunk(); err != nil {
+		return err
+	}
+	return e.loadChunk(e.currentChunk - 1)
+}
+
+// errCancelled is returned when the user backs out of a dialog, so the command
+// that asked stops without reporting an error
+var errCancelled = errors.New("cancelled")
+
+// reportError shows in the status bar that action failed and why, in words that
+// suggest what to do about it. Nil errors and cancellations show nothing.
+func (e *Editor) reportError(action string, err error) {
+	if err == nil || errors.Is(err, errCancelled) {
+		return
+	}
+	e.statusMessage = action + " failed: " + explainError(err)
+}
+
+// explainError describes the file system errors people can act on in plain
+// words, naming the file involved; others keep their own text
+func explainError(err error) string {
+	path := ""
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		path = " (" + pathErr.Path + ")"
+	}
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return "permission denied" + path + "; check the permissions of the file and its folder"
+	case errors.Is(err, fs.ErrNotExist):
+		return "no such file or folder" + path
+	case errors.Is(err, syscall.ENOSPC):
+		return "the disk is full" + path + "; free some space and try again"
+	case errors.Is(err, syscall.EROFS):
+		return "the disk is read-only" + path
+	case errors.Is(err, bufio.ErrTooLong):
+		return fmt.Sprintf("the file has lines over %d MB", maxLineBytes/(1024*1024))
+	}
+	return err.Error()
+}
+
+// saveBeforeLeavingChunk offers to save the current chunk's unsaved changes
+// before another chunk replaces it, returning errCancelled if the user would
+// rather stay
+func (e *Editor) saveBeforeLeavingChunk() error {
 	if e.modified {
-		response := e.prompt("Save changes? (y/n): ")
-		if response == "y" {
+		switch e.confirm("Save changes before leaving this chunk?", "Save", "Discard", "Cancel") {
+		case 0:
 			if err := e.saveFile(); err != nil {
-				return fmt.Errorf("failed to save file: %v", err)
+				return fmt.Errorf("failed to save file: %w", err)
 			}
+		case 1:
+			// Continue and lose changes (same as Ctrl+C behavior)
+		default:
+			return errCancelled
 		}
-		// If "n", continue and lose changes (same as Ctrl+C behavior)
 	}
+	return nil
+}
 
+// loadChunk replaces the buffer with chunk index of the file, keeping the undo
+// history of the chunk being left. A chunk past the end of the file is ignored.
+func (e *Editor) loadChunk(index int) error {
+	if e.following() {
+		// The new lines are only ever added to the chunk at the end
+		e.stopFollowing()
+	}
 	file, err := os.Open(e.filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	split := false
+	scanner := newLineScanner(file, maxLineBytes, &split)
 	lineCount := 0
 
-	// Skip lines to get to the next chunk
-	skipLines := (e.currentChunk + 1) * e.maxLines
+	// Skip lines to get to the chunk
+	skipLines := index * e.maxLines
 	for lineCount < skipLines && scanner.Scan() {
 		lineCount++
 	}
 
-	// Load the next chunk
-	e.lines = []string{}
-	chunkLines := 0
-	hasMoreContent := false
-
-	for scanner.Scan() && chunkLines < e.maxLines {
-		e.lines = append(e.lines, scanner.Text())
-		chunkLines++
+	// Load the chunk
+	chunk := []string{}
+	for len(chunk) < e.maxLines && scanner.Scan() {
+		chunk = append(chunk, scanner.Text())
 	}
 
 	// Check if there's more content after this chunk
-	if scanner.Scan() {
-		hasMoreContent = true
-	}
+	hasMoreContent := scanner.Scan()
 
-	if len(e.lines) == 0 {
-		return nil // No more content
+	if len(chunk) == 0 {
+		if index > 0 {
+			return scanner.Err() // No more content
+		}
+		chunk = []string{""}
 	}
 
-	e.currentChunk++
+	e.stashChunkHistory()
+	e.lines = chunk
+	e.currentChunk = index
 	e.truncated = hasMoreContent
 
 	// Reset cursor to top
@@ -1113,68 +2163,129 @@ func (e *Editor) loadNextChunk() error {
 	e.cursorY = 0
 	e.offsetY = 0
 	e.offsetX = 0
+	e.foldedBlocks = nil
 	e.clearSelection()
 	e.clearSearch()
+	e.invalidateWordCount()
 
-	e.pushUndoState()
+	e.restoreChunkHistory()
+	e.recordSavedLines()
+	e.modified = false
+	e.warnInvalidUTF8()
+	if split {
+		e.warnSplitLines()
+	}
 	return scanner.Err()
 }
 
-func (e *Editor) loadPrevChunk() error {
-	if e.currentChunk == 0 {
-		return nil // Already at first chunk
+// openAt places the cursor on a 1-based line and column of the file, loading the
+// chunk that holds the line first. Out-of-range positions are clamped.
+func (e *Editor) openAt(line, col int) error {
+	if line < 1 {
+		line = 1
 	}
-
-	// Check if current chunk has unsaved changes
-	if e.modified {
-		response := e.prompt("Save changes? (y/n): ")
-		if response == "y" {
-			if err := e.saveFile(); err != nil {
-				return fmt.Errorf("failed to save file: %v", err)
-			}
+	if col < 1 {
+		col = 1
+	}
+	if chunk := (line - 1) / e.maxLines; chunk != e.currentChunk && (e.truncated || e.currentChunk > 0) {
+		if err := e.loadChunk(chunk); err != nil {
+			return err
 		}
-		// If "n", continue and lose changes (same as Ctrl+C behavior)
 	}
 
-	file, err := os.Open(e.filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	e.cursorY = line - 1 - e.currentChunk*e.maxLines
+	e.cursorX = col - 1
+	e.adjustCursorPosition()
+	e.ensureCursorVisible()
+	return nil
+}
 
-	scanner := bufio.NewScanner(file)
-	lineCount := 0
+// chunkHistory holds the undo/redo stacks of a chunk that is not currently loaded
+type chunkHistory struct {
+	undoStack []undoState
+	redoStack []undoState
+	undoBytes int
+	redoBytes int
+}
 
-	// Skip lines to get to the previous chunk
-	skipLines := (e.currentChunk - 1) * e.maxLines
-	for lineCount < skipLines && scanner.Scan() {
-		lineCount++
+// pushUndoStateIfChanged records the current lines unless they already sit on top
+// of the undo stack, avoiding no-op undo steps.
+func (e *Editor) pushUndoStateIfChanged() {
+	if len(e.undoStack) == 0 || !linesEqual(e.undoStack[len(e.undoStack)-1].lines, e.lines) {
+		e.pushUndoState()
 	}
+}
 
-	// Load the previous chunk
-	e.lines = []string{}
-	chunkLines := 0
-
-	for scanner.Scan() && chunkLines < e.maxLines {
-		e.lines = append(e.lines, scanner.Text())
-		chunkLines++
+// stashChunkHistory parks the current chunk's undo history before another chunk is
+// loaded. Unsaved lines are recorded first so edits that were discarded can still
+// be brought back with undo after returning to the chunk.
+func (e *Editor) stashChunkHistory() {
+	if e.modified {
+		e.pushUndoStateIfChanged()
 	}
+	if e.chunkHistories == nil {
+		e.chunkHistories = make(map[int]chunkHistory)
+	}
+	e.chunkHistories[e.currentChunk] = chunkHistory{
+		undoStack: e.undoStack,
+		redoStack: e.redoStack,
+		undoBytes: e.undoBytes,
+		redoBytes: e.redoBytes,
+	}
+	e.trimChunkHistories()
+}
 
-	if len(e.lines) == 0 {
-		e.lines = []string{""}
+// trimChunkHistories drops the oldest undo and redo entries of the parked chunks,
+// whichever chunk they belong to, until together they fit in one undo budget, so
+// visiting many chunks holds no more history than the loaded chunk can
+func (e *Editor) trimChunkHistories() {
+	total := 0
+	for _, history := range e.chunkHistories {
+		total += history.undoBytes + history.redoBytes
+	}
+	for total > e.undoBudget {
+		oldest, redo := -1, false
+		var when time.Time
+		for chunk, history := range e.chunkHistories {
+			if len(history.undoStack) > 0 && (oldest < 0 || history.undoStack[0].time.Before(when)) {
+				oldest, redo, when = chunk, false, history.undoStack[0].time
+			}
+			if len(history.redoStack) > 0 && (oldest < 0 || history.redoStack[0].time.Before(when)) {
+				oldest, redo, when = chunk, true, history.redoStack[0].time
+			}
+		}
+		if oldest < 0 {
+			return
+		}
+		history := e.chunkHistories[oldest]
+		if redo {
+			total -= history.redoStack[0].size
+			history.redoBytes -= history.redoStack[0].size
+			history.redoStack = history.redoStack[1:]
+		} else {
+			total -= history.undoStack[0].size
+			history.undoBytes -= history.undoStack[0].size
+			history.undoStack = history.undoStack[1:]
+		}
+		e.chunkHistories[oldest] = history
 	}
+}
 
-	e.currentChunk--
-	e.truncated = true // If we can go back, there's always more content
+// restoreChunkHistory brings back the undo history of the newly loaded chunk, or
+// starts a fresh one based on the lines just read from disk.
+func (e *Editor) restoreChunkHistory() {
+	history := e.chunkHistories[e.currentChunk]
+	delete(e.chunkHistories, e.currentChunk)
 
-	// Reset cursor to top
-	e.cursorX = 0
-	e.cursorY = 0
-	e.offsetY = 0
-	e.offsetX = 0
-	e.clearSelection()
-	e.clearSearch()
+	e.undoStack = history.undoStack
+	e.redoStack = history.redoStack
+	e.undoBytes = history.undoBytes
+	e.redoBytes = history.redoBytes
+	if e.redoStack == nil {
+		e.redoStack = []undoState{}
+	}
 
-	e.pushUndoState()
-	return scanner.Err()
+	if len(e.undoStack) == 0 {
+		e.pushUndoState()
+	}
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportFormat is an output format offered by the pandoc export command
+type exportFormat struct {
+	name string
+	ext  string
+}
+
+var exportFormats = []exportFormat{
+	{"PDF", ".pdf"},
+	{"Word (DOCX)", ".docx"},
+	{"EPUB", ".epub"},
+	{"HTML", ".html"},
+}
+
+// exportPath derives the output file for filename by swapping its extension
+func exportPath(filename, ext string) string {
+	if filename == "" {
+		return "untitled" + ext
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+}
+
+// pandocArgs builds the pandoc command line for converting markdown on stdin to
+// output. Images are resolved relative to docDir. A non-empty title fills in the
+// metadata that standalone HTML and EPUB require.
+func pandocArgs(output, docDir, title string) []string {
+	args := []string{
+		"--from=markdown",
+		"--standalone",
+		"--resource-path=" + docDir,
+		"--output=" + output,
+	}
+	if title != "" {
+		args = append(args, "--metadata=title:"+title)
+	}
+	if strings.HasSuffix(output, ".pdf") {
+		args = append(args, "--variable=geometry:margin=1in")
+	}
+	return args
+}
+
+// firstLine returns the first non-empty line of s, for compact status messages
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// exportWithPandoc converts the document to PDF, DOCX, EPUB or HTML with pandoc,
+// reporting progress and errors in the status bar.
+func (e *Editor) exportWithPandoc() {
+	if _, err := exec.LookPath("pandoc"); err != nil {
+		e.statusMessage = "Export needs pandoc (https://pandoc.org) on your PATH"
+		return
+	}
+
+	names := make([]string, len(exportFormats))
+	for i, f := range exportFormats {
+		names[i] = f.name
+	}
+	choice := e.pickFromList("Export as", names, nil)
+	if choice < 0 {
+		return
+	}
+
+	output := e.promptFilename("Export to", exportPath(e.filename, exportFormats[choice].ext))
+	if output == "" {
+		return
+	}
+	if _, err := os.Stat(output); err == nil {
+		if e.confirm(fmt.Sprintf("File '%s' exists.", filepath.Base(output)), "Overwrite", "Cancel") != 0 {
+			return
+		}
+	}
+
+	// A chunked buffer holds only part of the file, so export the whole file
+	// with the loaded chunk in place, as saving would write it
+	lines, err := e.linesToSave()
+	if err != nil {
+		e.reportError("Export", err)
+		return
+	}
+	input := strings.Join(lines, "\n") + "\n"
+
+	// Metadata given on the command line overrides the document's, so only supply
+	// a title when the front matter has none
+	docDir := e.documentDir()
+	title := filepath.Base(exportPath(e.filename, ""))
+	for _, line := range lines[:frontMatterEnd(lines)+1] {
+		if strings.HasPrefix(line, "title:") {
+			title = ""
+		}
+	}
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		absOutput = output
+	}
+
+	cmd := exec.Command("pandoc", pandocArgs(absOutput, docDir, title)...)
+	cmd.Dir = docDir
+	cmd.Stdin = strings.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := e.runWithProgress(cmd, "Exporting "+filepath.Base(output)); err != nil {
+		if msg := firstLine(stderr.String()); msg != "" {
+			e.statusMessage = "Export failed: " + msg
+		} else {
+			e.reportError("Export", err)
+		}
+		return
+	}
+	e.statusMessage = "Exported " + output
+}
+
+// runWithProgress runs cmd while animating a spinner in the status bar
+func (e *Editor) runWithProgress(cmd *exec.Cmd, label string) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	spinner := `|/-\`
+	for i := 0; ; i++ {
+		e.statusMessage = fmt.Sprintf("%s %c", label, spinner[i%len(spinner)])
+		e.drawStatusBar()
+		e.screen.Show()
+		select {
+		case err := <-done:
+			e.statusMessage = ""
+			return err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -2,9 +2,53 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"unicode/utf8"
 )
 
+// maxLineBytes is the longest line read as one line; longer ones are split
+const maxLineBytes = 10 * 1024 * 1024
+
+// newLineScanner returns a scanner over the lines of r that handles lines of up
+// to limit bytes. When split is not nil, a longer line comes out in pieces of at
+// most limit bytes, cut between characters, and *split is set; otherwise it
+// stops the scan with bufio.ErrTooLong.
+func newLineScanner(r io.Reader, limit int, split *bool) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(64*1024, limit+1)), limit+1)
+	if split != nil {
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if len(data) > limit && bytes.IndexByte(data[:limit+1], '\n') < 0 {
+				n := limit
+				for n > limit-utf8.UTFMax && !utf8.RuneStart(data[n]) {
+					n--
+				}
+				*split = true
+				return n, data[:n], nil
+			}
+			return bufio.ScanLines(data, atEOF)
+		})
+	}
+	return scanner
+}
+
+// warnSplitLines makes the buffer read-only when a line too long to edit was
+// loaded in pieces, since saving would write the pieces as separate lines
+func (e *Editor) warnSplitLines() {
+	e.readOnly = true
+	e.statusMessage = fmt.Sprintf("%s has lines over %d MB, shown split into pieces; opened read-only", filepath.Base(e.filename), maxLineBytes/(1024*1024))
+}
+
 func (e *Editor) loadFile() error {
 	file, err := os.Open(e.filename)
 	if err != nil {
@@ -13,11 +57,8 @@ func (e *Editor) loadFile() error {
 	defer file.Close()
 
 	e.lines = []string{}
-	scanner := bufio.NewScanner(file)
-	// Increase the scanner buffer to handle very long lines
-	const maxCapacity = 10 * 1024 * 1024 // 10MB per line cap
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, maxCapacity)
+	split := false
+	scanner := newLineScanner(file, maxLineBytes, &split)
 	lineCount := 0
 
 	// Load file with chunk loading to prevent crashes on huge files
@@ -34,11 +75,77 @@ func (e *Editor) loadFile() error {
 		e.lines = []string{""}
 	}
 
+	e.useTabs, e.tabWidth = detectIndent(e.lines, e.useTabs, e.tabWidth)
 	e.pushUndoState() // Save initial state after loading
+	e.recordSavedLines()
 	e.invalidateWordCount()
+	e.warnInvalidUTF8()
+	if split {
+		e.warnSplitLines()
+	}
 	return scanner.Err()
 }
 
+// warnInvalidUTF8 reports in the status bar how many loaded lines hold bytes that
+// are not valid UTF-8. Each such byte is shown as �, and the bytes are written
+// back as they were unless they are edited away.
+func (e *Editor) warnInvalidUTF8() {
+	count := 0
+	for _, line := range e.lines {
+		if !utf8.ValidString(line) {
+			count++
+		}
+	}
+	if count == 1 {
+		e.statusMessage = "Warning: 1 line is not valid UTF-8 (bad bytes shown as �, kept when saving)"
+	} else if count > 1 {
+		e.statusMessage = fmt.Sprintf("Warning: %d lines are not valid UTF-8 (bad bytes shown as �, kept when saving)", count)
+	}
+}
+
+// detectIndent guesses whether lines are indented with tabs or spaces and, for
+// spaces, the indent width: the most common step between a line's indent and a
+// deeper one after it. The given settings are returned when nothing is indented.
+func detectIndent(lines []string, useTabs bool, width int) (bool, int) {
+	tabbed, spaced := 0, 0
+	steps := map[int]int{}
+	prevIndent := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			tabbed++
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent > 0 {
+			spaced++
+		}
+		if step := indent - prevIndent; step >= 2 && step <= 8 {
+			steps[step]++
+		}
+		prevIndent = indent
+	}
+
+	if tabbed > spaced {
+		return true, width
+	}
+	if spaced == 0 {
+		return useTabs, width
+	}
+	best := 0
+	for step, count := range steps {
+		if count > steps[best] || (count == steps[best] && step < best) {
+			best = step
+		}
+	}
+	if best == 0 {
+		return false, width
+	}
+	return false, best
+}
+
 func (e *Editor) saveFile() error {
 	if e.currentChunk == 0 && !e.truncated {
 		// Simple case: small file or first chunk of non-truncated file
@@ -50,41 +157,129 @@ func (e *Editor) saveFile() error {
 }
 
 func (e *Editor) saveEntireFile() error {
-	file, err := os.Create(e.filename)
+	if err := writeLines(e.filename, e.lines); err != nil {
+		return err
+	}
+	e.modified = false
+	e.recordSavedLines()
+	return nil
+}
+
+func (e *Editor) saveChunkToFile() error {
+	newAllLines, err := e.fileWithChunk()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
+	// Write the entire modified file
+	if err := writeLines(e.filename, newAllLines); err != nil {
+		return err
+	}
+
+	e.modified = false
+	e.recordSavedLines()
+	return nil
+}
+
+// writeLines writes lines to path, one per line, replacing what was there. They
+// go to a temporary file beside it that then takes its place, so a full disk or
+// a failure part way through leaves the old file as it was. The file keeps its
+// permissions, and a symlink keeps pointing at it. Where the folder cannot be
+// written, the file is written in place.
+func writeLines(path string, lines []string) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if errors.Is(err, fs.ErrPermission) {
+		return writeLinesInPlace(path, lines)
+	}
+	if err != nil {
+		return err
+	}
+	err = writeLinesTo(file, lines)
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		// Name the file being saved rather than the temporary one
+		if inner := errors.Unwrap(err); inner != nil {
+			err = inner
+		}
+		return &fs.PathError{Op: "write", Path: path, Err: err}
+	}
+	return nil
+}
+
+// writeLinesInPlace overwrites path with lines, for when no temporary file can
+// be made beside it
+func writeLinesInPlace(path string, lines []string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = writeLinesTo(file, lines)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeLinesTo writes lines to file, one per line. A short write, such as on a
+// full disk, is reported by the final flush.
+func writeLinesTo(file *os.File, lines []string) error {
 	writer := bufio.NewWriter(file)
-	for i, line := range e.lines {
+	for i, line := range lines {
 		if i > 0 {
 			writer.WriteString("\n")
 		}
 		writer.WriteString(line)
 	}
-	if err := writer.Flush(); err != nil {
-		return err
+	return writer.Flush()
+}
+
+// linesToSave returns the whole file as saving would write it: the buffer, or
+// for a file loaded in chunks the file on disk with the loaded chunk replaced
+func (e *Editor) linesToSave() ([]string, error) {
+	if e.currentChunk == 0 && !e.truncated {
+		return e.lines, nil
 	}
-	e.modified = false
-	return nil
+	return e.fileWithChunk()
 }
 
-func (e *Editor) saveChunkToFile() error {
+// fileWithChunk reads the file on disk and returns its lines with the loaded
+// chunk replaced by the buffer
+func (e *Editor) fileWithChunk() ([]string, error) {
 	// Read the entire original file
 	originalFile, err := os.Open(e.filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer originalFile.Close()
 
+	// Lines too long to read stop the save rather than be split
 	var allLines []string
-	scanner := bufio.NewScanner(originalFile)
+	scanner := newLineScanner(originalFile, maxLineBytes, nil)
 	for scanner.Scan() {
 		allLines = append(allLines, scanner.Text())
 	}
 	if err := scanner.Err(); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Calculate where this chunk starts in the original file
@@ -107,25 +302,219 @@ func (e *Editor) saveChunkToFile() error {
 
 	// Keep everything after our chunk
 	newAllLines = append(newAllLines, allLines[chunkEndLine:]...)
+	return newAllLines, nil
+}
 
-	// Write the entire modified file
-	file, err := os.Create(e.filename)
+// saveElsewhere offers another place for the changes when the file cannot be
+// written because of its permissions or a read-only disk: another file, which
+// the buffer then belongs to, or a temporary copy with instructions for putting
+// it in place. The buffer stays unsaved after a temporary copy. It returns
+// failed when the user picks neither.
+func (e *Editor) saveElsewhere(failed error) error {
+	name := filepath.Base(e.filename)
+	reason := "permission denied"
+	if errors.Is(failed, syscall.EROFS) {
+		reason = "the disk is read-only"
+	}
+	lines, err := e.linesToSave()
 	if err != nil {
 		return err
 	}
+
+	switch e.confirm(fmt.Sprintf("Cannot save %s: %s.", name, reason), "Save as", "Temporary copy", "Cancel") {
+	case 0:
+		target := e.promptFilename("Save as", e.filename)
+		if target == "" {
+			return errCancelled
+		}
+		if _, err := os.Stat(target); err == nil && target != e.filename {
+			if e.confirm(fmt.Sprintf("File '%s' exists.", filepath.Base(target)), "Overwrite", "Cancel") != 0 {
+				return errCancelled
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := e.claimNewName(target); err != nil {
+			return err
+		}
+		if err := writeLines(target, lines); err != nil {
+			return err
+		}
+		e.modified = false
+		e.recordSavedLines()
+		e.statusMessage = fmt.Sprintf("Saved %s lines to %s", groupDigits(len(lines)), filepath.Base(target))
+		return nil
+	case 1:
+		file, err := os.CreateTemp("", "mkmd-*-"+name)
+		if err != nil {
+			return err
+		}
+		file.Close()
+		if err := writeLines(file.Name(), lines); err != nil {
+			return err
+		}
+		e.messageBox("Saved a temporary copy", putInPlaceInstructions(file.Name(), e.filename, reason))
+		e.statusMessage = "Copied to " + file.Name() + "; " + name + " is not saved"
+		return nil
+	}
+	return failed
+}
+
+// putInPlaceInstructions explains how to replace original with the temporary
+// copy at temp once mkmd could not write it for reason
+func putInPlaceInstructions(temp, original, reason string) []string {
+	lines := []string{"Your changes are in", "  " + temp, ""}
+	switch {
+	case reason != "permission denied":
+		return append(lines, "Once the disk can be written, copy it over", "  "+original)
+	case runtime.GOOS == "windows":
+		return append(lines, "From a terminal run as administrator:", "  copy \""+temp+"\" \""+original+"\"")
+	}
+	return append(lines, "To put it in place, run:", "  sudo cp '"+temp+"' '"+original+"'")
+}
+
+// recordSavedLines remembers the current lines as the on-disk version of the chunk,
+// used as the baseline for reverting regions.
+func (e *Editor) recordSavedLines() {
+	e.savedLines = make([]string, len(e.lines))
+	copy(e.savedLines, e.lines)
+	e.fileLineCount = 0
+}
+
+// countFileLines returns the number of lines in the file at path, or 0 when it
+// cannot be read
+func countFileLines(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
 	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	for i, line := range newAllLines {
-		if i > 0 {
-			writer.WriteString("\n")
+	var split bool
+	scanner := newLineScanner(file, maxLineBytes, &split)
+	count := 0
+	for scanner.Scan() {
+		count++
+	}
+	return count
+}
+
+// readDiskChunk reads the lines of the current chunk as they are stored on disk.
+// A file that does not exist yet reads as empty.
+func (e *Editor) readDiskChunk() ([]string, error) {
+	lines := []string{}
+	if e.filename == "" {
+		return lines, nil
+	}
+
+	file, err := os.Open(e.filename)
+	if os.IsNotExist(err) {
+		return lines, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var split bool
+	scanner := newLineScanner(file, maxLineBytes, &split)
+
+	chunkStart := e.currentChunk * e.maxLines
+	for lineNum := 0; lineNum < chunkStart+e.maxLines && scanner.Scan(); lineNum++ {
+		if lineNum >= chunkStart {
+			lines = append(lines, scanner.Text())
 		}
-		writer.WriteString(line)
 	}
-	if err := writer.Flush(); err != nil {
-		return err
+	return lines, scanner.Err()
+}
+
+// documentDir returns the directory links in the document are relative to
+func (e *Editor) documentDir() string {
+	if e.filename == "" {
+		return "."
 	}
+	return filepath.Dir(e.filename)
+}
 
-	e.modified = false
-	return nil
+// completePath completes input against the entries of its directory (relative to
+// baseDir unless absolute). It extends input to the longest common prefix of the
+// matches and returns the matching entry names, with directories ending in "/".
+func completePath(baseDir, input string) (string, []string) {
+	dirPart, prefix := filepath.Split(input)
+	dir := dirPart
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(baseDir, dirPart)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return input, nil
+	}
+
+	matches := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// Hidden entries only complete when explicitly asked for
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		matches = append(matches, name)
+	}
+	if len(matches) == 0 {
+		return input, nil
+	}
+	sort.Strings(matches)
+
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			_, size := utf8.DecodeLastRuneInString(common)
+			common = common[:len(common)-size]
+		}
+	}
+	return dirPart + common, matches
+}
+
+// copyIntoAssets copies src into an assets/ folder next to the document and returns
+// the new path. An existing file with the same name is never overwritten; a numeric
+// suffix is added instead.
+func copyIntoAssets(docDir, src string) (string, error) {
+	assets := filepath.Join(docDir, "assets")
+	if err := os.MkdirAll(assets, 0755); err != nil {
+		return "", err
+	}
+
+	ext := filepath.Ext(src)
+	stem := strings.TrimSuffix(filepath.Base(src), ext)
+	dest := filepath.Join(assets, stem+ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			break
+		}
+		dest = filepath.Join(assets, fmt.Sprintf("%s-%d%s", stem, i, ext))
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return "", err
+	}
+	return dest, out.Close()
 }
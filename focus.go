@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// paddedScreen shifts drawing right by left columns, so the text drawing code can
+// keep working from column 0 while focus mode centers it
+type paddedScreen struct {
+	tcell.Screen
+	left int
+}
+
+func (s *paddedScreen) SetContent(x, y int, primary rune, combining []rune, style tcell.Style) {
+	s.Screen.SetContent(x+s.left, y, primary, combining, style)
+}
+
+func (s *paddedScreen) GetContent(x, y int) (rune, []rune, tcell.Style, int) {
+	return s.Screen.GetContent(x+s.left, y)
+}
+
+func (s *paddedScreen) ShowCursor(x, y int) {
+	s.Screen.ShowCursor(x+s.left, y)
+}
+
+// fullWidth is the terminal width. In focus mode and with the minimap e.width is
+// only the text column, so the status bar, prompts and overlays use this instead.
+func (e *Editor) fullWidth() int {
+	if e.focusMode || e.minimap {
+		width, _ := e.screen.Size()
+		return width
+	}
+	return e.width
+}
+
+// layoutText sets the text area for the terminal size: the whole width, in focus
+// mode a column of the configured width centered between blank margins, or with
+// the minimap the width left of it
+func (e *Editor) layoutText() {
+	width, height := e.screen.Size()
+	e.width, e.height, e.focusPad = width, height, 0
+	if e.focusMode && e.config.focusWidth < width {
+		e.width = e.config.focusWidth
+		e.focusPad = (width - e.width) / 2
+	} else if e.minimap && !e.focusMode && width >= 3*minimapWidth {
+		e.width = width - minimapWidth
+	}
+}
+
+// toggleFocus switches distraction-free writing on or off
+func (e *Editor) toggleFocus() {
+	e.focusMode = !e.focusMode
+	e.layoutText()
+	if e.focusMode {
+		e.statusMessage = "Focus mode (Alt+M to leave)"
+	} else {
+		e.statusMessage = "Focus mode off"
+	}
+	e.ensureCursorVisible()
+}
+
+// drawFocused draws the text area shifted into the centered column and, when
+// dimming is on, fades every line outside the cursor's paragraph
+func (e *Editor) drawFocused(drawText func()) {
+	screen := e.screen
+	e.screen = &paddedScreen{Screen: screen, left: e.focusPad}
+	defer func() { e.screen = screen }()
+
+	drawText()
+	if !e.focusDim || e.cursorY >= len(e.lines) {
+		return
+	}
+	start, end := e.cursorY, e.cursorY
+	for start > 0 && strings.TrimSpace(e.lines[start-1]) != "" {
+		start--
+	}
+	for end+1 < len(e.lines) && strings.TrimSpace(e.lines[end+1]) != "" {
+		end++
+	}
+	e.forEachVisibleRune(func(x, y, col, sx, sy int, runes []rune) {
+		if _, _, style, _ := e.screen.GetContent(sx, sy); (y < start || y > end) && style == e.theme.text {
+			e.drawRune(sx, sy, col, runes, x, e.theme.dim)
+		}
+	})
+}
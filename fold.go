@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// codeBlocks returns the opening and closing fence lines of each fenced code
+// block. A block left open runs to the last line.
+func codeBlocks(lines []string) [][2]int {
+	var blocks [][2]int
+	open := -1
+	for y, line := range lines {
+		if !isCodeFence(line) {
+			continue
+		}
+		if open < 0 {
+			open = y
+			continue
+		}
+		blocks = append(blocks, [2]int{open, y})
+		open = -1
+	}
+	if open >= 0 && open < len(lines)-1 {
+		blocks = append(blocks, [2]int{open, len(lines) - 1})
+	}
+	return blocks
+}
+
+// foldedLines returns the lines hidden by folded code blocks, as first and last
+// line pairs: everything after a folded block's opening fence up to and
+// including its closing fence. Blocks are folded by their place in the
+// document, so folds stay put as text is edited above them.
+func (e *Editor) foldedLines() [][2]int {
+	if len(e.foldedBlocks) == 0 {
+		return nil
+	}
+	var hidden [][2]int
+	for i, block := range codeBlocks(e.lines) {
+		if e.foldedBlocks[i] {
+			hidden = append(hidden, [2]int{block[0] + 1, block[1]})
+		}
+	}
+	return hidden
+}
+
+// lineHidden reports whether line y is among the hidden lines
+func lineHidden(hidden [][2]int, y int) bool {
+	for _, span := range hidden {
+		if y >= span[0] && y <= span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// screenRowOf returns the screen row of line y when lines are not wrapped,
+// counting only the lines not hidden by folds. Hidden lines give -1.
+func (e *Editor) screenRowOf(y int) int {
+	hidden := e.foldedLines()
+	if lineHidden(hidden, y) {
+		return -1
+	}
+	row := y - e.offsetY
+	for _, span := range hidden {
+		if y > e.offsetY {
+			row -= max(0, min(span[1], y-1)-max(span[0], e.offsetY)+1)
+		} else {
+			row += max(0, min(span[1], e.offsetY-1)-max(span[0], y+1)+1)
+		}
+	}
+	return row
+}
+
+// linesBack returns the line n shown lines above line y, passing over hidden
+// lines and stopping at the first line
+func (e *Editor) linesBack(y, n int) int {
+	hidden := e.foldedLines()
+	if hidden == nil {
+		return max(0, y-n)
+	}
+	for n > 0 && y > 0 {
+		y--
+		if !lineHidden(hidden, y) {
+			n--
+		}
+	}
+	return y
+}
+
+// nextShownLine returns the nearest line after y (delta 1) or before it
+// (delta -1) that folds do not hide, or -1 when there is none
+func (e *Editor) nextShownLine(y, delta int) int {
+	hidden := e.foldedLines()
+	for y += delta; y >= 0 && y < len(e.lines); y += delta {
+		if !lineHidden(hidden, y) {
+			return y
+		}
+	}
+	return -1
+}
+
+// unfoldAtCursor unfolds the block hiding the cursor line, for jumps that land
+// inside a folded block
+func (e *Editor) unfoldAtCursor() {
+	if len(e.foldedBlocks) == 0 {
+		return
+	}
+	for i, block := range codeBlocks(e.lines) {
+		if e.foldedBlocks[i] && e.cursorY > block[0] && e.cursorY <= block[1] {
+			delete(e.foldedBlocks, i)
+		}
+	}
+}
+
+// toggleFold folds the code block at the cursor to its opening fence, or
+// unfolds it. Outside code blocks it folds every block, or unfolds them all
+// when some are folded.
+func (e *Editor) toggleFold() {
+	blocks := codeBlocks(e.lines)
+	if len(blocks) == 0 {
+		e.statusMessage = "No code blocks to fold"
+		return
+	}
+	if e.foldedBlocks == nil {
+		e.foldedBlocks = make(map[int]bool)
+	}
+	for i, block := range blocks {
+		if e.cursorY < block[0] || e.cursorY > block[1] {
+			continue
+		}
+		if e.foldedBlocks[i] {
+			delete(e.foldedBlocks, i)
+			e.statusMessage = "Unfolded code block"
+			return
+		}
+		e.foldedBlocks[i] = true
+		e.clearSelection()
+		if e.cursorY != block[0] {
+			e.cursorY, e.cursorX = block[0], 0
+		}
+		e.ensureCursorVisible()
+		e.statusMessage = "Folded code block"
+		return
+	}
+
+	if len(e.foldedBlocks) > 0 {
+		clear(e.foldedBlocks)
+		e.statusMessage = "Unfolded all code blocks"
+		return
+	}
+	for i := range blocks {
+		e.foldedBlocks[i] = true
+	}
+	e.ensureCursorVisible()
+	e.statusMessage = fmt.Sprintf("Folded %d code blocks", len(blocks))
+}
+
+// foldPlaceholder returns what is drawn after the opening fence of a folded
+// block starting at line y, or "" when the line does not open one
+func (e *Editor) foldPlaceholder(y int) string {
+	for _, span := range e.foldedLines() {
+		if span[0] == y+1 {
+			n := span[1] - span[0]
+			if !isCodeFence(e.lines[span[1]]) {
+				n++
+			}
+			if n == 1 {
+				return " … (1 line)"
+			}
+			return fmt.Sprintf(" … (%d lines)", n)
+		}
+	}
+	return ""
+}
+
+// drawFoldPlaceholder draws the placeholder of a folded block after its
+// opening fence, which ends at display column col on screen row sy
+func (e *Editor) drawFoldPlaceholder(y, col, sy int) {
+	if text := e.foldPlaceholder(y); text != "" && col < e.width {
+		e.drawText(max(0, col), sy, strings.TrimRight(text, " "), e.theme.dim)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// followInterval is how often a followed file is checked for new lines
+const followInterval = 250 * time.Millisecond
+
+// follower tails a file another process is appending to, like tail -f, adding
+// the new lines to the end of the buffer holding it. The buffer is read-only
+// while it is followed, so it always shows the file as it is on disk.
+type follower struct {
+	path     string        // Absolute path of the followed file
+	info     os.FileInfo   // The file as last read, to notice it being replaced
+	offset   int64         // Bytes read, up to the end of the last complete line
+	partial  bool          // The buffer's last line is unfinished, read past offset
+	chunk    int           // Chunk of the file the buffer shows
+	readOnly bool          // Whether the buffer was read-only before following
+	stop     chan struct{} // Closed to end watchFollowed
+
+	// The size and modification time of the file when it was last read,
+	// checked by watchFollowed
+	size, modTime atomic.Int64
+}
+
+// followRequest is the payload of the interrupt posted when the followed file
+// has changed, so its new lines are read on the event loop
+type followRequest struct{}
+
+// readLines reads file from f.offset to its end and adds its lines to lines,
+// replacing an unfinished last line read before. Each complete line moves
+// offset past it; an unfinished one at the end is added without doing so.
+// With maxLines above 0, a chunk that fills up is dropped to start the next, so
+// reading from the start leaves the last chunk.
+func (f *follower) readLines(file *os.File, lines []string, maxLines int) ([]string, error) {
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		return lines, err
+	}
+	if f.partial && len(lines) > 0 {
+		lines = lines[:len(lines)-1]
+	}
+	f.partial = false
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if maxLines > 0 && len(lines) >= maxLines {
+				lines = nil
+				f.chunk++
+			}
+			complete := strings.HasSuffix(line, "\n")
+			lines = append(lines, strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+			if complete {
+				f.offset += int64(len(line))
+			} else {
+				f.partial = true
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return lines, err
+		}
+	}
+	if len(lines) == 0 {
+		// An empty file shows as one empty line for the next text to replace
+		lines = []string{""}
+		f.partial = true
+	}
+	return lines, nil
+}
+
+// markRead records the size and modification time of the file just read
+func (f *follower) markRead(info os.FileInfo) {
+	f.size.Store(info.Size())
+	f.modTime.Store(info.ModTime().UnixNano())
+}
+
+// watchFollowed checks the followed file every followInterval until stop is
+// closed, asking the event loop to read it while its size or modification time
+// differs from when it was last read. A request that is lost, or handled before
+// the last change, is made again on the next tick.
+func watchFollowed(screen tcell.Screen, f *follower) {
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(f.path)
+		if err != nil || info.Size() == f.size.Load() && info.ModTime().UnixNano() == f.modTime.Load() {
+			continue
+		}
+		screen.PostEvent(tcell.NewEventInterrupt(followRequest{}))
+	}
+}
+
+// following reports whether the active buffer is being followed
+func (e *Editor) following() bool {
+	return e.follow != nil && e.bufferIndex(e.follow.path) == e.activeBuffer
+}
+
+// toggleFollow starts following the file of the active buffer, or stops if it
+// is already followed. Only one file is followed at a time.
+func (e *Editor) toggleFollow() {
+	name := bufferName(e.filename)
+	switch {
+	case e.following():
+		e.stopFollowing()
+		e.statusMessage = "Stopped following " + name
+	case e.filename == "":
+		e.statusMessage = "Save the buffer to a file to follow it"
+	case e.modified:
+		e.statusMessage = "Save or undo the changes first: following shows the file as it is on disk"
+	default:
+		if err := e.startFollowing(); err != nil {
+			e.reportError("Following "+name, err)
+			return
+		}
+		e.statusMessage = fmt.Sprintf("Following %s: new lines appear at the end (Alt+Shift+L to stop)", name)
+	}
+}
+
+// startFollowing reads the file of the active buffer again, showing its last
+// chunk with the cursor on the last line, and starts watching it for new lines
+func (e *Editor) startFollowing() error {
+	path, err := filepath.Abs(e.filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	f := &follower{path: path, info: info, readOnly: e.readOnly, stop: make(chan struct{})}
+	lines, err := f.readLines(file, nil, e.maxLines)
+	if err != nil {
+		return err
+	}
+	f.markRead(info)
+
+	e.stopFollowing()
+	e.follow = f
+	e.readOnly = true
+	e.lines = lines
+	e.currentChunk = f.chunk
+	e.truncated = false
+	e.foldedBlocks = nil
+	e.clearSelection()
+	e.clearSearch()
+	e.recordSavedLines()
+	e.invalidateWordCount()
+	e.cursorY, e.cursorX = len(e.lines)-1, 0
+	e.ensureCursorVisible()
+	go watchFollowed(e.screen, f)
+	return nil
+}
+
+// stopFollowing ends follow mode, giving the followed buffer back its read-only
+// setting. Its undo history starts again from the lines shown, as the earlier
+// one may be of a different chunk.
+func (e *Editor) stopFollowing() {
+	f := e.follow
+	if f == nil {
+		return
+	}
+	close(f.stop)
+	e.follow = nil
+	index := e.bufferIndex(f.path)
+	if index < 0 {
+		return
+	}
+	if index != e.activeBuffer {
+		b := &e.buffers[index]
+		b.readOnly = f.readOnly
+		lines := append([]string(nil), b.lines...)
+		b.undoStack = []undoState{{lines: lines, time: time.Now(), size: linesSize(lines)}}
+		b.redoStack, b.undoBytes, b.redoBytes = []undoState{}, linesSize(lines), 0
+		return
+	}
+	e.readOnly = f.readOnly
+	e.undoStack, e.undoBytes = nil, 0
+	e.pushUndoState()
+}
+
+// followFile, on the event loop, adds the lines appended to the followed file
+// since it was last read to the end of its buffer. The file is read again from
+// the start when it shrank or was replaced, as when a log is rotated. The
+// cursor stays on the last line unless it was moved or the view scrolled away
+// from there.
+func (e *Editor) followFile() {
+	f := e.follow
+	if f == nil {
+		return
+	}
+	index := e.bufferIndex(f.path)
+	if index < 0 {
+		// The buffer was closed or saved under another name
+		close(f.stop)
+		e.follow = nil
+		return
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return // Gone for a moment while it is being replaced
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+
+	active := index == e.activeBuffer
+	b := e.captureBuffer()
+	if !active {
+		b = e.buffers[index]
+	}
+	pinned := b.cursorY >= len(b.lines)-1
+	if active {
+		row := e.screenRowOf(e.cursorY)
+		pinned = pinned && row >= 0 && row < e.height-1
+	}
+	lines, chunk := b.lines, f.chunk
+	if !os.SameFile(info, f.info) || info.Size() < f.offset {
+		lines, chunk = nil, -1
+		f.offset, f.partial, f.chunk = 0, false, 0
+	}
+	f.info = info
+	lines, err = f.readLines(file, lines, e.maxLines)
+	f.markRead(info)
+	if err != nil {
+		e.reportError("Following "+bufferName(f.path), err)
+	}
+
+	// A full chunk gives way to the next, as when the file is loaded, so the
+	// buffer always holds the chunk it says it does
+	if f.chunk != chunk {
+		if active {
+			e.clearSelection()
+			e.foldedBlocks = nil
+		} else {
+			e.buffers[index].foldedBlocks = nil
+		}
+	}
+	if !active {
+		b := &e.buffers[index]
+		b.lines, b.currentChunk, b.truncated = lines, f.chunk, false
+		b.savedLines = append([]string(nil), lines...)
+		b.cursorY = min(b.cursorY, len(lines)-1)
+		if pinned {
+			b.cursorY, b.cursorX = len(lines)-1, 0
+			b.offsetY = max(0, len(lines)-(e.height-1))
+		}
+		return
+	}
+	e.lines, e.currentChunk, e.truncated = lines, f.chunk, false
+	e.recordSavedLines()
+	e.invalidateWordCount()
+	if pinned {
+		e.cursorY, e.cursorX = len(e.lines)-1, 0
+		e.ensureCursorVisible()
+	} else {
+		e.adjustCursorPosition()
+	}
+}
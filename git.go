@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runGit runs git in dir and returns its trimmed combined output. A failure is
+// reported with git's first output line as the error text.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if err != nil {
+		if msg := firstLine(output); msg != "" {
+			return output, errors.New(msg)
+		}
+		return output, err
+	}
+	return output, nil
+}
+
+// commitFile saves the buffer, then stages and commits just the current file with
+// a message typed at the prompt, so edit/save/commit needs no shell.
+func (e *Editor) commitFile() {
+	if _, err := exec.LookPath("git"); err != nil {
+		e.statusMessage = "git is not installed"
+		return
+	}
+	if e.filename == "" || e.modified {
+		if err := e.saveFileWithPrompt(); err != nil || e.filename == "" {
+			e.statusMessage = "Commit cancelled: file not saved"
+			return
+		}
+	}
+
+	dir := e.documentDir()
+	name := filepath.Base(e.filename)
+	if _, err := runGit(dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		e.statusMessage = name + " is not in a git repository"
+		return
+	}
+
+	message := e.promptWithHistory("commit", "Commit message: ")
+	if strings.TrimSpace(message) == "" {
+		e.statusMessage = "Commit cancelled"
+		return
+	}
+
+	if _, err := runGit(dir, "add", "--", name); err != nil {
+		e.statusMessage = "git add failed: " + err.Error()
+		return
+	}
+	if out, err := runGit(dir, "commit", "-m", message, "--", name); err != nil {
+		// "nothing to commit" is printed on stdout after the status summary
+		if strings.Contains(out, "nothing to commit") || strings.Contains(out, "no changes added") {
+			e.statusMessage = "Nothing to commit for " + name
+		} else {
+			e.statusMessage = "git commit failed: " + err.Error()
+		}
+		return
+	}
+
+	hash, _ := runGit(dir, "rev-parse", "--short", "HEAD")
+	e.statusMessage = "Committed " + name + " as " + hash
+}
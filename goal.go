@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseWordGoal reads a word goal as written in the config file and the goal
+// prompt: N for a document of N words, +N for N words more than the document
+// held when it was opened. 0 means no goal.
+func parseWordGoal(text string) (words int, session bool, err error) {
+	text = strings.TrimSpace(text)
+	digits, session := strings.CutPrefix(text, "+")
+	words, err = strconv.Atoi(strings.ReplaceAll(digits, ",", ""))
+	if err != nil || words < 0 || strings.HasPrefix(digits, "-") {
+		return 0, false, fmt.Errorf("a word goal is a number of words, or +N for this session, got %q", text)
+	}
+	return words, session && words > 0, nil
+}
+
+// goalProgress returns the words counted towards the word goal: the document's,
+// or for a session goal those added since the buffer was opened, less those
+// removed
+func (e *Editor) goalProgress() int {
+	if e.sessionGoal {
+		return e.sessionWords()
+	}
+	return e.wordCount()
+}
+
+// goalStatus is the word goal part of the status bar, such as
+// "1,240/2,000 — 62%", with a check mark once it is reached
+func (e *Editor) goalStatus() string {
+	progress := e.goalProgress()
+	done := groupDigits(progress)
+	if e.sessionGoal {
+		done = signedWords(progress)
+	}
+	status := fmt.Sprintf("%s/%s — %d%%", done, groupDigits(e.wordGoal), max(0, progress)*100/e.wordGoal)
+	if progress >= e.wordGoal {
+		status += " ✓"
+	}
+	return status
+}
+
+// checkWordGoal says well done in the status bar when editing takes the buffer
+// to the word goal. A buffer that is already there when it is opened, or when
+// the goal is set, passes quietly.
+func (e *Editor) checkWordGoal() {
+	if e.wordGoal == 0 {
+		return
+	}
+	met := e.goalProgress() >= e.wordGoal
+	if met && !e.goalMet && e.modified {
+		what := "words"
+		if e.sessionGoal {
+			what = "words this session"
+		}
+		e.statusMessage = fmt.Sprintf("✓ Word goal reached: %s %s. Well done!", groupDigits(e.wordGoal), what)
+	}
+	e.goalMet = met
+}
+
+// setWordGoal asks for a word goal for the document (N) or the session (+N),
+// shown as progress in the status bar until it is cleared with 0
+func (e *Editor) setWordGoal() {
+	text := e.promptWithHistory("goal", "Word goal (N for the document, +N for this session, 0 for none): ")
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	words, session, err := parseWordGoal(text)
+	if err != nil {
+		e.statusMessage = err.Error()
+		return
+	}
+	e.wordGoal, e.sessionGoal = words, session
+	if words == 0 {
+		e.statusMessage = "Word goal cleared"
+		return
+	}
+	e.goalMet = e.goalProgress() >= words
+	e.statusMessage = "Word goal: " + e.goalStatus()
+}
@@ -0,0 +1,134 @@
+package main
+
+import "unicode"
+
+// The buffer is edited by rune, but several runes can make one character on
+// screen: a letter followed by combining accents, an emoji with a skin tone or a
+// variation selector, emoji joined into one by zero width joiners, and flags made
+// of two regional indicators. These helpers find where such grapheme clusters
+// start and end, so the cursor moves over them, deletion removes them whole, and
+// they are drawn in one cell.
+
+const zeroWidthJoiner = '\u200d'
+
+// isRegionalIndicator reports whether r is one of the letters that pair up into
+// flags
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// isGraphemeExtend reports whether r always belongs with the character before it:
+// combining marks (accents and the keycap mark), variation selectors, emoji skin
+// tones, the tag characters of subdivision flags and the zero width joiner
+func isGraphemeExtend(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me):
+		return true
+	case r == zeroWidthJoiner:
+		return true
+	case r >= 0xFE00 && r <= 0xFE0F, r >= 0xE0100 && r <= 0xE01EF:
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF:
+		return true
+	case r >= 0xE0020 && r <= 0xE007F:
+		return true
+	}
+	return false
+}
+
+// continuesGrapheme reports whether runes[i] belongs to the same character on
+// screen as the rune before it
+func continuesGrapheme(runes []rune, i int) bool {
+	if i <= 0 || i >= len(runes) {
+		return false
+	}
+	r := runes[i]
+	if isGraphemeExtend(r) {
+		return true
+	}
+	// An emoji after a joiner joins the emoji before it
+	if runes[i-1] == zeroWidthJoiner && unicode.Is(unicode.So, r) {
+		return true
+	}
+	// Regional indicators pair up from the first of a run
+	if isRegionalIndicator(r) {
+		run := 0
+		for j := i - 1; j >= 0 && isRegionalIndicator(runes[j]); j-- {
+			run++
+		}
+		return run%2 == 1
+	}
+	return false
+}
+
+// graphemeStart returns the start of the character holding runes[i]
+func graphemeStart(runes []rune, i int) int {
+	i = min(i, len(runes))
+	for i > 0 && continuesGrapheme(runes, i) {
+		i--
+	}
+	return i
+}
+
+// graphemeEnd returns the end of the character starting at runes[i]
+func graphemeEnd(runes []rune, i int) int {
+	if i >= len(runes) {
+		return len(runes)
+	}
+	i++
+	for i < len(runes) && continuesGrapheme(runes, i) {
+		i++
+	}
+	return i
+}
+
+// prevGrapheme returns the start of the character before rune position i
+func prevGrapheme(runes []rune, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	return graphemeStart(runes, min(i, len(runes))-1)
+}
+
+// clusterWidth returns the screen columns of the character starting at runes[i]:
+// that of its first rune, widened to two for flags and emoji presentation
+func clusterWidth(runes []rune, i int) int {
+	w := displayWidthRune(runes[i])
+	end := graphemeEnd(runes, i)
+	if end-i == 1 || w >= 2 {
+		return w
+	}
+	for _, r := range runes[i+1 : end] {
+		if r == '\ufe0f' || isRegionalIndicator(r) {
+			return 2
+		}
+	}
+	return w
+}
+
+// runeCells returns the screen columns runes[i] takes when it starts at display
+// column col: a tab runs to the next tab stop, a control character takes its
+// placeholder's width, the first rune of a character takes the character's width
+// and the rest take none
+func (e *Editor) runeCells(runes []rune, i, col int) int {
+	switch {
+	case runes[i] == '\t':
+		return e.tabWidth - col%e.tabWidth
+	case controlPlaceholder(runes[i]) != "":
+		return runeLen(controlPlaceholder(runes[i]))
+	case continuesGrapheme(runes, i):
+		return 0
+	}
+	return clusterWidth(runes, i)
+}
+
+// snapCursorToGrapheme moves the cursor back to the start of the character it is
+// inside, if it landed between the runes of one
+func (e *Editor) snapCursorToGrapheme() {
+	if e.cursorY < len(e.lines) {
+		runes := []rune(e.lines[e.cursorY])
+		if e.cursorX < len(runes) {
+			e.cursorX = graphemeStart(runes, e.cursorX)
+		}
+	}
+}
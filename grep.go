@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxProjectHits caps how many matches project-wide search lists
+const maxProjectHits = 10000
+
+// grepProject finds term in the files under root and the active buffer,
+// ignoring case as the in-document search does. It returns the matches in file
+// order and whether the list was cut short at maxProjectHits.
+func (e *Editor) grepProject(root, term string) ([]projectHit, bool, error) {
+	files, err := searchFiles(root)
+	if err != nil {
+		return nil, false, err
+	}
+	// The document being edited counts even when it is elsewhere or unnamed
+	if !containsPath(files, e.filename) {
+		files = append([]string{e.filename}, files...)
+	}
+
+	lower := strings.ToLower(term)
+	var hits []projectHit
+	for _, path := range files {
+		var lines []string
+		if path == "" {
+			lines = e.lines
+		} else if lines, err = e.projectLines(path); err != nil {
+			continue
+		}
+		for y, line := range lines {
+			lowerLine := strings.ToLower(line)
+			for offset := 0; ; {
+				idx := strings.Index(lowerLine[offset:], lower)
+				if idx < 0 {
+					break
+				}
+				offset += idx
+				if len(hits) == maxProjectHits {
+					return hits, true, nil
+				}
+				x := utf8.RuneCountInString(lowerLine[:offset])
+				hits = append(hits, projectHit{path, x, y, line})
+				offset += len(lower)
+			}
+		}
+	}
+	return hits, false, nil
+}
+
+// searchProject asks for a term, searches the files under the working
+// directory for it, and lists the matches as file:line with the lines around
+// each. The chosen match is opened in a buffer, and the term becomes the search
+// term so F3 carries on there.
+func (e *Editor) searchProject() {
+	term := e.promptWithHistory("search", "Search files: ")
+	if term == "" {
+		return
+	}
+	hits, cut, err := e.grepProject(".", term)
+	if err != nil {
+		e.reportError("Searching files", err)
+		return
+	}
+	if len(hits) == 0 {
+		e.statusMessage = fmt.Sprintf("No matches for %q in the files here", term)
+		return
+	}
+
+	files := 0
+	for i, hit := range hits {
+		if i == 0 || hit.path != hits[i-1].path {
+			files++
+		}
+	}
+	title := fmt.Sprintf("%q in %d files", term, files)
+	if cut {
+		title = fmt.Sprintf("%q in %d files, first %d matches", term, files, len(hits))
+	}
+	if e.pickHit(title, hits) {
+		e.searchTerm = term
+	}
+}
+
+// fileReplacement is a project-wide replace in one file: the matches found
+// there and which of them are to be replaced
+type fileReplacement struct {
+	path string
+	hits []projectHit
+	keep []bool
+}
+
+// planReplacements groups hits, in file order, by file with every match kept
+func planReplacements(hits []projectHit) []fileReplacement {
+	var plans []fileReplacement
+	for i, hit := range hits {
+		if i == 0 || hit.path != hits[i-1].path {
+			plans = append(plans, fileReplacement{path: hit.path})
+		}
+		plan := &plans[len(plans)-1]
+		plan.hits = append(plan.hits, hit)
+		plan.keep = append(plan.keep, true)
+	}
+	return plans
+}
+
+// kept counts the matches of the plan that are to be replaced
+func (p fileReplacement) kept() int {
+	n := 0
+	for _, k := range p.keep {
+		if k {
+			n++
+		}
+	}
+	return n
+}
+
+// apply returns a copy of lines with the kept matches, each size runes long,
+// replaced by with
+func (p fileReplacement) apply(lines []string, size int, with string) []string {
+	out := make([]string, len(lines))
+	copy(out, lines)
+	for i := 0; i < len(p.hits); {
+		y := p.hits[i].y
+		var xs []int
+		for ; i < len(p.hits) && p.hits[i].y == y; i++ {
+			if p.keep[i] {
+				xs = append(xs, p.hits[i].x)
+			}
+		}
+		if len(xs) > 0 && y < len(out) {
+			out[y] = replaceAt(out[y], xs, size, with)
+		}
+	}
+	return out
+}
+
+// replaceAt replaces the runs of size runes that start at the rune positions
+// xs, in ascending order, with with
+func replaceAt(line string, xs []int, size int, with string) string {
+	runes := []rune(line)
+	var sb strings.Builder
+	last := 0
+	for _, x := range xs {
+		sb.WriteString(string(runes[last:x]))
+		sb.WriteString(with)
+		last = x + size
+	}
+	sb.WriteString(string(runes[last:]))
+	return sb.String()
+}
+
+// replaceProject replaces a term in the files under the working directory. The
+// matches are found as searchProject finds them; a dialog then offers a
+// preview that changes nothing, a review file by file and match by match, or
+// replacing them all. Files on disk are rewritten atomically; open buffers are
+// changed in place, as one undo step, and left for saving.
+func (e *Editor) replaceProject() {
+	term := e.promptWithHistory("search", "Replace in files: ")
+	if term == "" {
+		return
+	}
+	with := e.promptWithHistory("replace", fmt.Sprintf("Replace %q with: ", term))
+
+	hits, cut, err := e.grepProject(".", term)
+	if err != nil {
+		e.reportError("Searching files", err)
+		return
+	}
+	if len(hits) == 0 {
+		e.statusMessage = fmt.Sprintf("No matches for %q in the files here", term)
+		return
+	}
+	if cut {
+		e.statusMessage = fmt.Sprintf("Over %d matches for %q; narrow the search to replace", maxProjectHits, term)
+		return
+	}
+
+	plans := planReplacements(hits)
+	size := runeLen(term)
+	question := fmt.Sprintf("Replace %d matches of %q in %d files with %q?", len(hits), term, len(plans), with)
+	for choice := 0; choice == 0; {
+		choice = e.confirm(question, "Preview", "Review", "Replace all")
+		switch choice {
+		case 0:
+			e.previewReplacements(plans, size, with)
+		case 1:
+			if !e.reviewReplacements(plans, size) {
+				e.statusMessage = "Replace cancelled"
+				return
+			}
+		case -1:
+			e.statusMessage = "Replace cancelled"
+			return
+		}
+	}
+	e.statusMessage = e.applyReplacements(plans, size, with)
+}
+
+// previewReplacements lists the files with their match counts, the lines as
+// they are and as they would become showing below the highlighted one. Nothing
+// is changed.
+func (e *Editor) previewReplacements(plans []fileReplacement, size int, with string) {
+	items := make([]string, len(plans))
+	for i, plan := range plans {
+		items[i] = fmt.Sprintf("%s  (%d of %d matches)", hitName(plan.hits[0]), plan.kept(), len(plan.hits))
+	}
+	preview := func(i int) []string {
+		plan := plans[i]
+		var before []string
+		if plan.path == "" {
+			before = e.lines
+		} else {
+			before, _ = e.projectLines(plan.path)
+		}
+		after := plan.apply(before, size, with)
+		var out []string
+		for y := range before {
+			if before[y] != after[y] {
+				out = append(out, fmt.Sprintf("%5d - %s", y+1, before[y]), fmt.Sprintf("%5d + %s", y+1, after[y]))
+			}
+		}
+		return out
+	}
+	e.pickFromList("Preview (nothing is changed yet)", items, preview)
+}
+
+// reviewReplacements asks about each file, then if wanted about each match in
+// it, and clears keep for the matches turned down. Finish stops asking and
+// leaves the rest out. It returns false when Escape cancels the whole replace.
+func (e *Editor) reviewReplacements(plans []fileReplacement, size int) bool {
+	finished := false
+	for p := range plans {
+		plan := &plans[p]
+		if finished {
+			clear(plan.keep)
+			continue
+		}
+		question := fmt.Sprintf("%s: %d matches", hitName(plan.hits[0]), len(plan.hits))
+		switch e.confirm(question, "All", "One by one", "Skip", "Finish") {
+		case 0:
+			continue
+		case 2:
+			clear(plan.keep)
+			continue
+		case 3:
+			clear(plan.keep)
+			finished = true
+			continue
+		case -1:
+			return false
+		}
+
+		rest := false
+		for i, hit := range plan.hits {
+			if rest || finished {
+				plan.keep[i] = rest
+				continue
+			}
+			question := fmt.Sprintf("%s:%d  %s", hitName(hit), hit.y+1, markMatch(hit.line, hit.x, size))
+			switch e.confirm(question, "Replace", "Skip", "Rest of file", "Finish") {
+			case 1:
+				plan.keep[i] = false
+			case 2:
+				rest = true
+			case 3:
+				plan.keep[i] = false
+				finished = true
+			case -1:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// markMatch returns line with brackets around the size runes at x, cut down to
+// some context on each side
+func markMatch(line string, x, size int) string {
+	const context = 30
+	runes := []rune(line)
+	end := min(x+size, len(runes))
+	before, after := string(runes[max(0, x-context):x]), string(runes[end:min(len(runes), end+context)])
+	if x > context {
+		before = "…" + before
+	}
+	if end+context < len(runes) {
+		after += "…"
+	}
+	return strings.TrimLeft(before, " \t") + "[" + string(runes[x:end]) + "]" + after
+}
+
+// applyReplacements makes the kept replacements and returns a summary for the
+// status bar. A file that changed on disk since it was searched, or an open
+// buffer that is read-only, is left alone.
+func (e *Editor) applyReplacements(plans []fileReplacement, size int, with string) string {
+	replaced, files, unsaved := 0, 0, 0
+	var failed []string
+	for _, plan := range plans {
+		n := plan.kept()
+		if n == 0 {
+			continue
+		}
+		inBuffer, err := e.replaceInFile(plan, size, with)
+		if err != nil {
+			failed = append(failed, hitName(plan.hits[0])+": "+explainError(err))
+			continue
+		}
+		replaced += n
+		files++
+		if inBuffer {
+			unsaved++
+		}
+	}
+
+	msg := fmt.Sprintf("Replaced %d matches in %d files", replaced, files)
+	if unsaved > 0 {
+		msg += fmt.Sprintf(" (%d open buffers left unsaved)", unsaved)
+	}
+	if len(failed) > 0 {
+		msg += "; not changed: " + strings.Join(failed, ", ")
+	}
+	return msg
+}
+
+// errChangedOnDisk is returned when a file no longer holds a match where the
+// search found it
+var errChangedOnDisk = errors.New("changed since it was searched")
+
+// replaceInFile makes the kept replacements of plan, in its buffer when the file
+// is open and on disk otherwise, and reports whether it was a buffer
+func (e *Editor) replaceInFile(plan fileReplacement, size int, with string) (bool, error) {
+	return e.rewriteFile(plan.path, func(lines []string) ([]string, error) {
+		for _, hit := range plan.hits {
+			if hit.y >= len(lines) || strings.TrimSuffix(lines[hit.y], "\r") != hit.line {
+				return nil, errChangedOnDisk
+			}
+		}
+		return plan.apply(lines, size, with), nil
+	})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// codeToken is the kind of a character inside a fenced code block
+type codeToken uint8
+
+const (
+	codePlain codeToken = iota
+	codeKeyword
+	codeString
+	codeComment
+	codeNumber
+)
+
+// codeSyntax describes enough of a programming language to colour its
+// keywords, strings, comments and numbers
+type codeSyntax struct {
+	keywords     map[string]bool
+	ignoreCase   bool      // Keywords match in any case, as in SQL
+	lineComments []string  // Start a comment running to the end of the line
+	blockComment [2]string // Open and close a comment that may span lines
+	quotes       string    // Characters that open and close a string
+}
+
+// keywordSet builds a keyword lookup from a space-separated list
+func keywordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	cKeywords  = "auto break case char const continue default do double else enum extern float for goto if inline int long register return short signed sizeof static struct switch typedef union unsigned void volatile while bool true false NULL"
+	jsKeywords = "async await break case catch class const continue debugger default delete do else export extends false finally for function if import in instanceof let new null of return static super switch this throw true try typeof undefined var void while with yield"
+)
+
+// codeSyntaxes holds the languages highlighted in code blocks, by the name
+// given after the opening fence
+var codeSyntaxes = map[string]*codeSyntax{
+	"go": {
+		keywords:     keywordSet("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var true false nil iota any bool byte error int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr float32 float64 append cap len make new panic recover"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	},
+	"python": {
+		keywords:     keywordSet("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield True False None self print"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	},
+	"javascript": {
+		keywords:     keywordSet(jsKeywords),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	},
+	"typescript": {
+		keywords:     keywordSet(jsKeywords + " abstract any boolean declare enum implements interface keyof namespace never number private protected public readonly string type unknown"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	},
+	"rust": {
+		keywords:     keywordSet("as async await break const continue crate dyn else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while bool char str String i8 i16 i32 i64 i128 isize u8 u16 u32 u64 u128 usize f32 f64 Some None Ok Err"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"",
+	},
+	"c": {
+		keywords:     keywordSet(cKeywords),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+	},
+	"cpp": {
+		keywords:     keywordSet(cKeywords + " catch class constexpr delete explicit friend namespace new nullptr operator override private protected public template this throw try typename using virtual std string vector"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+	},
+	"java": {
+		keywords:     keywordSet("abstract assert boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long native new package private protected public return short static super switch synchronized this throw throws try var void volatile while true false null String"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+	},
+	"ruby": {
+		keywords:     keywordSet("alias and begin break case class def do else elsif end ensure false for if in module next nil not or redo rescue retry return self super then true undef unless until when while yield require attr_accessor puts"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	},
+	"shell": {
+		keywords:     keywordSet("if then else elif fi for while until do done case esac in function return local export readonly echo exit set unset source cd"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	},
+	"sql": {
+		keywords:     keywordSet("select from where and or not insert into values update set delete create table drop alter index view join left right inner outer on as group by order having limit offset union all distinct null is in like between case when then else end primary key foreign references default"),
+		ignoreCase:   true,
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "'\"",
+	},
+	"json": {
+		keywords: keywordSet("true false null"),
+		quotes:   "\"",
+	},
+	"yaml": {
+		keywords:     keywordSet("true false null yes no on off"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	},
+}
+
+// codeLanguageAliases maps other common fence names to those in codeSyntaxes
+var codeLanguageAliases = map[string]string{
+	"golang":  "go",
+	"py":      "python",
+	"python3": "python",
+	"js":      "javascript",
+	"jsx":     "javascript",
+	"mjs":     "javascript",
+	"node":    "javascript",
+	"ts":      "typescript",
+	"tsx":     "typescript",
+	"rs":      "rust",
+	"h":       "c",
+	"c++":     "cpp",
+	"cc":      "cpp",
+	"cxx":     "cpp",
+	"hpp":     "cpp",
+	"rb":      "ruby",
+	"sh":      "shell",
+	"bash":    "shell",
+	"zsh":     "shell",
+	"console": "shell",
+	"yml":     "yaml",
+}
+
+// fenceSyntax returns the syntax named after an opening code fence, or nil
+// when the fence names no language or one that is not known
+func fenceSyntax(line string) *codeSyntax {
+	info := strings.Fields(strings.TrimLeft(strings.TrimLeft(line, " \t"), "`"))
+	if len(info) == 0 {
+		return nil
+	}
+	name := strings.ToLower(strings.Trim(info[0], "{}."))
+	if alias, ok := codeLanguageAliases[name]; ok {
+		name = alias
+	}
+	return codeSyntaxes[name]
+}
+
+// tokens classifies each rune of a line of code, given whether the line starts
+// inside a block comment, and reports whether it ends inside one
+func (s *codeSyntax) tokens(line string, inComment bool) ([]codeToken, bool) {
+	runes := []rune(line)
+	kinds := make([]codeToken, len(runes))
+	mark := func(from, to int, kind codeToken) {
+		for i := from; i < to; i++ {
+			kinds[i] = kind
+		}
+	}
+	isWord := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+
+	for i := 0; i < len(runes); {
+		if inComment {
+			end := i
+			for end < len(runes) && !hasRunePrefix(runes, end, s.blockComment[1]) {
+				end++
+			}
+			if end == len(runes) {
+				mark(i, end, codeComment)
+				return kinds, true
+			}
+			end += runeLen(s.blockComment[1])
+			mark(i, end, codeComment)
+			i, inComment = end, false
+			continue
+		}
+		r := runes[i]
+		switch {
+		case hasRunePrefix(runes, i, s.blockComment[0]):
+			mark(i, i+runeLen(s.blockComment[0]), codeComment)
+			i += runeLen(s.blockComment[0])
+			inComment = true
+			continue
+		case s.startsLineComment(runes, i):
+			mark(i, len(runes), codeComment)
+			return kinds, false
+		case strings.ContainsRune(s.quotes, r):
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' && r != '`' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(runes))
+			mark(i, end, codeString)
+			i = end
+			continue
+		case unicode.IsDigit(r) && (i == 0 || !isWord(runes[i-1])):
+			end := i
+			for end < len(runes) && (isWord(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			mark(i, end, codeNumber)
+			i = end
+			continue
+		case isWord(r):
+			end := i
+			for end < len(runes) && isWord(runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+			if s.ignoreCase {
+				word = strings.ToLower(word)
+			}
+			if s.keywords[word] {
+				mark(i, end, codeKeyword)
+			}
+			i = end
+			continue
+		}
+		i++
+	}
+	return kinds, inComment
+}
+
+// hasRunePrefix reports whether runes[i:] begins with a non-empty prefix
+func hasRunePrefix(runes []rune, i int, prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	for _, r := range prefix {
+		if i >= len(runes) || runes[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// startsLineComment reports whether a line comment begins at runes[i]. A # only
+// counts at the start of the line or after a space, so $# in shell is not one.
+func (s *codeSyntax) startsLineComment(runes []rune, i int) bool {
+	for _, c := range s.lineComments {
+		if !hasRunePrefix(runes, i, c) {
+			continue
+		}
+		if c == "#" && i > 0 && !unicode.IsSpace(runes[i-1]) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// codeTokens classifies the characters of the lines from first to last that
+// lie in fenced code blocks naming a known language. Other lines are absent.
+func (e *Editor) codeTokens(first, last int) map[int][]codeToken {
+	found := make(map[int][]codeToken)
+	var syntax *codeSyntax
+	inFence, inComment := false, false
+	for y := 0; y < len(e.lines) && y <= last; y++ {
+		line := e.lines[y]
+		if isCodeFence(line) {
+			inFence = !inFence
+			syntax, inComment = nil, false
+			if inFence {
+				syntax = fenceSyntax(line)
+			}
+			continue
+		}
+		if !inFence || syntax == nil {
+			continue
+		}
+		// Lines above the screen only matter for a comment left open
+		if y < first && syntax.blockComment[0] == "" {
+			continue
+		}
+		var kinds []codeToken
+		kinds, inComment = syntax.tokens(line, inComment)
+		if y >= first {
+			found[y] = kinds
+		}
+	}
+	return found
+}
+
+// drawCodeHighlights colours keywords, strings, comments and numbers in fenced
+// code blocks, leaving other highlights as they are
+func (e *Editor) drawCodeHighlights() {
+	found := e.codeTokens(e.offsetY, e.offsetY+e.height)
+	if len(found) == 0 {
+		return
+	}
+	styles := [...]tcell.Style{
+		codeKeyword: e.theme.codeKeyword,
+		codeString:  e.theme.codeString,
+		codeComment: e.theme.codeComment,
+		codeNumber:  e.theme.codeNumber,
+	}
+	e.forEachVisibleRune(func(x, y, col, sx, sy int, runes []rune) {
+		kinds := found[y]
+		if x >= len(kinds) || kinds[x] == codePlain {
+			return
+		}
+		if _, _, style, _ := e.screen.GetContent(sx, sy); style == e.theme.text {
+			e.drawRune(sx, sy, col, runes, x, styles[kinds[x]])
+		}
+	})
+}
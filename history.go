@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// linesEqual reports whether two line slices hold the same content
+func linesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// changedRange trims the common leading and trailing lines of a and b and returns
+// the start of the differing region and its end in each slice (end exclusive).
+func changedRange(a, b []string) (start, endA, endB int) {
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB = len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+	return start, endA, endB
+}
+
+// diffPreview renders the changed region between two states as -/+ lines
+func diffPreview(before, after []string, maxLines int) []string {
+	start, endA, endB := changedRange(before, after)
+	preview := []string{}
+	for i := start; i < endA && len(preview) < maxLines; i++ {
+		preview = append(preview, fmt.Sprintf("%5d - %s", i+1, before[i]))
+	}
+	for i := start; i < endB && len(preview) < maxLines; i++ {
+		preview = append(preview, fmt.Sprintf("%5d + %s", i+1, after[i]))
+	}
+	return preview
+}
+
+// undoCheckpoints returns the undo stack indices worth listing, newest first,
+// skipping entries that duplicate the state right after them.
+func (e *Editor) undoCheckpoints() []int {
+	indices := []int{}
+	for i := len(e.undoStack) - 1; i >= 0; i-- {
+		if i+1 < len(e.undoStack) && linesEqual(e.undoStack[i].lines, e.undoStack[i+1].lines) {
+			continue
+		}
+		indices = append(indices, i)
+	}
+	return indices
+}
+
+// describeCheckpoint builds the one-line summary shown in the history browser
+func (e *Editor) describeCheckpoint(index int) string {
+	state := e.undoStack[index]
+	label := state.time.Format("15:04:05")
+	if linesEqual(state.lines, e.lines) {
+		return fmt.Sprintf("%s  (current)", label)
+	}
+	if index == 0 {
+		return fmt.Sprintf("%s  original", label)
+	}
+	start, endA, endB := changedRange(e.undoStack[index-1].lines, state.lines)
+	return fmt.Sprintf("%s  Ln %d  -%d +%d lines  (%s ago)", label, start+1, endA-start, endB-start, time.Since(state.time).Round(time.Second))
+}
+
+// jumpToUndoState undoes repeatedly until the given undo stack entry is loaded, so
+// every skipped state stays reachable with redo.
+func (e *Editor) jumpToUndoState(index int) {
+	for len(e.undoStack) > index && len(e.undoStack) > 1 {
+		e.undo()
+	}
+}
+
+// showUndoHistory opens the undo history browser and jumps to the chosen checkpoint
+func (e *Editor) showUndoHistory() {
+	checkpoints := e.undoCheckpoints()
+	items := make([]string, len(checkpoints))
+	for i, index := range checkpoints {
+		items[i] = e.describeCheckpoint(index)
+	}
+
+	preview := func(i int) []string {
+		index := checkpoints[i]
+		if index == 0 {
+			return []string{"(state when the file was opened)"}
+		}
+		return diffPreview(e.undoStack[index-1].lines, e.undoStack[index].lines, e.height)
+	}
+
+	if choice := e.pickFromList("Undo history", items, preview); choice >= 0 {
+		e.jumpToUndoState(checkpoints[choice])
+	}
+}
+
+// snapshot is a named copy of the buffer kept outside the rolling undo history
+type snapshot struct {
+	name  string
+	chunk int // Chunk the snapshot was taken in; only restorable there
+	state undoState
+}
+
+// createSnapshot tags the current buffer state with a user-supplied name
+func (e *Editor) createSnapshot() {
+	name := e.prompt("Snapshot name: ")
+	if name == "" {
+		return
+	}
+
+	linesCopy := make([]string, len(e.lines))
+	copy(linesCopy, e.lines)
+	e.snapshots = append(e.snapshots, snapshot{
+		name:  name,
+		chunk: e.currentChunk,
+		state: undoState{
+			lines:   linesCopy,
+			cursorX: e.cursorX,
+			cursorY: e.cursorY,
+			offsetY: e.offsetY,
+			time:    time.Now(),
+		},
+	})
+}
+
+// restoreSnapshot lets the user pick a snapshot of the current chunk and loads it.
+// The restore itself is a normal undoable change.
+func (e *Editor) restoreSnapshot() {
+	candidates := []snapshot{}
+	for i := len(e.snapshots) - 1; i >= 0; i-- {
+		if e.snapshots[i].chunk == e.currentChunk {
+			candidates = append(candidates, e.snapshots[i])
+		}
+	}
+
+	items := make([]string, len(candidates))
+	for i, snap := range candidates {
+		items[i] = fmt.Sprintf("%s  %s  (%d lines)", snap.state.time.Format("15:04:05"), snap.name, len(snap.state.lines))
+	}
+	preview := func(i int) []string {
+		return diffPreview(e.lines, candidates[i].state.lines, e.height)
+	}
+
+	choice := e.pickFromList("Restore snapshot", items, preview)
+	if choice < 0 {
+		return
+	}
+
+	e.applySnapshot(candidates[choice])
+}
+
+// applySnapshot replaces the buffer with a snapshot as a single undo step
+func (e *Editor) applySnapshot(snap snapshot) {
+	e.pushUndoState()
+	e.clearSearch()
+	e.lines = make([]string, len(snap.state.lines))
+	copy(e.lines, snap.state.lines)
+	e.invalidateWordCount()
+	e.modified = true
+	e.restoreUndoPosition(snap.state)
+}
+
+// revertedLines returns current with every changed hunk that touches lines
+// startY..endY put back to its content in base. Other changes are left alone.
+func revertedLines(base, current []string, startY, endY int) ([]string, bool) {
+	hunks := diffHunks(diffLines(base, current))
+	result := make([]string, len(current))
+	copy(result, current)
+	reverted := false
+
+	// Apply from the bottom up so earlier hunk positions stay valid
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		lo, hi := h.bStart, h.bEnd-1
+		if h.bStart == h.bEnd {
+			// A pure deletion sits between two lines and touches both
+			lo, hi = h.bStart-1, h.bStart
+		}
+		if hi < startY || lo > endY {
+			continue
+		}
+
+		restored := make([]string, 0, len(result)-(h.bEnd-h.bStart)+(h.aEnd-h.aStart))
+		restored = append(restored, result[:h.bStart]...)
+		restored = append(restored, base[h.aStart:h.aEnd]...)
+		restored = append(restored, result[h.bEnd:]...)
+		result = restored
+		reverted = true
+	}
+
+	if len(result) == 0 {
+		result = []string{""}
+	}
+	return result, reverted
+}
+
+// revertSelection reverts the lines in the selection (or the cursor line) to the
+// last saved version or a chosen snapshot, as a single undo step.
+func (e *Editor) revertSelection() {
+	startY, endY := e.selectedLines()
+
+	base := e.savedLines
+	candidates := []snapshot{}
+	for i := len(e.snapshots) - 1; i >= 0; i-- {
+		if e.snapshots[i].chunk == e.currentChunk {
+			candidates = append(candidates, e.snapshots[i])
+		}
+	}
+	if len(candidates) > 0 {
+		items := []string{"Last saved version"}
+		for _, snap := range candidates {
+			items = append(items, "Snapshot: "+snap.name)
+		}
+		choice := e.pickFromList("Revert selection to", items, nil)
+		if choice < 0 {
+			return
+		}
+		if choice > 0 {
+			base = candidates[choice-1].state.lines
+		}
+	}
+
+	lines, reverted := revertedLines(base, e.lines, startY, endY)
+	if !reverted {
+		return
+	}
+
+	e.pushUndoState()
+	e.clearSearch()
+	e.clearSelection()
+	e.lines = lines
+	e.invalidateWordCount()
+	e.modified = true
+	e.cursorY = startY
+	e.adjustCursorPosition()
+	e.ensureCursorVisible()
+}
+
+// showDiffFromDisk shows a unified diff between the file on disk and the buffer
+func (e *Editor) showDiffFromDisk() {
+	onDisk, err := e.readDiskChunk()
+	if err != nil {
+		e.messageBox("Diff against saved file", []string{"Could not read file: " + err.Error()})
+		return
+	}
+
+	lines := unifiedDiff(onDisk, e.lines, 3)
+	if len(lines) == 0 {
+		e.messageBox("Diff against saved file", []string{"No changes since the last save."})
+		return
+	}
+
+	e.viewLines("Diff against saved file", lines, e.diffLineStyle)
+}
+
+// diffLineStyle colors unified diff lines: additions, removals and hunk headers
+func (e *Editor) diffLineStyle(line string) tcell.Style {
+	switch {
+	case strings.HasPrefix(line, "@@"):
+		return e.theme.hunk
+	case strings.HasPrefix(line, "+"):
+		return e.theme.added
+	case strings.HasPrefix(line, "-"):
+		return e.theme.removed
+	}
+	return e.theme.text
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	hrPattern         = regexp.MustCompile(`^ {0,3}([-*_])( *[-*_]){2,} *$`)
+	tableDelimPattern = regexp.MustCompile(`^\|? *:?-+:? *(\| *:?-+:? *)*\|? *$`)
+	listItemPattern   = regexp.MustCompile(`^( *)([-*+]|\d{1,9}[.)])( +|$)`)
+	linkTailPattern   = regexp.MustCompile(`^\((<[^>]*>|[^)\s]*)(?:\s+"([^"]*)")?\)`)
+)
+
+// markdownToHTML renders markdown as an HTML fragment. It covers the CommonMark
+// blocks people use in notes (headings, paragraphs, lists, quotes, code, rules)
+// plus pipe tables and strikethrough; it is not a full CommonMark implementation.
+func markdownToHTML(text string) string {
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n"))
+	return b.String()
+}
+
+func renderBlocks(b *strings.Builder, lines []string) {
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + renderParagraph(paragraph) + "</p>\n")
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flush()
+
+		case isCodeFence(line):
+			flush()
+			lang := strings.TrimSpace(strings.TrimLeft(trimmed, "`"))
+			end := i + 1
+			for end < len(lines) && !isCodeFence(lines[end]) {
+				end++
+			}
+			if lang != "" {
+				b.WriteString(fmt.Sprintf("<pre><code class=\"language-%s\">", html.EscapeString(strings.Fields(lang)[0])))
+			} else {
+				b.WriteString("<pre><code>")
+			}
+			for _, code := range lines[i+1 : end] {
+				b.WriteString(html.EscapeString(code) + "\n")
+			}
+			b.WriteString("</code></pre>\n")
+			i = end
+
+		case hrPattern.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+
+		case strings.HasPrefix(trimmed, "#"):
+			level, text, ok := parseHeading(line)
+			if !ok {
+				paragraph = append(paragraph, line)
+				continue
+			}
+			flush()
+			b.WriteString(fmt.Sprintf("<h%d id=\"%s\">%s</h%d>\n", level, headingSlug(text), renderInline(text), level))
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				inner := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(inner, " "))
+			}
+			i--
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quote)
+			b.WriteString("</blockquote>\n")
+
+		case strings.Contains(line, "|") && i+1 < len(lines) && tableDelimPattern.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "|"):
+			flush()
+			i = renderTable(b, lines, i) - 1
+
+		case listItemPattern.MatchString(line):
+			flush()
+			i = renderList(b, lines, i) - 1
+
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+}
+
+// renderParagraph joins paragraph lines, turning trailing double spaces or
+// backslashes into line breaks
+func renderParagraph(lines []string) string {
+	parts := make([]string, len(lines))
+	for i, line := range lines {
+		text := strings.TrimLeft(line, " ")
+		hardBreak := i < len(lines)-1 && (strings.HasSuffix(text, "  ") || strings.HasSuffix(text, "\\"))
+		text = strings.TrimRight(text, " ")
+		if hardBreak {
+			text = strings.TrimSuffix(text, "\\")
+		}
+		parts[i] = renderInline(text)
+		if hardBreak {
+			parts[i] += "<br>"
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// renderList renders the list starting at lines[start] and returns the index of
+// the first line after it
+func renderList(b *strings.Builder, lines []string, start int) int {
+	first := listItemPattern.FindStringSubmatch(lines[start])
+	indent := len(first[1])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+		if number, _ := strconv.Atoi(strings.TrimRight(first[2], ".)")); number != 1 {
+			b.WriteString(fmt.Sprintf("<ol start=\"%d\">\n", number))
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	// Gather items: each runs until the next marker at this indentation or a line
+	// indented less than its content
+	var items [][]string
+	loose := false
+	i := start
+	for i < len(lines) {
+		if !continuesList(lines[i], indent, ordered) {
+			break
+		}
+		m := listItemPattern.FindStringSubmatch(lines[i])
+		contentIndent := len(m[0])
+		item := []string{lines[i][len(m[0]):]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line continues the item only if indented content follows
+				if i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" && leadingSpaces(lines[i+1]) > indent {
+					item = append(item, "")
+					loose = true
+					i++
+					continue
+				}
+				if i+1 < len(lines) && continuesList(lines[i+1], indent, ordered) {
+					loose = true
+					i++
+				}
+				break
+			}
+			if leadingSpaces(line) <= indent && (listItemPattern.MatchString(line) || isListBreak(line)) {
+				break
+			}
+			if leadingSpaces(line) >= contentIndent {
+				line = line[contentIndent:]
+			} else {
+				line = strings.TrimLeft(line, " ")
+			}
+			item = append(item, line)
+			i++
+		}
+		items = append(items, item)
+	}
+
+	for _, item := range items {
+		var inner strings.Builder
+		renderBlocks(&inner, item)
+		content := inner.String()
+		// Tight lists render their first paragraph without <p> tags
+		if !loose && strings.HasPrefix(content, "<p>") {
+			end := strings.Index(content, "</p>\n")
+			content = content[3:end] + "\n" + content[end+5:]
+		}
+		b.WriteString("<li>" + strings.TrimSuffix(content, "\n") + "</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// renderTable renders a pipe table whose header is lines[start] and returns the
+// index of the first line after it
+func renderTable(b *strings.Builder, lines []string, start int) int {
+	cells := func(line string) []string {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+		parts := strings.Split(line, "|")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		return parts
+	}
+
+	aligns := []string{}
+	for _, spec := range cells(lines[start+1]) {
+		switch {
+		case strings.HasPrefix(spec, ":") && strings.HasSuffix(spec, ":"):
+			aligns = append(aligns, " style=\"text-align: center\"")
+		case strings.HasSuffix(spec, ":"):
+			aligns = append(aligns, " style=\"text-align: right\"")
+		case strings.HasPrefix(spec, ":"):
+			aligns = append(aligns, " style=\"text-align: left\"")
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+	row := func(line, cell string) string {
+		var r strings.Builder
+		r.WriteString("<tr>")
+		for i, text := range cells(line) {
+			align := ""
+			if i < len(aligns) {
+				align = aligns[i]
+			}
+			r.WriteString(fmt.Sprintf("<%s%s>%s</%s>", cell, align, renderInline(text), cell))
+		}
+		r.WriteString("</tr>\n")
+		return r.String()
+	}
+
+	b.WriteString("<table>\n<thead>\n" + row(lines[start], "th") + "</thead>\n")
+	i := start + 2
+	if i < len(lines) && strings.Contains(lines[i], "|") {
+		b.WriteString("<tbody>\n")
+		for ; i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != ""; i++ {
+			b.WriteString(row(lines[i], "td"))
+		}
+		b.WriteString("</tbody>\n")
+	}
+	b.WriteString("</table>\n")
+	return i
+}
+
+// continuesList reports whether line is another item of a list at indent
+func continuesList(line string, indent int, ordered bool) bool {
+	m := listItemPattern.FindStringSubmatch(line)
+	return m != nil && len(m[1]) == indent && (m[2][0] >= '0' && m[2][0] <= '9') == ordered
+}
+
+func leadingSpaces(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " "))
+}
+
+// renderInline renders code spans, links, images, autolinks, emphasis and
+// strikethrough within a line of text
+func renderInline(text string) string {
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		rest := string(runes[i:])
+
+		switch {
+		case r == '\\' && i+1 < len(runes) && strings.ContainsRune("\\`*_{}[]()#+-.!|~<>", runes[i+1]):
+			b.WriteString(html.EscapeString(string(runes[i+1])))
+			i++
+			continue
+
+		case r == '`':
+			ticks := 0
+			for i+ticks < len(runes) && runes[i+ticks] == '`' {
+				ticks++
+			}
+			fence := strings.Repeat("`", ticks)
+			after := string(runes[i+ticks:])
+			if end := strings.Index(after, fence); end >= 0 {
+				code := strings.TrimSpace(after[:end])
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += ticks + runeLen(after[:end]) + ticks - 1
+				continue
+			}
+			b.WriteString(fence)
+			i += ticks - 1
+			continue
+
+		case r == '!' && strings.HasPrefix(rest, "!["):
+			if label, dest, title, n, ok := parseLink(string(runes[i+1:])); ok {
+				b.WriteString(fmt.Sprintf("<img src=\"%s\" alt=\"%s\"%s>", html.EscapeString(dest), html.EscapeString(label), titleAttr(title)))
+				i += n
+				continue
+			}
+
+		case r == '[':
+			if label, dest, title, n, ok := parseLink(rest); ok {
+				b.WriteString(fmt.Sprintf("<a href=\"%s\"%s>%s</a>", html.EscapeString(dest), titleAttr(title), renderInline(label)))
+				i += n - 1
+				continue
+			}
+
+		case r == '<':
+			if end := strings.Index(rest, ">"); end > 0 && (strings.Contains(rest[:end], "://") || strings.HasPrefix(rest, "<mailto:")) && !strings.Contains(rest[:end], " ") {
+				url := rest[1:end]
+				b.WriteString(fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(url), html.EscapeString(url)))
+				i += runeLen(rest[:end])
+				continue
+			}
+
+		case r == '*' || r == '_' || r == '~':
+			var prev rune
+			if i > 0 {
+				prev = runes[i-1]
+			}
+			if rendered, n, ok := renderEmphasis(rest, prev); ok {
+				b.WriteString(rendered)
+				i += n - 1
+				continue
+			}
+		}
+
+		b.WriteString(html.EscapeString(string(r)))
+	}
+	return b.String()
+}
+
+// renderEmphasis renders a strong, emphasis or strikethrough span at the start of s
+// and returns its length in runes. prev is the rune before s; intraword underscores
+// are left alone.
+func renderEmphasis(s string, prev rune) (string, int, bool) {
+	for _, delim := range []struct{ mark, tag string }{
+		{"**", "strong"}, {"__", "strong"}, {"~~", "del"}, {"*", "em"}, {"_", "em"},
+	} {
+		if !strings.HasPrefix(s, delim.mark) {
+			continue
+		}
+		if delim.mark[0] == '_' && isWordRune(prev) {
+			continue
+		}
+		inner := s[len(delim.mark):]
+		end := closingDelimiter(inner, delim.mark)
+		if end <= 0 {
+			continue
+		}
+		rendered := "<" + delim.tag + ">" + renderInline(inner[:end]) + "</" + delim.tag + ">"
+		return rendered, runeLen(delim.mark)*2 + runeLen(inner[:end]), true
+	}
+	return "", 0, false
+}
+
+// closingDelimiter finds the byte index of mark closing an emphasis span in s. The
+// span may not start or end with a space.
+func closingDelimiter(s, mark string) int {
+	if s == "" || s[0] == ' ' {
+		return -1
+	}
+	for i := 1; i <= len(s)-len(mark); i++ {
+		if s[i] == '`' {
+			// Delimiters inside code spans don't count
+			if end := strings.IndexByte(s[i+1:], '`'); end >= 0 {
+				i += end + 1
+				continue
+			}
+		}
+		if strings.HasPrefix(s[i:], mark) && s[i-1] != ' ' {
+			// "**" must not be mistaken for the closer of a single "*"
+			if len(mark) == 1 && i+1 < len(s) && s[i+1] == mark[0] {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// parseLink parses "[label](dest "title")" at the start of s and returns its parts
+// and length in runes
+func parseLink(s string) (label, dest, title string, n int, ok bool) {
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				m := linkTailPattern.FindStringSubmatch(s[i+1:])
+				if m == nil {
+					return "", "", "", 0, false
+				}
+				dest = strings.TrimSuffix(strings.TrimPrefix(m[1], "<"), ">")
+				return s[1:i], dest, m[2], runeLen(s[:i+1+len(m[0])]), true
+			}
+		}
+	}
+	return "", "", "", 0, false
+}
+
+func titleAttr(title string) string {
+	if title == "" {
+		return ""
+	}
+	return fmt.Sprintf(" title=\"%s\"", html.EscapeString(title))
+}
+
+// htmlClipboardCommand returns a command that puts HTML on the system clipboard as
+// rich text, or nil when no suitable tool is available
+func htmlClipboardCommand(fragment string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("set the clipboard to «data HTML%s»", strings.ToUpper(hex.EncodeToString([]byte(fragment))))
+		return exec.Command("osascript", "-e", script)
+	case "windows":
+		cmd := exec.Command("powershell", "-NoProfile", "-Command", "$input | Out-String | Set-Clipboard -AsHtml")
+		cmd.Stdin = strings.NewReader(fragment)
+		return cmd
+	}
+
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("wl-copy"); err == nil && os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmd = exec.Command("wl-copy", "--type", "text/html")
+	} else if _, err := exec.LookPath("xclip"); err == nil {
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-t", "text/html")
+	} else {
+		return nil
+	}
+	cmd.Stdin = strings.NewReader(fragment)
+	return cmd
+}
+
+// copyAsHTML renders the selection (or the whole buffer) to HTML and places it on
+// the system clipboard as rich text. The HTML source also goes to the internal
+// clipboard so it can be pasted as text.
+func (e *Editor) copyAsHTML() {
+	source := strings.Join(e.lines, "\n")
+	if e.selectionStart {
+		source = e.getSelectedText()
+	}
+	fragment := markdownToHTML(source)
+
+	e.clipboard = fragment
+	e.clipboardBlock = false
+
+	cmd := htmlClipboardCommand(fragment)
+	if cmd == nil {
+		e.statusMessage = "No clipboard tool found (install xclip or wl-clipboard); HTML copied to the internal clipboard"
+		return
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := firstLine(string(out)); msg != "" {
+			e.statusMessage = "Copy as HTML failed: " + msg
+		} else {
+			e.reportError("Copy as HTML", err)
+		}
+		return
+	}
+	e.statusMessage = "Copied as HTML"
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Terminal graphics protocols used for inline image previews
+const (
+	graphicsNone = iota
+	graphicsKitty
+	graphicsITerm
+	graphicsSixel
+)
+
+// Approximate pixel size of a terminal cell, used to scale sixel output
+const (
+	cellPixelWidth  = 10
+	cellPixelHeight = 20
+)
+
+var imageLinkPattern = regexp.MustCompile(`!\[[^\]]*\]\((<[^>]*>|[^)\s]+)[^)]*\)`)
+
+// imageLinkAt returns the destination of the markdown image link covering rune
+// column x of line, with any angle brackets removed.
+func imageLinkAt(line string, x int) (string, bool) {
+	for _, m := range imageLinkPattern.FindAllStringSubmatchIndex(line, -1) {
+		start := byteIndexToRuneIndex(line, m[0])
+		end := byteIndexToRuneIndex(line, m[1])
+		if x >= start && x <= end {
+			target := line[m[2]:m[3]]
+			return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">"), true
+		}
+	}
+	return "", false
+}
+
+// detectGraphicsProtocol guesses the terminal's image protocol from its environment
+func detectGraphicsProtocol(getenv func(string) string) int {
+	term := getenv("TERM")
+	program := getenv("TERM_PROGRAM")
+	switch {
+	case getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || program == "ghostty":
+		return graphicsKitty
+	case program == "iTerm.app" || program == "WezTerm" || getenv("LC_TERMINAL") == "iTerm2":
+		return graphicsITerm
+	case strings.Contains(term, "sixel") || term == "mlterm" || term == "foot" || strings.HasPrefix(term, "foot-"):
+		return graphicsSixel
+	}
+	return graphicsNone
+}
+
+// kittyImage encodes PNG data as a kitty graphics command scaled to cols x rows cells.
+// The payload is split into 4096-byte chunks as the protocol requires.
+func kittyImage(data []byte, cols, rows int) string {
+	payload := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for first := true; first || payload != ""; first = false {
+		chunk := payload
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		payload = payload[len(chunk):]
+		more := 0
+		if payload != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,q=2,c=%d,r=%d,m=%d;%s\x1b\\", cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return b.String()
+}
+
+// itermImage encodes image file data as an iTerm2 inline image fitted to cols x rows cells
+func itermImage(data []byte, cols, rows int) string {
+	return fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1:%s\a",
+		len(data), cols, rows, base64.StdEncoding.EncodeToString(data))
+}
+
+// sixelImage encodes img as sixel graphics no larger than maxW x maxH pixels, using
+// nearest-neighbour scaling and a fixed 6x6x6 colour cube.
+func sixelImage(img image.Image, maxW, maxH int) string {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 {
+		return ""
+	}
+	w, h := srcW, srcH
+	if w > maxW {
+		w, h = maxW, h*maxW/w
+	}
+	if h > maxH {
+		w, h = w*maxH/h, maxH
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	// Map every output pixel to a palette index (-1 for transparent)
+	pixels := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, a := img.At(bounds.Min.X+x*srcW/w, bounds.Min.Y+y*srcH/h).RGBA()
+			if a < 0x8000 {
+				pixels[y*w+x] = -1
+				continue
+			}
+			pixels[y*w+x] = int(r*5/0xffff)*36 + int(g*5/0xffff)*6 + int(b*5/0xffff)
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "\x1bPq\"1;1;%d;%d", w, h)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+
+	// Each sixel row covers six pixel rows; emit one pass per colour present
+	for band := 0; band < h; band += 6 {
+		used := map[int]bool{}
+		for y := band; y < band+6 && y < h; y++ {
+			for x := 0; x < w; x++ {
+				if c := pixels[y*w+x]; c >= 0 {
+					used[c] = true
+				}
+			}
+		}
+		for c := 0; c < 216; c++ {
+			if !used[c] {
+				continue
+			}
+			fmt.Fprintf(&out, "#%d", c)
+			for x := 0; x < w; x++ {
+				bits := 0
+				for dy := 0; dy < 6 && band+dy < h; dy++ {
+					if pixels[(band+dy)*w+x] == c {
+						bits |= 1 << dy
+					}
+				}
+				out.WriteByte(byte(63 + bits))
+			}
+			out.WriteByte('$')
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\")
+	return out.String()
+}
+
+// graphicsSequence builds the escape sequence showing the image file in cols x rows cells
+func graphicsSequence(protocol int, data []byte, cols, rows int) (string, error) {
+	if protocol == graphicsITerm {
+		return itermImage(data, cols, rows), nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if protocol == graphicsSixel {
+		return sixelImage(img, cols*cellPixelWidth, rows*cellPixelHeight), nil
+	}
+
+	// Kitty transfers PNG directly; other formats are re-encoded
+	if format != "png" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	}
+	return kittyImage(data, cols, rows), nil
+}
+
+// openExternally opens path with the system's default viewer
+func openExternally(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// previewImage shows the image linked under the cursor using the terminal's
+// graphics protocol, or opens it in an external viewer when the terminal has none.
+func (e *Editor) previewImage() {
+	target, ok := imageLinkAt(e.lines[e.cursorY], e.cursorX)
+	if !ok {
+		e.statusMessage = "No image link under cursor"
+		return
+	}
+
+	path := target
+	if !isURL(target) {
+		path = resolvePath(e.documentDir(), target)
+	}
+
+	protocol := detectGraphicsProtocol(os.Getenv)
+	tty, hasTty := e.screen.Tty()
+	if protocol == graphicsNone || !hasTty || isURL(target) {
+		if err := openExternally(path); err != nil {
+			e.statusMessage = "Cannot open image: " + err.Error()
+			return
+		}
+		e.statusMessage = "Opened " + filepath.Base(path) + " externally"
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		e.statusMessage = "Cannot read image: " + err.Error()
+		return
+	}
+	cols, rows := e.fullWidth()-2, e.height-3
+	sequence, err := graphicsSequence(protocol, data, cols, rows)
+	if err != nil {
+		e.statusMessage = "Cannot decode image: " + err.Error()
+		return
+	}
+
+	e.showGraphics(tty, filepath.Base(path), sequence, protocol)
+}
+
+// showGraphics draws an empty overlay and writes the image sequence over it,
+// waiting in the modal loop for a key press before clearing the image and
+// repainting the editor. A resize writes the image again in the new window.
+func (e *Editor) showGraphics(tty io.Writer, title, sequence string, protocol int) {
+	titleStyle := e.theme.prompt
+	width, height := 0, 0
+	clearImage := func() {
+		if protocol == graphicsKitty {
+			fmt.Fprint(tty, "\x1b_Ga=d,q=2\x1b\\")
+		}
+		e.screen.LockRegion(0, 1, width, height-2, false)
+	}
+
+	e.modal(func() {
+		placed := width == e.fullWidth() && height == e.height
+		if !placed {
+			if width > 0 {
+				clearImage()
+			}
+			width, height = e.fullWidth(), e.height
+			e.screen.Clear()
+		}
+		e.fillRow(0, titleStyle)
+		e.drawText(0, 0, " Preview: "+title, titleStyle)
+		e.fillRow(e.height-1, titleStyle)
+		e.drawText(0, e.height-1, " Press any key to close", titleStyle)
+		e.screen.HideCursor()
+		e.screen.Show()
+		if !placed {
+			// Keep tcell from drawing over the image while it is on screen
+			e.screen.LockRegion(0, 1, width, height-2, true)
+			fmt.Fprintf(tty, "\x1b[2;2H%s", sequence)
+		}
+	}, func(*tcell.EventKey) bool { return true })
+
+	clearImage()
+	e.screen.Sync()
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 )
@@ -13,16 +15,16 @@ func (e *Editor) handleMouse(ev *tcell.EventMouse) {
 	// Handle scroll wheel/trackpad events first (they can occur with any button state)
 	// Check for any wheel event flags using bitwise operations
 	wheelEvent := false
-	scrollAmount := 1 // Default scroll amount for smooth trackpad experience
 
 	if buttons&tcell.WheelUp != 0 {
 		wheelEvent = true
-		// Add upward momentum (negative delta)
-		e.addScrollMomentum(-float64(scrollAmount * 15)) // Multiply for more responsive feel
+		e.scrollWheel(-1)
 	} else if buttons&tcell.WheelDown != 0 {
 		wheelEvent = true
-		// Add downward momentum (positive delta)
-		e.addScrollMomentum(float64(scrollAmount * 15)) // Multiply for more responsive feel
+		e.scrollWheel(1)
+	} else if e.softWrap && buttons&(tcell.WheelLeft|tcell.WheelRight) != 0 {
+		// Wrapped lines never scroll sideways
+		wheelEvent = true
 	} else if buttons&tcell.WheelLeft != 0 {
 		// Horizontal scroll left (trackpad gesture)
 		wheelEvent = true
@@ -41,18 +43,52 @@ func (e *Editor) handleMouse(ev *tcell.EventMouse) {
 		return
 	}
 
+	// Clicking or dragging in the minimap jumps to that part of the document
+	if buttons == tcell.Button1 && e.minimapShown() && x >= e.width && y < e.height-1 {
+		e.jumpToMinimapRow(y)
+		return
+	}
+
+	// Clicking or dragging on the scrollbar scrolls the view
+	if buttons == tcell.ButtonNone {
+		e.scrollbarDrag = false
+	} else if buttons == tcell.Button1 && (e.scrollbarDrag || (x == e.width-1 && y < e.height-1)) {
+		if _, _, ok := e.scrollbarThumb(e.height - 1); ok {
+			e.scrollbarDrag = true
+			e.scrollToScrollbarRow(y)
+			return
+		}
+	}
+
 	// Handle regular mouse button events (clicks, drags, etc.)
 	switch buttons {
 	case tcell.Button1: // Left click
 		// Convert screen coordinates to line/column with horizontal scrolling
 		screenRow := y
-		screenCol := x
+		screenCol := max(0, x-e.focusPad)
+
+		// Wrapped rows map to buffer positions through the wrap layout
+		if e.softWrap && screenRow >= 0 && screenRow < e.height-1 {
+			if lineX, lineY, ok := e.wrapScreenToBuffer(screenCol, screenRow); ok {
+				e.clickSelection(ev.Modifiers())
+				e.cursorX, e.cursorY = lineX, lineY
+				e.ensureCursorVisible()
+			}
+			break
+		}
 
 		// Validate coordinates and don't allow clicking on status bar
 		if screenRow >= 0 && screenRow < e.height-1 {
 			// Calculate target line accounting for vertical scroll
-			targetLineY := screenRow + e.offsetY
+			targetLineY := e.offsetY
+			if lineHidden(e.foldedLines(), targetLineY) {
+				targetLineY = e.nextShownLine(targetLineY, 1)
+			}
+			for i := 0; i < screenRow && targetLineY >= 0; i++ {
+				targetLineY = e.nextShownLine(targetLineY, 1)
+			}
 			if targetLineY >= 0 && targetLineY < len(e.lines) {
+				e.clickSelection(ev.Modifiers())
 				e.cursorY = targetLineY
 
 				// Calculate target column accounting for horizontal scroll and Unicode
@@ -64,14 +100,19 @@ func (e *Editor) handleMouse(ev *tcell.EventMouse) {
 				currentDisplayX := 0
 				targetRuneX := 0
 
-				for i, r := range runes {
-					runeWidth := displayWidthRune(r)
-					if currentDisplayX+runeWidth/2 > targetDisplayX {
-						// Click is closer to this rune position
-						break
+				if hasRTL(runes) {
+					// Reordered text: the character drawn under the click
+					targetRuneX = e.bidiRuneAt(runes, 0, len(runes), 0, targetDisplayX)
+				} else {
+					for i := range runes {
+						runeWidth := e.runeCells(runes, i, currentDisplayX)
+						if currentDisplayX+runeWidth > targetDisplayX {
+							// The click is on this character, so the cursor goes before it
+							break
+						}
+						currentDisplayX += runeWidth
+						targetRuneX = i + 1
 					}
-					currentDisplayX += runeWidth
-					targetRuneX = i + 1
 				}
 
 				// Clamp to valid range
@@ -80,7 +121,6 @@ func (e *Editor) handleMouse(ev *tcell.EventMouse) {
 				}
 
 				e.cursorX = targetRuneX
-				e.clearSelection()
 				e.ensureCursorVisible()
 			}
 		}
@@ -92,8 +132,356 @@ func (e *Editor) handleMouse(ev *tcell.EventMouse) {
 	}
 }
 
+// clickSelection prepares the selection for a click that is about to move the
+// cursor. Shift+click selects from the cursor to the click, or from the anchor
+// of the current selection. Alt+click keeps the selection as it is, so the
+// click moves its free end, for terminals that keep Shift+click for themselves;
+// with multiple cursors it would add one instead. A plain click clears the
+// selection.
+func (e *Editor) clickSelection(mods tcell.ModMask) {
+	switch {
+	case mods&tcell.ModShift != 0:
+		e.startSelection()
+	case mods&tcell.ModAlt != 0:
+		// Keep the selection
+	default:
+		e.clearSelection()
+	}
+}
+
+// handleAltKey dispatches Alt+letter commands
+func (e *Editor) handleAltKey(r rune) {
+	switch r {
+	case 'z':
+		// Browse undo history
+		e.showUndoHistory()
+	case 'Z':
+		// Suspend to the shell (Ctrl+Z is undo)
+		e.suspend()
+	case 's':
+		// Create a named snapshot
+		e.createSnapshot()
+	case 'r':
+		// Restore a named snapshot
+		e.restoreSnapshot()
+	case 'u':
+		// Revert the selected lines to the saved version
+		e.revertSelection()
+	case 'd':
+		// Diff the buffer against the file on disk
+		e.showDiffFromDisk()
+	case 't':
+		// Insert or refresh the table of contents
+		e.insertTOC()
+	case 'f':
+		// Jump between a footnote marker and its definition
+		e.jumpFootnote()
+	case 'n':
+		// Insert a new footnote
+		e.insertFootnote()
+	case 'i':
+		// Insert an image with path completion
+		e.insertImage()
+	case 'l':
+		// Insert a link with path completion
+		e.insertLink()
+	case 'p':
+		// Preview the image linked under the cursor
+		e.previewImage()
+	case 'o':
+		// Renumber all ordered lists
+		e.renumberLists()
+	case 'O':
+		// Toggle automatic list renumbering
+		e.autoRenumber = !e.autoRenumber
+		if e.autoRenumber {
+			e.statusMessage = "Automatic list renumbering on"
+		} else {
+			e.statusMessage = "Automatic list renumbering off"
+		}
+	case 'h':
+		// Copy the selection or buffer as rich-text HTML
+		e.copyAsHTML()
+	case 'g':
+		// Commit the current file with git
+		e.commitFile()
+	case 'e':
+		// Export with pandoc
+		e.exportWithPandoc()
+	case 'q':
+		// Convert punctuation in the whole document
+		e.smartenDocument()
+	case '.':
+		// Next buffer
+		e.cycleBuffer(1)
+	case ',':
+		// Previous buffer
+		e.cycleBuffer(-1)
+	case 'b':
+		// Pick a buffer from the list
+		e.pickBuffer()
+	case 'w':
+		// Toggle soft wrap
+		e.toggleSoftWrap()
+	case 'j':
+		// Reflow the paragraph or selection to the wrap column
+		e.reflow()
+	case 'J':
+		// Toggle wrapping at the wrap column while typing
+		e.hardWrap = !e.hardWrap
+		if e.hardWrap {
+			e.statusMessage = fmt.Sprintf("Wrap while typing at column %d on", e.config.wrapColumn)
+		} else {
+			e.statusMessage = "Wrap while typing off"
+		}
+	case 'v':
+		// Toggle showing whitespace as symbols
+		e.showInvisibles = !e.showInvisibles
+		if e.showInvisibles {
+			e.statusMessage = "Showing invisible characters"
+		} else {
+			e.statusMessage = "Hiding invisible characters"
+		}
+	case 'c':
+		// Toggle the column guide
+		e.showRuler = !e.showRuler
+		if e.showRuler {
+			e.statusMessage = fmt.Sprintf("Column guide at %d", e.config.rulerColumn)
+		} else {
+			e.statusMessage = "Column guide off"
+		}
+	case 'C':
+		// Toggle highlighting text past the column guide
+		e.rulerOverflow = !e.rulerOverflow
+		if e.rulerOverflow {
+			e.statusMessage = fmt.Sprintf("Highlighting text past column %d", e.config.rulerColumn)
+		} else {
+			e.statusMessage = "Overflow highlighting off"
+		}
+	case 'X':
+		// Toggle highlighting the cursor's column
+		e.cursorColumn = !e.cursorColumn
+		if e.cursorColumn {
+			e.statusMessage = "Highlighting the cursor column"
+		} else {
+			e.statusMessage = "Cursor column highlight off"
+		}
+	case 'm':
+		// Toggle distraction-free focus mode
+		e.toggleFocus()
+	case 'M':
+		// Toggle fading text outside the current paragraph in focus mode
+		e.focusDim = !e.focusDim
+		if e.focusDim {
+			e.statusMessage = "Fading other paragraphs in focus mode"
+		} else {
+			e.statusMessage = "Not fading other paragraphs"
+		}
+	case 'D':
+		// Toggle drafting mode, which refuses to delete
+		e.draftMode = !e.draftMode
+		if e.draftMode {
+			e.statusMessage = "Drafting mode: backspace, delete and cut are off"
+		} else {
+			e.statusMessage = "Drafting mode off"
+		}
+	case '*':
+		// Search for the word under the cursor
+		e.searchWordAtCursor(1)
+	case '#':
+		// Search backwards for the word under the cursor
+		e.searchWordAtCursor(-1)
+	case 'x':
+		// Insert a character by name
+		e.insertCharByName()
+	case ';':
+		// Insert the date or time
+		e.insertDate()
+	case ':':
+		// Insert a template
+		e.insertTemplate()
+	case 'y':
+		// Paste an earlier cut from the kill ring
+		e.yankFromRing()
+	case 'T':
+		// Swap the characters around the cursor
+		e.transposeChars()
+	case 'W':
+		// Swap the word at the cursor with the next one
+		e.transposeWords()
+	case 'H':
+		// Browse the #tags in the project's markdown files
+		e.browseTags()
+	case 'G':
+		// Search the files under the working directory
+		e.searchProject()
+	case 'R':
+		// Replace in the files under the working directory
+		e.replaceProject()
+	case 'B':
+		// Compare the buffer side by side with another open one
+		e.compareBuffers()
+	case 'K':
+		// Save or open a named workspace
+		e.pickWorkspace()
+	case 'L':
+		// Follow the file as another process appends to it, like tail -f
+		e.toggleFollow()
+	case 'I':
+		// Set a word goal for the document or the session
+		e.setWordGoal()
+	case 'U':
+		// Show the writing statistics of the buffer or selection
+		e.showStatistics()
+	case 'a':
+		// Report the headings, lists, links and words by section
+		e.showStructure()
+	case 'Y':
+		// Start a timed writing session, or end the one running
+		e.toggleTimer()
+	case 'E':
+		// Show the recent days of the writing log
+		e.showWritingLog()
+	case 'N':
+		// Rename the current file, updating links to it
+		e.renameFile()
+	case 'F':
+		// Fold or unfold the code block at the cursor (elsewhere: all of them)
+		e.toggleFold()
+	case '|':
+		// Sort the table at the cursor by a column
+		e.sortTable()
+	case '$':
+		// Jump to the next unclosed math delimiter
+		e.jumpUnclosedMath()
+	case '>':
+		// Jump to the next merge conflict
+		e.jumpConflict(1)
+	case '<':
+		// Jump to the previous merge conflict
+		e.jumpConflict(-1)
+	case '=':
+		// Keep ours, theirs or both of the merge conflict at the cursor
+		e.resolveConflict()
+	case 'k':
+		// Suggest spellings for the word at the cursor
+		e.spellingSuggestions()
+	case 'S':
+		// Toggle spell checking
+		e.toggleSpellCheck()
+	case 'V':
+		// Toggle the minimap
+		e.toggleMinimap()
+	case 'A':
+		// Toggle abbreviation expansion
+		e.toggleAbbreviations()
+	case 'P':
+		// Toggle auto-pairing of brackets and quotes
+		e.toggleAutoPair()
+	case 'Q':
+		// Toggle smart punctuation while typing
+		e.smartPunctuation = !e.smartPunctuation
+		if e.smartPunctuation {
+			e.statusMessage = "Smart punctuation on"
+		} else {
+			e.statusMessage = "Smart punctuation off"
+		}
+	}
+}
+
+// editsBuffer reports whether a key would change the buffer, for read-only mode
+func editsBuffer(ev *tcell.EventKey) bool {
+	switch ev.Key() {
+	case tcell.KeyEnter, tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyDelete, tcell.KeyTab,
+		tcell.KeyCtrlX, tcell.KeyCtrlV, tcell.KeyCtrlZ, tcell.KeyCtrlY, tcell.KeyCtrlK, tcell.KeyCtrlU:
+		return true
+	case tcell.KeyLeft, tcell.KeyRight:
+		// Alt+Left/Right shift headings
+		return ev.Modifiers() == tcell.ModAlt
+	case tcell.KeyRune:
+		if ev.Modifiers()&tcell.ModAlt != 0 {
+			return strings.ContainsRune("zrutnilosqjk;:xyTW|=", ev.Rune())
+		}
+		return ev.Rune() >= 32
+	}
+	return false
+}
+
+// collectPaste adds the text a key inside a bracketed paste stands for
+func (e *Editor) collectPaste(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyRune:
+		e.pasted = append(e.pasted, ev.Rune())
+	case tcell.KeyEnter:
+		e.pasted = append(e.pasted, '\r')
+	case tcell.KeyCtrlJ:
+		e.pasted = append(e.pasted, '\n')
+	case tcell.KeyTab:
+		e.pasted = append(e.pasted, '\t')
+	}
+}
+
+// lineBreaks turns Windows and old Mac line breaks in pasted text into newlines
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// deletesText reports whether a key removes text, for drafting mode
+func deletesText(ev *tcell.EventKey) bool {
+	switch ev.Key() {
+	case tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyDelete, tcell.KeyCtrlX, tcell.KeyCtrlK, tcell.KeyCtrlU:
+		return true
+	}
+	return false
+}
+
+// suspendRequest is the payload of the interrupt posted when mkmd is sent
+// SIGTSTP, so the suspend happens on the event loop
+type suspendRequest struct{}
+
+// suspend hands the terminal back to the shell and stops, as Ctrl+Z does in
+// other programs, then takes the terminal back and redraws everything when the
+// process is continued
+func (e *Editor) suspend() {
+	if err := e.screen.Suspend(); err != nil {
+		e.reportError("Suspend", err)
+		return
+	}
+	stopErr := stopProcess()
+	if err := e.screen.Resume(); err != nil {
+		e.reportError("Resuming the screen", err)
+		return
+	}
+	e.reportError("Suspend", stopErr)
+	// The terminal may have been resized or drawn over while we were away
+	e.handleResize()
+	e.screen.Sync()
+}
+
+// handleInterrupt acts on a request posted to the event loop from another
+// goroutine. The main loop and the loop of every prompt and dialog hand their
+// interrupts here, so none is lost while a dialog is open.
+func (e *Editor) handleInterrupt(data any) {
+	switch data := data.(type) {
+	case suspendRequest:
+		e.suspend()
+	case shutdownRequest:
+		e.shutdown(data.signal)
+	case compareRequest:
+		e.compareWith(1)
+	case followRequest:
+		e.followFile()
+	case timerRequest:
+		e.checkTimer(time.Now())
+	case scrollFrame:
+		// Drawn by applyScrollMomentum after the event
+	}
+}
+
 func (e *Editor) run() error {
 	defer e.screen.Fini()
+	defer e.releaseLocks()
+	defer e.recoverPanic()
+	e.watchSuspendSignals()
+	e.watchShutdownSignals()
 
 	// Initial draw
 	e.draw()
@@ -101,22 +489,54 @@ func (e *Editor) run() error {
 	for {
 		ev := e.screen.PollEvent()
 
+		switch ev.(type) {
+		case *tcell.EventKey, *tcell.EventMouse, *tcell.EventPaste, *tcell.EventResize:
+			// Input acts on the view where a smooth scroll is going
+			e.finishGlide()
+		}
+
 		switch ev := ev.(type) {
 		case *tcell.EventKey:
+			if e.pasting {
+				// Keys inside a bracketed paste are the pasted text
+				e.collectPaste(ev)
+				continue
+			}
+			// Any key press dismisses the previous status message
+			e.statusMessage = ""
+			e.statusShown = ""
+			edits := e.editCount
+			e.joinKill, e.killed = e.killed, false
+
+			if e.readOnly && editsBuffer(ev) {
+				e.statusMessage = "Read-only"
+				if e.following() {
+					e.statusMessage = "Following the file: read-only (Alt+Shift+L to stop)"
+				}
+				e.draw()
+				continue
+			}
+			if e.draftMode && deletesText(ev) {
+				e.statusMessage = "Drafting: no deleting, just keep writing (Alt+Shift+D to stop)"
+				e.draw()
+				continue
+			}
+
 			// Handle keyboard events - includes standard shortcuts and navigation
 			switch ev.Key() {
 			case tcell.KeyCtrlD:
-				// Save and exit
-				if err := e.saveFileWithPrompt(); err != nil {
-					return fmt.Errorf("failed to save file: %v", err)
+				// Save every buffer and exit, staying open if any of them
+				// could not be saved
+				if err := e.forEachBuffer(e.saveFileWithPrompt); err != nil {
+					e.reportError("Save", err)
+					break
 				}
+				e.logAllBuffers(time.Now())
 				return nil
 
 			case tcell.KeyCtrlS:
 				// Save file
-				if err := e.saveFileWithPrompt(); err != nil {
-					// Could show error in status bar, but for now just continue
-				}
+				e.reportError("Save", e.saveFileWithPrompt())
 
 			case tcell.KeyCtrlZ:
 				// Undo
@@ -129,6 +549,7 @@ func (e *Editor) run() error {
 			case tcell.KeyCtrlA:
 				// Select entire document
 				e.selectionStart = true
+				e.blockSelection = false
 				e.selectionStartX = 0
 				e.selectionStartY = 0
 				e.cursorY = len(e.lines) - 1
@@ -148,22 +569,46 @@ func (e *Editor) run() error {
 				// Find next
 				e.findNext()
 
+			case tcell.KeyF7:
+				// Next misspelled word, or previous with Shift
+				if ev.Modifiers()&tcell.ModShift != 0 {
+					e.jumpMisspelling(-1)
+				} else {
+					e.jumpMisspelling(1)
+				}
+
 			case tcell.KeyCtrlG:
 				// Go to line
 				e.goToLine()
 
+			case tcell.KeyCtrlL:
+				// Put the cursor line in the middle, then at the top, then the bottom
+				e.recenter()
+
+			case tcell.KeyCtrlRightSq:
+				// Jump to matching bracket
+				e.jumpToMatchingBracket()
+
 			case tcell.KeyCtrlT:
 				// Next chunk
-				e.loadNextChunk()
+				e.reportError("Loading the next chunk", e.loadNextChunk())
 
 			case tcell.KeyCtrlB:
 				// Previous chunk (back)
-				e.loadPrevChunk()
+				e.reportError("Loading the previous chunk", e.loadPrevChunk())
 
 			case tcell.KeyCtrlX:
 				// Cut
 				e.cut()
 
+			case tcell.KeyCtrlK:
+				// Cut to the end of the line
+				e.killLineEnd()
+
+			case tcell.KeyCtrlU:
+				// Cut to the start of the line
+				e.killLineStart()
+
 			case tcell.KeyCtrlC:
 				// Copy
 				if e.selectionStart {
@@ -171,36 +616,65 @@ func (e *Editor) run() error {
 				}
 
 			case tcell.KeyCtrlQ:
-				// Quit
-				if e.modified {
-					response := e.prompt("Save changes? (y/n): ")
-					if response == "y" {
-						if err := e.saveFileWithPrompt(); err != nil {
-							return fmt.Errorf("failed to save file: %v", err)
-						}
-					}
+				// Quit, offering to save each modified buffer
+				if err := e.offerToSave(); err != nil {
+					e.reportError("Save", err)
+					break
 				}
+				e.logAllBuffers(time.Now())
 				return nil
 
 			case tcell.KeyCtrlV:
 				// Paste
 				e.paste()
 
+			case tcell.KeyInsert:
+				// Toggle insert/overtype
+				e.toggleOvertype()
+
+			case tcell.KeyCtrlSpace:
+				// Keep the next abbreviation as typed
+				e.keepNextAbbreviation()
+
 			case tcell.KeyEnter:
+				y, x := e.cursorY, e.cursorX
 				e.insertNewline()
+				if e.abbreviate {
+					e.expandAbbreviation(y, x)
+				}
 
 			case tcell.KeyBackspace, tcell.KeyBackspace2:
+				if ev.Modifiers()&tcell.ModCtrl != 0 {
+					// Delete the previous word
+					e.deleteWordLeft()
+					break
+				}
+				if e.autoPair && e.deleteEmptyPair() {
+					break
+				}
 				e.backspace()
 
 			case tcell.KeyDelete:
+				if ev.Modifiers()&tcell.ModCtrl != 0 {
+					// Delete the next word
+					e.deleteWordRight()
+					break
+				}
 				e.delete()
 
 			case tcell.KeyTab:
-				// Insert 4 spaces for tab
-				for i := 0; i < 4; i++ {
-					e.insertChar(' ')
+				if !e.moveTableCell(1) {
+					e.insertTab()
 				}
+			case tcell.KeyBacktab:
+				// Previous table cell
+				e.moveTableCell(-1)
 			case tcell.KeyLeft:
+				if ev.Modifiers() == tcell.ModAlt {
+					// Promote the heading(s) under the cursor
+					e.shiftHeadings(-1)
+					break
+				}
 				// Handle Left arrow with modifier keys (Ctrl=word nav, Shift=selection)
 				if ev.Modifiers()&tcell.ModCtrl != 0 {
 					if ev.Modifiers()&tcell.ModShift != 0 {
@@ -211,14 +685,16 @@ func (e *Editor) run() error {
 					e.moveWordLeft()
 					e.ensureCursorVisible()
 				} else {
-					// Regular left arrow movement
-					if ev.Modifiers()&tcell.ModShift != 0 {
+					// Regular left arrow movement (Alt+Shift selects a column block)
+					if ev.Modifiers()&(tcell.ModShift|tcell.ModAlt) == tcell.ModShift|tcell.ModAlt {
+						e.startBlockSelection()
+					} else if ev.Modifiers()&tcell.ModShift != 0 {
 						e.startSelection()
 					} else {
 						e.clearSelection()
 					}
 					if e.cursorX > 0 {
-						e.cursorX--
+						e.cursorX = prevGrapheme([]rune(e.lines[e.cursorY]), e.cursorX)
 					} else if e.cursorY > 0 {
 						e.cursorY--
 						e.cursorX = runeLen(e.lines[e.cursorY])
@@ -227,6 +703,11 @@ func (e *Editor) run() error {
 				}
 
 			case tcell.KeyRight:
+				if ev.Modifiers() == tcell.ModAlt {
+					// Demote the heading(s) under the cursor
+					e.shiftHeadings(1)
+					break
+				}
 				// Check if Ctrl is pressed for word navigation
 				if ev.Modifiers()&tcell.ModCtrl != 0 {
 					if ev.Modifiers()&tcell.ModShift != 0 {
@@ -237,14 +718,16 @@ func (e *Editor) run() error {
 					e.moveWordRight()
 					e.ensureCursorVisible()
 				} else {
-					// Check if Shift is pressed for selection
-					if ev.Modifiers()&tcell.ModShift != 0 {
+					// Check if Shift is pressed for selection (Alt+Shift selects a column block)
+					if ev.Modifiers()&(tcell.ModShift|tcell.ModAlt) == tcell.ModShift|tcell.ModAlt {
+						e.startBlockSelection()
+					} else if ev.Modifiers()&tcell.ModShift != 0 {
 						e.startSelection()
 					} else {
 						e.clearSelection()
 					}
 					if e.cursorY < len(e.lines) && e.cursorX < runeLen(e.lines[e.cursorY]) {
-						e.cursorX++
+						e.cursorX = graphemeEnd([]rune(e.lines[e.cursorY]), e.cursorX)
 					} else if e.cursorY < len(e.lines)-1 {
 						e.cursorY++
 						e.cursorX = 0
@@ -303,66 +786,143 @@ func (e *Editor) run() error {
 				}
 
 			case tcell.KeyPgUp:
+				from := e.offsetY
 				e.clearSelection()
 				e.cursorY -= e.height - 1
 				if e.cursorY < 0 {
 					e.cursorY = 0
 				}
 				e.ensureCursorVisible()
+				e.smoothScroll(from)
 
 			case tcell.KeyPgDn:
+				from := e.offsetY
 				e.clearSelection()
 				e.cursorY += e.height - 1
 				if e.cursorY >= len(e.lines) {
 					e.cursorY = len(e.lines) - 1
 				}
 				e.ensureCursorVisible()
+				e.smoothScroll(from)
 
 			case tcell.KeyUp:
-				// Check if Shift is pressed for selection
-				if ev.Modifiers()&tcell.ModShift != 0 {
+				if ev.Modifiers()&^tcell.ModCtrl == tcell.ModAlt {
+					// Previous heading (Ctrl+Alt: same level or higher)
+					e.jumpHeading(-1, ev.Modifiers()&tcell.ModCtrl != 0)
+					break
+				}
+				if ev.Modifiers()&tcell.ModCtrl != 0 {
+					// Ctrl+Up moves to the previous paragraph, Ctrl+Shift+Up selects by paragraph
+					if ev.Modifiers()&tcell.ModShift != 0 {
+						e.startSelection()
+					} else {
+						e.clearSelection()
+					}
+					e.moveParagraph(-1)
+					e.ensureCursorVisible()
+					break
+				}
+				// Check if Shift is pressed for selection (Alt+Shift selects a column block)
+				if ev.Modifiers()&(tcell.ModShift|tcell.ModAlt) == tcell.ModShift|tcell.ModAlt {
+					e.startBlockSelection()
+				} else if ev.Modifiers()&tcell.ModShift != 0 {
 					e.startSelection()
 				} else {
 					e.clearSelection()
 				}
-				if e.cursorY > 0 {
-					e.cursorY--
+				if e.softWrap {
+					e.moveVisualRow(-1)
+				} else if y := e.nextShownLine(e.cursorY, -1); y >= 0 {
+					e.cursorY = y
 					if e.cursorX > runeLen(e.lines[e.cursorY]) {
 						e.cursorX = runeLen(e.lines[e.cursorY])
 					}
+					e.snapCursorToGrapheme()
 				}
 				e.ensureCursorVisible()
 
 			case tcell.KeyDown:
-				// Check if Shift is pressed for selection
-				if ev.Modifiers()&tcell.ModShift != 0 {
+				if ev.Modifiers()&^tcell.ModCtrl == tcell.ModAlt {
+					// Next heading (Ctrl+Alt: same level or higher)
+					e.jumpHeading(1, ev.Modifiers()&tcell.ModCtrl != 0)
+					break
+				}
+				if ev.Modifiers()&tcell.ModCtrl != 0 {
+					// Ctrl+Down moves to the next paragraph, Ctrl+Shift+Down selects by paragraph
+					if ev.Modifiers()&tcell.ModShift != 0 {
+						e.startSelection()
+					} else {
+						e.clearSelection()
+					}
+					e.moveParagraph(1)
+					e.ensureCursorVisible()
+					break
+				}
+				// Check if Shift is pressed for selection (Alt+Shift selects a column block)
+				if ev.Modifiers()&(tcell.ModShift|tcell.ModAlt) == tcell.ModShift|tcell.ModAlt {
+					e.startBlockSelection()
+				} else if ev.Modifiers()&tcell.ModShift != 0 {
 					e.startSelection()
 				} else {
 					e.clearSelection()
 				}
-				if e.cursorY < len(e.lines)-1 {
-					e.cursorY++
+				if e.softWrap {
+					e.moveVisualRow(1)
+				} else if y := e.nextShownLine(e.cursorY, 1); y >= 0 {
+					e.cursorY = y
 					if e.cursorX > runeLen(e.lines[e.cursorY]) {
 						e.cursorX = runeLen(e.lines[e.cursorY])
 					}
+					e.snapCursorToGrapheme()
 				}
 				e.ensureCursorVisible()
 
 			default:
+				// Alt+letter commands
+				if ev.Key() == tcell.KeyRune && ev.Modifiers()&tcell.ModAlt != 0 {
+					e.handleAltKey(ev.Rune())
+					break
+				}
 				// Regular character input
 				if ev.Rune() != 0 && ev.Rune() >= 32 {
+					if e.autoPair && e.wrapSelection(ev.Rune()) {
+						break
+					}
 					e.clearSelection()
-					e.insertChar(ev.Rune())
+					e.typeChar(ev.Rune())
+					if e.hardWrap {
+						e.wrapAsYouType()
+					}
 				}
 			}
 
+			if e.autoRenumber && e.editCount != edits {
+				e.renumberListAtCursor()
+			}
+
+		case *tcell.EventPaste:
+			if ev.Start() {
+				e.pasting = true
+				e.pasted = e.pasted[:0]
+				continue
+			}
+			e.pasting = false
+			e.statusMessage = ""
+			e.pasteText(lineBreaks.Replace(string(e.pasted)))
+
 		case *tcell.EventResize:
 			e.handleResize()
 
 		case *tcell.EventMouse:
 			e.handleMouse(ev)
+
+		case *tcell.EventInterrupt:
+			e.handleInterrupt(ev.Data())
 		}
 
+		e.trackWords()
+		e.checkWordGoal()
+		e.updatePreview()
 		e.scroll()
 		e.applyScrollMomentum() // Apply momentum scrolling with decay
 		e.draw()
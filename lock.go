@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A file open for editing is locked through a small file beside it, so a second
+// mkmd opening it can warn before the two overwrite each other's saves. That
+// matters most for large files, where saving a chunk rewrites the whole file.
+
+// lockPath returns the path of the lock file kept beside filename
+func lockPath(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".mkmd-lock")
+}
+
+// acquireLock takes the lock on filename and returns the open lock file. When
+// another mkmd holds it, the file is nil and holder is that process's ID, or 0
+// when it cannot be told.
+func acquireLock(filename string) (lock *os.File, holder int, err error) {
+	path := lockPath(filename)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	locked, err := tryLock(file)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if !locked {
+		data, _ := os.ReadFile(path)
+		holder, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		file.Close()
+		return nil, holder, nil
+	}
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return file, 0, nil
+}
+
+// claimFile locks the active buffer's file. If another mkmd already has it
+// open, it asks whether to open it read-only (the default) or edit it anyway.
+// Files opened read-only, and files whose folder cannot take a lock file, are
+// left unlocked.
+func (e *Editor) claimFile() {
+	if e.filename == "" || e.readOnly {
+		return
+	}
+	lock, holder, err := acquireLock(e.filename)
+	if err != nil {
+		return
+	}
+	if lock != nil {
+		e.locks = append(e.locks, lock)
+		return
+	}
+
+	other := "another mkmd"
+	if holder > 0 {
+		other = fmt.Sprintf("another mkmd (process %d)", holder)
+	}
+	question := fmt.Sprintf("%s is open in %s.", filepath.Base(e.filename), other)
+	if e.confirm(question, "Read-only", "Edit anyway") != 1 {
+		e.readOnly = true
+	}
+}
+
+// claimNewName gives the buffer the name filename, which it is about to be saved
+// as, and locks it in place of the old name. If another mkmd has the file open
+// and the answer is not to edit it anyway, the buffer keeps its old name and
+// errCancelled is returned, so nothing is written over the other's file.
+func (e *Editor) claimNewName(filename string) error {
+	old := e.filename
+	if filename == old {
+		return nil
+	}
+	e.filename = filename
+	e.claimFile()
+	if e.readOnly {
+		e.readOnly = false
+		e.filename = old
+		e.statusMessage = fmt.Sprintf("Not saved: %s is open in another mkmd", filepath.Base(filename))
+		return errCancelled
+	}
+	if old != "" {
+		e.releaseLock(old)
+	}
+	return nil
+}
+
+// releaseLock gives up the lock on filename, if this mkmd holds it, and removes
+// its lock file
+func (e *Editor) releaseLock(filename string) {
+	path := lockPath(filename)
+	for i, lock := range e.locks {
+		if lock.Name() == path {
+			os.Remove(path)
+			lock.Close()
+			e.locks = append(e.locks[:i], e.locks[i+1:]...)
+			return
+		}
+	}
+}
+
+// releaseLocks gives up the locks on the files this mkmd opened and removes
+// their lock files
+func (e *Editor) releaseLocks() {
+	for _, lock := range e.locks {
+		os.Remove(lock.Name())
+		lock.Close()
+	}
+	e.locks = nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fileLocking reports whether this system can lock files
+const fileLocking = true
+
+// tryLock takes an exclusive lock on file without waiting, returning false when
+// another process holds it. The lock goes away with the process, so one left
+// by a crash does not get in the way.
+func tryLock(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -4,28 +4,321 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
 )
 
+// version is the release printed by --version
+const version = "0.3"
+
+// cliOptions holds the parsed command line
+type cliOptions struct {
+	filenames  []string // Files to open, one buffer each
+	line       int      // 1-based line to open the first file at, 0 when not given
+	col        int      // 1-based column to open the first file at, 0 when not given
+	serve      string   // Address of the live preview server, empty when disabled
+	readOnly   bool     // Open without allowing edits or saves
+	configPath string   // Config file given with --config, empty for the default
+	settings   []string // Config overrides from flags, as alternating key/value
+	version    bool     // Print the version and exit
+	diff       bool     // Compare the two files side by side on opening
+	workspace  string   // Workspace to open instead of filenames
+	follow     bool     // Follow the first file as another process appends to it
+}
+
+// parseArgs reads the command line: filenames, the first of which may end in
+// :LINE or :LINE:COL (as printed by grep -n and compilers), an optional +LINE,
+// and the long flags listed in the usage text. Flags taking a value accept
+// both "--flag value" and "--flag=value".
+func parseArgs(args []string) (cliOptions, error) {
+	opts := cliOptions{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		// next returns the flag's argument, taking the next word if needed
+		next := func() (string, error) {
+			if hasValue {
+				return value, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			return args[i], nil
+		}
+
+		switch {
+		case arg == "--serve":
+			opts.serve = defaultPreviewAddr
+		case name == "--serve":
+			opts.serve = value
+		case arg == "--readonly":
+			opts.readOnly = true
+		case arg == "--version":
+			opts.version = true
+		case arg == "--diff":
+			opts.diff = true
+		case arg == "--follow":
+			opts.follow = true
+		case name == "--line":
+			v, err := next()
+			if err != nil {
+				return opts, err
+			}
+			line, err := strconv.Atoi(v)
+			if err != nil || line < 1 {
+				return opts, fmt.Errorf("invalid line number %q", v)
+			}
+			opts.line = line
+		case name == "--chunk-lines", name == "--theme", name == "--tab-width":
+			v, err := next()
+			if err != nil {
+				return opts, err
+			}
+			key := strings.ReplaceAll(strings.TrimPrefix(name, "--"), "-", "_")
+			opts.settings = append(opts.settings, key, v)
+		case name == "--workspace":
+			v, err := next()
+			if err != nil {
+				return opts, err
+			}
+			opts.workspace = v
+		case name == "--config":
+			v, err := next()
+			if err != nil {
+				return opts, err
+			}
+			opts.configPath = v
+		case strings.HasPrefix(arg, "--"):
+			return opts, fmt.Errorf("unknown flag %q", name)
+		case strings.HasPrefix(arg, "+") && len(arg) > 1:
+			line, err := strconv.Atoi(arg[1:])
+			if err != nil || line < 1 {
+				return opts, fmt.Errorf("invalid line number %q", arg)
+			}
+			opts.line = line
+		default:
+			opts.filenames = append(opts.filenames, arg)
+		}
+	}
+
+	if len(opts.filenames) > 0 {
+		opts.filenames[0], opts.line, opts.col = splitPosition(opts.filenames[0], opts.line)
+	}
+	filenames, err := expandFilenames(opts.filenames)
+	if err != nil {
+		return opts, err
+	}
+	opts.filenames = filenames
+	if opts.workspace != "" && len(opts.filenames) > 0 {
+		return opts, fmt.Errorf("--workspace opens its own files; leave out the filenames")
+	}
+	if opts.follow && len(opts.filenames) == 0 {
+		return opts, fmt.Errorf("--follow needs a file to follow")
+	}
+	if opts.diff && len(opts.filenames) != 2 {
+		return opts, fmt.Errorf("--diff needs two files, got %d", len(opts.filenames))
+	}
+	return opts, nil
+}
+
+// expandFilenames readies the filenames for opening, one buffer each. A name
+// holding *, ? or [ that is not an existing file is a pattern the shell did not
+// expand (nothing matched, or the shell does not expand them), so it is
+// expanded here and must match something. Folders, as `mkmd *` brings in, are
+// left out, and a file named twice opens once.
+func expandFilenames(names []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	folders := 0
+	for _, name := range names {
+		matches := []string{name}
+		if _, err := os.Stat(name); err != nil && strings.ContainsAny(name, "*?[") {
+			var globErr error
+			matches, globErr = filepath.Glob(name)
+			if globErr != nil {
+				return nil, fmt.Errorf("bad pattern %q", name)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", name)
+			}
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				folders++
+				continue
+			}
+			key, err := filepath.Abs(match)
+			if err != nil {
+				key = match
+			}
+			if !seen[key] {
+				seen[key] = true
+				files = append(files, match)
+			}
+		}
+	}
+	if len(files) == 0 && folders > 0 {
+		return nil, fmt.Errorf("%s is a folder, not a file", names[0])
+	}
+	return files, nil
+}
+
+// loadSettings reads the config file and applies the overrides given as flags
+func loadSettings(opts cliOptions) (config, error) {
+	path := opts.configPath
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	cfg, err := loadConfig(path, opts.configPath != "")
+	if err != nil {
+		return cfg, err
+	}
+	for i := 0; i+1 < len(opts.settings); i += 2 {
+		if err := cfg.set(opts.settings[i], opts.settings[i+1]); err != nil {
+			return cfg, fmt.Errorf("--%s: %v", strings.ReplaceAll(opts.settings[i], "_", "-"), err)
+		}
+	}
+	return cfg, nil
+}
+
+// splitPosition separates a trailing :LINE or :LINE:COL from filename. A file
+// whose real name ends in ":123" wins over the position syntax.
+func splitPosition(filename string, line int) (string, int, int) {
+	if _, err := os.Stat(filename); err == nil || filename == "" {
+		return filename, line, 0
+	}
+	name, position := filename, []int{}
+	for len(position) < 2 {
+		i := strings.LastIndex(name, ":")
+		if i <= 0 {
+			break
+		}
+		n, convErr := strconv.Atoi(name[i+1:])
+		if convErr != nil || n < 1 {
+			break
+		}
+		position = append([]int{n}, position...)
+		name = name[:i]
+	}
+	switch len(position) {
+	case 1:
+		return name, position[0], 0
+	case 2:
+		return name, position[0], position[1]
+	}
+	return filename, line, 0
+}
+
+const usage = `Usage: %s [flags] [+LINE] [filename[:LINE[:COL]] ...]
+
+Flags:
+  --readonly          open without allowing edits or saves
+  --line N            open the first file at line N (same as +N)
+  --chunk-lines N     lines loaded at a time from large files (default 10000)
+  --config PATH       read settings from PATH
+  --theme NAME        colour theme: %s
+  --tab-width N       columns per tab stop and indent (default 4)
+  --serve[=ADDR]      serve a live HTML preview (default %s)
+  --workspace NAME    open the files of a saved workspace (Alt+Shift+K saves one)
+  --diff              compare two files side by side (mkmd --diff a.md b.md)
+  --follow            show lines appended to the first file as they arrive, like tail -f
+  --version           print the version and exit
+
+Run without a filename to open an empty buffer. Each filename opens in its own buffer;
+patterns such as "notes/*.md" are expanded even when the shell leaves them alone, and
+folders are skipped.
+`
+
 // CLI entrypoint. Editor implementation is in other files.
 func main() {
-	args := os.Args[1:]
-	var filename string
-	switch len(args) {
-	case 0:
-		// Open an empty buffer (no filename yet)
-		filename = ""
-	case 1:
-		filename = args[0]
-	default:
-		fmt.Fprintf(os.Stderr, "Usage: %s [filename]\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "\nRun without an argument to open an empty buffer.\n")
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		fmt.Fprintf(os.Stderr, usage, os.Args[0], strings.Join(themeNames(), ", "), defaultPreviewAddr)
 		os.Exit(1)
 	}
+	if opts.version {
+		fmt.Printf("mkmd %s\n", version)
+		return
+	}
+
+	cfg, err := loadSettings(opts)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	var ws workspace
+	if opts.workspace != "" {
+		if ws, err = readWorkspace(opts.workspace); err != nil {
+			log.Fatalf("Failed to open workspace: %v", err)
+		}
+	}
 
-	editor, err := NewEditor(filename)
+	first := ""
+	if len(opts.filenames) > 0 {
+		first = opts.filenames[0]
+	}
+	editor, err := NewEditor(first, cfg)
 	if err != nil {
 		log.Fatalf("Failed to create editor: %v", err)
 	}
+	editor.readOnly = editor.readOnly || opts.readOnly
+	editor.openReadOnly = opts.readOnly
+	editor.claimFile()
+
+	if opts.line > 0 {
+		if err := editor.openAt(opts.line, opts.col); err != nil {
+			editor.releaseLocks()
+			editor.screen.Fini()
+			log.Fatalf("Failed to open %s at line %d: %v", first, opts.line, err)
+		}
+	}
+
+	if len(opts.filenames) > 1 {
+		for _, filename := range opts.filenames[1:] {
+			if err := editor.openBuffer(filename); err != nil {
+				editor.releaseLocks()
+				editor.screen.Fini()
+				log.Fatalf("Failed to open %s: %v", filename, err)
+			}
+			editor.claimFile()
+		}
+		editor.switchBuffer(0)
+	}
+	if opts.workspace != "" {
+		missing, err := editor.openWorkspace(ws)
+		if err != nil {
+			editor.releaseLocks()
+			editor.screen.Fini()
+			log.Fatalf("Failed to open workspace %s: %v", opts.workspace, err)
+		}
+		if missing > 0 {
+			editor.statusMessage = fmt.Sprintf("%d files of the workspace no longer exist", missing)
+		}
+	}
+	if opts.follow {
+		if err := editor.startFollowing(); err != nil {
+			editor.releaseLocks()
+			editor.screen.Fini()
+			log.Fatalf("Failed to follow %s: %v", first, err)
+		}
+	}
+	if opts.diff {
+		editor.screen.PostEvent(tcell.NewEventInterrupt(compareRequest{}))
+	}
+
+	if opts.serve != "" {
+		if err := editor.startPreview(opts.serve); err != nil {
+			editor.releaseLocks()
+			editor.screen.Fini()
+			log.Fatalf("Failed to start preview server: %v", err)
+		}
+		defer editor.preview.close()
+	}
 
 	if err := editor.run(); err != nil {
 		log.Fatalf("Editor error: %v", err)
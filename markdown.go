@@ -0,0 +1,987 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Markers delimiting a generated table of contents so it can be refreshed in place
+const (
+	tocStartMarker = "<!-- toc -->"
+	tocEndMarker   = "<!-- tocstop -->"
+)
+
+// parseHeading parses an ATX heading ("## Title") and returns its level and text
+func parseHeading(line string) (level int, text string, ok bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return 0, "", false // Four spaces of indentation make a code block
+	}
+	for level < len(trimmed) && trimmed[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, "", false
+	}
+	rest := trimmed[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return 0, "", false // "#tag" is not a heading
+	}
+	text = strings.TrimSpace(rest)
+	// Strip an optional closing sequence of #s
+	if stripped := strings.TrimRight(text, "#"); stripped != text && (stripped == "" || strings.HasSuffix(stripped, " ")) {
+		text = strings.TrimSpace(stripped)
+	}
+	return level, text, true
+}
+
+// headingSlug builds a GitHub-style anchor for a heading: lowercase, punctuation
+// dropped, spaces turned into hyphens.
+func headingSlug(text string) string {
+	var slug strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			slug.WriteRune(r)
+		case r == ' ':
+			slug.WriteRune('-')
+		}
+	}
+	return slug.String()
+}
+
+// heading is a markdown heading found in the buffer
+type heading struct {
+	line  int
+	level int
+	text  string
+}
+
+// headings returns the ATX headings of the buffer, skipping fenced code blocks
+func (e *Editor) headings() []heading {
+	result := []heading{}
+	inFence := false
+	for i, line := range e.lines {
+		if isCodeFence(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if level, text, ok := parseHeading(line); ok {
+			result = append(result, heading{line: i, level: level, text: text})
+		}
+	}
+	return result
+}
+
+// jumpHeading moves the cursor to the next (delta 1) or previous (delta -1)
+// heading. With sameLevel, headings deeper than the one the cursor's section
+// starts with are skipped, so the jump lands on a sibling or parent section.
+func (e *Editor) jumpHeading(delta int, sameLevel bool) {
+	list := e.headings()
+	level := 6
+	if sameLevel {
+		for _, h := range list {
+			if h.line <= e.cursorY {
+				level = h.level
+			}
+		}
+	}
+
+	target := -1
+	for i := range list {
+		h := list[i]
+		if delta < 0 {
+			h = list[len(list)-1-i]
+		}
+		if h.level <= level && (h.line-e.cursorY)*delta > 0 {
+			target = h.line
+			break
+		}
+	}
+	if target < 0 {
+		if delta > 0 {
+			e.statusMessage = "No next heading"
+		} else {
+			e.statusMessage = "No previous heading"
+		}
+		return
+	}
+	e.clearSelection()
+	e.cursorY = target
+	e.cursorX = 0
+	e.ensureCursorVisible()
+}
+
+// buildTOC renders a nested markdown list linking to each heading. Duplicate
+// anchors get -1, -2... suffixes the way GitHub numbers them.
+func buildTOC(headings []heading) []string {
+	if len(headings) == 0 {
+		return nil
+	}
+	minLevel := 6
+	for _, h := range headings {
+		if h.level < minLevel {
+			minLevel = h.level
+		}
+	}
+
+	seen := map[string]int{}
+	toc := []string{}
+	for _, h := range headings {
+		slug := headingSlug(h.text)
+		if n, dup := seen[slug]; dup {
+			seen[slug] = n + 1
+			slug = fmt.Sprintf("%s-%d", slug, n+1)
+		} else {
+			seen[slug] = 0
+		}
+		indent := strings.Repeat("  ", h.level-minLevel)
+		toc = append(toc, fmt.Sprintf("%s- [%s](#%s)", indent, h.text, slug))
+	}
+	return toc
+}
+
+// findTOCMarkers returns the line indices of the TOC start and end markers
+func (e *Editor) findTOCMarkers() (start, end int, ok bool) {
+	start = -1
+	for i, line := range e.lines {
+		switch strings.TrimSpace(line) {
+		case tocStartMarker:
+			if start < 0 {
+				start = i
+			}
+		case tocEndMarker:
+			if start >= 0 {
+				return start, i, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// insertTOC refreshes the table of contents between the TOC markers, or inserts a
+// new marked TOC at the cursor if the document has none yet.
+func (e *Editor) insertTOC() {
+	// Headings inside an existing TOC block never appear there, so no filtering needed
+	toc := buildTOC(e.headings())
+
+	e.pushUndoState()
+	e.clearSearch()
+	e.clearSelection()
+	e.invalidateWordCount()
+
+	if start, end, ok := e.findTOCMarkers(); ok {
+		newLines := make([]string, 0, len(e.lines)-(end-start-1)+len(toc))
+		newLines = append(newLines, e.lines[:start+1]...)
+		newLines = append(newLines, toc...)
+		newLines = append(newLines, e.lines[end:]...)
+		e.lines = newLines
+		if e.cursorY > start {
+			e.cursorY = start
+			e.cursorX = 0
+		}
+	} else {
+		block := append(append([]string{tocStartMarker}, toc...), tocEndMarker)
+		at := e.cursorY + 1
+		replace := 0
+		if strings.TrimSpace(e.lines[e.cursorY]) == "" {
+			// Fill the blank line the cursor is on instead of adding after it
+			at = e.cursorY
+			replace = 1
+		}
+		newLines := make([]string, 0, len(e.lines)+len(block))
+		newLines = append(newLines, e.lines[:at]...)
+		newLines = append(newLines, block...)
+		newLines = append(newLines, e.lines[at+replace:]...)
+		e.lines = newLines
+		e.cursorY = at
+		e.cursorX = 0
+	}
+
+	e.modified = true
+	e.adjustCursorPosition()
+	e.ensureCursorVisible()
+}
+
+var (
+	footnoteRefPattern = regexp.MustCompile(`\[\^([^\]\s]+)\]`)
+	footnoteDefPattern = regexp.MustCompile(`^ {0,3}\[\^([^\]\s]+)\]:`)
+)
+
+// footnote is a footnote marker or definition found in the buffer
+type footnote struct {
+	label string
+	line  int
+	col   int // Rune column of the opening bracket
+}
+
+// footnotes collects footnote references and definitions outside fenced code blocks
+func (e *Editor) footnotes() (refs, defs []footnote) {
+	inFence := false
+	for y, line := range e.lines {
+		if isCodeFence(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		defEnd := 0
+		if m := footnoteDefPattern.FindStringSubmatchIndex(line); m != nil {
+			start := strings.Index(line, "[")
+			defs = append(defs, footnote{label: line[m[2]:m[3]], line: y, col: byteIndexToRuneIndex(line, start)})
+			defEnd = m[1]
+		}
+		for _, m := range footnoteRefPattern.FindAllStringSubmatchIndex(line[defEnd:], -1) {
+			refs = append(refs, footnote{
+				label: line[defEnd+m[2] : defEnd+m[3]],
+				line:  y,
+				col:   byteIndexToRuneIndex(line, defEnd+m[0]),
+			})
+		}
+	}
+	return refs, defs
+}
+
+// footnoteAtCursor returns the footnote marker or definition under the cursor
+func (e *Editor) footnoteAtCursor(refs, defs []footnote) (footnote, bool, bool) {
+	for _, def := range defs {
+		if def.line == e.cursorY {
+			return def, true, true
+		}
+	}
+	for _, ref := range refs {
+		width := runeLen(ref.label) + 3 // "[^" + label + "]"
+		if ref.line == e.cursorY && e.cursorX >= ref.col && e.cursorX <= ref.col+width {
+			return ref, false, true
+		}
+	}
+	return footnote{}, false, false
+}
+
+// jumpFootnote jumps from a footnote marker to its definition or from a definition
+// back to its first marker. Elsewhere it reports orphaned footnotes.
+func (e *Editor) jumpFootnote() {
+	refs, defs := e.footnotes()
+	current, isDef, ok := e.footnoteAtCursor(refs, defs)
+	if !ok {
+		e.statusMessage = footnoteReport(refs, defs)
+		return
+	}
+
+	targets := defs
+	if isDef {
+		targets = refs
+	}
+	for _, target := range targets {
+		if target.label == current.label {
+			e.clearSelection()
+			e.cursorY = target.line
+			e.cursorX = target.col
+			e.ensureCursorVisible()
+			return
+		}
+	}
+
+	if isDef {
+		e.statusMessage = fmt.Sprintf("Footnote [^%s] is never referenced", current.label)
+	} else {
+		e.statusMessage = fmt.Sprintf("Footnote [^%s] has no definition", current.label)
+	}
+}
+
+// footnoteReport summarizes footnotes that are missing a definition or never used
+func footnoteReport(refs, defs []footnote) string {
+	defined := map[string]bool{}
+	for _, def := range defs {
+		defined[def.label] = true
+	}
+	used := map[string]bool{}
+	for _, ref := range refs {
+		used[ref.label] = true
+	}
+
+	missing := []string{}
+	for label := range used {
+		if !defined[label] {
+			missing = append(missing, "[^"+label+"]")
+		}
+	}
+	unused := []string{}
+	for label := range defined {
+		if !used[label] {
+			unused = append(unused, "[^"+label+"]")
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unused)
+
+	if len(missing) == 0 && len(unused) == 0 {
+		return fmt.Sprintf("Footnotes OK (%d defined)", len(defs))
+	}
+	parts := []string{}
+	if len(missing) > 0 {
+		parts = append(parts, "no definition: "+strings.Join(missing, " "))
+	}
+	if len(unused) > 0 {
+		parts = append(parts, "unused: "+strings.Join(unused, " "))
+	}
+	return "Orphaned footnotes - " + strings.Join(parts, "; ")
+}
+
+// nextFootnoteLabel returns one more than the highest numeric footnote label in use
+func nextFootnoteLabel(refs, defs []footnote) string {
+	highest := 0
+	for _, list := range [][]footnote{refs, defs} {
+		for _, f := range list {
+			if n, err := strconv.Atoi(f.label); err == nil && n > highest {
+				highest = n
+			}
+		}
+	}
+	return strconv.Itoa(highest + 1)
+}
+
+// insertFootnote inserts a new numbered footnote marker at the cursor and an empty
+// definition at the end of the document, leaving the cursor ready to type it.
+func (e *Editor) insertFootnote() {
+	refs, defs := e.footnotes()
+	label := nextFootnoteLabel(refs, defs)
+
+	e.pushUndoState()
+	e.clearSearch()
+	e.clearSelection()
+	e.invalidateWordCount()
+
+	marker := "[^" + label + "]"
+	e.lines[e.cursorY] = runeInsert(e.lines[e.cursorY], e.cursorX, marker)
+
+	// Keep definitions separated from the body text by a blank line
+	if last := e.lines[len(e.lines)-1]; strings.TrimSpace(last) != "" && !footnoteDefPattern.MatchString(last) {
+		e.lines = append(e.lines, "")
+	}
+	definition := marker + ": "
+	e.lines = append(e.lines, definition)
+
+	e.cursorY = len(e.lines) - 1
+	e.cursorX = runeLen(definition)
+	e.modified = true
+	e.ensureCursorVisible()
+}
+
+// isURL reports whether target should be linked verbatim rather than as a file path
+func isURL(target string) bool {
+	return strings.Contains(target, "://") || strings.HasPrefix(target, "mailto:") || strings.HasPrefix(target, "#")
+}
+
+// linkTarget turns a path typed at the prompt into a markdown link destination
+// relative to the document, using forward slashes and angle brackets when the
+// path contains characters that would end the destination early.
+func linkTarget(docDir, path string) string {
+	if isURL(path) {
+		return path
+	}
+	if filepath.IsAbs(path) {
+		if absDir, err := filepath.Abs(docDir); err == nil {
+			if rel, err := filepath.Rel(absDir, path); err == nil {
+				path = rel
+			}
+		}
+	}
+	path = filepath.ToSlash(path)
+	if strings.ContainsAny(path, " ()<>") {
+		return "<" + path + ">"
+	}
+	return path
+}
+
+// resolvePath returns the filesystem path for a path typed relative to the document
+func resolvePath(docDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(docDir, path)
+}
+
+// insertInline replaces the selection (if any) with text and places the cursor
+// after it. Text with newlines splits the cursor line.
+func (e *Editor) insertInline(text string) {
+	e.pushUndoState()
+	e.clearSearch()
+	e.invalidateWordCount()
+	if e.selectionStart {
+		e.deleteSelection()
+		e.clearSelection()
+	}
+
+	parts := strings.Split(text, "\n")
+	line := e.lines[e.cursorY]
+	tail := runeSubstring(line, e.cursorX, runeLen(line))
+	inserted := make([]string, len(parts))
+	copy(inserted, parts)
+	inserted[0] = runeSubstring(line, 0, e.cursorX) + parts[0]
+	inserted[len(parts)-1] += tail
+	e.lines = append(e.lines[:e.cursorY], append(inserted, e.lines[e.cursorY+1:]...)...)
+	e.cursorY += len(parts) - 1
+	e.cursorX = runeLen(inserted[len(parts)-1]) - runeLen(tail)
+	e.modified = true
+	e.ensureCursorVisible()
+}
+
+// insertLink prompts for a path or URL (Tab completes paths relative to the
+// document) and link text, then inserts a markdown link. Selected text becomes
+// the link text.
+func (e *Editor) insertLink() {
+	docDir := e.documentDir()
+	path := e.promptPath("Link to", docDir)
+	if path == "" {
+		return
+	}
+
+	text := ""
+	if e.selectionStart && !e.blockSelection {
+		text = e.getSelectedText()
+	}
+	if text == "" || strings.Contains(text, "\n") {
+		text = e.prompt("Link text: ")
+	}
+	if text == "" {
+		text = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	e.insertInline(fmt.Sprintf("[%s](%s)", text, linkTarget(docDir, path)))
+}
+
+// insertImage prompts for an image path (Tab completes relative to the document),
+// optionally copies it into an assets/ folder next to the document, and inserts
+// the markdown image syntax at the cursor.
+func (e *Editor) insertImage() {
+	docDir := e.documentDir()
+	path := e.promptPath("Image", docDir)
+	if path == "" {
+		return
+	}
+
+	if !isURL(path) {
+		full := resolvePath(docDir, path)
+		if _, err := os.Stat(full); err != nil {
+			e.statusMessage = "Image not found: " + path
+			return
+		}
+
+		assets, _ := filepath.Abs(filepath.Join(docDir, "assets"))
+		absFull, _ := filepath.Abs(full)
+		choice := 1 // Link to the file where it is
+		if filepath.Dir(absFull) != assets {
+			choice = e.confirm("Copy the image into assets/?", "Copy", "Link in place")
+		}
+		if choice < 0 {
+			return
+		}
+		if choice == 0 {
+			copied, err := copyIntoAssets(docDir, full)
+			if err != nil {
+				e.reportError("Copying the image", err)
+				return
+			}
+			path, _ = filepath.Rel(docDir, copied)
+		}
+	}
+
+	alt := e.prompt("Alt text: ")
+	if alt == "" {
+		alt = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	e.insertInline(fmt.Sprintf("![%s](%s)", alt, linkTarget(docDir, path)))
+}
+
+// pasteText handles text the terminal pasted, or typed for a file dropped on
+// it. The path of an existing file offers to open the file, link to it (an
+// image link for an image), or insert the path as text; other text is inserted
+// as it is, in one undo step. Tab- or comma-separated rows, as copied from a
+// spreadsheet, can be pasted as a table instead.
+func (e *Editor) pasteText(text string) {
+	path := droppedPath(text)
+	if path == "" {
+		if e.readOnly {
+			e.statusMessage = "Read-only"
+			return
+		}
+		if rows, separator := tabularData(text); rows != nil {
+			kind := "comma"
+			if separator == '\t' {
+				kind = "tab"
+			}
+			question := fmt.Sprintf("Pasted %d rows of %s-separated values.", len(rows), kind)
+			switch e.confirm(question, "Table", "Text") {
+			case 0:
+				text = markdownTable(rows)
+				if e.cursorX > 0 {
+					text = "\n" + text
+				}
+			case -1:
+				return
+			}
+		}
+		e.insertInline(text)
+		return
+	}
+
+	name := filepath.Base(path)
+	question := fmt.Sprintf("Pasted the path of %s.", name)
+	choices := []string{"Open", "Link", "Insert text"}
+	if e.readOnly {
+		choices = choices[:1]
+	}
+	switch e.confirm(question, choices...) {
+	case 0:
+		if err := e.openBuffer(path); err != nil {
+			e.reportError("Opening "+name, err)
+			return
+		}
+		e.claimFile()
+	case 1:
+		docDir := e.documentDir()
+		title := strings.TrimSuffix(name, filepath.Ext(name))
+		if strings.HasPrefix(mime.TypeByExtension(filepath.Ext(name)), "image/") {
+			e.insertInline(fmt.Sprintf("![%s](%s)", title, linkTarget(docDir, path)))
+		} else {
+			e.insertInline(fmt.Sprintf("[%s](%s)", title, linkTarget(docDir, path)))
+		}
+	case 2:
+		e.insertInline(text)
+	}
+}
+
+// droppedPath returns the file path text holds when it is nothing but the
+// absolute path of an existing file, as terminals type it for a dropped file:
+// perhaps quoted, with backslashes before spaces, or as a file:// URL.
+// Otherwise it returns "".
+func droppedPath(text string) string {
+	path := strings.TrimSpace(text)
+	if path == "" || strings.Contains(path, "\n") {
+		return ""
+	}
+	if len(path) > 1 && (path[0] == '\'' || path[0] == '"') && path[len(path)-1] == path[0] {
+		path = path[1 : len(path)-1]
+	} else if u, err := url.Parse(path); err == nil && u.Scheme == "file" {
+		path = u.Path
+	} else if filepath.Separator == '/' {
+		path = shellUnescaper.Replace(path)
+	}
+	if !filepath.IsAbs(path) {
+		return ""
+	}
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return path
+}
+
+// shellUnescaper undoes the backslashes terminals put before spaces and other
+// shell characters in a dropped file's path
+var shellUnescaper = strings.NewReplacer(`\ `, " ", `\(`, "(", `\)`, ")", `\'`, "'", `\&`, "&", `\\`, `\`)
+
+var (
+	orderedItemPattern = regexp.MustCompile(`^([ \t]*)(\d{1,9})([.)])([ \t]|$)`)
+	bulletItemPattern  = regexp.MustCompile(`^([ \t]*)[-*+]([ \t]|$)`)
+)
+
+// listLevel is an open ordered list while renumbering
+type listLevel struct {
+	indent int
+	delim  string
+	next   int
+}
+
+// indentWidth measures leading whitespace, counting a tab as four columns
+func indentWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r == '\t' {
+			width += 4
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// isListBreak reports whether line ends any list: unindented text that is not an item
+func isListBreak(line string) bool {
+	if strings.TrimSpace(line) == "" || line[0] == ' ' || line[0] == '\t' {
+		return false
+	}
+	return !orderedItemPattern.MatchString(line) && !bulletItemPattern.MatchString(line)
+}
+
+// renumberLines renumbers the ordered list items in lines[start:end+1] in place.
+// Each list keeps the number of its first item and nested lists are numbered
+// independently by indentation. Returns the number of lines changed.
+func renumberLines(lines []string, start, end int) int {
+	var stack []listLevel
+	changed := 0
+	inFence := false
+	blanks := 0
+
+	for y := start; y <= end && y < len(lines); y++ {
+		line := lines[y]
+		if isCodeFence(line) {
+			inFence = !inFence
+			// A fence inside a list item is indented and does not end the list
+			if line[0] != ' ' && line[0] != '\t' {
+				stack = nil
+			}
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			blanks++
+			if blanks >= 2 {
+				stack = nil
+			}
+			continue
+		}
+		blanks = 0
+
+		if m := orderedItemPattern.FindStringSubmatchIndex(line); m != nil {
+			indent := indentWidth(line[m[2]:m[3]])
+			delim := line[m[6]:m[7]]
+			number, _ := strconv.Atoi(line[m[4]:m[5]])
+
+			for len(stack) > 0 && stack[len(stack)-1].indent > indent {
+				stack = stack[:len(stack)-1]
+			}
+			if n := len(stack); n > 0 && stack[n-1].indent == indent && stack[n-1].delim == delim {
+				stack[n-1].next++
+			} else {
+				if n > 0 && stack[n-1].indent == indent {
+					stack = stack[:n-1]
+				}
+				stack = append(stack, listLevel{indent: indent, delim: delim, next: number})
+			}
+
+			if want := stack[len(stack)-1].next; want != number {
+				lines[y] = line[:m[4]] + strconv.Itoa(want) + line[m[5]:]
+				changed++
+			}
+		} else if m := bulletItemPattern.FindStringSubmatch(line); m != nil {
+			// A bullet ends any ordered list at its level or deeper
+			indent := indentWidth(m[1])
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+		} else if isListBreak(line) {
+			stack = nil
+		}
+	}
+	return changed
+}
+
+// renumberLists renumbers every ordered list in the document
+func (e *Editor) renumberLists() {
+	linesCopy := make([]string, len(e.lines))
+	copy(linesCopy, e.lines)
+	changed := renumberLines(linesCopy, 0, len(linesCopy)-1)
+	if changed == 0 {
+		e.statusMessage = "Lists already numbered"
+		return
+	}
+
+	e.pushUndoState()
+	e.clearSearch()
+	e.invalidateWordCount()
+	e.lines = linesCopy
+	e.modified = true
+	e.adjustCursorPosition()
+	e.statusMessage = fmt.Sprintf("Renumbered %d list items", changed)
+}
+
+// renumberListAtCursor renumbers the list around the cursor as part of the edit
+// that was just made, keeping the cursor on the same character.
+func (e *Editor) renumberListAtCursor() {
+	if e.cursorY >= len(e.lines) {
+		return
+	}
+	if e.inFencedBlock(e.cursorY) || isCodeFence(e.lines[e.cursorY]) {
+		return
+	}
+
+	// The block runs between lines that cannot belong to a list
+	start, end := e.cursorY, e.cursorY
+	for start > 0 && !isListBreak(e.lines[start-1]) && !isCodeFence(e.lines[start-1]) {
+		start--
+	}
+	for end < len(e.lines)-1 && !isListBreak(e.lines[end+1]) && !isCodeFence(e.lines[end+1]) {
+		end++
+	}
+
+	before := runeLen(e.lines[e.cursorY])
+	if renumberLines(e.lines, start, end) == 0 {
+		return
+	}
+	e.invalidateWordCount()
+	if delta := runeLen(e.lines[e.cursorY]) - before; delta != 0 {
+		// Shift the cursor only if it sits after the number that changed width
+		line := e.lines[e.cursorY]
+		if m := orderedItemPattern.FindStringSubmatchIndex(line); m != nil && e.cursorX >= byteIndexToRuneIndex(line, m[5])-delta {
+			e.cursorX += delta
+		}
+	}
+	if e.cursorX < 0 {
+		e.cursorX = 0
+	}
+}
+
+// inFencedBlock reports whether line y lies inside a fenced code block
+func (e *Editor) inFencedBlock(y int) bool {
+	inFence := false
+	for _, line := range e.lines[:y] {
+		if isCodeFence(line) {
+			inFence = !inFence
+		}
+	}
+	return inFence
+}
+
+// frontMatterEnd returns the index of the line closing a leading YAML front matter
+// block, or -1 when the document has none.
+func frontMatterEnd(lines []string) int {
+	if len(lines) == 0 || lines[0] != "---" {
+		return -1
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == "---" || lines[i] == "..." {
+			return i
+		}
+	}
+	return -1
+}
+
+// punctContext tracks the parts of a line where punctuation must stay literal:
+// code spans, HTML tags and comments, and link destinations.
+type punctContext struct {
+	code, tag, dest bool
+	prev            rune
+}
+
+func (c *punctContext) feed(r rune) {
+	switch {
+	case r == '`' && !c.tag && !c.dest:
+		c.code = !c.code
+	case c.code:
+	case r == '<':
+		c.tag = true
+	case r == '>':
+		c.tag = false
+	case r == '(' && c.prev == ']':
+		c.dest = true
+	case r == ')' && c.dest:
+		c.dest = false
+	}
+	c.prev = r
+}
+
+func (c *punctContext) plain() bool {
+	return !c.code && !c.tag && !c.dest
+}
+
+// opensQuote reports whether a quote typed after prev starts a quotation
+func opensQuote(prev rune) bool {
+	return prev == 0 || unicode.IsSpace(prev) || strings.ContainsRune("([{“‘—–/", prev)
+}
+
+// smartPunct decides how typing r after before (the line up to the cursor) is
+// written in typography mode: drop runes are removed before inserting out.
+func smartPunct(before []rune, r rune) (drop int, out string) {
+	var prev, prevPrev rune
+	if n := len(before); n > 0 {
+		prev = before[n-1]
+		if n > 1 {
+			prevPrev = before[n-2]
+		}
+	}
+
+	switch r {
+	case '"':
+		if opensQuote(prev) {
+			return 0, "“"
+		}
+		return 0, "”"
+	case '\'':
+		if opensQuote(prev) {
+			return 0, "‘"
+		}
+		return 0, "’"
+	case '-':
+		// "--" between words becomes an en dash and a third dash an em dash.
+		// Dashes that start a line (rules, front matter, list markers) and table
+		// rows are left alone.
+		if prev == '–' {
+			return 1, "—"
+		}
+		if prev == '-' && strings.TrimSpace(string(before[:len(before)-1])) != "" &&
+			(unicode.IsLetter(prevPrev) || unicode.IsDigit(prevPrev) || prevPrev == ' ') &&
+			!strings.ContainsRune(string(before), '|') {
+			return 1, "–"
+		}
+	case '.':
+		if prev == '.' && prevPrev == '.' {
+			return 2, "…"
+		}
+	}
+	return 0, string(r)
+}
+
+// smartenLine applies typography rules to a whole line as if it had been typed
+func smartenLine(line string) string {
+	result := []rune{}
+	ctx := punctContext{}
+	for _, r := range line {
+		if ctx.plain() {
+			drop, out := smartPunct(result, r)
+			result = append(result[:len(result)-drop], []rune(out)...)
+		} else {
+			result = append(result, r)
+		}
+		ctx.feed(r)
+	}
+	return string(result)
+}
+
+// insertSmartChar inserts a typed character, converting quotes, dashes and
+// ellipses outside code. The literal character is its own undo step so a single
+// undo brings back the straight punctuation.
+func (e *Editor) insertSmartChar(r rune) {
+	if e.cursorY >= len(e.lines) || e.inFencedBlock(e.cursorY) || e.cursorY <= frontMatterEnd(e.lines) {
+		e.insertChar(r)
+		return
+	}
+
+	before := []rune(e.lines[e.cursorY])
+	if e.cursorX < len(before) {
+		before = before[:e.cursorX]
+	}
+	ctx := punctContext{}
+	for _, c := range before {
+		ctx.feed(c)
+	}
+	drop, out := smartPunct(before, r)
+	if !ctx.plain() || (drop == 0 && out == string(r)) {
+		e.insertChar(r)
+		return
+	}
+
+	e.insertChar(r)
+	e.pushUndoState()
+	start := e.cursorX - 1 - drop
+	e.lines[e.cursorY] = runeInsert(runeDelete(e.lines[e.cursorY], start, e.cursorX), start, out)
+	e.cursorX = start + runeLen(out)
+}
+
+// smartenDocument applies typography rules to every line outside code blocks and
+// front matter
+func (e *Editor) smartenDocument() {
+	linesCopy := make([]string, len(e.lines))
+	copy(linesCopy, e.lines)
+
+	changed := 0
+	inFence := false
+	for i := frontMatterEnd(linesCopy) + 1; i < len(linesCopy); i++ {
+		if isCodeFence(linesCopy[i]) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if smart := smartenLine(linesCopy[i]); smart != linesCopy[i] {
+			linesCopy[i] = smart
+			changed++
+		}
+	}
+	if changed == 0 {
+		e.statusMessage = "No punctuation to convert"
+		return
+	}
+
+	e.pushUndoState()
+	e.clearSearch()
+	e.invalidateWordCount()
+	e.lines = linesCopy
+	e.modified = true
+	e.adjustCursorPosition()
+	e.statusMessage = fmt.Sprintf("Converted punctuation on %d lines", changed)
+}
+
+// shiftHeadings adds (delta > 0) or removes (delta < 0) a '#' from the heading under
+// the cursor or every heading in the selection. Headings already at H1 or H6 are
+// left as they are.
+func (e *Editor) shiftHeadings(delta int) {
+	startY, endY := e.selectedLines()
+
+	linesCopy := make([]string, len(e.lines))
+	copy(linesCopy, e.lines)
+	found, changed, cursorShift := 0, 0, 0
+	inFence := e.inFencedBlock(startY)
+	for y := startY; y <= endY && y < len(linesCopy); y++ {
+		line := linesCopy[y]
+		if isCodeFence(line) {
+			inFence = !inFence
+			continue
+		}
+		level, _, ok := parseHeading(line)
+		if inFence || !ok {
+			continue
+		}
+		found++
+		if level+delta < 1 || level+delta > 6 {
+			continue
+		}
+
+		hashes := strings.Index(line, "#")
+		if delta > 0 {
+			linesCopy[y] = line[:hashes] + "#" + line[hashes:]
+		} else {
+			linesCopy[y] = line[:hashes] + line[hashes+1:]
+		}
+		changed++
+		if y == e.cursorY && e.cursorX > hashes {
+			cursorShift = delta
+		}
+	}
+
+	switch {
+	case found == 0:
+		e.statusMessage = "No heading to promote or demote"
+		return
+	case changed == 0 && delta < 0:
+		e.statusMessage = "Already at H1"
+		return
+	case changed == 0:
+		e.statusMessage = "Already at H6"
+		return
+	}
+
+	e.pushUndoState()
+	e.clearSearch()
+	e.lines = linesCopy
+	e.cursorX += cursorShift
+	e.modified = true
+	e.ensureCursorVisible()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// mathDelimiter is a $ or $$ that opens math and is never closed
+type mathDelimiter struct {
+	x, y    int
+	display bool
+}
+
+// mathInLine finds the TeX math in a line: inline $...$ spans and $$...$$
+// display math, which may carry on from an earlier line (display) and on to a
+// later one (the returned bool). As in Pandoc, an inline span's opening $ has
+// no space after it and its closing $ has no space before it and no digit
+// after it, so "$5 and $10" holds no math. Code spans and escaped dollars are
+// skipped. unclosed holds the openers with no partner on the line, except an
+// inline $ followed by a digit, which is taken to be a price.
+func mathInLine(runes []rune, display bool) (spans [][2]int, unclosed []mathDelimiter, _ bool) {
+	start, opened, code := 0, -1, false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case display:
+			if r == '\\' {
+				i++
+			} else if r == '$' && i+1 < len(runes) && runes[i+1] == '$' {
+				spans = append(spans, [2]int{start, i + 2})
+				if opened >= 0 {
+					unclosed = unclosed[:opened]
+				}
+				display, opened = false, -1
+				i++
+			}
+		case r == '`':
+			code = !code
+		case code:
+		case r == '\\':
+			i++
+		case r != '$':
+		case i+1 < len(runes) && runes[i+1] == '$':
+			start, opened, display = i, len(unclosed), true
+			unclosed = append(unclosed, mathDelimiter{x: i, display: true})
+			i++
+		case i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			end := closingDollar(runes, i)
+			if end < 0 {
+				if !unicode.IsDigit(runes[i+1]) {
+					unclosed = append(unclosed, mathDelimiter{x: i})
+				}
+				continue
+			}
+			spans = append(spans, [2]int{i, end + 1})
+			i = end
+		}
+	}
+	if display {
+		spans = append(spans, [2]int{start, len(runes)})
+	}
+	return spans, unclosed, display
+}
+
+// closingDollar returns the index of the $ closing the inline math opened at
+// runes[open], or -1 when the line has none. A $$ never closes inline math.
+func closingDollar(runes []rune, open int) int {
+	for j := open + 2; j < len(runes); j++ {
+		switch {
+		case runes[j] == '\\':
+			j++
+		case runes[j] == '$' && j+1 < len(runes) && runes[j+1] == '$':
+			j++
+		case runes[j] == '$' && !unicode.IsSpace(runes[j-1]) &&
+			(j+1 == len(runes) || !unicode.IsDigit(runes[j+1])):
+			return j
+		}
+	}
+	return -1
+}
+
+// scanMath finds the math in a document outside the lines marked as code. It
+// returns the math on each line, the lines of display math blocks (those
+// starting with $$ or inside one), and the delimiters left unclosed. A blank
+// line ends display math, as it ends the paragraph holding it.
+func scanMath(lines []string, code []bool) (spans [][][2]int, block []bool, unclosed []mathDelimiter) {
+	spans = make([][][2]int, len(lines))
+	block = make([]bool, len(lines))
+	display := false
+	var pending mathDelimiter
+	for y, line := range lines {
+		if code[y] {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" && display {
+			unclosed = append(unclosed, pending)
+			display = false
+			continue
+		}
+		block[y] = display || strings.HasPrefix(trimmed, "$$")
+		var found []mathDelimiter
+		spans[y], found, display = mathInLine([]rune(line), display)
+		for _, d := range found {
+			d.y = y
+			if d.display {
+				pending = d
+			} else {
+				unclosed = append(unclosed, d)
+			}
+		}
+	}
+	if display {
+		unclosed = append(unclosed, pending)
+	}
+	sort.Slice(unclosed, func(i, j int) bool {
+		a, b := unclosed[i], unclosed[j]
+		return a.y < b.y || a.y == b.y && a.x < b.x
+	})
+	return spans, block, unclosed
+}
+
+// countWordsOutside counts the whitespace-separated words of a line, leaving
+// out math: a word touching math counts only if the rest of it has a letter
+// or digit
+func countWordsOutside(line string, math [][2]int) int {
+	if len(math) == 0 {
+		return len(strings.Fields(line))
+	}
+	runes := []rune(line)
+	inMath := func(i int) bool {
+		for _, span := range math {
+			if i >= span[0] && i < span[1] {
+				return true
+			}
+		}
+		return false
+	}
+	count := 0
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		touches, wordy := false, false
+		for ; i < len(runes) && !unicode.IsSpace(runes[i]); i++ {
+			if inMath(i) {
+				touches = true
+			} else if unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) {
+				wordy = true
+			}
+		}
+		if !touches || wordy {
+			count++
+		}
+	}
+	return count
+}
+
+// drawMath colours the math on screen, leaving other highlights as they are
+func (e *Editor) drawMath() {
+	spans, _, _ := scanMath(e.lines, codeLiteral(e.lines))
+	e.forEachVisibleRune(func(x, y, col, sx, sy int, runes []rune) {
+		for _, span := range spans[y] {
+			if x >= span[0] && x < span[1] {
+				if _, _, style, _ := e.screen.GetContent(sx, sy); style == e.theme.text {
+					e.drawRune(sx, sy, col, runes, x, e.theme.math)
+				}
+				return
+			}
+		}
+	})
+}
+
+// jumpUnclosedMath moves the cursor to the next math delimiter after it that
+// is never closed, wrapping around the document
+func (e *Editor) jumpUnclosedMath() {
+	_, _, unclosed := scanMath(e.lines, codeLiteral(e.lines))
+	if len(unclosed) == 0 {
+		e.statusMessage = "Math delimiters are balanced"
+		return
+	}
+	next := 0
+	for i, d := range unclosed {
+		if d.y > e.cursorY || d.y == e.cursorY && d.x > e.cursorX {
+			next = i
+			break
+		}
+	}
+	d := unclosed[next]
+	e.clearSelection()
+	e.cursorY, e.cursorX = d.y, d.x
+	e.ensureCursorVisible()
+	delimiter := "$"
+	if d.display {
+		delimiter = "$$"
+	}
+	e.statusMessage = fmt.Sprintf("Unclosed %s (%d of %d)", delimiter, next+1, len(unclosed))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+)
+
+// minimapWidth is the number of columns the minimap takes from the text area,
+// including its border
+const minimapWidth = 12
+
+// minimapScale is the number of text columns each minimap cell stands for
+const minimapScale = 8
+
+// minimapShown reports whether the minimap is on and has room: it gives way to
+// focus mode and to narrow windows
+func (e *Editor) minimapShown() bool {
+	return e.minimap && !e.focusMode && e.fullWidth() >= e.width+minimapWidth
+}
+
+// minimapLinesPerRow returns how many lines each minimap row stands for, so the
+// whole buffer fits in rows rows
+func (e *Editor) minimapLinesPerRow(rows int) int {
+	return max(1, (len(e.lines)+rows-1)/rows)
+}
+
+// minimapGlyph shades a cell by how much of it is text
+func minimapGlyph(filled, capacity int) rune {
+	switch {
+	case filled == 0:
+		return ' '
+	case filled*4 <= capacity:
+		return '░'
+	case filled*5 <= capacity*3:
+		return '▒'
+	default:
+		return '▓'
+	}
+}
+
+// drawMinimap draws a compressed view of the buffer to the right of the text
+// area. Each cell shades a block of lines and columns by how much text it holds;
+// the rows in view are highlighted and cells holding search matches marked.
+func (e *Editor) drawMinimap() {
+	if !e.minimapShown() {
+		return
+	}
+	rows := e.height - 1
+	per := e.minimapLinesPerRow(rows)
+	cells := minimapWidth - 1
+	lowerSearch := strings.ToLower(e.searchTerm)
+
+	for sy := 0; sy < rows; sy++ {
+		e.screen.SetContent(e.width, sy, '│', nil, e.theme.dim)
+		first := sy * per
+		last := min(first+per, len(e.lines))
+		filled := make([]int, cells)
+		matched := make([]bool, cells)
+		for y := first; y < last; y++ {
+			col := 0
+			runes := []rune(e.lines[y])
+			for i, r := range runes {
+				w := e.runeCells(runes, i, col)
+				if c := col / minimapScale; c < cells && r != ' ' && r != '\t' {
+					filled[c] += w
+				}
+				col += w
+			}
+			if lowerSearch == "" {
+				continue
+			}
+			line := strings.ToLower(e.lines[y])
+			for idx := strings.Index(line, lowerSearch); idx != -1; {
+				if c := e.displayColumn(e.lines[y], runeLen(line[:idx])) / minimapScale; c < cells {
+					matched[c] = true
+				}
+				next := strings.Index(line[idx+len(lowerSearch):], lowerSearch)
+				if next == -1 {
+					break
+				}
+				idx += len(lowerSearch) + next
+			}
+		}
+
+		style := e.theme.text
+		if first < last && first < e.offsetY+rows && last > e.offsetY {
+			style = e.theme.selection
+		}
+		for c := 0; c < cells; c++ {
+			cellStyle := style
+			if matched[c] {
+				cellStyle = e.theme.search
+			}
+			e.screen.SetContent(e.width+1+c, sy, minimapGlyph(filled[c], (last-first)*minimapScale), nil, cellStyle)
+		}
+	}
+}
+
+// jumpToMinimapRow moves the cursor to the first line a minimap row stands for
+// and centers it in the window
+func (e *Editor) jumpToMinimapRow(sy int) {
+	rows := e.height - 1
+	line := min(sy*e.minimapLinesPerRow(rows), len(e.lines)-1)
+	e.clearSelection()
+	e.cursorY, e.cursorX = line, 0
+	e.offsetY = max(0, min(line-rows/2, len(e.lines)-rows))
+	e.scrollMomentum = 0
+	e.ensureCursorVisible()
+}
+
+// toggleMinimap shows or hides the minimap, narrowing the text area to make room
+func (e *Editor) toggleMinimap() {
+	e.minimap = !e.minimap
+	e.layoutText()
+	if e.minimap {
+		e.statusMessage = "Minimap on"
+	} else {
+		e.statusMessage = "Minimap off"
+	}
+	e.ensureCursorVisible()
+}
@@ -3,8 +3,17 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -66,8 +75,9 @@ func createTestEditor(filename string) (*Editor, error) {
 		height:             24,
 		offsetY:            0,
 		offsetX:            0,
-		undoStack:          make([][]string, 0),
-		redoStack:          make([][]string, 0),
+		undoStack:          make([]undoState, 0),
+		redoStack:          make([]undoState, 0),
+		undoBudget:         defaultUndoBudget,
 		modified:           false,
 		searchTerm:         "",
 		searchIndex:        0,
@@ -84,6 +94,9 @@ func createTestEditor(filename string) (*Editor, error) {
 		scrollMomentum:     0.0,
 		maxScrollMomentum:  250.0,
 		momentumDecay:      0.85,
+		theme:              themes["default"],
+		config:             defaultConfig(),
+		tabWidth:           4,
 	}
 
 	// Load existing file if filename is provided and file exists
@@ -388,9 +401,8 @@ func TestEditorStateManagement(t *testing.T) {
 
 	// Test undo (should undo the last character insertion)
 	editor.undo()
-	// The undo might be working correctly, let's test the functionality rather than exact content
-	if len(editor.lines[0]) >= len("hello") {
-		t.Error("Undo should have removed at least one character")
+	if editor.lines[0] != "hell" {
+		t.Errorf("Undo should remove exactly the last character, got '%s'", editor.lines[0])
 	}
 
 	// Should have redo state now
@@ -405,17 +417,39 @@ func TestEditorStateManagement(t *testing.T) {
 	}
 
 	// Test bounded undo stack
-	// Insert more than maxUndoStates operations
-	for i := 0; i < maxUndoStates+10; i++ {
+	// Shrink the budget and insert enough operations to exceed it
+	editor.undoBudget = 2048
+	for i := 0; i < 200; i++ {
 		editor.insertChar('x')
 	}
 
-	// Should not exceed maxUndoStates
-	if len(editor.undoStack) > maxUndoStates {
-		t.Errorf("Undo stack exceeded maxUndoStates: %d > %d", len(editor.undoStack), maxUndoStates)
+	// Should not exceed the byte budget
+	if editor.undoBytes > editor.undoBudget {
+		t.Errorf("Undo stack exceeded its budget: %d > %d bytes", editor.undoBytes, editor.undoBudget)
+	}
+	if editor.undoBytes != sumUndoSizes(editor.undoStack) {
+		t.Errorf("Tracked undo bytes %d don't match stack contents %d", editor.undoBytes, sumUndoSizes(editor.undoStack))
+	}
+
+	// Small documents keep deep history under the default budget
+	editor.undoBudget = defaultUndoBudget
+	for i := 0; i < 500; i++ {
+		editor.insertChar('y')
+	}
+	if len(editor.undoStack) < 500 {
+		t.Errorf("Expected at least 500 undo states for a small document, got %d", len(editor.undoStack))
 	}
 }
 
+// sumUndoSizes totals the recorded sizes of a history stack
+func sumUndoSizes(stack []undoState) int {
+	total := 0
+	for _, state := range stack {
+		total += state.size
+	}
+	return total
+}
+
 // TestCursorPositioning tests cursor boundary handling
 func TestCursorPositioning(t *testing.T) {
 	editor, err := createTestEditor("")
@@ -1053,3 +1087,5150 @@ func TestPromptBackspaceUnicode(t *testing.T) {
 		t.Fatal("prompt did not return in time")
 	}
 }
+
+// TestBlockCopyPaste verifies that a column block copy pastes onto successive lines
+// at the cursor column instead of being spliced into a single spot.
+func TestBlockCopyPaste(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{
+		"abcd",
+		"efgh",
+		"ij",
+	}
+
+	// Select columns 1-3 on the first two lines
+	editor.cursorX, editor.cursorY = 1, 0
+	editor.startBlockSelection()
+	editor.cursorX, editor.cursorY = 3, 1
+
+	if got := editor.getSelectedText(); got != "bc\nfg" {
+		t.Fatalf("Block selection should be %q, got %q", "bc\nfg", got)
+	}
+
+	editor.copy()
+	if !editor.clipboardBlock {
+		t.Fatal("Clipboard should be marked as a block copy")
+	}
+
+	// Paste at column 4 of the second line; the short third line gets padded
+	editor.clearSelection()
+	editor.cursorX, editor.cursorY = 4, 1
+	editor.paste()
+
+	expected := []string{"abcd", "efghbc", "ij  fg"}
+	for i, want := range expected {
+		if editor.lines[i] != want {
+			t.Errorf("Line %d: expected %q, got %q", i, want, editor.lines[i])
+		}
+	}
+	if editor.cursorY != 2 || editor.cursorX != 6 {
+		t.Errorf("Cursor should end after the last pasted segment (6,2), got (%d,%d)", editor.cursorX, editor.cursorY)
+	}
+
+	// Pasting past the last line appends new lines
+	editor.cursorX, editor.cursorY = 0, 2
+	editor.paste()
+	if len(editor.lines) != 4 || editor.lines[3] != "fg" {
+		t.Errorf("Block paste should append missing lines, got %q", editor.lines)
+	}
+
+	// A block cut removes the same columns from every row
+	editor.lines = []string{"abcd", "efgh"}
+	editor.cursorX, editor.cursorY = 1, 0
+	editor.startBlockSelection()
+	editor.cursorX, editor.cursorY = 3, 1
+	editor.cut()
+	if editor.lines[0] != "ad" || editor.lines[1] != "eh" {
+		t.Errorf("Block cut should remove columns 1-3, got %q", editor.lines)
+	}
+}
+
+// TestMatchingBracket covers bracket, backtick and code fence matching
+func TestMatchingBracket(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{
+		"[text](url (x))",
+		"{",
+		"  a `code` b",
+		"}",
+		"```go",
+		"x()",
+		"```",
+	}
+
+	tests := []struct {
+		name         string
+		x, y         int
+		wantX, wantY int
+	}{
+		{"OpenSquare", 0, 0, 5, 0},
+		{"CloseSquare", 5, 0, 0, 0},
+		{"NestedParen", 6, 0, 14, 0},
+		{"InnerParen", 11, 0, 13, 0},
+		{"MultiLineBrace", 0, 1, 0, 3},
+		{"BraceBackward", 0, 3, 0, 1},
+		{"BacktickOpen", 4, 2, 9, 2},
+		{"BacktickClose", 9, 2, 4, 2},
+		{"FenceOpen", 1, 4, 0, 6},
+		{"FenceClose", 0, 6, 0, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y, ok := editor.findMatchingBracket(tt.x, tt.y, len(editor.lines))
+			if !ok || x != tt.wantX || y != tt.wantY {
+				t.Errorf("Expected match at (%d,%d), got (%d,%d) ok=%v", tt.wantX, tt.wantY, x, y, ok)
+			}
+		})
+	}
+
+	// Jump uses the character before the cursor when the cursor is past a closer
+	editor.cursorX, editor.cursorY = 15, 0
+	editor.jumpToMatchingBracket()
+	if editor.cursorX != 6 || editor.cursorY != 0 {
+		t.Errorf("Jump should land on the opening paren (6,0), got (%d,%d)", editor.cursorX, editor.cursorY)
+	}
+}
+
+// TestUndoRestoresCursor verifies undo/redo move the cursor and viewport back to the edit
+func TestUndoRestoresCursor(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.height = 10
+	editor.lines = make([]string, 50)
+	for i := range editor.lines {
+		editor.lines[i] = fmt.Sprintf("line %d", i)
+	}
+	editor.undoStack = editor.undoStack[:0]
+	editor.pushUndoState()
+
+	// Edit near the bottom of the buffer
+	editor.cursorY, editor.cursorX = 40, 4
+	editor.ensureCursorVisible()
+	editor.insertChar('!')
+	editedOffset := editor.offsetY
+
+	// Wander off to the top and undo
+	editor.cursorY, editor.cursorX, editor.offsetY = 0, 0, 0
+	editor.undo()
+
+	if editor.lines[40] != "line 40" {
+		t.Fatalf("Undo should restore the line, got %q", editor.lines[40])
+	}
+	if editor.cursorY != 40 || editor.cursorX != 4 {
+		t.Errorf("Undo should move the cursor back to the edit (4,40), got (%d,%d)", editor.cursorX, editor.cursorY)
+	}
+	if editor.offsetY != editedOffset {
+		t.Errorf("Undo should restore the viewport offset %d, got %d", editedOffset, editor.offsetY)
+	}
+
+	// Redo from elsewhere also returns to the edit
+	editor.cursorY, editor.offsetY = 0, 0
+	editor.redo()
+	if editor.lines[40] != "line! 40" {
+		t.Errorf("Redo should reapply the edit, got %q", editor.lines[40])
+	}
+	if editor.cursorY != 40 {
+		t.Errorf("Redo should move the cursor back to line 40, got %d", editor.cursorY)
+	}
+}
+
+// TestUndoHistoryBrowser checks checkpoint listing, previews and jumping to a checkpoint
+func TestUndoHistoryBrowser(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	for _, r := range "abc" {
+		editor.insertChar(r)
+	}
+
+	// Initial state is pushed twice (load + first edit); the duplicate is hidden
+	checkpoints := editor.undoCheckpoints()
+	if len(checkpoints) != 3 {
+		t.Fatalf("Expected 3 checkpoints, got %d (%v)", len(checkpoints), checkpoints)
+	}
+
+	preview := diffPreview([]string{"x", "old", "y"}, []string{"x", "new", "y"}, 10)
+	if len(preview) != 2 || !strings.Contains(preview[0], "- old") || !strings.Contains(preview[1], "+ new") {
+		t.Errorf("Unexpected diff preview: %q", preview)
+	}
+
+	// Pick the second-newest checkpoint through the overlay ("a" typed)
+	done := make(chan struct{})
+	go func() {
+		editor.showUndoHistory()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("undo history browser did not return in time")
+	}
+
+	if editor.lines[0] != "a" {
+		t.Errorf("Expected buffer to be %q after jumping, got %q", "a", editor.lines[0])
+	}
+
+	// Skipped states remain reachable with redo
+	editor.redo()
+	editor.redo()
+	if editor.lines[0] != "abc" {
+		t.Errorf("Redo after jumping should restore %q, got %q", "abc", editor.lines[0])
+	}
+}
+
+// TestSnapshots verifies named snapshots survive the undo limit and restore as one undo step
+func TestSnapshots(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"draft one"}
+
+	done := make(chan struct{})
+	go func() {
+		editor.createSnapshot()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	for _, r := range "v1" {
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("snapshot prompt did not return in time")
+	}
+
+	if len(editor.snapshots) != 1 || editor.snapshots[0].name != "v1" {
+		t.Fatalf("Expected one snapshot named v1, got %+v", editor.snapshots)
+	}
+
+	// Push the undo history well past its limit
+	editor.undoBudget = 1024
+	editor.cursorX = runeLen(editor.lines[0])
+	for i := 0; i < 100; i++ {
+		editor.insertChar('x')
+	}
+
+	editor.applySnapshot(editor.snapshots[0])
+	if editor.lines[0] != "draft one" {
+		t.Fatalf("Snapshot restore should bring back %q, got %q", "draft one", editor.lines[0])
+	}
+
+	// Restoring is itself undoable
+	editor.undo()
+	if !strings.HasSuffix(editor.lines[0], "xxx") {
+		t.Errorf("Undoing the restore should bring back the edited text, got %q", editor.lines[0])
+	}
+}
+
+// TestChunkUndoHistory verifies each chunk keeps its own undo history across navigation
+func TestChunkUndoHistory(t *testing.T) {
+	filename := createLargeTestFile(t, 15000, "Test")
+	defer os.Remove(filename)
+
+	editor, err := createTestEditor(filename)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	// Edit the first chunk, then leave it answering "n" to the save prompt
+	editor.insertChar('!')
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- editor.loadNextChunk()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'n', tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Failed to load next chunk: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("save prompt did not return in time")
+	}
+	if editor.modified {
+		t.Error("A chunk freshly loaded from disk should not be marked modified")
+	}
+
+	// The second chunk starts with its own history
+	editor.undo()
+	if editor.lines[0] != "Test line 10001" {
+		t.Errorf("Undo in a fresh chunk should not pull in other chunks, got %q", editor.lines[0])
+	}
+
+	if err := editor.loadPrevChunk(); err != nil {
+		t.Fatalf("Failed to load previous chunk: %v", err)
+	}
+	if editor.lines[0] != "Test line 1" {
+		t.Fatalf("Expected chunk 0 reloaded from disk, got %q", editor.lines[0])
+	}
+
+	// The discarded edit is one undo away, and the original state one further
+	editor.undo()
+	if editor.lines[0] != "!Test line 1" {
+		t.Errorf("Undo after returning should restore the unsaved edit, got %q", editor.lines[0])
+	}
+	editor.undo()
+	if editor.lines[0] != "Test line 1" {
+		t.Errorf("Second undo should restore the original line, got %q", editor.lines[0])
+	}
+}
+
+// TestDiffLines checks the edit script and hunk grouping used by diff-based features
+func TestDiffLines(t *testing.T) {
+	a := []string{"one", "two", "three", "four"}
+	b := []string{"one", "2", "three", "four", "five"}
+
+	ops := diffLines(a, b)
+	var kinds strings.Builder
+	for _, op := range ops {
+		kinds.WriteByte(op.kind)
+	}
+	if kinds.String() != " -+  +" {
+		t.Errorf("Unexpected edit script %q", kinds.String())
+	}
+
+	hunks := diffHunks(ops)
+	if len(hunks) != 2 {
+		t.Fatalf("Expected 2 hunks, got %d", len(hunks))
+	}
+	if hunks[0] != (diffHunk{aStart: 1, aEnd: 2, bStart: 1, bEnd: 2}) {
+		t.Errorf("Unexpected first hunk %+v", hunks[0])
+	}
+	if hunks[1] != (diffHunk{aStart: 4, aEnd: 4, bStart: 4, bEnd: 5}) {
+		t.Errorf("Unexpected second hunk %+v", hunks[1])
+	}
+}
+
+// TestDiffLargeChange checks that texts too different to diff line by line in
+// reasonable time and memory are replaced as a single hunk
+func TestDiffLargeChange(t *testing.T) {
+	a := make([]string, 5000)
+	b := make([]string, 5000)
+	for i := range a {
+		a[i] = fmt.Sprintf("old %d", i)
+		b[i] = fmt.Sprintf("new %d", i)
+	}
+	b = append([]string{"kept"}, b...)
+	a = append([]string{"kept"}, a...)
+
+	ops := diffLines(a, b)
+	if len(ops) != 1+5000+5000 || ops[0].kind != ' ' || ops[1].kind != '-' || ops[len(ops)-1].kind != '+' {
+		t.Fatalf("Expected the kept line then every line removed and added, got %d ops", len(ops))
+	}
+	hunks := diffHunks(ops)
+	if len(hunks) != 1 || hunks[0] != (diffHunk{aStart: 1, aEnd: 5001, bStart: 1, bEnd: 5001}) {
+		t.Errorf("Expected one hunk replacing the changed lines, got %+v", hunks)
+	}
+	if got := unifiedDiff(a, b, 1); len(got) != 1+1+10000 || got[0] != "@@ -1,5001 +1,5001 @@" {
+		t.Errorf("Expected one hunk in the unified diff, got %d lines from %q", len(got), got[0])
+	}
+}
+
+// TestRevertRegion verifies only changes inside the selected lines are reverted
+func TestRevertRegion(t *testing.T) {
+	saved := []string{"# Title", "para one", "", "para two", "end"}
+	current := []string{"# New title", "para one", "", "para two REWRITTEN", "extra", "end"}
+
+	// Revert only the second paragraph (lines 3-4 of the current buffer)
+	lines, ok := revertedLines(saved, current, 3, 4)
+	if !ok {
+		t.Fatal("Expected a revert to happen")
+	}
+	expected := []string{"# New title", "para one", "", "para two", "end"}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+
+	// Selecting untouched lines reverts nothing
+	if _, ok := revertedLines(saved, current, 1, 1); ok {
+		t.Error("Untouched lines should not report a revert")
+	}
+
+	// End to end through the editor with the last saved version as the baseline
+	filename := createTempFile(t, strings.Join(saved, "\n"))
+	defer os.Remove(filename)
+	editor, err := createTestEditor(filename)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.insertChar('!') // "!# Title"
+	editor.cursorY, editor.cursorX = 4, 0
+	editor.insertChar('?') // "?end"
+	editor.revertSelection()
+	if editor.lines[4] != "end" || editor.lines[0] != "!# Title" {
+		t.Errorf("Only the cursor line should be reverted, got %q", editor.lines)
+	}
+
+	editor.undo()
+	if editor.lines[4] != "?end" {
+		t.Errorf("Revert should be a single undo step, got %q", editor.lines[4])
+	}
+}
+
+// TestUnifiedDiff checks hunk headers, context and the disk comparison used by the diff view
+func TestUnifiedDiff(t *testing.T) {
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	b := []string{"1", "2", "three", "4", "5", "6", "7", "8", "9", "10", "11"}
+
+	got := unifiedDiff(a, b, 1)
+	expected := []string{
+		"@@ -2,3 +2,3 @@",
+		" 2",
+		"-3",
+		"+three",
+		" 4",
+		"@@ -10,1 +10,2 @@",
+		" 10",
+		"+11",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected unified diff:\n%s", strings.Join(got, "\n"))
+	}
+
+	if len(unifiedDiff(a, a, 3)) != 0 {
+		t.Error("Identical input should produce an empty diff")
+	}
+
+	filename := createTempFile(t, "alpha\nbeta")
+	defer os.Remove(filename)
+	editor, err := createTestEditor(filename)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines[1] = "BETA"
+	onDisk, err := editor.readDiskChunk()
+	if err != nil {
+		t.Fatalf("readDiskChunk failed: %v", err)
+	}
+	diff := unifiedDiff(onDisk, editor.lines, 3)
+	if len(diff) != 4 || diff[2] != "-beta" || diff[3] != "+BETA" {
+		t.Errorf("Unexpected diff against disk: %q", diff)
+	}
+}
+
+// TestTableOfContents covers heading parsing, anchors, insertion and in-place refresh
+func TestTableOfContents(t *testing.T) {
+	headingTests := []struct {
+		line  string
+		level int
+		text  string
+		ok    bool
+	}{
+		{"# Title", 1, "Title", true},
+		{"### Deep ###", 3, "Deep", true},
+		{"#tag", 0, "", false},
+		{"####### Seven", 0, "", false},
+		{"    # Code", 0, "", false},
+		{"## C# tips", 2, "C# tips", true},
+	}
+	for _, tt := range headingTests {
+		level, text, ok := parseHeading(tt.line)
+		if ok != tt.ok || level != tt.level || text != tt.text {
+			t.Errorf("parseHeading(%q) = %d %q %v, want %d %q %v", tt.line, level, text, ok, tt.level, tt.text, tt.ok)
+		}
+	}
+
+	if slug := headingSlug("Hello, World! (v2)"); slug != "hello-world-v2" {
+		t.Errorf("Unexpected slug %q", slug)
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{
+		"# Guide",
+		"",
+		"## Setup",
+		"```",
+		"# not a heading",
+		"```",
+		"## Setup",
+	}
+	editor.cursorY = 1
+	editor.insertTOC()
+
+	expected := []string{
+		"# Guide",
+		tocStartMarker,
+		"- [Guide](#guide)",
+		"  - [Setup](#setup)",
+		"  - [Setup](#setup-1)",
+		tocEndMarker,
+		"## Setup",
+	}
+	for i, want := range expected {
+		if editor.lines[i] != want {
+			t.Errorf("Line %d: expected %q, got %q", i, want, editor.lines[i])
+		}
+	}
+
+	// Add a heading and refresh: the block is rebuilt in place
+	editor.lines = append(editor.lines, "### Usage")
+	editor.cursorY = 0
+	editor.insertTOC()
+	if editor.lines[5] != "    - [Usage](#usage)" || editor.lines[6] != tocEndMarker {
+		t.Errorf("Refreshed TOC should include the new heading, got %q", editor.lines)
+	}
+	if strings.Count(strings.Join(editor.lines, "\n"), tocStartMarker) != 1 {
+		t.Error("Refreshing should not insert a second TOC")
+	}
+}
+
+// TestFootnotes covers footnote navigation, insertion and orphan reporting
+func TestFootnotes(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{
+		"Claim one[^1] and two[^note].",
+		"Missing[^ghost].",
+		"",
+		"[^1]: First source.",
+		"[^note]: A note with a [^1] back-reference.",
+		"[^unused]: Never cited.",
+	}
+
+	// Marker -> definition
+	editor.cursorY, editor.cursorX = 0, 10
+	editor.jumpFootnote()
+	if editor.cursorY != 3 || editor.cursorX != 0 {
+		t.Errorf("Expected jump to definition at (0,3), got (%d,%d)", editor.cursorX, editor.cursorY)
+	}
+
+	// Definition -> first marker
+	editor.cursorY, editor.cursorX = 4, 5
+	editor.jumpFootnote()
+	if editor.cursorY != 0 || editor.cursorX != 21 {
+		t.Errorf("Expected jump back to marker at (21,0), got (%d,%d)", editor.cursorX, editor.cursorY)
+	}
+
+	// Away from footnotes: report orphans
+	editor.cursorY, editor.cursorX = 2, 0
+	editor.jumpFootnote()
+	want := "Orphaned footnotes - no definition: [^ghost]; unused: [^unused]"
+	if editor.statusMessage != want {
+		t.Errorf("Expected %q, got %q", want, editor.statusMessage)
+	}
+
+	// Insert picks the next free number and appends a definition
+	editor.cursorY, editor.cursorX = 1, 7
+	editor.insertFootnote()
+	if editor.lines[1] != "Missing[^2][^ghost]." {
+		t.Errorf("Unexpected marker insertion: %q", editor.lines[1])
+	}
+	last := editor.lines[len(editor.lines)-1]
+	if last != "[^2]: " || editor.cursorY != len(editor.lines)-1 || editor.cursorX != len(last) {
+		t.Errorf("Expected cursor at the end of a new definition, got %q at (%d,%d)", last, editor.cursorX, editor.cursorY)
+	}
+}
+
+// TestImageLinkInsertion covers path completion, link targets and copying into assets/
+func TestImageLinkInsertion(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/images", 0755)
+	os.WriteFile(dir+"/images/cat one.png", []byte("png"), 0644)
+	os.WriteFile(dir+"/images/cathedral.jpg", []byte("jpg"), 0644)
+	os.WriteFile(dir+"/notes.md", []byte(""), 0644)
+
+	// Unique prefix completes fully, directories get a trailing slash
+	if got, _ := completePath(dir, "ima"); got != "images/" {
+		t.Errorf("Expected directory completion, got %q", got)
+	}
+	// Ambiguous prefix extends to the common prefix and lists candidates
+	got, matches := completePath(dir, "images/c")
+	if got != "images/cat" || len(matches) != 2 {
+		t.Errorf("Expected common prefix with 2 matches, got %q %v", got, matches)
+	}
+
+	if target := linkTarget(dir, dir+"/images/cat one.png"); target != "<images/cat one.png>" {
+		t.Errorf("Expected relative, bracketed target, got %q", target)
+	}
+	if target := linkTarget(dir, "https://example.com/a b"); target != "https://example.com/a b" {
+		t.Errorf("URLs should be kept verbatim, got %q", target)
+	}
+
+	// Copies never overwrite an existing asset
+	first, err := copyIntoAssets(dir, dir+"/images/cathedral.jpg")
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	second, err := copyIntoAssets(dir, dir+"/images/cathedral.jpg")
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if !strings.HasSuffix(first, "assets/cathedral.jpg") || !strings.HasSuffix(second, "assets/cathedral-1.jpg") {
+		t.Errorf("Unexpected asset paths %q, %q", first, second)
+	}
+
+	// Full flow: selected text becomes the link text
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.filename = dir + "/notes.md"
+	editor.lines = []string{"see the cat here"}
+	editor.selectionStart = true
+	editor.selectionStartX, editor.selectionStartY = 8, 0
+	editor.cursorX, editor.cursorY = 11, 0
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		// "im" Tab -> "images/", then "cat " Tab -> "images/cat one.png"
+		for _, r := range "im\tcat \t" {
+			if r == '\t' {
+				editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone))
+			} else {
+				editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+			}
+		}
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	}()
+	editor.insertLink()
+
+	if editor.lines[0] != "see the [cat](<images/cat one.png>) here" {
+		t.Errorf("Unexpected link insertion: %q", editor.lines[0])
+	}
+}
+
+// TestImagePreview covers image link detection, protocol detection and encoding
+func TestImagePreview(t *testing.T) {
+	line := "Before ![a cat](<assets/cat one.png> \"title\") and ![b](b.jpg) after"
+	if target, ok := imageLinkAt(line, 10); !ok || target != "assets/cat one.png" {
+		t.Errorf("Expected first image, got %q %v", target, ok)
+	}
+	if target, ok := imageLinkAt(line, 55); !ok || target != "b.jpg" {
+		t.Errorf("Expected second image, got %q %v", target, ok)
+	}
+	if _, ok := imageLinkAt(line, 2); ok {
+		t.Error("Expected no image outside the links")
+	}
+
+	envs := []struct {
+		env  map[string]string
+		want int
+	}{
+		{map[string]string{"TERM": "xterm-kitty"}, graphicsKitty},
+		{map[string]string{"TERM_PROGRAM": "iTerm.app"}, graphicsITerm},
+		{map[string]string{"TERM": "foot"}, graphicsSixel},
+		{map[string]string{"TERM": "xterm-256color"}, graphicsNone},
+	}
+	for _, tc := range envs {
+		if got := detectGraphicsProtocol(func(k string) string { return tc.env[k] }); got != tc.want {
+			t.Errorf("%v: expected protocol %d, got %d", tc.env, tc.want, got)
+		}
+	}
+
+	// Kitty payloads are chunked at 4096 bytes
+	seq := kittyImage(make([]byte, 6000), 10, 5)
+	if strings.Count(seq, "\x1b_G") != 2 || !strings.Contains(seq, "m=1;") || !strings.Contains(seq, "\x1b_Gm=0;") {
+		t.Errorf("Unexpected kitty chunking: %d commands", strings.Count(seq, "\x1b_G"))
+	}
+
+	// A 2x7 red image needs two sixel bands of one colour each
+	img := image.NewRGBA(image.Rect(0, 0, 2, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 2; x++ {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	sixel := sixelImage(img, 100, 100)
+	if !strings.HasPrefix(sixel, "\x1bPq\"1;1;2;7") || !strings.HasSuffix(sixel, "#180~~$-#180@@$-\x1b\\") {
+		t.Errorf("Unexpected sixel output tail: %q", sixel[len(sixel)-20:])
+	}
+}
+
+// TestRenumberLists covers manual and automatic ordered list renumbering
+func TestRenumberLists(t *testing.T) {
+	lines := []string{
+		"1. one",
+		"1. two",
+		"   1. nested",
+		"   5. nested",
+		"   continuation",
+		"7. three",
+		"",
+		"4) other delimiter",
+		"4) again",
+		"",
+		"```",
+		"1. code",
+		"1. code",
+		"```",
+		"Paragraph",
+		"3. restarts at its own number",
+		"9. next",
+	}
+	want := []string{
+		"1. one",
+		"2. two",
+		"   1. nested",
+		"   2. nested",
+		"   continuation",
+		"3. three",
+		"",
+		"4) other delimiter",
+		"5) again",
+		"",
+		"```",
+		"1. code",
+		"1. code",
+		"```",
+		"Paragraph",
+		"3. restarts at its own number",
+		"4. next",
+	}
+	if changed := renumberLines(lines, 0, len(lines)-1); changed != 5 {
+		t.Errorf("Expected 5 changed lines, got %d", changed)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Line %d: expected %q, got %q", i, want[i], lines[i])
+		}
+	}
+
+	// Automatic mode renumbers after each edit, the way run() applies it
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	edit := func(f func()) {
+		edits := editor.editCount
+		f()
+		if editor.editCount != edits {
+			editor.renumberListAtCursor()
+		}
+	}
+
+	editor.lines = []string{"Intro", "1. a", "2. b", "3. c", "4. d", "5. e", "6. f", "7. g", "8. h", "9. i", "10. j"}
+	editor.cursorY, editor.cursorX = 2, 0
+	editor.startSelection()
+	editor.cursorY = 3
+	edit(editor.cut)
+	if editor.lines[2] != "2. c" || editor.lines[9] != "9. j" {
+		t.Errorf("Expected list renumbered after cut, got %v", editor.lines)
+	}
+
+	// Typing a new item at the end widens its number; the cursor follows the text
+	editor.cursorY, editor.cursorX = 9, runeLen(editor.lines[9])
+	edit(editor.insertNewline)
+	for _, r := range "1. k" {
+		edit(func() { editor.insertChar(r) })
+	}
+	if editor.lines[10] != "10. k" || editor.cursorX != 5 {
+		t.Errorf("Expected \"10. k\" with cursor at 5, got %q at %d", editor.lines[10], editor.cursorX)
+	}
+}
+
+// TestSmartPunctuation covers typography conversion while typing and for the whole document
+func TestSmartPunctuation(t *testing.T) {
+	cases := map[string]string{
+		`He said "it's fine"...`:          "He said “it’s fine”…",
+		"pages 10--20 and wait---what":    "pages 10–20 and wait—what",
+		"keep `\"code\" -- ...` literal":  "keep `\"code\" -- ...` literal",
+		`<img alt="x"> and [a](b--c "t")`: `<img alt="x"> and [a](b--c "t")`,
+		"| a -- b |":                      "| a -- b |",
+		"---":                             "---",
+		"- 'quoted' item <!-- note -->":   "- ‘quoted’ item <!-- note -->",
+	}
+	for in, want := range cases {
+		if got := smartenLine(in); got != want {
+			t.Errorf("smartenLine(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.lines = []string{"---", `title: "x"`, "---", "", "```", "", "```"}
+	editor.cursorY = 3
+	for _, r := range `"a--b"` {
+		editor.insertSmartChar(r)
+	}
+	if editor.lines[3] != "“a–b”" || editor.cursorX != 5 {
+		t.Errorf("Expected typed conversion, got %q at %d", editor.lines[3], editor.cursorX)
+	}
+
+	// Undo brings back the literal character
+	editor.undo()
+	if editor.lines[3] != "“a–b\"" {
+		t.Errorf("Expected straight quote after undo, got %q", editor.lines[3])
+	}
+
+	// Code blocks and front matter are left alone
+	editor.cursorY, editor.cursorX = 5, 0
+	editor.insertSmartChar('"')
+	editor.smartenDocument()
+	if editor.lines[1] != `title: "x"` || editor.lines[5] != `"` {
+		t.Errorf("Expected front matter and code untouched, got %q %q", editor.lines[1], editor.lines[5])
+	}
+}
+
+// TestShiftHeadings covers promoting and demoting headings under the cursor or selection
+func TestShiftHeadings(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.lines = []string{"# Title", "text", "## Section", "```", "# not a heading", "```", "###### Deep"}
+
+	editor.cursorY, editor.cursorX = 2, 5
+	editor.shiftHeadings(1)
+	if editor.lines[2] != "### Section" || editor.cursorX != 6 {
+		t.Errorf("Expected demoted heading with cursor following, got %q at %d", editor.lines[2], editor.cursorX)
+	}
+
+	// Across a selection: H1 can't be promoted, others are; code is skipped
+	editor.selectionStart = true
+	editor.selectionStartX, editor.selectionStartY = 0, 0
+	editor.cursorY, editor.cursorX = 6, 0
+	editor.shiftHeadings(-1)
+	want := []string{"# Title", "text", "## Section", "```", "# not a heading", "```", "##### Deep"}
+	for i := range want {
+		if editor.lines[i] != want[i] {
+			t.Errorf("Line %d: expected %q, got %q", i, want[i], editor.lines[i])
+		}
+	}
+
+	editor.clearSelection()
+	editor.cursorY = 0
+	editor.shiftHeadings(-1)
+	if editor.statusMessage != "Already at H1" || editor.lines[0] != "# Title" {
+		t.Errorf("Expected H1 to stay, got %q (%q)", editor.lines[0], editor.statusMessage)
+	}
+}
+
+// TestExportArgs covers output naming and the pandoc command line
+func TestExportArgs(t *testing.T) {
+	if got := exportPath("notes/today.md", ".pdf"); got != "notes/today.pdf" {
+		t.Errorf("Expected notes/today.pdf, got %q", got)
+	}
+	if got := exportPath("", ".docx"); got != "untitled.docx" {
+		t.Errorf("Expected untitled.docx, got %q", got)
+	}
+
+	args := strings.Join(pandocArgs("/tmp/out.pdf", "notes", "today"), " ")
+	for _, want := range []string{"--standalone", "--resource-path=notes", "--output=/tmp/out.pdf", "--metadata=title:today", "geometry:margin=1in"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("Expected %q in %q", want, args)
+		}
+	}
+	if args := strings.Join(pandocArgs("out.epub", ".", ""), " "); strings.Contains(args, "title") || strings.Contains(args, "geometry") {
+		t.Errorf("Unexpected title or PDF options in %q", args)
+	}
+}
+
+// TestExportChunks checks that exporting from the last chunk of a large file
+// sends pandoc the whole file, with the chunk's unsaved edits in place
+func TestExportChunks(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor arg; do case $arg in --output=*) out=${arg#--output=};; esac; done\ncat > \"$out\"\n"
+	os.WriteFile(filepath.Join(dir, "pandoc"), []byte(script), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	path := filepath.Join(dir, "book.md")
+	os.WriteFile(path, []byte("one\ntwo\nthree\nfour\nfive"), 0644)
+	editor, err := createTestEditor(path)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.maxLines = 2
+	if err := editor.loadChunk(2); err != nil || editor.truncated || editor.currentChunk != 2 {
+		t.Fatalf("Expected the last chunk, got chunk %d (%v)", editor.currentChunk, err)
+	}
+	editor.lines[0] = "FIVE"
+	editor.modified = true
+
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)) // PDF
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)) // book.pdf
+	editor.exportWithPandoc()
+	data, err := os.ReadFile(filepath.Join(dir, "book.pdf"))
+	if err != nil || string(data) != "one\ntwo\nthree\nfour\nFIVE\n" {
+		t.Errorf("Expected the whole file exported, got %q (%v, %q)", data, err, editor.statusMessage)
+	}
+}
+
+// TestMarkdownToHTML covers the markdown subset rendered by copy as HTML
+func TestMarkdownToHTML(t *testing.T) {
+	source := strings.Join([]string{
+		"# Notes & *Ideas*",
+		"",
+		"Some **bold**, _em_, ~~gone~~, `a < b` and snake_case_name.  ",
+		"A [link](https://example.com \"Site\") and ![cat](<img/cat 1.png>).",
+		"",
+		"- one",
+		"- two",
+		"  1. nested",
+		"  2. nested",
+		"",
+		"3. starts at three",
+		"",
+		"> quoted",
+		"",
+		"```go",
+		"x := \"<tag>\"",
+		"```",
+		"",
+		"| Name | Qty |",
+		"|:-----|----:|",
+		"| a    | 1   |",
+		"",
+		"---",
+	}, "\n")
+
+	got := markdownToHTML(source)
+	for _, want := range []string{
+		`<h1 id="notes--ideas">Notes &amp; <em>Ideas</em></h1>`,
+		`<strong>bold</strong>, <em>em</em>, <del>gone</del>, <code>a &lt; b</code> and snake_case_name.<br>`,
+		`<a href="https://example.com" title="Site">link</a>`,
+		`<img src="img/cat 1.png" alt="cat">`,
+		"<ul>\n<li>one</li>\n<li>two\n<ol>\n<li>nested</li>\n<li>nested</li>\n</ol></li>\n</ul>",
+		`<ol start="3">`,
+		"<blockquote>\n<p>quoted</p>\n</blockquote>",
+		"<pre><code class=\"language-go\">x := &#34;&lt;tag&gt;&#34;\n</code></pre>",
+		`<th style="text-align: left">Name</th><th style="text-align: right">Qty</th>`,
+		"<td style=\"text-align: left\">a</td>",
+		"<hr>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in output:\n%s", want, got)
+		}
+	}
+}
+
+// TestCommitFile covers saving, staging and committing the current file
+func TestCommitFile(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	if _, err := runGit(dir, "init", "-q"); err != nil {
+		t.Fatalf("git init failed: %v", err)
+	}
+	os.WriteFile(dir+"/other.md", []byte("untouched"), 0644)
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.filename = dir + "/journal.md"
+	editor.lines = []string{"Dear diary"}
+	editor.modified = true
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		for _, r := range "entry" {
+			editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	}()
+	editor.commitFile()
+
+	if editor.modified {
+		t.Error("Expected the buffer to be saved before committing")
+	}
+	if !strings.HasPrefix(editor.statusMessage, "Committed journal.md as ") {
+		t.Errorf("Unexpected status: %q", editor.statusMessage)
+	}
+	files, _ := runGit(dir, "show", "--name-only", "--format=%s", "HEAD")
+	if files != "entry\n\njournal.md" {
+		t.Errorf("Expected only journal.md committed with message, got %q", files)
+	}
+}
+
+// TestOpenAtPosition covers file:line[:col] and +line arguments and jumping into chunks
+func TestOpenAtPosition(t *testing.T) {
+	cases := []struct {
+		args       []string
+		file       string
+		line, col  int
+		shouldFail bool
+	}{
+		{[]string{"notes.md:120"}, "notes.md", 120, 0, false},
+		{[]string{"notes.md:120:7"}, "notes.md", 120, 7, false},
+		{[]string{"+42", "notes.md"}, "notes.md", 42, 0, false},
+		{[]string{"notes.md", "+42"}, "notes.md", 42, 0, false},
+		{[]string{"dir:name/notes.md"}, "dir:name/notes.md", 0, 0, false},
+		{[]string{"+x", "notes.md"}, "", 0, 0, true},
+		{[]string{"a.md:3", "b.md"}, "a.md", 3, 0, false},
+	}
+	for _, tc := range cases {
+		opts, err := parseArgs(tc.args)
+		first := ""
+		if len(opts.filenames) > 0 {
+			first = opts.filenames[0]
+		}
+		if (err != nil) != tc.shouldFail || (err == nil && (first != tc.file || opts.line != tc.line || opts.col != tc.col)) {
+			t.Errorf("parseArgs(%v) = %+v, %v", tc.args, opts, err)
+		}
+	}
+
+	// Line numbers beyond the first chunk load the chunk holding them
+	filename := createLargeTestFile(t, 25000, "Test")
+	defer os.Remove(filename)
+	editor, err := createTestEditor(filename)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	if err := editor.openAt(22345, 3); err != nil {
+		t.Fatalf("openAt failed: %v", err)
+	}
+	if editor.currentChunk != 2 || editor.cursorY != 2344 || editor.cursorX != 2 {
+		t.Errorf("Expected chunk 2 line 2344 col 2, got chunk %d line %d col %d", editor.currentChunk, editor.cursorY, editor.cursorX)
+	}
+	if editor.lines[editor.cursorY] != "Test line 22345" {
+		t.Errorf("Expected cursor on file line 22345, got %q", editor.lines[editor.cursorY])
+	}
+}
+
+// TestPreviewServer covers the rendered page, the event stream, and serving images
+func TestPreviewServer(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/pic.png", []byte("png"), 0644)
+
+	preview, err := startPreviewServer("127.0.0.1:0", dir, "notes.md")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer preview.close()
+
+	preview.update([]string{"# Hello"})
+	time.Sleep(150 * time.Millisecond)
+
+	resp, err := http.Get(preview.url)
+	if err != nil {
+		t.Fatalf("GET / failed: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), `<h1 id="hello">Hello</h1>`) || !strings.Contains(string(page), "<title>notes.md</title>") {
+		t.Errorf("Unexpected page:\n%s", page)
+	}
+
+	resp, err = http.Get(preview.url + "pic.png")
+	if err != nil {
+		t.Fatalf("GET image failed: %v", err)
+	}
+	img, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(img) != "png" {
+		t.Errorf("Expected image contents, got %q", img)
+	}
+
+	// The stream starts with the current body and pushes each change
+	resp, err = http.Get(preview.url + "events")
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var data []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Reading event failed: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				return strings.Join(data, "\n")
+			}
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	if got := readEvent(); got != "<h1 id=\"hello\">Hello</h1>\n" {
+		t.Errorf("Unexpected first event %q", got)
+	}
+	preview.update([]string{"# Hello", "", "world"})
+	if got := readEvent(); got != "<h1 id=\"hello\">Hello</h1>\n<p>world</p>\n" {
+		t.Errorf("Unexpected update event %q", got)
+	}
+
+	// Another host name, as a rebinding web page would send, and hidden files
+	// are refused
+	os.WriteFile(dir+"/.env", []byte("secret"), 0644)
+	os.MkdirAll(dir+"/.git", 0755)
+	os.WriteFile(dir+"/.git/config", []byte("secret"), 0644)
+	status := func(path, host string) int {
+		req, _ := http.NewRequest("GET", preview.url+path, nil)
+		if host != "" {
+			req.Host = host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	port := preview.url[strings.LastIndex(preview.url, ":")+1 : len(preview.url)-1]
+	for _, tt := range []struct {
+		path, host string
+		want       int
+	}{
+		{"pic.png", "localhost:" + port, http.StatusOK},
+		{"pic.png", "evil.example:" + port, http.StatusForbidden},
+		{"", "evil.example:" + port, http.StatusForbidden},
+		{"pic.png", "127.0.0.1:1", http.StatusForbidden},
+		{".env", "", http.StatusNotFound},
+		{".git/config", "", http.StatusNotFound},
+		{"x/../.git/config", "", http.StatusNotFound},
+	} {
+		if got := status(tt.path, tt.host); got != tt.want {
+			t.Errorf("GET %q from %q: expected status %d, got %d", tt.path, tt.host, tt.want, got)
+		}
+	}
+}
+
+// TestPreviewUpdates checks that the preview follows its own buffer through
+// every kind of change, and not the buffers switched to
+func TestPreviewUpdates(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/one.md", []byte("# One"), 0644)
+	os.WriteFile(dir+"/two.md", []byte("# Two"), 0644)
+	editor, err := createTestEditor(dir + "/one.md")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	if err := editor.loadFile(); err != nil {
+		t.Fatalf("loadFile: %v", err)
+	}
+	if err := editor.startPreview("127.0.0.1:0"); err != nil {
+		t.Fatalf("startPreview: %v", err)
+	}
+	defer editor.preview.close()
+	sent := func() string { return strings.Join(editor.preview.sent, "|") }
+	if sent() != "# One" {
+		t.Fatalf("Expected the buffer sent on start, got %q", sent())
+	}
+
+	editor.cursorX = 5
+	editor.pasteText("\npasted")
+	editor.updatePreview()
+	if sent() != "# One|pasted" {
+		t.Errorf("Expected the paste sent, got %q", sent())
+	}
+	editor.undo()
+	editor.updatePreview()
+	if sent() != "# One" {
+		t.Errorf("Expected the undo sent, got %q", sent())
+	}
+
+	if err := editor.openBuffer(dir + "/two.md"); err != nil {
+		t.Fatalf("openBuffer: %v", err)
+	}
+	editor.lines[0] = "# Two edited"
+	editor.updatePreview()
+	if sent() != "# One" {
+		t.Errorf("Expected the preview to keep its own buffer, got %q", sent())
+	}
+}
+
+// TestConfigAndBuffers covers the config file, flag overrides, multiple buffers and read-only mode
+func TestConfigAndBuffers(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/config"
+	os.WriteFile(path, []byte("# settings\nchunk_lines = 500\n\ntheme = mono\n"), 0644)
+
+	opts, err := parseArgs([]string{"--config", path, "--chunk-lines=200", "--readonly", "--line", "5", "a.md", "b.md"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if !opts.readOnly || opts.line != 5 || len(opts.filenames) != 2 {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+	cfg, err := loadSettings(opts)
+	if err != nil {
+		t.Fatalf("loadSettings failed: %v", err)
+	}
+	if cfg.chunkLines != 200 || cfg.theme != "mono" {
+		t.Errorf("Expected flag to override file, got %+v", cfg)
+	}
+
+	os.WriteFile(path, []byte("theme = plaid\n"), 0644)
+	if _, err := loadConfig(path, true); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("Expected an error naming the line, got %v", err)
+	}
+	if _, err := loadConfig(dir+"/missing", false); err != nil {
+		t.Errorf("A missing default config should be ignored, got %v", err)
+	}
+	if _, err := loadConfig(dir+"/missing", true); err == nil {
+		t.Error("A missing --config file should be an error")
+	}
+	if _, err := parseArgs([]string{"--theme"}); err == nil {
+		t.Error("Expected an error for a flag without its value")
+	}
+
+	// Each buffer keeps its own text, cursor and undo history
+	os.WriteFile(dir+"/one.md", []byte("one"), 0644)
+	os.WriteFile(dir+"/two.md", []byte("two"), 0644)
+	editor, err := createTestEditor(dir + "/one.md")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.cursorX = 3
+	editor.insertChar('!')
+	if err := editor.openBuffer(dir + "/two.md"); err != nil {
+		t.Fatalf("openBuffer failed: %v", err)
+	}
+	if editor.bufferCount() != 2 || editor.activeBuffer != 1 || editor.lines[0] != "two" || editor.modified {
+		t.Fatalf("Expected clean second buffer, got %d/%d %q", editor.activeBuffer, editor.bufferCount(), editor.lines[0])
+	}
+	editor.cycleBuffer(1)
+	if editor.lines[0] != "one!" || !editor.modified || editor.cursorX != 4 {
+		t.Errorf("Expected first buffer restored, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.undo()
+	if editor.lines[0] != "one" {
+		t.Errorf("Expected undo in first buffer, got %q", editor.lines[0])
+	}
+
+	// Saving every buffer returns to the one that was active
+	editor.cycleBuffer(-1)
+	editor.insertChar('?')
+	if err := editor.forEachBuffer(editor.saveFileWithPrompt); err != nil {
+		t.Fatalf("Saving buffers failed: %v", err)
+	}
+	if editor.activeBuffer != 1 {
+		t.Errorf("Expected to stay on buffer 2, got %d", editor.activeBuffer+1)
+	}
+	if data, _ := os.ReadFile(dir + "/two.md"); string(data) != "?two" {
+		t.Errorf("Expected second buffer saved, got %q", data)
+	}
+
+	// Read-only mode refuses edits and saves but not movement
+	editor.readOnly = true
+	if !editsBuffer(tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone)) || !editsBuffer(tcell.NewEventKey(tcell.KeyRune, 'o', tcell.ModAlt)) {
+		t.Error("Expected typing and Alt+o to count as edits")
+	}
+	if editsBuffer(tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModShift)) || editsBuffer(tcell.NewEventKey(tcell.KeyRune, '.', tcell.ModAlt)) {
+		t.Error("Expected selection and buffer switching to be allowed")
+	}
+	editor.lines[0] = "changed"
+	editor.saveFileWithPrompt()
+	if data, _ := os.ReadFile(dir + "/two.md"); string(data) != "?two" {
+		t.Errorf("Expected read-only save to be refused, got %q", data)
+	}
+}
+
+// TestTabBehavior covers indent detection, tab stops when drawing, and the Tab key
+func TestTabBehavior(t *testing.T) {
+	cases := []struct {
+		lines   []string
+		useTabs bool
+		width   int
+	}{
+		{[]string{"- a", "  - b", "    - c"}, false, 2},
+		{[]string{"1. a", "   more", "2. b", "   more"}, false, 3},
+		{[]string{"x", "\ty", "\t\tz"}, true, 4},
+		{[]string{"plain", "prose"}, false, 4},
+	}
+	for _, tc := range cases {
+		useTabs, width := detectIndent(tc.lines, false, 4)
+		if useTabs != tc.useTabs || width != tc.width {
+			t.Errorf("detectIndent(%q) = %v, %d; want %v, %d", tc.lines, useTabs, width, tc.useTabs, tc.width)
+		}
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	// A tab after "ab" reaches the next stop at column 4
+	editor.lines = []string{"ab\tc", "\tx"}
+	if col := editor.displayColumn(editor.lines[0], 3); col != 4 {
+		t.Errorf("Expected c at column 4, got %d", col)
+	}
+	editor.draw()
+	if r, _, _, _ := editor.screen.GetContent(4, 0); r != 'c' {
+		t.Errorf("Expected c drawn at column 4, got %q", r)
+	}
+	if r, _, _, _ := editor.screen.GetContent(2, 0); r != ' ' {
+		t.Errorf("Expected the tab drawn as blanks, got %q", r)
+	}
+	editor.tabWidth = 8
+	editor.draw()
+	if r, _, _, _ := editor.screen.GetContent(8, 1); r != 'x' {
+		t.Errorf("Expected x at column 8 with 8-column tabs, got %q", r)
+	}
+
+	// Tab inserts spaces to the next indent stop, or a tab when configured
+	editor.tabWidth = 4
+	editor.lines = []string{"ab"}
+	editor.cursorX, editor.cursorY = 2, 0
+	editor.insertTab()
+	if editor.lines[0] != "ab  " || editor.cursorX != 4 {
+		t.Errorf("Expected spaces to column 4, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.useTabs = true
+	editor.insertTab()
+	if editor.lines[0] != "ab  \t" {
+		t.Errorf("Expected a tab character, got %q", editor.lines[0])
+	}
+
+	cfg := defaultConfig()
+	if err := cfg.set("tab_width", "0"); err == nil {
+		t.Error("Expected tab_width 0 to be rejected")
+	}
+	if err := cfg.set("use_tabs", "true"); err != nil || !cfg.useTabs {
+		t.Errorf("Expected use_tabs to be set, got %v", err)
+	}
+}
+
+// TestSoftWrap covers wrapping at word boundaries, continuation indent, and cursor
+// movement, drawing and clicks over visual rows
+func TestSoftWrap(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.width, editor.height = 20, 6
+	editor.softWrap = true
+
+	rowText := func(line string) []string {
+		var out []string
+		for _, r := range editor.wrapRows(line) {
+			out = append(out, strings.Repeat(" ", r.indent)+runeSubstring(line, r.start, r.end))
+		}
+		return out
+	}
+	got := rowText("the quick brown fox jumps over the lazy dog")
+	want := []string{"the quick brown fox ", "jumps over the lazy ", "dog"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	got = rowText("- a list item that wraps around")
+	want = []string{"- a list item that ", "  wraps around"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected list continuation indent %q, got %q", want, got)
+	}
+	if got := rowText("abcdefghijklmnopqrstuvwxyz"); len(got) != 2 || got[0] != "abcdefghijklmnopqrst" {
+		t.Errorf("Expected a long word broken at the edge, got %q", got)
+	}
+
+	// Down moves through the rows of one line before the next line
+	editor.lines = []string{"the quick brown fox jumps over the lazy dog", "end"}
+	editor.cursorX, editor.cursorY = 4, 0
+	editor.moveVisualRow(1)
+	if editor.cursorY != 0 || editor.cursorX != 24 {
+		t.Errorf("Expected row 2 column 4 (rune 24), got %d,%d", editor.cursorY, editor.cursorX)
+	}
+	editor.moveVisualRow(1)
+	editor.moveVisualRow(1)
+	if editor.cursorY != 1 || editor.cursorX != 3 {
+		t.Errorf("Expected end of next line, got %d,%d", editor.cursorY, editor.cursorX)
+	}
+	editor.moveVisualRow(-1)
+	if editor.cursorY != 0 || editor.cursorX != 43 {
+		t.Errorf("Expected last row of first line, got %d,%d", editor.cursorY, editor.cursorX)
+	}
+
+	editor.draw()
+	if r, _, _, _ := editor.screen.GetContent(0, 1); r != 'j' {
+		t.Errorf("Expected second row to start with j, got %q", r)
+	}
+	if r, _, _, _ := editor.screen.GetContent(0, 3); r != 'e' {
+		t.Errorf("Expected next line on row 4, got %q", r)
+	}
+
+	if x, y, ok := editor.wrapScreenToBuffer(6, 1); !ok || y != 0 || x != 26 {
+		t.Errorf("Expected click on row 2 col 6 at rune 26, got %d,%d %v", x, y, ok)
+	}
+
+	// Scrolling keeps every row up to the cursor on screen
+	editor.lines = []string{"one two three four five six seven eight nine ten eleven twelve", "a", "b", "c", "d"}
+	editor.offsetY = 0
+	editor.cursorX, editor.cursorY = 0, 4
+	editor.ensureCursorVisible()
+	if editor.offsetY != 1 {
+		t.Errorf("Expected the wrapped first line scrolled off, got offset %d", editor.offsetY)
+	}
+}
+
+// TestReflow covers paragraph reflow with list and quote prefixes, hard breaks,
+// selections, and wrapping while typing
+func TestReflow(t *testing.T) {
+	cases := []struct {
+		in, want []string
+	}{
+		{[]string{"one two three four five six"}, []string{"one two three", "four five six"}},
+		{[]string{"- one two three four", "five six"}, []string{"- one two three", "  four five six"}},
+		{[]string{"> one two three four five six"}, []string{"> one two three", "> four five six"}},
+		{[]string{"a b  ", "c d e f g h"}, []string{"a b  ", "c d e f g h"}},
+		{[]string{"supercalifragilistic word"}, []string{"supercalifragilistic", "word"}},
+	}
+	for _, tc := range cases {
+		if got := reflowLines(tc.in, 15); strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("reflowLines(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.config.wrapColumn = 15
+
+	// The paragraph around the cursor is reflowed; the list item after it and the
+	// fenced code are separate
+	editor.lines = []string{
+		"one two", "three four five six",
+		"- item",
+		"```", "code code code code code", "```",
+	}
+	editor.cursorX, editor.cursorY = 6, 1 // before "four"
+	editor.reflow()
+	want := []string{"one two three", "four five six", "- item", "```", "code code code code code", "```"}
+	if strings.Join(editor.lines, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %q, got %q", want, editor.lines)
+	}
+	if editor.cursorY != 1 || editor.cursorX != 0 {
+		t.Errorf("Expected cursor to stay before four, got %d,%d", editor.cursorY, editor.cursorX)
+	}
+	editor.undo()
+	if editor.lines[0] != "one two" {
+		t.Errorf("Expected undo to restore the paragraph, got %q", editor.lines[0])
+	}
+	editor.cursorY = 4
+	editor.reflow()
+	if editor.statusMessage != "No paragraph to reflow" {
+		t.Errorf("Expected code to be left alone, got %q", editor.statusMessage)
+	}
+
+	// Typing past the wrap column carries the last word to a new line
+	editor.lines = []string{"- one two three"}
+	editor.cursorX, editor.cursorY = runeLen(editor.lines[0]), 0
+	editor.insertChar('s')
+	editor.wrapAsYouType()
+	if strings.Join(editor.lines, "|") != "- one two|  threes" || editor.cursorY != 1 || editor.cursorX != 8 {
+		t.Errorf("Expected wrap with list indent, got %q at %d,%d", editor.lines, editor.cursorY, editor.cursorX)
+	}
+}
+
+// TestShowInvisibles checks whitespace symbols and the trailing whitespace style
+func TestShowInvisibles(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"a b\tc d  "}
+	editor.showInvisibles = true
+	editor.draw()
+
+	want := map[int]rune{1: '·', 3: '→', 4: 'c', 5: '␣', 7: '·', 8: '·'}
+	for x, r := range want {
+		if got, _, _, _ := editor.screen.GetContent(x, 0); got != r {
+			t.Errorf("Column %d: expected %q, got %q", x, r, got)
+		}
+	}
+	if _, _, style, _ := editor.screen.GetContent(1, 0); style != editor.theme.dim {
+		t.Error("Expected inner spaces drawn faint")
+	}
+	if _, _, style, _ := editor.screen.GetContent(8, 0); style != editor.theme.trailing {
+		t.Error("Expected trailing spaces in the trailing style")
+	}
+
+	// Selected whitespace keeps the selection colour
+	editor.selectionStart = true
+	editor.selectionStartX, editor.selectionStartY = 0, 0
+	editor.cursorX = 3
+	editor.draw()
+	if r, _, style, _ := editor.screen.GetContent(1, 0); r != '·' || style != editor.theme.selection {
+		t.Errorf("Expected selected space symbol, got %q", r)
+	}
+
+	editor.showInvisibles = false
+	editor.draw()
+	if r, _, _, _ := editor.screen.GetContent(1, 0); r != ' ' {
+		t.Errorf("Expected plain space when hidden, got %q", r)
+	}
+}
+
+// TestColumnRuler checks the guide and the highlight of text past it
+func TestColumnRuler(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.config.rulerColumn = 10
+	editor.lines = []string{"short", "this line is too long"}
+	editor.showRuler = true
+	editor.draw()
+	if r, _, style, _ := editor.screen.GetContent(10, 0); r != '│' || style != editor.theme.dim {
+		t.Errorf("Expected guide on a short line, got %q", r)
+	}
+	if r, _, _, _ := editor.screen.GetContent(10, 1); r != 'i' {
+		t.Errorf("Expected text to win over the guide, got %q", r)
+	}
+	if _, _, style, _ := editor.screen.GetContent(12, 1); style != editor.theme.text {
+		t.Error("Expected no overflow highlight by default")
+	}
+
+	editor.rulerOverflow = true
+	editor.draw()
+	if _, _, style, _ := editor.screen.GetContent(9, 1); style != editor.theme.text {
+		t.Error("Expected text before the column unhighlighted")
+	}
+	if _, _, style, _ := editor.screen.GetContent(10, 1); style != editor.theme.overflow {
+		t.Error("Expected text from the column on highlighted")
+	}
+
+	// The guide scrolls with the text
+	editor.offsetX = 4
+	editor.draw()
+	if r, _, _, _ := editor.screen.GetContent(6, 0); r != '│' {
+		t.Errorf("Expected guide at screen column 6 when scrolled, got %q", r)
+	}
+}
+
+// TestScrollOff checks the cursor is kept away from the window edges
+func TestScrollOff(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = make([]string, 100)
+	editor.height = 12 // 11 text rows
+	editor.config.scrollOff = 3
+
+	// Moving down starts scrolling three lines before the bottom row
+	editor.cursorY = 7
+	editor.ensureCursorVisible()
+	if editor.offsetY != 0 {
+		t.Errorf("Expected no scroll yet, got %d", editor.offsetY)
+	}
+	editor.cursorY = 8
+	editor.ensureCursorVisible()
+	if editor.offsetY != 1 {
+		t.Errorf("Expected one line of scroll, got %d", editor.offsetY)
+	}
+
+	// Moving up keeps three lines above the cursor
+	editor.offsetY = 50
+	editor.cursorY = 52
+	editor.ensureCursorVisible()
+	if editor.offsetY != 49 {
+		t.Errorf("Expected offset 49, got %d", editor.offsetY)
+	}
+
+	// Near the ends the margin gives way
+	editor.cursorY = 99
+	editor.ensureCursorVisible()
+	if editor.offsetY != 89 {
+		t.Errorf("Expected the last line on the bottom row, got offset %d", editor.offsetY)
+	}
+	editor.cursorY = 1
+	editor.ensureCursorVisible()
+	if editor.offsetY != 0 {
+		t.Errorf("Expected offset 0 at the top, got %d", editor.offsetY)
+	}
+
+	// A margin larger than half the window still leaves the cursor room to move
+	editor.config.scrollOff = 50
+	if margin := editor.scrollMargin(); margin != 5 {
+		t.Errorf("Expected margin capped at 5, got %d", margin)
+	}
+}
+
+// TestFocusMode checks the centered column, hidden status bar and paragraph dimming
+func TestFocusMode(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	screenWidth, _ := editor.screen.Size()
+	editor.config.focusWidth = 40
+	editor.lines = []string{"first paragraph", "", "second paragraph"}
+	editor.cursorY = 2
+	editor.focusDim = true
+	editor.toggleFocus()
+	if editor.width != 40 || editor.focusPad != (screenWidth-40)/2 {
+		t.Fatalf("Expected a 40-column centered text area, got width %d pad %d", editor.width, editor.focusPad)
+	}
+
+	editor.statusMessage = ""
+	editor.draw()
+	if r, _, _, _ := editor.screen.GetContent(editor.focusPad, 0); r != 'f' {
+		t.Errorf("Expected text to start after the left margin, got %q", r)
+	}
+	if _, _, style, _ := editor.screen.GetContent(editor.focusPad, 0); style != editor.theme.dim {
+		t.Error("Expected the other paragraph to be faded")
+	}
+	if _, _, style, _ := editor.screen.GetContent(editor.focusPad, 2); style != editor.theme.text {
+		t.Error("Expected the current paragraph at full strength")
+	}
+	if r, _, _, _ := editor.screen.GetContent(1, editor.height-1); r != ' ' {
+		t.Errorf("Expected no status bar, got %q", r)
+	}
+
+	// Clicks land where they would without the margin
+	editor.handleMouse(tcell.NewEventMouse(editor.focusPad+3, 0, tcell.Button1, tcell.ModNone))
+	focusedX := editor.cursorX
+
+	editor.toggleFocus()
+	if editor.width != screenWidth || editor.focusPad != 0 {
+		t.Errorf("Expected full width after leaving focus mode, got %d", editor.width)
+	}
+	editor.handleMouse(tcell.NewEventMouse(3, 0, tcell.Button1, tcell.ModNone))
+	if editor.cursorY != 0 || editor.cursorX != focusedX {
+		t.Errorf("Expected click at column %d, got %d", editor.cursorX, focusedX)
+	}
+}
+
+// screenText returns the characters drawn on screen row y
+func screenText(editor *Editor, y int) string {
+	width, _ := editor.screen.Size()
+	var row strings.Builder
+	for x := 0; x < width; x++ {
+		r, _, _, _ := editor.screen.GetContent(x, y)
+		row.WriteRune(r)
+	}
+	return strings.TrimRight(row.String(), " ")
+}
+
+// TestDraftMode checks which keys drafting mode refuses and its status indicator
+func TestDraftMode(t *testing.T) {
+	for _, key := range []tcell.Key{tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyDelete, tcell.KeyCtrlX} {
+		if !deletesText(tcell.NewEventKey(key, 0, tcell.ModNone)) {
+			t.Errorf("Expected key %v to count as deleting", key)
+		}
+	}
+	for _, ev := range []*tcell.EventKey{
+		tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyCtrlZ, 0, tcell.ModNone),
+	} {
+		if deletesText(ev) {
+			t.Errorf("Expected %v to be allowed while drafting", ev.Name())
+		}
+	}
+
+	editor, err := createTestEditor("draft.md")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.handleAltKey('D')
+	editor.statusMessage = ""
+	editor.draw()
+	if status := screenText(editor, editor.height-1); !strings.Contains(status, "[Drafting]") {
+		t.Errorf("Expected drafting indicator, got %q", status)
+	}
+	editor.handleAltKey('D')
+	if editor.draftMode {
+		t.Error("Expected Alt+Shift+D to turn drafting off again")
+	}
+}
+
+// TestOvertype checks that overtype replaces characters and appends at line end
+func TestOvertype(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"cat"}
+	editor.toggleOvertype()
+	for _, r := range "dogs" {
+		editor.typeChar(r)
+	}
+	if editor.lines[0] != "dogs" || editor.cursorX != 4 {
+		t.Errorf("Expected overtyped line, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.undo()
+	if editor.lines[0] != "dog" {
+		t.Errorf("Expected one undo step per character, got %q", editor.lines[0])
+	}
+
+	// Smart punctuation still applies
+	editor.smartPunctuation = true
+	editor.lines = []string{"say xyz"}
+	editor.cursorX = 4
+	editor.typeChar('"')
+	if editor.lines[0] != "say “yz" {
+		t.Errorf("Expected curly quote over x, got %q", editor.lines[0])
+	}
+
+	editor.statusMessage = ""
+	editor.draw()
+	if status := screenText(editor, editor.height-1); !strings.Contains(status, "[OVR]") {
+		t.Errorf("Expected overtype indicator, got %q", status)
+	}
+	editor.toggleOvertype()
+	editor.typeChar('!')
+	if editor.lines[0] != "say “!yz" {
+		t.Errorf("Expected insertion after toggling back, got %q", editor.lines[0])
+	}
+}
+
+// TestSpellCheck checks word lists, hunspell affixes, underlining, navigation
+// and suggestions
+func TestSpellCheck(t *testing.T) {
+	dir := t.TempDir()
+	wordList := dir + "/words"
+	if err := os.WriteFile(wordList, []byte("the\nquick\nbrown\nfox\nParis\nin\nsee\nand\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	aff := "SET UTF-8\nSFX S Y 2\nSFX S 0 s [^y]\nSFX S y ies y\nPFX U Y 1\nPFX U 0 un .\n"
+	if err := os.WriteFile(dir+"/en_XX.aff", []byte(aff), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/en_XX.dic", []byte("2\nwalk/SU\nparty/S\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hunspell, err := loadDictionary(dir + "/en_XX.dic")
+	if err != nil {
+		t.Fatalf("Failed to load hunspell dictionary: %v", err)
+	}
+	for word, want := range map[string]bool{"walks": true, "unwalks": true, "parties": true, "partys": false, "Walk": true, "WALKS": true} {
+		if hunspell.check(word) != want {
+			t.Errorf("check(%q) = %v, want %v", word, !want, want)
+		}
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.config.dictionary = wordList
+	editor.toggleSpellCheck()
+	if !editor.spellCheck {
+		t.Fatalf("Expected spell check on, got %q", editor.statusMessage)
+	}
+
+	// Code, addresses, identifiers, acronyms and front matter are not checked
+	editor.lines = []string{"---", "titel: x", "---", "The quikc fox in paris", "see `codez` and https://exampel.com and fox_bar and NASA", "```", "foxx", "```", "brown foxx"}
+	literal := reflowLiteral(editor.lines)
+	d, _ := editor.spellDictionary()
+	for y, want := range map[int][][2]int{1: nil, 3: {{4, 9}, {17, 22}}, 4: nil, 6: nil, 8: {{6, 10}}} {
+		if got := editor.misspellings(d, y, literal); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Line %d: expected misspellings %v, got %v", y, want, got)
+		}
+	}
+
+	editor.draw()
+	if _, _, style, _ := editor.screen.GetContent(5, 3); style != editor.theme.spelling {
+		t.Error("Expected a misspelled word to be underlined")
+	}
+	if _, _, style, _ := editor.screen.GetContent(1, 3); style != editor.theme.text {
+		t.Error("Expected a correct word to be drawn plainly")
+	}
+
+	editor.cursorY, editor.cursorX = 3, 0
+	for _, want := range [][2]int{{4, 3}, {17, 3}, {6, 8}, {4, 3}} {
+		editor.jumpMisspelling(1)
+		if editor.cursorX != want[0] || editor.cursorY != want[1] {
+			t.Errorf("Expected next misspelling at %v, got (%d, %d)", want, editor.cursorX, editor.cursorY)
+		}
+	}
+	editor.jumpMisspelling(-1)
+	if editor.cursorX != 6 || editor.cursorY != 8 {
+		t.Errorf("Expected previous misspelling to wrap to the end, got (%d, %d)", editor.cursorX, editor.cursorY)
+	}
+
+	if got := d.suggest("Bronw", 3); len(got) == 0 || got[0] != "Brown" {
+		t.Errorf("Expected Brown first, got %v", got)
+	}
+	if got := d.suggest("pari", 3); len(got) == 0 || got[0] != "Paris" {
+		t.Errorf("Expected a name to keep its capital, got %v", got)
+	}
+
+	editor.cursorY, editor.cursorX = 3, 6
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.handleAltKey('k')
+	if editor.lines[3] != "The quick fox in paris" || editor.cursorX != 9 {
+		t.Errorf("Expected the suggestion to replace the word, got %q at %d", editor.lines[3], editor.cursorX)
+	}
+	editor.undo()
+	if editor.lines[3] != "The quikc fox in paris" {
+		t.Errorf("Expected undo to restore the word, got %q", editor.lines[3])
+	}
+}
+
+// TestPersonalDictionary checks that added and ignored words are saved, and that
+// front matter picks the document's dictionary
+func TestPersonalDictionary(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("DICPATH", dir)
+	os.WriteFile(dir+"/words", []byte("the\nfox\n"), 0644)
+	os.WriteFile(dir+"/de_DE.dic", []byte("2\nHund\nund\n"), 0644)
+
+	open := func(filename string) *Editor {
+		editor, err := createTestEditor(filename)
+		if err != nil {
+			t.Fatalf("Failed to create editor: %v", err)
+		}
+		editor.config.dictionary = dir + "/words"
+		editor.lines = []string{"the quikc fox zorp"}
+		if err := editor.setSpellCheck(true); err != nil {
+			t.Fatalf("Failed to start spell check: %v", err)
+		}
+		return editor
+	}
+	misspelled := func(editor *Editor) string {
+		d, _ := editor.spellDictionary()
+		var words []string
+		for _, span := range editor.misspellings(d, 0, reflowLiteral(editor.lines)) {
+			words = append(words, runeSubstring(editor.lines[0], span[0], span[1]))
+		}
+		return strings.Join(words, " ")
+	}
+
+	editor := open(dir + "/a.md")
+	defer editor.screen.Fini()
+	editor.cursorX = 15
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnd, 0, tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.spellingSuggestions()
+	editor.cursorX = 5
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnd, 0, tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.spellingSuggestions()
+	if got := misspelled(editor); got != "" {
+		t.Errorf("Expected added and ignored words to be accepted, got %q", got)
+	}
+	if editor.lines[0] != "the quikc fox zorp" || editor.modified {
+		t.Errorf("Expected the document to be unchanged, got %q", editor.lines[0])
+	}
+	if data, _ := os.ReadFile(dir + "/mkmd/dictionary"); string(data) != "zorp\n" {
+		t.Errorf("Expected zorp in the personal dictionary, got %q", data)
+	}
+
+	// The ignore list belongs to the document; the personal dictionary to everyone
+	same := open(dir + "/a.md")
+	defer same.screen.Fini()
+	if got := misspelled(same); got != "" {
+		t.Errorf("Expected the ignore list to be remembered, got %q", got)
+	}
+	other := open(dir + "/b.md")
+	defer other.screen.Fini()
+	if got := misspelled(other); got != "quikc" {
+		t.Errorf("Expected only the ignored word in another document, got %q", got)
+	}
+
+	other.lines = []string{"---", "lang: de", "---", "Hund und fox"}
+	d, err := other.spellDictionary()
+	if err != nil || !d.check("Hund") || d.check("fox") {
+		t.Errorf("Expected the German dictionary from front matter, got %v", err)
+	}
+	other.lines[1] = `lang: "fr-FR"`
+	if _, err := other.spellDictionary(); err == nil || !strings.Contains(other.statusMessage, "fr_FR") {
+		t.Errorf("Expected a missing dictionary to be reported, got %q", other.statusMessage)
+	}
+}
+
+// TestAbbreviations checks expansion at word boundaries, case matching, undo and
+// the places abbreviations are left alone
+func TestAbbreviations(t *testing.T) {
+	cfg := defaultConfig()
+	for _, setting := range [][2]string{{"abbrev teh", "the"}, {"abbrev btw/", "by the way"}} {
+		if err := cfg.set(setting[0], setting[1]); err != nil {
+			t.Fatalf("Failed to set %q: %v", setting[0], err)
+		}
+	}
+	if err := cfg.set("abbrev two words", "x"); err == nil {
+		t.Error("Expected an abbreviation with a space to be rejected")
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.config = cfg
+	editor.abbreviate = true
+
+	typeText := func(text string) {
+		for _, r := range text {
+			editor.typeChar(r)
+		}
+	}
+	typeText("Teh cat, btw/ (teh) TEH.")
+	if want := "The cat, by the way (the) THE."; editor.lines[0] != want || editor.cursorX != runeLen(want) {
+		t.Errorf("Expected %q, got %q at %d", want, editor.lines[0], editor.cursorX)
+	}
+	editor.undo()
+	if want := "The cat, by the way (the) TEH."; editor.lines[0] != want {
+		t.Errorf("Expected one undo to bring back the typed abbreviation, got %q", editor.lines[0])
+	}
+
+	// Enter is a boundary too, and expansion works mid-line
+	editor.lines, editor.cursorX = []string{"teh end"}, 3
+	y, x := editor.cursorY, editor.cursorX
+	editor.insertNewline()
+	editor.expandAbbreviation(y, x)
+	if editor.lines[0] != "the" || editor.lines[1] != " end" || editor.cursorY != 1 {
+		t.Errorf("Expected expansion before the new line, got %q", editor.lines)
+	}
+
+	for _, line := range []string{"`teh", "see http://teh", "tehx"} {
+		editor.lines, editor.cursorY, editor.cursorX = []string{line}, 0, runeLen(line)
+		editor.typeChar(' ')
+		if editor.lines[0] != line+" " {
+			t.Errorf("Expected %q to be left alone, got %q", line, editor.lines[0])
+		}
+	}
+	editor.lines, editor.cursorX = []string{"```", "teh"}, 3
+	editor.cursorY = 1
+	editor.typeChar(' ')
+	if editor.lines[1] != "teh " {
+		t.Errorf("Expected code to be left alone, got %q", editor.lines[1])
+	}
+
+	// Ctrl+Space keeps only the next abbreviation as typed
+	editor.lines, editor.cursorY, editor.cursorX = []string{"teh"}, 0, 3
+	editor.keepNextAbbreviation()
+	typeText(" teh ")
+	if editor.lines[0] != "teh the " || editor.keepAbbreviation {
+		t.Errorf("Expected one abbreviation kept and the next expanded, got %q", editor.lines[0])
+	}
+
+	editor.handleAltKey('A')
+	editor.lines, editor.cursorY, editor.cursorX = []string{"teh"}, 0, 3
+	editor.typeChar(' ')
+	if editor.abbreviate || editor.lines[0] != "teh " {
+		t.Errorf("Expected no expansion with abbreviations off, got %q", editor.lines[0])
+	}
+}
+
+// TestDateAndTemplates checks date formatting, config templates and inserting a
+// template at the cursor
+func TestDateAndTemplates(t *testing.T) {
+	when := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.UTC)
+	for format, want := range map[string]string{
+		"%Y-%m-%d":        "2024-03-05",
+		"%A, %B %e, %Y":   "Tuesday, March 5, 2024",
+		"%I:%M %p (%j)":   "02:07 PM (065)",
+		"100%% %q %H:%M%": "100% %q 14:07%",
+	} {
+		if got := formatDate(when, format); got != want {
+			t.Errorf("formatDate(%q) = %q, want %q", format, got, want)
+		}
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(dir+"/standup.md", []byte("## {title} {date}\r\n- {cursor}\r\n"), 0644)
+	os.WriteFile(dir+"/config", []byte("date_format = %d/%m/%Y\ntemplate Standup = standup.md\n"), 0644)
+	cfg, err := loadConfig(dir+"/config", true)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.templates["Standup"] != dir+"/standup.md" || len(cfg.dateFormats) != 1 {
+		t.Fatalf("Expected the template path to be resolved, got %v and %v", cfg.templates, cfg.dateFormats)
+	}
+
+	editor, err := createTestEditor(dir + "/notes.md")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.config = cfg
+
+	// A single date format is inserted without asking
+	editor.lines = []string{"Due: "}
+	editor.cursorX = 5
+	editor.insertDate()
+	if want := "Due: " + time.Now().Format("02/01/2006"); editor.lines[0] != want {
+		t.Errorf("Expected %q, got %q", want, editor.lines[0])
+	}
+
+	names := []string{}
+	for _, tmpl := range editor.templates() {
+		names = append(names, tmpl.name)
+	}
+	if strings.Join(names, ",") != "Daily journal,Meeting notes,Standup" {
+		t.Errorf("Unexpected template list %v", names)
+	}
+
+	editor.lines, editor.cursorX = []string{"ab"}, 1
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnd, 0, tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.insertTemplate()
+	want := []string{"a## notes " + time.Now().Format("02/01/2006"), "- ", "b"}
+	if strings.Join(editor.lines, "|") != strings.Join(want, "|") || editor.cursorX != 2 || editor.cursorY != 1 {
+		t.Errorf("Expected %q with the cursor at the mark, got %q at (%d, %d)", want, editor.lines, editor.cursorX, editor.cursorY)
+	}
+	editor.undo()
+	if strings.Join(editor.lines, "|") != "ab" {
+		t.Errorf("Expected one undo to remove the template, got %q", editor.lines)
+	}
+}
+
+// TestCharPicker checks searching characters by name and inserting by code point
+func TestCharPicker(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	pick := func(query string) {
+		for _, r := range query {
+			editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+		editor.handleAltKey('x')
+	}
+	pick("DASH em")
+	pick("sect")
+	pick("u+2192")
+	pick("U+1F600")
+	if editor.lines[0] != "—§→😀" || editor.cursorX != 4 {
+		t.Errorf("Expected the picked characters, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	pick("no such")
+	if editor.lines[0] != "—§→😀" || !strings.Contains(editor.statusMessage, "no such") {
+		t.Errorf("Expected nothing inserted for an unknown name, got %q and %q", editor.lines[0], editor.statusMessage)
+	}
+
+	if _, ok := parseCodePoint("U+D800"); ok {
+		t.Error("Expected a surrogate code point to be rejected")
+	}
+}
+
+// TestAutoPair checks closing, stepping over, wrapping and deleting pairs
+func TestAutoPair(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.autoPair = true
+	for _, r := range "f(x[1]) \"hi\"" {
+		editor.typeChar(r)
+	}
+	if editor.lines[0] != `f(x[1]) "hi"` || editor.cursorX != 12 {
+		t.Errorf("Expected balanced text with closers stepped over, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+
+	// No pairing before a word, after a word for quotes, or for a fence
+	editor.lines = []string{"word"}
+	editor.cursorX = 0
+	editor.typeChar('(')
+	editor.cursorX = 5
+	editor.typeChar('"')
+	if editor.lines[0] != `(word"` {
+		t.Errorf("Expected unpaired characters, got %q", editor.lines[0])
+	}
+	editor.lines = []string{""}
+	editor.cursorX = 0
+	for _, r := range "```" {
+		editor.typeChar(r)
+	}
+	if editor.lines[0] != "```" || editor.cursorX != 3 {
+		t.Errorf("Expected a plain fence, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+
+	// Backspace inside an empty pair removes both, and undo brings the pair back
+	editor.lines = []string{"a "}
+	editor.cursorX = 2
+	editor.typeChar('[')
+	if !editor.deleteEmptyPair() || editor.lines[0] != "a " || editor.cursorX != 2 {
+		t.Errorf("Expected the empty pair deleted, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.undo()
+	if editor.lines[0] != "a []" {
+		t.Errorf("Expected undo to restore the pair, got %q", editor.lines[0])
+	}
+
+	// Wrapping a selection keeps the text selected
+	editor.lines = []string{"see this now"}
+	editor.selectionStart = true
+	editor.selectionStartX, editor.selectionStartY = 8, 0
+	editor.cursorX, editor.cursorY = 4, 0
+	if !editor.wrapSelection('`') || editor.lines[0] != "see `this` now" || editor.getSelectedText() != "this" {
+		t.Errorf("Expected the selection wrapped, got %q with %q selected", editor.lines[0], editor.getSelectedText())
+	}
+}
+
+// TestAutoCloseMarkdown checks emphasis markers and code fences are closed
+func TestAutoCloseMarkdown(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.autoPair = true
+	for _, r := range "**bold** _it_ snake_case" {
+		editor.typeChar(r)
+	}
+	if editor.lines[0] != "**bold** _it_ snake_case" || editor.cursorX != 24 {
+		t.Errorf("Expected closed emphasis, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+
+	// A marker inside an empty pair grows it; a single * stays a bullet
+	editor.lines = []string{""}
+	editor.cursorX = 0
+	for _, r := range "***" {
+		editor.typeChar(r)
+	}
+	if editor.lines[0] != "******" || editor.cursorX != 3 {
+		t.Errorf("Expected bold italic markers, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.lines = []string{""}
+	editor.cursorX = 0
+	editor.typeChar('*')
+	editor.typeChar(' ')
+	if editor.lines[0] != "* " {
+		t.Errorf("Expected a bullet, got %q", editor.lines[0])
+	}
+
+	// An opening fence gets a closer that one undo removes
+	editor.lines = []string{"text", "  "}
+	editor.cursorX, editor.cursorY = 2, 1
+	for _, r := range "```" {
+		editor.typeChar(r)
+	}
+	if fmt.Sprint(editor.lines) != "[text   ```   ```]" || editor.cursorY != 1 || editor.cursorX != 5 {
+		t.Errorf("Expected a closed fence, got %q at %d,%d", editor.lines, editor.cursorX, editor.cursorY)
+	}
+	editor.undo()
+	if fmt.Sprint(editor.lines) != "[text   ```]" {
+		t.Errorf("Expected undo to leave the fence open, got %q", editor.lines)
+	}
+
+	// Typing the closing fence of an open block adds nothing
+	editor.lines = []string{"```", "code", ""}
+	editor.cursorX, editor.cursorY = 0, 2
+	for _, r := range "```" {
+		editor.typeChar(r)
+	}
+	if len(editor.lines) != 3 || editor.lines[2] != "```" {
+		t.Errorf("Expected the block closed as typed, got %q", editor.lines)
+	}
+}
+
+// TestJumpHeading checks moving between headings, optionally by level
+func TestJumpHeading(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"intro", "# One", "text", "## Sub", "```", "# not a heading", "```", "### Deep", "# Two"}
+	var visited []int
+	for i := 0; i < 4; i++ {
+		editor.jumpHeading(1, false)
+		visited = append(visited, editor.cursorY)
+	}
+	if fmt.Sprint(visited) != "[1 3 7 8]" {
+		t.Errorf("Expected headings outside code in order, got %v", visited)
+	}
+	editor.statusMessage = ""
+	editor.jumpHeading(1, false)
+	if editor.cursorY != 8 || editor.statusMessage != "No next heading" {
+		t.Errorf("Expected to stay on the last heading, got line %d and %q", editor.cursorY, editor.statusMessage)
+	}
+
+	// Same level or higher skips subsections in both directions
+	editor.cursorY, editor.cursorX = 2, 3
+	editor.jumpHeading(1, true)
+	if editor.cursorY != 8 || editor.cursorX != 0 {
+		t.Errorf("Expected the next top-level heading, got line %d", editor.cursorY)
+	}
+	editor.cursorY = 7
+	editor.jumpHeading(-1, true)
+	if editor.cursorY != 3 {
+		t.Errorf("Expected the previous heading of level 3 or higher, got line %d", editor.cursorY)
+	}
+	editor.jumpHeading(-1, false)
+	editor.jumpHeading(-1, false)
+	if editor.cursorY != 1 || editor.statusMessage != "No previous heading" {
+		t.Errorf("Expected to stop at the first heading, got line %d and %q", editor.cursorY, editor.statusMessage)
+	}
+}
+
+// TestMoveParagraph checks paragraph motion and selecting by paragraph
+func TestMoveParagraph(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"one", "one more", "", "  ", "two", "", "three end"}
+	editor.cursorX = 2
+	var stops []string
+	for i := 0; i < 4; i++ {
+		editor.moveParagraph(1)
+		stops = append(stops, fmt.Sprintf("%d:%d", editor.cursorY, editor.cursorX))
+	}
+	if fmt.Sprint(stops) != "[2:0 5:0 6:9 6:9]" {
+		t.Errorf("Expected forward stops at blank lines then the end, got %v", stops)
+	}
+	stops = nil
+	for i := 0; i < 3; i++ {
+		editor.moveParagraph(-1)
+		stops = append(stops, fmt.Sprintf("%d:%d", editor.cursorY, editor.cursorX))
+	}
+	if fmt.Sprint(stops) != "[5:0 3:0 0:0]" {
+		t.Errorf("Expected backward stops at blank lines then the start, got %v", stops)
+	}
+
+	// Shift extends the selection over whole paragraphs
+	editor.startSelection()
+	editor.moveParagraph(1)
+	if editor.getSelectedText() != "one\none more\n" {
+		t.Errorf("Expected the first paragraph selected, got %q", editor.getSelectedText())
+	}
+}
+
+// TestSearchWordAtCursor checks searching for the word under the cursor
+func TestSearchWordAtCursor(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"the cat sat", "a Cat_nap here", "", "cat."}
+	editor.cursorX = 6
+	editor.handleAltKey('*')
+	if editor.searchTerm != "cat" || editor.cursorY != 1 || editor.cursorX != 2 {
+		t.Errorf("Expected the next cat, got %q at %d,%d", editor.searchTerm, editor.cursorX, editor.cursorY)
+	}
+	editor.findNext()
+	if editor.cursorY != 3 || editor.cursorX != 0 {
+		t.Errorf("Expected F3 to continue the search, got %d,%d", editor.cursorX, editor.cursorY)
+	}
+
+	// Just after a word counts; # goes backwards and wraps
+	editor.lines[0] = "sat the cat sat"
+	editor.cursorX, editor.cursorY = 3, 0
+	editor.handleAltKey('#')
+	if editor.searchTerm != "sat" || editor.cursorY != 0 || editor.cursorX != 12 {
+		t.Errorf("Expected the last sat, got %q at %d,%d", editor.searchTerm, editor.cursorX, editor.cursorY)
+	}
+
+	editor.cursorX, editor.cursorY = 0, 2
+	editor.handleAltKey('*')
+	if editor.statusMessage != "No word at cursor" || editor.searchTerm != "sat" {
+		t.Errorf("Expected nothing to search on a blank line, got %q", editor.statusMessage)
+	}
+}
+
+// TestScrollbar checks the scrollbar thumb, clicking and dragging it, and its
+// position across chunks
+func TestScrollbar(t *testing.T) {
+	dir := t.TempDir()
+	var content strings.Builder
+	for i := 0; i < 250; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	path := dir + "/long.md"
+	if err := os.WriteFile(path, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = strings.Split(strings.TrimSuffix(content.String(), "\n"), "\n")
+	start, end, ok := editor.scrollbarThumb(23)
+	if !ok || start != 0 || end != 2 {
+		t.Errorf("Expected a two-row thumb at the top, got %d-%d (%v)", start, end, ok)
+	}
+	editor.draw()
+	if r, _, _, _ := editor.screen.GetContent(79, 0); r != '┃' {
+		t.Errorf("Expected the thumb drawn in the last column, got %q", r)
+	}
+
+	// Clicking the bottom of the bar scrolls to the end; a drag continues
+	// wherever the mouse goes until the button is released
+	editor.handleMouse(tcell.NewEventMouse(79, 22, tcell.Button1, tcell.ModNone))
+	if editor.offsetY != 227 || editor.cursorY != 0 {
+		t.Errorf("Expected the view at the end with the cursor left alone, got offset %d, cursor %d", editor.offsetY, editor.cursorY)
+	}
+	if start, _, _ := editor.scrollbarThumb(23); start != 21 {
+		t.Errorf("Expected the thumb at the bottom, got %d", start)
+	}
+	editor.handleMouse(tcell.NewEventMouse(10, 11, tcell.Button1, tcell.ModNone))
+	if editor.offsetY != 113 || editor.cursorY != 0 {
+		t.Errorf("Expected the drag to scroll to the middle, got offset %d", editor.offsetY)
+	}
+	editor.handleMouse(tcell.NewEventMouse(10, 11, tcell.ButtonNone, tcell.ModNone))
+	editor.handleMouse(tcell.NewEventMouse(2, 1, tcell.Button1, tcell.ModNone))
+	if editor.cursorY != 114 || editor.offsetY != 113 {
+		t.Errorf("Expected a click in the text to move the cursor, got line %d", editor.cursorY)
+	}
+
+	// A short buffer has no bar, and the setting turns it off
+	editor.lines = []string{"short"}
+	editor.offsetY = 0
+	if _, _, ok := editor.scrollbarThumb(23); ok {
+		t.Error("Expected no scrollbar when everything fits")
+	}
+
+	// Chunked files are measured as a whole
+	chunked, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer chunked.screen.Fini()
+	chunked.filename = path
+	chunked.maxLines = 100
+	if err := chunked.loadChunk(1); err != nil {
+		t.Fatal(err)
+	}
+	if top, total := chunked.scrollbarSpan(); top != 100 || total != 250 {
+		t.Errorf("Expected the second chunk at line 100 of 250, got %d of %d", top, total)
+	}
+	chunked.config.scrollbar = false
+	if _, _, ok := chunked.scrollbarThumb(23); ok {
+		t.Error("Expected no scrollbar when turned off")
+	}
+}
+
+// TestMinimap checks the minimap layout, shading, markers and clicking
+func TestMinimap(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = nil
+	for i := 0; i < 96; i++ {
+		editor.lines = append(editor.lines, fmt.Sprintf("line %02d %s", i, strings.Repeat("x", i%4*8)))
+	}
+	editor.lines[60] = "the needle is here"
+	editor.handleAltKey('V')
+	width, _ := editor.screen.Size()
+	if !editor.minimapShown() || editor.width != width-minimapWidth || editor.fullWidth() != width {
+		t.Fatalf("Expected the text area narrowed for the minimap, got width %d of %d", editor.width, width)
+	}
+	if _, _, ok := editor.scrollbarThumb(editor.height - 1); ok {
+		t.Error("Expected the minimap to replace the scrollbar")
+	}
+
+	editor.searchTerm = "needle"
+	editor.draw()
+	cell := func(c, sy int) (rune, tcell.Style) {
+		r, _, style, _ := editor.screen.GetContent(editor.width+1+c, sy)
+		return r, style
+	}
+	if r, style := cell(0, 0); r != '▓' || style != editor.theme.selection {
+		t.Errorf("Expected a full cell in the viewport at the top, got %q", r)
+	}
+	if r, style := cell(5, 23); r != ' ' || style != editor.theme.text {
+		t.Errorf("Expected an empty cell outside the viewport, got %q", r)
+	}
+	if _, style := cell(0, 15); style != editor.theme.search {
+		t.Error("Expected the search match marked")
+	}
+
+	// Clicking a row jumps there and centers it
+	editor.handleMouse(tcell.NewEventMouse(editor.width+3, 20, tcell.Button1, tcell.ModNone))
+	if editor.cursorY != 80 || editor.offsetY != 68 {
+		t.Errorf("Expected a jump to line 80, got line %d, offset %d", editor.cursorY, editor.offsetY)
+	}
+
+	editor.handleAltKey('V')
+	if editor.minimapShown() || editor.width != width {
+		t.Errorf("Expected the full width back, got %d", editor.width)
+	}
+}
+
+// TestSmoothScroll checks page jumps animate towards their target when enabled,
+// a frame at a time through applyScrollMomentum
+func TestSmoothScroll(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	frames := func() []int {
+		var offsets []int
+		for editor.glide != nil {
+			editor.applyScrollMomentum()
+			offsets = append(offsets, editor.offsetY)
+		}
+		return offsets
+	}
+
+	editor.lines = make([]string, 500)
+	editor.offsetY = 0
+	editor.cursorY = 60
+	editor.ensureCursorVisible()
+	editor.smoothScroll(0)
+	if editor.glide != nil || editor.offsetY != 38 {
+		t.Errorf("Expected no animation when smooth scrolling is off, got offset %d", editor.offsetY)
+	}
+
+	editor.config.smoothScroll = true
+	from := editor.offsetY
+	editor.cursorY += 23
+	editor.ensureCursorVisible()
+	editor.smoothScroll(from)
+	if offsets := frames(); fmt.Sprint(offsets) != "[50 56 59 60 61]" {
+		t.Errorf("Expected frames easing from 38 to 61, got %v", offsets)
+	}
+
+	// A long jump only animates its last three screens
+	editor.cursorY = 400
+	editor.ensureCursorVisible()
+	editor.smoothScroll(61)
+	if offsets := frames(); len(offsets) != 7 || offsets[0] != 378-69/2 || editor.offsetY != 378 {
+		t.Errorf("Expected a short animation into line 378, got %v", offsets)
+	}
+
+	// Input finishes the animation at once
+	editor.cursorY = 100
+	editor.ensureCursorVisible()
+	editor.smoothScroll(378)
+	editor.finishGlide()
+	if editor.glide != nil || editor.offsetY != 100 {
+		t.Errorf("Expected the view at its target, got %d", editor.offsetY)
+	}
+}
+
+// TestRecenter checks Ctrl+L cycles the cursor line through the middle, top and
+// bottom of the window without moving the cursor
+func TestRecenter(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = make([]string, 200)
+	editor.cursorY, editor.cursorX = 100, 0
+	editor.ensureCursorVisible()
+	var offsets []int
+	for i := 0; i < 4; i++ {
+		editor.recenter()
+		offsets = append(offsets, editor.offsetY)
+	}
+	if fmt.Sprint(offsets) != "[89 100 78 89]" || editor.cursorY != 100 {
+		t.Errorf("Expected middle, top, bottom, middle, got %v with the cursor on %d", offsets, editor.cursorY)
+	}
+
+	// Scroll-off keeps its margin, and the top of the document is a limit
+	editor.config.scrollOff = 3
+	editor.offsetY = 89
+	editor.recenter()
+	if editor.offsetY != 97 {
+		t.Errorf("Expected the margin kept at the top, got %d", editor.offsetY)
+	}
+	editor.cursorY = 4
+	editor.recenter()
+	if editor.offsetY != 0 {
+		t.Errorf("Expected no scrolling above the first line, got %d", editor.offsetY)
+	}
+
+	// With soft wrap, rows of wrapped lines count
+	editor.config.scrollOff = 0
+	editor.softWrap = true
+	editor.lines[99] = strings.Repeat("word ", 40)
+	editor.cursorY = 100
+	editor.offsetY = 0
+	editor.recenter()
+	if editor.offsetY != 91 {
+		t.Errorf("Expected the wrapped line counted as three rows, got %d", editor.offsetY)
+	}
+}
+
+// TestGoToPercent checks Ctrl+G with a percentage, across chunks of a large file
+func TestGoToPercent(t *testing.T) {
+	filename := createLargeTestFile(t, 25000, "Test")
+	defer os.Remove(filename)
+	editor, err := createTestEditor(filename)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	goTo := func(input string) {
+		for _, r := range input {
+			editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+		editor.goToLine()
+	}
+	goTo("50%")
+	if editor.currentChunk != 1 || editor.lines[editor.cursorY] != "Test line 12501" {
+		t.Errorf("Expected the middle of the file, got chunk %d line %q", editor.currentChunk, editor.lines[editor.cursorY])
+	}
+	goTo("100%")
+	if editor.currentChunk != 2 || editor.lines[editor.cursorY] != "Test line 25000" {
+		t.Errorf("Expected the last line, got chunk %d line %q", editor.currentChunk, editor.lines[editor.cursorY])
+	}
+	goTo(" 0 %")
+	if editor.currentChunk != 0 || editor.cursorY != 0 {
+		t.Errorf("Expected the first chunk's first line, got chunk %d line %d", editor.currentChunk, editor.cursorY)
+	}
+
+	// Small documents, out-of-range and invalid percentages
+	small, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer small.screen.Fini()
+	editor = small
+	editor.lines = make([]string, 11)
+	goTo("25%")
+	if editor.cursorY != 3 {
+		t.Errorf("Expected line 3 of 0-10, got %d", editor.cursorY)
+	}
+	goTo("250%")
+	if editor.cursorY != 10 {
+		t.Errorf("Expected the last line for over 100%%, got %d", editor.cursorY)
+	}
+	goTo("half%")
+	if editor.cursorY != 10 || !strings.Contains(editor.statusMessage, "half%") {
+		t.Errorf("Expected an error for a bad percentage, got %q", editor.statusMessage)
+	}
+}
+
+// TestGraphemeClusters checks that emoji sequences, flags, skin tones and
+// variation selectors are measured, drawn and deleted as one character
+func TestGraphemeClusters(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	// a, family (man ZWJ woman ZWJ girl), b, French flag, c, thumbs up with a
+	// skin tone, d, heart with emoji presentation, e
+	line := "a\U0001F468\u200d\U0001F469\u200d\U0001F467b\U0001F1EB\U0001F1F7c\U0001F44D\U0001F3FDd❤\ufe0fe"
+	runes := []rune(line)
+	editor.lines = []string{line}
+
+	for _, c := range []struct{ start, end, col int }{{1, 6, 1}, {7, 9, 4}, {10, 12, 7}, {13, 15, 10}} {
+		if got := graphemeEnd(runes, c.start); got != c.end {
+			t.Errorf("Expected the character at %d to end at %d, got %d", c.start, c.end, got)
+		}
+		if got := prevGrapheme(runes, c.end); got != c.start {
+			t.Errorf("Expected the character before %d to start at %d, got %d", c.end, c.start, got)
+		}
+		if got := editor.displayColumn(line, c.start); got != c.col {
+			t.Errorf("Expected rune %d at column %d, got %d", c.start, c.col, got)
+		}
+		if got := editor.displayColumn(line, c.end); got != c.col+2 {
+			t.Errorf("Expected rune %d at column %d, got %d", c.end, c.col+2, got)
+		}
+	}
+	if got := graphemeStart(runes, 4); got != 1 {
+		t.Errorf("Expected a position inside the family to snap to 1, got %d", got)
+	}
+
+	// The family is drawn in one cell with the rest of its runes combined
+	editor.draw()
+	mainc, combining, _, _ := editor.screen.GetContent(1, 0)
+	if mainc != 0x1F468 || len(combining) != 4 {
+		t.Errorf("Expected the family in one cell, got %q with %d combining runes", string(mainc), len(combining))
+	}
+	if mainc, _, _, _ := editor.screen.GetContent(3, 0); mainc != 'b' {
+		t.Errorf("Expected 'b' after the family, got %q", string(mainc))
+	}
+
+	// Backspace and Delete remove whole characters
+	editor.cursorX = 15
+	editor.backspace()
+	if editor.cursorX != 13 || editor.lines[0] != string(runes[:13])+"e" {
+		t.Errorf("Expected backspace to remove the heart, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.cursorX = 1
+	editor.delete()
+	if editor.lines[0] != "ab\U0001F1EB\U0001F1F7c\U0001F44D\U0001F3FDde" {
+		t.Errorf("Expected delete to remove the family, got %q", editor.lines[0])
+	}
+	editor.cursorX = 2
+	editor.delete()
+	if editor.lines[0] != "abc\U0001F44D\U0001F3FDde" {
+		t.Errorf("Expected delete to remove the flag, got %q", editor.lines[0])
+	}
+}
+
+// TestCombiningMarks checks that accents typed as separate combining marks stay
+// with their letter on screen, in cursor columns and when deleted
+func TestCombiningMarks(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	line := "cafe\u0301 noe\u0308\u0323l"
+	runes := []rune(line)
+	editor.lines = []string{line}
+
+	if got := editor.displayColumn(line, 6); got != 5 {
+		t.Errorf("Expected ' noël' to start at column 5, got %d", got)
+	}
+	if got := editor.displayColumn(line, 11); got != 8 {
+		t.Errorf("Expected the final l at column 8, got %d", got)
+	}
+	if graphemeEnd(runes, 3) != 5 || graphemeEnd(runes, 8) != 11 || graphemeStart(runes, 10) != 8 {
+		t.Error("Expected each accented letter to be one character")
+	}
+
+	editor.draw()
+	mainc, combining, _, _ := editor.screen.GetContent(3, 0)
+	if mainc != 'e' || string(combining) != "\u0301" {
+		t.Errorf("Expected e with its accent in one cell, got %q %q", string(mainc), string(combining))
+	}
+	if mainc, _, _, _ := editor.screen.GetContent(4, 0); mainc != ' ' {
+		t.Errorf("Expected the space right after the accented e, got %q", string(mainc))
+	}
+
+	editor.cursorX = 11
+	editor.backspace()
+	if editor.lines[0] != "cafe\u0301 nol" || editor.cursorX != 8 {
+		t.Errorf("Expected backspace to remove the letter and both marks, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.cursorX = 3
+	editor.delete()
+	if editor.lines[0] != "caf nol" {
+		t.Errorf("Expected delete to remove the accented e, got %q", editor.lines[0])
+	}
+}
+
+// TestBidiText checks that right-to-left runs are drawn reversed with numbers and
+// brackets kept readable, and that the cursor and clicks follow the reordering
+func TestBidiText(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	screenRow := func(n int) string {
+		var sb strings.Builder
+		for x := 0; x < n; x++ {
+			mainc, _, _, _ := editor.screen.GetContent(x, 0)
+			sb.WriteRune(mainc)
+		}
+		return sb.String()
+	}
+
+	// A Hebrew word inside an English line
+	editor.lines = []string{"ab שלום cd"}
+	editor.draw()
+	if got := screenRow(10); got != "ab םולש cd" {
+		t.Errorf("Expected the Hebrew run reversed, got %q", got)
+	}
+	if got := editor.visualColumn(editor.lines[0], 3); got != 6 {
+		t.Errorf("Expected the first Hebrew letter at column 6, got %d", got)
+	}
+	if got := editor.visualColumn(editor.lines[0], 8); got != 8 {
+		t.Errorf("Expected 'c' to keep column 8, got %d", got)
+	}
+
+	// A Hebrew line with a number in brackets
+	editor.lines = []string{"שלום (12) עולם"}
+	editor.draw()
+	if got := screenRow(14); got != "םלוע (12) םולש" {
+		t.Errorf("Expected the line right to left with the number in order, got %q", got)
+	}
+	editor.handleMouse(tcell.NewEventMouse(13, 0, tcell.Button1, tcell.ModNone))
+	if editor.cursorX != 0 {
+		t.Errorf("Expected a click on the rightmost letter to reach the line start, got %d", editor.cursorX)
+	}
+	editor.handleMouse(tcell.NewEventMouse(6, 0, tcell.Button1, tcell.ModNone))
+	if editor.cursorX != 6 {
+		t.Errorf("Expected a click on '1' to land on it, got %d", editor.cursorX)
+	}
+	editor.handleMouse(tcell.NewEventMouse(20, 0, tcell.Button1, tcell.ModNone))
+	if editor.cursorX != 14 {
+		t.Errorf("Expected a click past the text to reach the line end, got %d", editor.cursorX)
+	}
+
+	// Soft-wrapped rows are reordered the same way
+	editor.softWrap = true
+	rows := editor.wrapRows(editor.lines[0])
+	if _, col := editor.wrapLocate(editor.lines[0], rows, 0); col != 13 {
+		t.Errorf("Expected the first letter at column 13 when wrapped, got %d", col)
+	}
+	editor.draw()
+	if got := screenRow(14); got != "םלוע (12) םולש" {
+		t.Errorf("Expected the wrapped row drawn right to left, got %q", got)
+	}
+}
+
+// TestPromptCursor checks that prompts put the terminal cursor at their input,
+// where input methods show the text being composed
+func TestPromptCursor(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	sim := editor.screen.(tcell.SimulationScreen)
+
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, '名', tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, '前', tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if got := editor.prompt("Name: "); got != "名前" {
+		t.Fatalf("Expected the typed name, got %q", got)
+	}
+	if x, y, visible := sim.GetCursor(); x != 10 || y != editor.height-1 || !visible {
+		t.Errorf("Expected the cursor after the wide input at (10, %d), got (%d, %d) visible %v", editor.height-1, x, y, visible)
+	}
+
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	editor.promptFilename("Save as", "notes.md")
+	if x, _, _ := sim.GetCursor(); x != len("Save as: notes.md") {
+		t.Errorf("Expected the cursor after the filename, got %d", x)
+	}
+}
+
+// TestControlCharacters checks that control characters are drawn as placeholders
+// in the control style and counted in the status bar
+func TestControlCharacters(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"a\x01b\x7fc\u0085d"}
+	editor.draw()
+
+	var sb strings.Builder
+	for x := 0; x < 9; x++ {
+		mainc, _, _, _ := editor.screen.GetContent(x, 0)
+		sb.WriteRune(mainc)
+	}
+	if got := sb.String(); got != "a^Ab^?c\ufffdd" {
+		t.Errorf("Expected placeholders for the control characters, got %q", got)
+	}
+	if _, _, style, _ := editor.screen.GetContent(1, 0); style != editor.theme.control {
+		t.Error("Expected the placeholder in the control style")
+	}
+	if got := editor.displayColumn(editor.lines[0], 2); got != 3 {
+		t.Errorf("Expected 'b' at column 3 after the two-cell ^A, got %d", got)
+	}
+
+	var status strings.Builder
+	for x := 0; x < editor.width; x++ {
+		mainc, _, _, _ := editor.screen.GetContent(x, editor.height-1)
+		status.WriteRune(mainc)
+	}
+	if !strings.Contains(status.String(), "[3 control chars]") {
+		t.Errorf("Expected the status bar to flag 3 control characters, got %q", status.String())
+	}
+
+	editor.lines = []string{"plain\ttext"}
+	editor.invalidateWordCount()
+	if n := editor.controlCharCount(); n != 0 {
+		t.Errorf("Expected tabs not to count as control characters, got %d", n)
+	}
+}
+
+// TestInvalidUTF8 checks that bytes that are not valid UTF-8 are flagged on load,
+// drawn as replacement markers, and written back unchanged
+func TestInvalidUTF8(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/latin1.md"
+	if err := os.WriteFile(path, []byte("caf\xe9 cr\xe8me\nplain\nna\xefve\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	editor, err := createTestEditor(path)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	if err := editor.loadFile(); err != nil {
+		t.Fatalf("Failed to load file: %v", err)
+	}
+	if !strings.Contains(editor.statusMessage, "2 lines are not valid UTF-8") {
+		t.Errorf("Expected a warning about 2 lines, got %q", editor.statusMessage)
+	}
+
+	editor.statusMessage = ""
+	editor.draw()
+	if mainc, _, style, _ := editor.screen.GetContent(3, 0); mainc != '\ufffd' || style != editor.theme.control {
+		t.Errorf("Expected a replacement marker in the control style, got %q", string(mainc))
+	}
+
+	// Editing around a bad byte keeps it
+	editor.cursorX = runeLen(editor.lines[0])
+	editor.insertChar('!')
+	editor.cursorX = 0
+	editor.delete()
+	if err := editor.saveFile(); err != nil {
+		t.Fatalf("Failed to save file: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read saved file: %v", err)
+	}
+	if string(data) != "af\xe9 cr\xe8me!\nplain\nna\xefve" {
+		t.Errorf("Expected the original bytes kept, got %q", data)
+	}
+}
+
+// TestThemeColorFallback checks that 24-bit theme colours are fitted to the
+// palette of terminals with fewer colours
+func TestThemeColorFallback(t *testing.T) {
+	if got := nearestColor(tcell.NewHexColor(0xff0000), 16); got != tcell.ColorRed {
+		t.Errorf("Expected pure red to become the basic red, got %v", got)
+	}
+	if got := nearestColor(tcell.NewHexColor(0x870000), 256); got != tcell.PaletteColor(88) {
+		t.Errorf("Expected an exact palette match, got %v", got)
+	}
+	if got := nearestColor(tcell.ColorTeal, 8); got != tcell.ColorTeal {
+		t.Errorf("Expected named colours left alone, got %v", got)
+	}
+
+	dark := themes["solarized-dark"]
+	if got := dark.fitColors(1 << 24); got != dark {
+		t.Error("Expected a 24-bit terminal to keep the theme as it is")
+	}
+	for _, colors := range []int{256, 16, 8} {
+		fg, bg, _ := dark.fitColors(colors).text.Decompose()
+		if fg.IsRGB() || bg.IsRGB() || fg.Hex() < 0 || bg.Hex() < 0 {
+			t.Errorf("Expected palette colours with %d colours, got %v on %v", colors, fg, bg)
+		}
+		if colors == 8 && (fg >= tcell.PaletteColor(8) || bg >= tcell.PaletteColor(8)) {
+			t.Errorf("Expected basic colours with 8 colours, got %v on %v", fg, bg)
+		}
+	}
+	if got := themes["default"].fitColors(16); got != themes["default"] {
+		t.Error("Expected a theme of named colours unchanged")
+	}
+}
+
+// TestCursorColumn checks that the cursor's column is highlighted down the screen
+// when turned on, leaving other highlights alone
+func TestCursorColumn(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"hello", "hi", "", "a世b"}
+	editor.cursorX = 3
+	editor.draw()
+	if _, _, style, _ := editor.screen.GetContent(3, 1); style != editor.theme.text {
+		t.Error("Expected no highlight before it is turned on")
+	}
+
+	editor.handleAltKey('X')
+	editor.draw()
+	for sy := 0; sy < 3; sy++ {
+		if _, _, style, _ := editor.screen.GetContent(3, sy); style != editor.theme.column {
+			t.Errorf("Expected row %d highlighted at the cursor's column", sy)
+		}
+	}
+	if _, _, style, _ := editor.screen.GetContent(2, 1); style != editor.theme.text {
+		t.Error("Expected other columns left alone")
+	}
+	if r, _, style, _ := editor.screen.GetContent(3, 3); r != 'b' || style != editor.theme.column {
+		t.Errorf("Expected 'b' after the wide character highlighted, got %q", string(r))
+	}
+
+	// The second half of a wide character and selected text are skipped
+	editor.cursorX, editor.cursorY = 2, 1
+	editor.selectionStart, editor.selectionStartX, editor.selectionStartY = true, 0, 1
+	editor.draw()
+	if _, _, style, _ := editor.screen.GetContent(2, 3); style == editor.theme.column {
+		t.Error("Expected the second half of the wide character left alone")
+	}
+	if _, _, style, _ := editor.screen.GetContent(2, 0); style != editor.theme.column {
+		t.Error("Expected the cursor's column highlighted above the selection")
+	}
+	if _, _, style, _ := editor.screen.GetContent(1, 1); style != editor.theme.selection {
+		t.Error("Expected the selection to keep its highlight")
+	}
+
+	editor.handleAltKey('X')
+	if editor.cursorColumn {
+		t.Error("Expected Alt+Shift+X to turn the highlight off")
+	}
+}
+
+// TestPromptLineEditing checks cursor movement and editing keys in prompts
+func TestPromptLineEditing(t *testing.T) {
+	key := func(k tcell.Key, mod tcell.ModMask) *tcell.EventKey {
+		return tcell.NewEventKey(k, 0, mod)
+	}
+	line := newPromptLine("hello world")
+	steps := []struct {
+		ev     *tcell.EventKey
+		input  string
+		cursor int
+	}{
+		{key(tcell.KeyLeft, tcell.ModNone), "hello world", 10},
+		{tcell.NewEventKey(tcell.KeyRune, '!', tcell.ModNone), "hello worl!d", 11},
+		{key(tcell.KeyLeft, tcell.ModCtrl), "hello worl!d", 6},
+		{key(tcell.KeyCtrlW, tcell.ModCtrl), "worl!d", 0},
+		{key(tcell.KeyRight, tcell.ModCtrl), "worl!d", 5},
+		{key(tcell.KeyBackspace2, tcell.ModNone), "world", 4},
+		{key(tcell.KeyHome, tcell.ModNone), "world", 0},
+		{key(tcell.KeyDelete, tcell.ModNone), "orld", 0},
+		{key(tcell.KeyEnd, tcell.ModNone), "orld", 4},
+		{key(tcell.KeyBackspace2, tcell.ModAlt), "", 0},
+	}
+	for i, s := range steps {
+		if !line.edit(s.ev) {
+			t.Errorf("Step %d: expected the key to be handled", i)
+		}
+		if line.String() != s.input || line.cursor != s.cursor {
+			t.Errorf("Step %d: expected %q at %d, got %q at %d", i, s.input, s.cursor, line.String(), line.cursor)
+		}
+	}
+	line = newPromptLine("one two three")
+	line.cursor = 4
+	line.edit(key(tcell.KeyDelete, tcell.ModCtrl))
+	line.edit(key(tcell.KeyCtrlK, tcell.ModCtrl))
+	if line.String() != "one " {
+		t.Errorf("Expected Ctrl+Delete and Ctrl+K to delete forwards, got %q", line.String())
+	}
+	line.edit(key(tcell.KeyCtrlU, tcell.ModCtrl))
+	if line.String() != "" || line.edit(key(tcell.KeyF5, tcell.ModNone)) {
+		t.Errorf("Expected Ctrl+U to clear the line and F5 to be ignored, got %q", line.String())
+	}
+
+	// Typing in the middle of a prompt
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	for _, ev := range []*tcell.EventKey{
+		tcell.NewEventKey(tcell.KeyRune, 'b', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyRune, 'c', tcell.ModNone),
+		key(tcell.KeyHome, tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone),
+		key(tcell.KeyEnd, tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyRune, 'd', tcell.ModNone),
+		key(tcell.KeyEnter, tcell.ModNone),
+	} {
+		editor.screen.PostEvent(ev)
+	}
+	if got := editor.prompt("Name: "); got != "abcd" {
+		t.Errorf("Expected %q, got %q", "abcd", got)
+	}
+}
+
+// TestPromptPathCycling checks that Tab completes file names and then steps
+// through the candidates when they agree no further
+func TestPromptPathCycling(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	dir := t.TempDir()
+	for _, name := range []string{"notes.md", "notebook.md", "todo.md"} {
+		os.WriteFile(dir+"/"+name, []byte(""), 0644)
+	}
+	os.Mkdir(dir+"/drafts", 0755)
+
+	post := func(keys ...tcell.Key) {
+		for _, k := range keys {
+			editor.screen.PostEvent(tcell.NewEventKey(k, 0, tcell.ModNone))
+		}
+	}
+	bottomRow := func() string {
+		var sb strings.Builder
+		for x := 0; x < editor.width; x++ {
+			mainc, _, _, _ := editor.screen.GetContent(x, editor.height-1)
+			sb.WriteRune(mainc)
+		}
+		return sb.String()
+	}
+
+	post(tcell.KeyTab, tcell.KeyTab, tcell.KeyEscape)
+	editor.promptPathFrom("Save as", "no", dir)
+	if got := bottomRow(); !strings.Contains(got, "Save as: notebook.md") || !strings.Contains(got, "[notebook.md] notes.md") {
+		t.Errorf("Expected the first candidate chosen and marked, got %q", got)
+	}
+
+	post(tcell.KeyTab, tcell.KeyTab, tcell.KeyTab, tcell.KeyTab, tcell.KeyBacktab, tcell.KeyEnter)
+	if got := editor.promptPathFrom("Save as", "no", dir); got != "notes.md" {
+		t.Errorf("Expected to cycle round and back to notes.md, got %q", got)
+	}
+
+	post(tcell.KeyTab, tcell.KeyEnter)
+	if got := editor.promptPathFrom("Save as", "dr", dir); got != "drafts/" {
+		t.Errorf("Expected a directory completed with a slash, got %q", got)
+	}
+
+	// Typing ends the cycle, so Tab completes the new text
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, 's', tcell.ModNone))
+	post(tcell.KeyTab, tcell.KeyTab, tcell.KeyEnter)
+	if got := editor.promptPathFrom("Save as", "note", dir); got != "notes.md" {
+		t.Errorf("Expected notes.md after typing, got %q", got)
+	}
+}
+
+// TestPromptHistory checks that answers are recalled with Up/Down by kind of
+// prompt and kept for the next session
+func TestPromptHistory(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+
+	post := func(keys ...tcell.Key) {
+		for _, k := range keys {
+			editor.screen.PostEvent(tcell.NewEventKey(k, 0, tcell.ModNone))
+		}
+	}
+	answer := func(kind, text string) {
+		for _, r := range text {
+			editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+		post(tcell.KeyEnter)
+		editor.promptWithHistory(kind, "? ")
+	}
+	answer("search", "fox")
+	answer("search", "dog")
+	answer("goto", "12")
+	answer("search", "fox")
+
+	post(tcell.KeyUp, tcell.KeyUp, tcell.KeyEnter)
+	if got := editor.promptWithHistory("search", "? "); got != "dog" {
+		t.Errorf("Expected the second newest search, got %q", got)
+	}
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'c', tcell.ModNone))
+	post(tcell.KeyUp, tcell.KeyUp, tcell.KeyUp, tcell.KeyDown, tcell.KeyDown, tcell.KeyEnter)
+	if got := editor.promptWithHistory("goto", "? "); got != "c" {
+		t.Errorf("Expected Down to bring back the typed text, got %q", got)
+	}
+	post(tcell.KeyUp, tcell.KeyEnter)
+	if got := editor.prompt("? "); got != "" {
+		t.Errorf("Expected no history for plain prompts, got %q", got)
+	}
+
+	// A new session reads the saved answers
+	next, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	if got := next.promptHistoryOf("search"); len(got) != 2 || got[0] != "fox" || got[1] != "dog" {
+		t.Errorf("Expected the searches saved once each, the last used last, got %q", got)
+	}
+	if got := next.promptHistoryOf("goto"); len(got) != 2 || got[1] != "c" {
+		t.Errorf("Expected the go-to answers saved, got %q", got)
+	}
+}
+
+// TestMain keeps the tests' prompt answers and saved words out of the user's
+// settings folder
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "mkmd-config")
+	if err == nil {
+		os.Setenv("XDG_CONFIG_HOME", dir)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// TestDialogs covers the list dialog, the message box and the form
+func TestDialogs(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	row := func(y int) string {
+		var sb strings.Builder
+		for x := 0; x < editor.width; x++ {
+			mainc, _, _, _ := editor.screen.GetContent(x, y)
+			sb.WriteRune(mainc)
+		}
+		return sb.String()
+	}
+	key := func(k tcell.Key, r rune) {
+		editor.screen.PostEvent(tcell.NewEventKey(k, r, tcell.ModNone))
+	}
+
+	// A list without a preview sits in a box in the middle of the screen
+	key(tcell.KeyDown, 0)
+	key(tcell.KeyEnter, 0)
+	if got := editor.pickFromList("Pick", []string{"one", "two"}, nil); got != 1 {
+		t.Errorf("Expected the second item, got %d", got)
+	}
+	if got := row(9); !strings.Contains(got, "┌ Pick (2/2) ─") || !strings.HasSuffix(strings.TrimRight(got, " "), "┐") {
+		t.Errorf("Expected the top border with the title, got %q", got)
+	}
+	if got := row(11); !strings.Contains(got, "│ two ") {
+		t.Errorf("Expected the items inside the box, got %q", got)
+	}
+
+	key(tcell.KeyRune, 'x')
+	editor.messageBox("Note", []string{"All done."})
+	if got := row(11); !strings.Contains(got, "│ All done.") {
+		t.Errorf("Expected the message in a box, got %q", got)
+	}
+
+	// The form keeps each field's text while moving between them
+	key(tcell.KeyRune, 'a')
+	key(tcell.KeyTab, 0)
+	key(tcell.KeyRune, 'b')
+	key(tcell.KeyUp, 0)
+	key(tcell.KeyRune, 'c')
+	key(tcell.KeyEnter, 0)
+	values, ok := editor.form("Replace", []formField{{"Find", ""}, {"With", "x"}})
+	if !ok || len(values) != 2 || values[0] != "ac" || values[1] != "xb" {
+		t.Errorf("Expected both fields filled in, got %q %v", values, ok)
+	}
+	if got := row(10); !strings.Contains(got, "│ Find: ac") {
+		t.Errorf("Expected labelled fields, got %q", got)
+	}
+	key(tcell.KeyRune, 'a')
+	key(tcell.KeyEscape, 0)
+	if _, ok := editor.form("Replace", []formField{{"Find", ""}}); ok {
+		t.Errorf("Expected Escape to cancel the form")
+	}
+}
+
+// TestStatusNotifications checks that messages time out and that saving and
+// failed searches report back
+func TestStatusNotifications(t *testing.T) {
+	dir := t.TempDir()
+	editor, err := createTestEditor(dir + "/x")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = make([]string, 1204)
+
+	if err := editor.saveFileWithPrompt(); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	if editor.statusMessage != "Saved 1,204 lines to x" {
+		t.Errorf("Expected a save notification, got %q", editor.statusMessage)
+	}
+	editor.draw()
+	if editor.statusMessage == "" {
+		t.Errorf("Expected a new message to stay up")
+	}
+	editor.statusSince = time.Now().Add(-statusTimeout)
+	editor.draw()
+	if editor.statusMessage != "" {
+		t.Errorf("Expected the message to time out, got %q", editor.statusMessage)
+	}
+
+	editor.searchTerm = "zebra"
+	editor.findNext()
+	if editor.statusMessage != `No matches for "zebra"` {
+		t.Errorf("Expected no matches reported, got %q", editor.statusMessage)
+	}
+	if got := groupDigits(1234567); got != "1,234,567" {
+		t.Errorf("Expected digits grouped, got %q", got)
+	}
+}
+
+// TestConfirmDialog checks choosing with the arrows, letters and Enter, and that
+// Escape cancels
+func TestConfirmDialog(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	key := func(k tcell.Key, r rune) {
+		editor.screen.PostEvent(tcell.NewEventKey(k, r, tcell.ModNone))
+	}
+
+	key(tcell.KeyEnter, 0)
+	if got := editor.confirm("Save changes?", "Save", "Discard", "Cancel"); got != 0 {
+		t.Errorf("Expected Enter to pick the first choice, got %d", got)
+	}
+	key(tcell.KeyRight, 0)
+	key(tcell.KeyTab, 0)
+	key(tcell.KeyRight, 0)
+	key(tcell.KeyLeft, 0)
+	key(tcell.KeyEnter, 0)
+	if got := editor.confirm("Save changes?", "Save", "Discard", "Cancel"); got != 2 {
+		t.Errorf("Expected the selection to wrap round both ways, got %d", got)
+	}
+	key(tcell.KeyRune, 'd')
+	key(tcell.KeyEnter, 0)
+	if got := editor.confirm("Save changes?", "Save", "Discard", "Cancel"); got != 1 {
+		t.Errorf("Expected a first letter to select its choice, got %d", got)
+	}
+	row := func(y int) string {
+		var sb strings.Builder
+		for x := 0; x < editor.width; x++ {
+			mainc, _, _, _ := editor.screen.GetContent(x, y)
+			sb.WriteRune(mainc)
+		}
+		return sb.String()
+	}
+	if got := row(12); !strings.Contains(got, " Save    Discard    Cancel ") {
+		t.Errorf("Expected the choices as buttons, got %q", got)
+	}
+	key(tcell.KeyRune, 'n')
+	key(tcell.KeyEscape, 0)
+	if got := editor.confirm("File 'x' exists.", "Overwrite", "Cancel"); got != -1 {
+		t.Errorf("Expected Escape to cancel, got %d", got)
+	}
+
+	// Cancelling keeps the chunk and its changes
+	editor.modified = true
+	key(tcell.KeyEscape, 0)
+	if err := editor.saveBeforeLeavingChunk(); err != errCancelled {
+		t.Errorf("Expected leaving the chunk to be cancelled, got %v", err)
+	}
+}
+
+// TestGoToLineForms covers relative lines, columns, bad input and line numbers
+// counted over a chunked file
+func TestGoToLineForms(t *testing.T) {
+	filename := createLargeTestFile(t, 25000, "Test")
+	defer os.Remove(filename)
+	editor, err := createTestEditor(filename)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	goTo := func(input string) {
+		for _, r := range input {
+			editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+		editor.statusMessage = ""
+		editor.goToLine()
+	}
+	goTo("12345:6")
+	if editor.currentChunk != 1 || editor.lines[editor.cursorY] != "Test line 12345" || editor.cursorX != 5 {
+		t.Errorf("Expected line 12345 column 6, got chunk %d line %q column %d", editor.currentChunk, editor.lines[editor.cursorY], editor.cursorX+1)
+	}
+	goTo("-345")
+	if editor.lines[editor.cursorY] != "Test line 12000" || editor.cursorX != 0 {
+		t.Errorf("Expected 345 lines up, got %q column %d", editor.lines[editor.cursorY], editor.cursorX+1)
+	}
+	goTo("+20000")
+	if editor.currentChunk != 2 || editor.lines[editor.cursorY] != "Test line 25000" || editor.statusMessage == "" {
+		t.Errorf("Expected the last line and a warning, got chunk %d line %q (%q)", editor.currentChunk, editor.lines[editor.cursorY], editor.statusMessage)
+	}
+
+	for _, bad := range []string{"abc", "12:x", "++3", "4:0"} {
+		y := editor.cursorY
+		goTo(bad)
+		if editor.cursorY != y || !strings.HasPrefix(editor.statusMessage, "Go to line: not a") {
+			t.Errorf("Expected %q rejected with a message, got line %d and %q", bad, editor.cursorY, editor.statusMessage)
+		}
+	}
+}
+
+// TestIncrementalSearchPosition checks the match count shown while searching
+func TestIncrementalSearchPosition(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"The Fox and the fox", "no animals here", "a fox again"}
+
+	editor.searchTerm = "FOX"
+	editor.cursorY, editor.cursorX = 0, 16
+	if current, total := editor.searchMatchPosition(); current != 2 || total != 3 {
+		t.Errorf("Expected match 2 of 3, got %d of %d", current, total)
+	}
+	editor.cursorX = 3
+	if current, _ := editor.searchMatchPosition(); current != 0 {
+		t.Errorf("Expected no match at the cursor, got %d", current)
+	}
+	editor.searchTerm = ""
+
+	bottomRow := func() string {
+		var sb strings.Builder
+		for x := 0; x < editor.width; x++ {
+			mainc, _, _, _ := editor.screen.GetContent(x, editor.height-1)
+			sb.WriteRune(mainc)
+		}
+		return strings.TrimRight(sb.String(), " ")
+	}
+	search := func(keys ...tcell.Key) string {
+		for _, k := range keys {
+			editor.screen.PostEvent(tcell.NewEventKey(k, 0, tcell.ModNone))
+		}
+		done := make(chan bool)
+		go func() {
+			editor.searchIncremental()
+			done <- true
+		}()
+		time.Sleep(50 * time.Millisecond)
+		row := bottomRow()
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+		<-done
+		return row
+	}
+
+	editor.searchTerm = "fox"
+	if got := search(tcell.KeyTab); !strings.HasSuffix(got, "match 2 of 3") {
+		t.Errorf("Expected the second match, got %q", got)
+	}
+	editor.searchTerm = "fox"
+	if got := search(tcell.KeyTab, tcell.KeyTab, tcell.KeyTab); !strings.HasSuffix(got, "match 1 of 3, wrapped") {
+		t.Errorf("Expected the search to wrap to the first match, got %q", got)
+	}
+	editor.searchTerm = "fox"
+	if got := search(tcell.KeyBacktab); !strings.HasSuffix(got, "match 3 of 3, wrapped") {
+		t.Errorf("Expected the search to wrap back to the last match, got %q", got)
+	}
+	editor.searchTerm = "zebra"
+	if got := search(); !strings.HasSuffix(got, "no match") {
+		t.Errorf("Expected no match shown, got %q", got)
+	}
+}
+
+// TestPromptHandlesResize checks that prompts and dialogs keep laying out the
+// editor behind them when the terminal is resized
+func TestPromptHandlesResize(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	sim := editor.screen.(tcell.SimulationScreen)
+	editor.lines = []string{"hello"}
+
+	sim.SetSize(60, 20)
+	editor.screen.PostEvent(tcell.NewEventResize(60, 20))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if got := editor.prompt("Name: "); got != "a" {
+		t.Errorf("Expected the prompt to carry on after the resize, got %q", got)
+	}
+	if editor.width != 60 || editor.height != 20 {
+		t.Errorf("Expected the editor laid out for 60x20, got %dx%d", editor.width, editor.height)
+	}
+	var sb strings.Builder
+	for x := 0; x < editor.width; x++ {
+		mainc, _, _, _ := editor.screen.GetContent(x, editor.height-1)
+		sb.WriteRune(mainc)
+	}
+	if got := sb.String(); !strings.HasPrefix(got, "Name: a") {
+		t.Errorf("Expected the prompt on the new bottom row, got %q", got)
+	}
+	if mainc, _, _, _ := editor.screen.GetContent(0, 0); mainc != 'h' {
+		t.Errorf("Expected the text redrawn behind the prompt, got %q", mainc)
+	}
+
+	// Dialogs too
+	sim.SetSize(80, 24)
+	editor.screen.PostEvent(tcell.NewEventResize(80, 24))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if got := editor.confirm("Sure?", "Yes", "No"); got != 0 || editor.width != 80 {
+		t.Errorf("Expected the dialog to answer after the resize, got %d at width %d", got, editor.width)
+	}
+}
+
+// TestReportError checks the status messages for failed operations
+func TestReportError(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+
+	editor.reportError("Save", &fs.PathError{Op: "open", Path: "/etc/notes.md", Err: syscall.EACCES})
+	if !strings.HasPrefix(editor.statusMessage, "Save failed: permission denied (/etc/notes.md); check") {
+		t.Errorf("Expected a permission problem explained, got %q", editor.statusMessage)
+	}
+	editor.reportError("Save", fmt.Errorf("failed to save file: %w", &fs.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}))
+	if editor.statusMessage != "Save failed: the disk is full (x); free some space and try again" {
+		t.Errorf("Expected a full disk explained through wrapping, got %q", editor.statusMessage)
+	}
+
+	editor.statusMessage = ""
+	editor.reportError("Save", nil)
+	editor.reportError("Save", errCancelled)
+	if editor.statusMessage != "" {
+		t.Errorf("Expected nothing reported for success or cancelling, got %q", editor.statusMessage)
+	}
+
+	// Cancelling the file name prompt is not a failure, but is not a save either
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	if err := editor.saveFileWithPrompt(); err != errCancelled {
+		t.Errorf("Expected the save to be cancelled, got %v", err)
+	}
+}
+
+// TestSuspend checks that Alt+Shift+Z and SIGTSTP give the terminal back and
+// take it again afterwards
+func TestSuspend(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	stops := 0
+	saved := stopProcess
+	stopProcess = func() error {
+		stops++
+		return nil
+	}
+	defer func() { stopProcess = saved }()
+
+	editor.handleAltKey('Z')
+	if stops != 1 || editor.statusMessage != "" {
+		t.Errorf("Expected one stop and no error, got %d and %q", stops, editor.statusMessage)
+	}
+
+	// A suspend request from the signal handler is acted on by the event loop
+	editor.screen.PostEvent(tcell.NewEventInterrupt(suspendRequest{}))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	editor.prompt("Name: ")
+	if stops != 2 {
+		t.Errorf("Expected the request handled under a prompt, got %d stops", stops)
+	}
+}
+
+// TestShutdownRecovery checks that a shutdown signal saves recovery copies of the
+// modified buffers and exits
+func TestShutdownRecovery(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	editor, err := createTestEditor(dir + "/a.md")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"changed a"}
+	editor.modified = true
+	if err := editor.openBuffer(dir + "/b.md"); err != nil {
+		t.Fatalf("Failed to open buffer: %v", err)
+	}
+	if err := editor.openBuffer(""); err != nil {
+		t.Fatalf("Failed to open buffer: %v", err)
+	}
+	editor.lines = []string{"new text"}
+	editor.modified = true
+
+	code := -1
+	saved := exitProcess
+	exitProcess = func(c int) { code = c }
+	defer func() { exitProcess = saved }()
+
+	editor.screen.PostEvent(tcell.NewEventInterrupt(shutdownRequest{syscall.SIGTERM}))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	editor.prompt("Name: ")
+	if code != 128+int(syscall.SIGTERM) {
+		t.Errorf("Expected to exit with the signal's status, got %d", code)
+	}
+
+	if data, err := os.ReadFile(dir + "/a.md.recovered"); err != nil || string(data) != "changed a\n" {
+		t.Errorf("Expected a recovery copy beside a.md, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(dir + "/b.md.recovered"); err == nil {
+		t.Errorf("Expected no copy of the unmodified buffer")
+	}
+	entries, _ := os.ReadDir(dir + "/mkmd/recovery")
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), "-untitled-3.md") {
+		t.Fatalf("Expected the unnamed buffer in the recovery folder, got %v", entries)
+	}
+	if data, _ := os.ReadFile(dir + "/mkmd/recovery/" + entries[0].Name()); string(data) != "new text\n" {
+		t.Errorf("Expected the unnamed buffer's text, got %q", data)
+	}
+}
+
+// TestSaveElsewhere checks the choices offered when a file cannot be written
+func TestSaveElsewhere(t *testing.T) {
+	dir := t.TempDir()
+	editor, err := createTestEditor(dir + "/x")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"one", "two"}
+	editor.modified = true
+	denied := &fs.PathError{Op: "open", Path: dir + "/x", Err: fs.ErrPermission}
+
+	// A temporary copy leaves the buffer unsaved and says where the copy is
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, 't', tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if err := editor.saveElsewhere(denied); err != nil {
+		t.Fatalf("Expected the copy to be written, got %v", err)
+	}
+	temp, ok := strings.CutPrefix(editor.statusMessage, "Copied to ")
+	temp, _, _ = strings.Cut(temp, ";")
+	if data, err := os.ReadFile(temp); !ok || err != nil || string(data) != "one\ntwo" {
+		t.Errorf("Expected the copy named in the status bar, got %q (%q, %v)", editor.statusMessage, data, err)
+	}
+	os.Remove(temp)
+	if !editor.modified {
+		t.Error("Expected the buffer to stay unsaved after a temporary copy")
+	}
+	if got := putInPlaceInstructions("/tmp/a", "/etc/b", "the disk is read-only"); strings.Contains(strings.Join(got, " "), "sudo") {
+		t.Errorf("Expected no sudo for a read-only disk, got %q", got)
+	}
+
+	// Saving as another file moves the buffer to it
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	for _, r := range ".md" {
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if err := editor.saveElsewhere(denied); err != nil {
+		t.Fatalf("Expected the file to be saved elsewhere, got %v", err)
+	}
+	if data, err := os.ReadFile(dir + "/x.md"); err != nil || string(data) != "one\ntwo" || editor.filename != dir+"/x.md" || editor.modified {
+		t.Errorf("Expected the buffer saved to x.md, got %q (%v), %q, modified %v", data, err, editor.filename, editor.modified)
+	}
+
+	// Cancelling reports the original failure
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	if err := editor.saveElsewhere(denied); err != denied {
+		t.Errorf("Expected the original error when cancelled, got %v", err)
+	}
+}
+
+// TestAtomicSave checks that saving replaces the file in one step, keeping its
+// permissions and symlinks, and leaves it alone when the save fails
+func TestAtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/x", []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir+"/x", dir+"/link"); err != nil {
+		t.Skip("symlinks not available:", err)
+	}
+	if err := writeLines(dir+"/link", []string{"new", "text"}); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/x"); string(data) != "new\ntext" {
+		t.Errorf("Expected the link's target saved, got %q", data)
+	}
+	if info, err := os.Lstat(dir + "/link"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Expected the symlink kept, got %v (%v)", info, err)
+	}
+	if info, _ := os.Stat(dir + "/x"); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the permissions kept, got %v", info.Mode().Perm())
+	}
+
+	// A save that cannot replace the file names it and leaves nothing behind
+	os.Mkdir(dir+"/folder", 0755)
+	os.WriteFile(dir+"/folder/inside", []byte("kept"), 0644)
+	err := writeLines(dir+"/folder", []string{"lost"})
+	if pathErr, ok := err.(*fs.PathError); !ok || pathErr.Path != dir+"/folder" {
+		t.Errorf("Expected an error naming the file saved, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("Expected no temporary file left, got %d entries", len(entries))
+	}
+}
+
+// TestFileLock checks that a file open in one editor is offered read-only to
+// another, and that the lock goes away when the first lets go
+func TestFileLock(t *testing.T) {
+	if !fileLocking {
+		t.Skip("file locks are not supported on this system")
+	}
+	dir := t.TempDir()
+	first, _ := createTestEditor(dir + "/x")
+	first.claimFile()
+	if data, err := os.ReadFile(lockPath(dir + "/x")); err != nil || strings.TrimSpace(string(data)) != fmt.Sprint(os.Getpid()) {
+		t.Errorf("Expected the lock file to name this process, got %q (%v)", data, err)
+	}
+
+	second, _ := createTestEditor(dir + "/x")
+	second.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	second.claimFile()
+	if !second.readOnly || len(second.locks) != 0 {
+		t.Errorf("Expected the second editor read-only without a lock, got %v and %d locks", second.readOnly, len(second.locks))
+	}
+
+	third, _ := createTestEditor(dir + "/x")
+	third.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'e', tcell.ModNone))
+	third.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	third.claimFile()
+	if third.readOnly {
+		t.Error("Expected Edit anyway to leave the file editable")
+	}
+
+	first.releaseLocks()
+	if _, err := os.Stat(lockPath(dir + "/x")); err == nil {
+		t.Error("Expected the lock file removed on release")
+	}
+	fourth, _ := createTestEditor(dir + "/x")
+	fourth.claimFile()
+	if fourth.readOnly || len(fourth.locks) != 1 {
+		t.Errorf("Expected the released file to lock without asking, got %v and %d locks", fourth.readOnly, len(fourth.locks))
+	}
+	fourth.releaseLocks()
+
+	// Naming an untitled buffer locks the new file, and a file another mkmd
+	// holds is not saved over unless the answer is to edit it anyway
+	fifth, _ := createTestEditor("")
+	if err := fifth.claimNewName(dir + "/y"); err != nil || len(fifth.locks) != 1 || fifth.filename != dir+"/y" {
+		t.Errorf("Expected the new name locked, got %v with %d locks", err, len(fifth.locks))
+	}
+	sixth, _ := createTestEditor("")
+	sixth.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if err := sixth.claimNewName(dir + "/y"); err != errCancelled || sixth.filename != "" || sixth.readOnly {
+		t.Errorf("Expected saving over a locked file to be cancelled, got %v, %q", err, sixth.filename)
+	}
+	fifth.releaseLocks()
+}
+
+// TestRecoverPanic checks that a panic in the event loop keeps the unsaved work
+// and exits rather than crashing with the terminal in raw mode
+func TestRecoverPanic(t *testing.T) {
+	dir := t.TempDir()
+	editor, err := createTestEditor(dir + "/x")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"unsaved"}
+	editor.modified = true
+
+	code := -1
+	saved := exitProcess
+	exitProcess = func(c int) { code = c }
+	defer func() { exitProcess = saved }()
+
+	func() {
+		defer editor.recoverPanic()
+		var lines []string
+		_ = lines[1]
+	}()
+	if code != 2 {
+		t.Errorf("Expected to exit with status 2, got %d", code)
+	}
+	if data, err := os.ReadFile(dir + "/x.recovered"); err != nil || string(data) != "unsaved\n" {
+		t.Errorf("Expected a recovery copy, got %q (%v)", data, err)
+	}
+}
+
+// TestLongLines checks that lines too long to edit are loaded in pieces, cut
+// between characters, and leave the buffer read-only
+func TestLongLines(t *testing.T) {
+	split := false
+	scanner := newLineScanner(strings.NewReader("12345678\nabcdefgéhij\nxy"), 8, &split)
+	var pieces []string
+	for scanner.Scan() {
+		pieces = append(pieces, scanner.Text())
+	}
+	if got := strings.Join(pieces, "|"); got != "12345678|abcdefg|éhij|xy" || !split {
+		t.Errorf("Expected the long line split before é, got %q (split %v)", got, split)
+	}
+	scanner = newLineScanner(strings.NewReader("abcdefghij\n"), 8, nil)
+	for scanner.Scan() {
+	}
+	if scanner.Err() != bufio.ErrTooLong {
+		t.Errorf("Expected an error without splitting, got %v", scanner.Err())
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(dir+"/x", []byte(strings.Repeat("a", maxLineBytes+10)+"\nend"), 0644)
+	editor, err := createTestEditor(dir + "/x")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	if err := editor.loadFile(); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if len(editor.lines) != 3 || len(editor.lines[1]) != 10 || !editor.readOnly || !strings.Contains(editor.statusMessage, "read-only") {
+		t.Errorf("Expected the line loaded in two pieces read-only, got %d lines, %v, %q", len(editor.lines), editor.readOnly, editor.statusMessage)
+	}
+}
+
+// TestDeleteWord checks Ctrl+Backspace and Ctrl+Delete against the word movement
+// boundaries, each undone in one step
+func TestDeleteWord(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"one two  three", "four"}
+	editor.cursorX = 9
+
+	editor.deleteWordLeft()
+	if editor.lines[0] != "one three" || editor.cursorX != 4 {
+		t.Errorf("Expected the previous word deleted, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.deleteWordRight()
+	if editor.lines[0] != "one " || editor.cursorX != 4 {
+		t.Errorf("Expected the next word deleted, got %q at %d", editor.lines[0], editor.cursorX)
+	}
+	editor.deleteWordRight()
+	if len(editor.lines) != 1 || editor.lines[0] != "one four" {
+		t.Errorf("Expected the next line joined at the end of a line, got %q", editor.lines)
+	}
+
+	editor.undo()
+	editor.undo()
+	if editor.lines[0] != "one three" {
+		t.Errorf("Expected each deletion undone in one step, got %q", editor.lines[0])
+	}
+}
+
+// TestKillLine checks that Ctrl+K and Ctrl+U cut to the end and start of the
+// line, or the line break, and that the text pastes back
+func TestKillLine(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"hello world", "next"}
+	editor.cursorX = 5
+
+	editor.killLineEnd()
+	if editor.lines[0] != "hello" || editor.clipboard != " world" {
+		t.Errorf("Expected the rest of the line cut, got %q and clipboard %q", editor.lines[0], editor.clipboard)
+	}
+	editor.killLineEnd()
+	if len(editor.lines) != 1 || editor.lines[0] != "hellonext" || editor.clipboard != "\n" {
+		t.Errorf("Expected the line break cut at the end of the line, got %q and clipboard %q", editor.lines, editor.clipboard)
+	}
+
+	editor.killLineStart()
+	if editor.lines[0] != "next" || editor.cursorX != 0 || editor.clipboard != "hello" {
+		t.Errorf("Expected the start of the line cut, got %q and clipboard %q", editor.lines[0], editor.clipboard)
+	}
+	editor.paste()
+	if editor.lines[0] != "hellonext" {
+		t.Errorf("Expected the cut text pasted back, got %q", editor.lines[0])
+	}
+
+	editor.lines = []string{"a", "b"}
+	editor.cursorX, editor.cursorY = 0, 1
+	editor.killLineStart()
+	if len(editor.lines) != 1 || editor.lines[0] != "ab" || editor.clipboard != "\n" {
+		t.Errorf("Expected the line break before cut at the start of a line, got %q and clipboard %q", editor.lines, editor.clipboard)
+	}
+}
+
+// TestKillRing checks that cuts are kept in the kill ring, that cuts on
+// consecutive keys join, and that an earlier cut can be pasted again
+func TestKillRing(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"first", "second", "third word"}
+	// What the event loop does at each key
+	nextKey := func() { editor.joinKill, editor.killed = editor.killed, false }
+
+	editor.killLineEnd()
+	nextKey()
+	editor.killLineEnd()
+	if len(editor.killRing) != 1 || editor.killRing[0] != "first\n" || editor.clipboard != "first\n" {
+		t.Errorf("Expected consecutive cuts joined, got %q", editor.killRing)
+	}
+
+	// Cutting backward after moving puts a new entry in front
+	nextKey()
+	editor.cursorX, editor.cursorY = 10, 1
+	nextKey()
+	editor.deleteWordLeft()
+	nextKey()
+	editor.deleteWordLeft()
+	if len(editor.killRing) != 2 || editor.killRing[1] != "third word" || editor.lines[1] != "" {
+		t.Errorf("Expected backward cuts joined in order, got %q and %q", editor.killRing, editor.lines)
+	}
+
+	// The older cut can still be pasted after the newer one replaced the clipboard
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.yankFromRing()
+	if got := strings.Join(editor.lines, "|"); got != "second|first|" || editor.killRing[1] != "first\n" {
+		t.Errorf("Expected the older cut pasted and made newest, got %q and %q", got, editor.killRing)
+	}
+}
+
+// TestTranspose checks swapping the characters around the cursor and the words
+// at and after it
+func TestTranspose(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	tests := []struct {
+		words bool
+		line  string
+		x     int
+		want  string
+		wantX int
+	}{
+		{false, "teh", 2, "the", 3},
+		{false, "teh", 3, "the", 3},
+		{false, "ab", 0, "ab", 0},
+		{false, "ae\u0301b", 3, "abe\u0301", 4},
+		{true, "one two three", 1, "two one three", 7},
+		{true, "one two three", 3, "two one three", 7},
+		{true, "one, two three", 5, "one, three two", 14},
+		{true, "one", 1, "one", 1},
+		{false, "teh \xff", 2, "the \xff", 3},
+		{true, "\xfe one two \xff", 2, "\xfe two one \xff", 9},
+	}
+	for _, tt := range tests {
+		editor.lines = []string{tt.line}
+		editor.cursorX, editor.cursorY = tt.x, 0
+		if tt.words {
+			editor.handleAltKey('W')
+		} else {
+			editor.handleAltKey('T')
+		}
+		if editor.lines[0] != tt.want || editor.cursorX != tt.wantX {
+			t.Errorf("Transposing %q at %d: expected %q at %d, got %q at %d", tt.line, tt.x, tt.want, tt.wantX, editor.lines[0], editor.cursorX)
+		}
+	}
+}
+
+// TestClickModifiers checks that Alt+click keeps the selection and a plain
+// click clears it
+func TestClickModifiers(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"hello world", "second line"}
+
+	editor.startSelection()
+	editor.cursorX = 6
+	editor.handleMouse(tcell.NewEventMouse(6, 1, tcell.Button1, tcell.ModAlt))
+	if got := editor.getSelectedText(); got != "hello world\nsecond" {
+		t.Errorf("Expected Alt+click to keep the selection and move its end, got %q", got)
+	}
+	editor.handleMouse(tcell.NewEventMouse(2, 0, tcell.Button1, tcell.ModNone))
+	if editor.selectionStart || editor.cursorX != 2 || editor.cursorY != 0 {
+		t.Errorf("Expected a plain click to clear the selection, got %v at %d,%d", editor.selectionStart, editor.cursorX, editor.cursorY)
+	}
+	editor.handleMouse(tcell.NewEventMouse(4, 1, tcell.Button1, tcell.ModAlt))
+	if editor.selectionStart || editor.cursorX != 4 || editor.cursorY != 1 {
+		t.Errorf("Expected Alt+click without a selection to only move the cursor, got %v at %d,%d", editor.selectionStart, editor.cursorX, editor.cursorY)
+	}
+}
+
+// TestPastedPath checks that a pasted or dropped file path is recognised and can
+// become a link, while other pasted text is inserted as it is
+func TestPastedPath(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/my notes.md", []byte("notes"), 0644)
+	os.WriteFile(dir+"/photo.png", []byte("png"), 0644)
+	tests := []struct {
+		text string
+		want string
+	}{
+		{dir + "/my notes.md", dir + "/my notes.md"},
+		{"'" + dir + "/my notes.md' ", dir + "/my notes.md"},
+		{strings.ReplaceAll(dir+"/my notes.md", " ", "\\ "), dir + "/my notes.md"},
+		{"file://" + strings.ReplaceAll(dir+"/my notes.md", " ", "%20"), dir + "/my notes.md"},
+		{dir, ""},
+		{dir + "/missing.md", ""},
+		{"my notes.md", ""},
+		{dir + "/photo.png\nmore", ""},
+	}
+	for _, tt := range tests {
+		if got := droppedPath(tt.text); got != tt.want {
+			t.Errorf("droppedPath(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	editor, err := createTestEditor(dir + "/x")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'l', tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.pasteText(dir + "/photo.png")
+	if editor.lines[0] != "![photo](photo.png)" {
+		t.Errorf("Expected an image link to the pasted path, got %q", editor.lines[0])
+	}
+
+	editor.lines = []string{""}
+	editor.cursorX = 0
+	for _, r := range "a\r\nb" {
+		key := tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone)
+		if r == '\r' || r == '\n' {
+			key = tcell.NewEventKey(tcell.Key(r), 0, tcell.ModNone)
+		}
+		editor.collectPaste(key)
+	}
+	editor.pasteText(lineBreaks.Replace(string(editor.pasted)))
+	if strings.Join(editor.lines, "|") != "a|b" {
+		t.Errorf("Expected pasted text inserted with one line break, got %q", editor.lines)
+	}
+}
+
+// TestClickColumn checks that a click on a character puts the cursor before it,
+// on either cell of a double-width character and with soft wrap. Rounding half
+// a cell used to put the cursor after a narrow character, so the first column
+// could not be clicked.
+func TestClickColumn(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"abc", "a漢b"}
+	for _, c := range []struct{ x, y, want int }{
+		{0, 0, 0}, {1, 0, 1}, {2, 0, 2}, {3, 0, 3}, {9, 0, 3},
+		{1, 1, 1}, {2, 1, 1}, {3, 1, 2},
+	} {
+		for _, wrap := range []bool{false, true} {
+			editor.softWrap = wrap
+			editor.handleMouse(tcell.NewEventMouse(c.x, c.y, tcell.Button1, tcell.ModNone))
+			if editor.cursorX != c.want || editor.cursorY != c.y {
+				t.Errorf("Click at %d,%d (soft wrap %v): expected column %d, got %d,%d", c.x, c.y, wrap, c.want, editor.cursorX, editor.cursorY)
+			}
+		}
+	}
+}
+
+// TestShiftClickKeepsAnchor checks that Shift+click selects from the cursor, and
+// moves the end of an existing selection, on either side of its anchor, without
+// moving the anchor
+func TestShiftClickKeepsAnchor(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"first line", "alpha beta gamma"}
+
+	editor.handleMouse(tcell.NewEventMouse(5, 0, tcell.Button1, tcell.ModShift))
+	if got := editor.getSelectedText(); got != "first" {
+		t.Errorf("Expected Shift+click to select from the cursor, got %q", got)
+	}
+
+	editor.clearSelection()
+	editor.cursorX, editor.cursorY = 6, 1
+	editor.startSelection()
+	editor.cursorX = 10
+	editor.handleMouse(tcell.NewEventMouse(16, 1, tcell.Button1, tcell.ModShift))
+	if got := editor.getSelectedText(); got != "beta gamma" {
+		t.Errorf("Expected the selection extended to the click, got %q", got)
+	}
+	editor.handleMouse(tcell.NewEventMouse(6, 0, tcell.Button1, tcell.ModShift))
+	if got := editor.getSelectedText(); got != "line\nalpha " || editor.selectionStartX != 6 || editor.selectionStartY != 1 {
+		t.Errorf("Expected the selection to run from the anchor back to the click, got %q from %d,%d", got, editor.selectionStartX, editor.selectionStartY)
+	}
+}
+
+// TestScrollSettings checks the wheel's momentum settings, the fixed-lines mode
+// and moving the cursor with the wheel
+func TestScrollSettings(t *testing.T) {
+	cfg := defaultConfig()
+	for _, kv := range [][2]string{{"scroll_multiplier", "30"}, {"scroll_decay", "0.5"}, {"scroll_max", "40"}, {"scroll_lines", "5"}} {
+		if err := cfg.set(kv[0], kv[1]); err != nil {
+			t.Fatalf("Failed to set %s: %v", kv[0], err)
+		}
+	}
+	for _, kv := range [][2]string{{"scroll_decay", "1"}, {"scroll_multiplier", "0"}, {"scroll_lines", "x"}} {
+		if err := cfg.set(kv[0], kv[1]); err == nil {
+			t.Errorf("Expected %s = %s to be refused", kv[0], kv[1])
+		}
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = make([]string, 100)
+	editor.config = cfg
+	editor.maxScrollMomentum = cfg.scrollMax
+	editor.handleMouse(tcell.NewEventMouse(0, 0, tcell.WheelDown, tcell.ModNone))
+	editor.handleMouse(tcell.NewEventMouse(0, 0, tcell.WheelDown, tcell.ModNone))
+	if editor.scrollMomentum != 40 {
+		t.Errorf("Expected the momentum capped at 40, got %v", editor.scrollMomentum)
+	}
+
+	editor.scrollMomentum = 0
+	editor.config.scrollMomentum = false
+	editor.handleMouse(tcell.NewEventMouse(0, 0, tcell.WheelDown, tcell.ModNone))
+	if editor.offsetY != 5 || editor.scrollMomentum != 0 || editor.cursorY != 0 {
+		t.Errorf("Expected the view scrolled 5 lines without momentum, got offset %d, momentum %v", editor.offsetY, editor.scrollMomentum)
+	}
+
+	editor.config.wheelMovesCursor = true
+	editor.handleMouse(tcell.NewEventMouse(0, 0, tcell.WheelDown, tcell.ModNone))
+	editor.handleMouse(tcell.NewEventMouse(0, 0, tcell.WheelUp, tcell.ModNone))
+	editor.handleMouse(tcell.NewEventMouse(0, 0, tcell.WheelDown, tcell.ModNone))
+	if editor.cursorY != 5 {
+		t.Errorf("Expected the wheel to move the cursor 5 lines, got line %d", editor.cursorY)
+	}
+}
+
+func TestCodeBlockHighlighting(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{
+		"```go",
+		"return \"x\" // done",
+		"/* open",
+		"func */ 42",
+		"```",
+		"return 42",
+		"```brainfuck",
+		"return",
+		"```",
+	}
+	editor.draw()
+
+	styleAt := func(x, y int) tcell.Style {
+		_, _, style, _ := editor.screen.GetContent(x, y)
+		return style
+	}
+	checks := []struct {
+		x, y int
+		want tcell.Style
+		what string
+	}{
+		{0, 1, editor.theme.codeKeyword, "keyword"},
+		{6, 1, editor.theme.text, "space between tokens"},
+		{8, 1, editor.theme.codeString, "string"},
+		{12, 1, editor.theme.codeComment, "line comment"},
+		{0, 3, editor.theme.codeComment, "block comment carried to the next line"},
+		{8, 3, editor.theme.codeNumber, "number after the block comment"},
+		{0, 5, editor.theme.text, "prose after the block"},
+		{0, 7, editor.theme.text, "unknown language"},
+	}
+	for _, c := range checks {
+		if got := styleAt(c.x, c.y); got != c.want {
+			t.Errorf("Expected the %s at (%d, %d) in its own style", c.what, c.x, c.y)
+		}
+	}
+
+	if fenceSyntax("``` {.Python}") != codeSyntaxes["python"] || fenceSyntax("```sh") != codeSyntaxes["shell"] {
+		t.Error("Expected fence names to be matched through aliases and Pandoc braces")
+	}
+	if kinds, _ := codeSyntaxes["shell"].tokens("echo $#", false); kinds[6] == codeComment {
+		t.Error("Expected $# not to start a shell comment")
+	}
+}
+
+func TestMathDelimiters(t *testing.T) {
+	tests := []struct {
+		line  string
+		spans string
+	}{
+		{"Euler: $e^{i\\pi} + 1 = 0$.", "[[7 25]]"},
+		{"It costs $5 and $10.", "[]"},
+		{"Not math: $ x $ or \\$y$", "[]"},
+		{"Code `$x$` then $$a$$ done", "[[16 21]]"},
+		{"Opens $$ \\sum", "[[6 13]]"},
+	}
+	for _, tt := range tests {
+		spans, _, _ := mathInLine([]rune(tt.line), false)
+		if got := fmt.Sprint(spans); got != fmt.Sprint(tt.spans) && !(tt.spans == "[]" && len(spans) == 0) {
+			t.Errorf("mathInLine(%q) = %v, want %s", tt.line, got, tt.spans)
+		}
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{
+		"The area is $\\pi r^2$, roughly.",
+		"$$",
+		"\\int_0^1 x \\, dx",
+		"$$",
+		"A $stray dollar and $$ another",
+		"",
+		"```",
+		"$$ in code",
+		"```",
+	}
+	if got := editor.wordCount(); got != 13 {
+		t.Errorf("Expected math left out of the word count, got %d words", got)
+	}
+	if got := fmt.Sprint(reflowLiteral(editor.lines)[:5]); got != "[false true true true false]" {
+		t.Errorf("Expected the display math block to be left alone by reflow, got %s", got)
+	}
+	if got := len(wordSpans("Let $xyzzy$ be")); got != 2 {
+		t.Errorf("Expected math not to be spell checked, got %d words", got)
+	}
+
+	editor.jumpUnclosedMath()
+	if editor.cursorY != 4 || editor.cursorX != 2 || editor.statusMessage != "Unclosed $ (1 of 2)" {
+		t.Errorf("Expected the stray $ first, got (%d, %d) %q", editor.cursorX, editor.cursorY, editor.statusMessage)
+	}
+	editor.jumpUnclosedMath()
+	if editor.cursorY != 4 || editor.cursorX != 20 || editor.statusMessage != "Unclosed $$ (2 of 2)" {
+		t.Errorf("Expected the unclosed $$ next, got (%d, %d) %q", editor.cursorX, editor.cursorY, editor.statusMessage)
+	}
+}
+
+func TestTableCellNavigation(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{
+		"Intro",
+		"| Name | Qty |",
+		"| ---- | --: |",
+		"| a\\|b |  |",
+		"",
+	}
+	editor.cursorY, editor.cursorX = 1, 3
+
+	steps := []struct {
+		delta int
+		x, y  int
+	}{
+		{1, 9, 1}, // Header's second cell
+		{1, 2, 3}, // Over the delimiter row to the first body cell
+		{1, 9, 3}, // Escaped pipe stays in the cell; empty cell gets one space in
+		{1, 2, 4}, // New row after the last cell
+		{-1, 9, 3},
+		{-1, 2, 3},
+		{-1, 9, 1},
+	}
+	for i, s := range steps {
+		if !editor.moveTableCell(s.delta) {
+			t.Fatalf("Step %d: expected the cursor to be in a table", i)
+		}
+		if editor.cursorX != s.x || editor.cursorY != s.y {
+			t.Errorf("Step %d: expected (%d, %d), got (%d, %d)", i, s.x, s.y, editor.cursorX, editor.cursorY)
+		}
+	}
+	if len(editor.lines) != 6 || editor.lines[4] != "|      |     |" || !editor.modified {
+		t.Errorf("Expected an empty row shaped like the delimiter row, got %q", editor.lines)
+	}
+
+	editor.cursorY, editor.cursorX = 0, 0
+	if editor.moveTableCell(1) {
+		t.Error("Expected Tab outside a table to insert as usual")
+	}
+}
+
+func TestPasteTabularData(t *testing.T) {
+	tests := []struct {
+		text string
+		rows int
+	}{
+		{"Name\tQty\nApple\t3\n", 2},
+		{"name,note\n\"Smith, J\",\"says \"\"hi\"\"\"\n", 2},
+		{"Size\tNote\n5\"\tscreen\n", 2},
+		{"a,b\nc,d,e\n", 0},
+		{"one line, with a comma", 0},
+		{"no\nseparators\n", 0},
+		{"| a | b |\n| - | - |\n", 0},
+	}
+	for _, tt := range tests {
+		if rows, _ := tabularData(tt.text); len(rows) != tt.rows {
+			t.Errorf("tabularData(%q) found %d rows, want %d", tt.text, len(rows), tt.rows)
+		}
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{"Fruit:"}
+	editor.cursorX = 6
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.pasteText("Name\tQty\nApple|Pie\t3\nFig\t12\n")
+	want := []string{
+		"Fruit:",
+		"| Name       | Qty |",
+		"| ---------- | --- |",
+		"| Apple\\|Pie | 3   |",
+		"| Fig        | 12  |",
+		"",
+	}
+	if strings.Join(editor.lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected an aligned table on its own lines, got %q", editor.lines)
+	}
+	if editor.cursorY != 5 || editor.cursorX != 0 {
+		t.Errorf("Expected the cursor after the table, got (%d, %d)", editor.cursorX, editor.cursorY)
+	}
+}
+
+func TestSortTable(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editor.lines = []string{
+		"| Fruit | Price |",
+		"| :---- | ----: |",
+		"| fig   | $1,200 |",
+		"| Apple | $3 |",
+		"| cherry |  |",
+	}
+	editor.cursorY = 3
+
+	sortBy := func(down int) string {
+		for range down {
+			editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone))
+		}
+		editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+		editor.sortTable()
+		var firsts []string
+		for _, row := range editor.lines[2:] {
+			firsts = append(firsts, strings.Fields(row)[1])
+		}
+		return strings.Join(firsts, " ")
+	}
+	if got := sortBy(0); got != "Apple cherry fig" || editor.statusMessage != "Sorted by Fruit (text, ascending)" {
+		t.Errorf("Expected rows sorted by name ignoring case, got %s (%q)", got, editor.statusMessage)
+	}
+	if got := sortBy(1); got != "cherry Apple fig" || editor.statusMessage != "Sorted by Price (numbers, ascending)" {
+		t.Errorf("Expected rows sorted by price with the empty cell first, got %s (%q)", got, editor.statusMessage)
+	}
+	if got := sortBy(1); got != "fig Apple cherry" || editor.statusMessage != "Sorted by Price (numbers, descending)" {
+		t.Errorf("Expected sorting again to reverse the order, got %s (%q)", got, editor.statusMessage)
+	}
+	if editor.lines[0] != "| Fruit | Price |" || editor.lines[1] != "| :---- | ----: |" || !editor.modified {
+		t.Errorf("Expected the header and delimiter rows kept, got %q", editor.lines[:2])
+	}
+}
+
+func TestFoldCodeBlocks(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.lines = []string{"Intro", "```go", "a := 1", "b := 2", "```", "After", "```", "x", "```"}
+	editor.cursorY = 3
+	editor.toggleFold()
+	if editor.cursorY != 1 || !editor.foldedBlocks[0] {
+		t.Fatalf("Expected the block folded with the cursor on its fence, got line %d", editor.cursorY)
+	}
+	editor.draw()
+	rowText := func(sy int) string {
+		var sb strings.Builder
+		for sx := 0; sx < 24; sx++ {
+			r, _, _, _ := editor.screen.GetContent(sx, sy)
+			sb.WriteRune(r)
+		}
+		return strings.TrimRight(sb.String(), " ")
+	}
+	if got := rowText(1); got != "```go … (2 lines)" {
+		t.Errorf("Expected the placeholder on the fence line, got %q", got)
+	}
+	if got := rowText(2); got != "After" {
+		t.Errorf("Expected the hidden lines skipped, got %q", got)
+	}
+	editor.softWrap = true
+	editor.draw()
+	if got := rowText(1) + "|" + rowText(2); got != "```go … (2 lines)|After" {
+		t.Errorf("Expected the fold kept with soft wrap, got %q", got)
+	}
+	editor.softWrap = false
+
+	if got := editor.nextShownLine(1, 1); got != 5 {
+		t.Errorf("Expected Down to pass over the folded lines, got line %d", got)
+	}
+	editor.handleMouse(tcell.NewEventMouse(2, 4, tcell.Button1, tcell.ModNone))
+	if editor.cursorY != 7 {
+		t.Errorf("Expected a click on screen row 4 to reach line 7, got %d", editor.cursorY)
+	}
+
+	// Landing inside a folded block unfolds it
+	editor.cursorY = 0
+	editor.toggleFold()
+	if len(editor.foldedBlocks) != 0 {
+		t.Fatalf("Expected Alt+Shift+F outside a block to unfold all, got %v", editor.foldedBlocks)
+	}
+	editor.toggleFold()
+	editor.lines = append([]string{"New first line"}, editor.lines...)
+	editor.cursorY = 3
+	editor.ensureCursorVisible()
+	if editor.foldedBlocks[0] || !editor.foldedBlocks[1] {
+		t.Errorf("Expected only the block holding the cursor unfolded, got %v", editor.foldedBlocks)
+	}
+}
+
+func TestTagIndex(t *testing.T) {
+	tags, xs := lineTags("#todo Call (#Work/home) about #42 and `#code` x#y # Heading #draft-")
+	if strings.Join(tags, ",") != "todo,Work/home,draft" || xs[0] != 0 || xs[1] != 12 {
+		t.Errorf("Expected todo, Work/home and draft, got %q at %v", tags, xs)
+	}
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.WriteFile("a.md", []byte("Notes #work\n```\n#work in code\n```\n"), 0644)
+	os.MkdirAll("sub", 0755)
+	os.WriteFile("sub/b.md", []byte("---\ntags: #meta\n---\nMore #Work and #ideas\n"), 0644)
+	os.MkdirAll(".git", 0755)
+	os.WriteFile(".git/c.md", []byte("#hidden\n"), 0644)
+	os.WriteFile("d.txt", []byte("#plain\n"), 0644)
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.lines = []string{"Unsaved #ideas"}
+
+	index, err := editor.tagIndex()
+	if err != nil {
+		t.Fatalf("tagIndex: %v", err)
+	}
+	if len(index) != 2 || len(index["work"]) != 2 || len(index["ideas"]) != 2 {
+		t.Fatalf("Expected work and ideas twice each, got %v", index)
+	}
+	if hit := index["work"][1]; projectPath(hit.path) != "sub/b.md" || hit.y != 3 || hit.x != 5 {
+		t.Errorf("Expected #Work at sub/b.md:4, got %+v", hit)
+	}
+
+	editor.cursorX = 9
+	if got := editor.tagAtCursor(); got != "ideas" {
+		t.Errorf("Expected the tag at the cursor, got %q", got)
+	}
+	if err := editor.gotoFile("sub/b.md", 5, 3); err != nil {
+		t.Fatalf("gotoFile: %v", err)
+	}
+	if editor.bufferCount() != 2 || editor.cursorY != 3 || editor.cursorX != 5 {
+		t.Errorf("Expected sub/b.md opened at the tag, got %d buffers at %d:%d", editor.bufferCount(), editor.cursorY, editor.cursorX)
+	}
+}
+
+func TestSearchProject(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.WriteFile("a.md", []byte("one Needle\nnone\nneedle and needle\n"), 0644)
+	os.MkdirAll("src", 0755)
+	os.WriteFile("src/b.go", []byte("// needle\n"), 0644)
+	os.WriteFile("build.log", []byte("needle\n"), 0644)
+	os.WriteFile("data.bin", []byte("needle\x00\x01"), 0644)
+	os.WriteFile(".gitignore", []byte("*.log\n"), 0644)
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.lines = []string{"unsaved needle"}
+
+	hits, cut, err := editor.grepProject(".", "NEEDLE")
+	if err != nil || cut {
+		t.Fatalf("grepProject: %v (cut %v)", err, cut)
+	}
+	// Outside a repository only hidden files are skipped
+	if len(hits) != 6 {
+		t.Errorf("Expected 6 matches without git, got %d: %v", len(hits), hits)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	if _, err := runGit(".", "init", "-q"); err != nil {
+		t.Fatalf("git init: %v", err)
+	}
+	hits, _, _ = editor.grepProject(".", "needle")
+	var got []string
+	for _, hit := range hits {
+		got = append(got, fmt.Sprintf("%s:%d:%d", strings.TrimPrefix(projectPath(hit.path), "."), hit.y+1, hit.x))
+	}
+	want := ":1:8,a.md:1:4,a.md:3:0,a.md:3:11,src/b.go:1:3"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Expected %s with .gitignore respected, got %v", want, got)
+	}
+	if label := hitLabel(hits[2]); label != "a.md:3  needle and needle" {
+		t.Errorf("Unexpected label %q", label)
+	}
+}
+
+func TestReplaceProject(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.WriteFile("a.md", []byte("Old and old\r\nkeep\r\nold\r\n"), 0644)
+	os.WriteFile("b.md", []byte("old\n"), 0644)
+	os.WriteFile("c.md", []byte("old\n"), 0644)
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.lines = []string{"an old draft"}
+	if err := editor.openBuffer("b.md"); err != nil {
+		t.Fatalf("openBuffer: %v", err)
+	}
+
+	hits, _, _ := editor.grepProject(".", "old")
+	plans := planReplacements(hits)
+	if len(plans) != 3 || plans[0].path != "a.md" || len(plans[0].hits) != 3 {
+		t.Fatalf("Expected the matches grouped by file, got %+v", plans)
+	}
+	plans[0].keep[1] = false
+	if got := markMatch(plans[0].hits[1].line, plans[0].hits[1].x, 3); got != "Old and [old]" {
+		t.Errorf("Unexpected marked match %q", got)
+	}
+	os.WriteFile("c.md", []byte("changed\n"), 0644)
+
+	msg := editor.applyReplacements(plans, 3, "new")
+	if msg != "Replaced 3 matches in 2 files (1 open buffers left unsaved); not changed: c.md: changed since it was searched" {
+		t.Errorf("Unexpected summary %q", msg)
+	}
+	if data, _ := os.ReadFile("a.md"); string(data) != "new and old\r\nkeep\r\nnew\r\n" {
+		t.Errorf("Expected a.md rewritten with its line endings, got %q", data)
+	}
+	if data, _ := os.ReadFile("b.md"); string(data) != "old\n" || editor.lines[0] != "new" || !editor.modified {
+		t.Errorf("Expected the open b.md changed in its buffer only, got %q on disk and %q", data, editor.lines)
+	}
+	editor.undo()
+	if editor.lines[0] != "old" {
+		t.Errorf("Expected the buffer change undoable, got %q", editor.lines)
+	}
+	if editor.activeBuffer != 1 {
+		t.Errorf("Expected the active buffer kept, got %d", editor.activeBuffer)
+	}
+}
+
+func TestRenameFileLinks(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.WriteFile("old.md", []byte("# Old\n"), 0644)
+	os.MkdirAll("sub", 0755)
+	os.WriteFile("sub/index.md", []byte("See [it](../old.md#part) and [[Old|the old one]]\r\n```\n[code](../old.md)\n```\n[ref]: <../old.md>\n[other](../other.md) https://x/old.md\n"), 0644)
+
+	from, _ := filepath.Abs("old.md")
+	to, _ := filepath.Abs("notes/new name.md")
+	r := relinker{docDir: documentFolder("sub/index.md"), from: from, to: to}
+	if got, n := r.line("[a](../old%2Emd) ![b](<../old.md>) [[old.md#x]]"); n != 3 || got != "[a](<../notes/new name.md>) ![b](<../notes/new name.md>) [[new name.md#x]]" {
+		t.Errorf("Unexpected relinked line %q (%d links)", got, n)
+	}
+
+	editor, err := createTestEditor("old.md")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	if err := moveFile(from, to); err != nil {
+		t.Fatalf("moveFile: %v", err)
+	}
+	editor.filename = "notes/new name.md"
+
+	files, _ := projectFiles(".")
+	counts, total := editor.countLinks(files, from, to)
+	if total != 3 || counts[filepath.Join("sub", "index.md")] != 3 {
+		t.Fatalf("Expected 3 links in sub/index.md, got %v", counts)
+	}
+	msg := editor.updateLinks(files, counts, from, to)
+	if msg != "updated 3 links in sub/index.md" {
+		t.Errorf("Unexpected summary %q", msg)
+	}
+	want := "See [it](<../notes/new name.md#part>) and [[new name|the old one]]\r\n```\n[code](../old.md)\n```\n[ref]: <../notes/new name.md>\n[other](../other.md) https://x/old.md\n"
+	if data, _ := os.ReadFile("sub/index.md"); string(data) != want {
+		t.Errorf("Unexpected rewritten file:\n%q", data)
+	}
+}
+
+func TestExpandFilenames(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.MkdirAll("ch", 0755)
+	os.MkdirAll("assets", 0755)
+	for _, name := range []string{"ch/2.md", "ch/1.md", "a.md", "odd[1].md"} {
+		os.WriteFile(name, []byte("x\n"), 0644)
+	}
+
+	cases := []struct {
+		args []string
+		want string
+		fail bool
+	}{
+		{[]string{"ch/*.md"}, "ch/1.md ch/2.md", false},
+		{[]string{"a.md", "*", "new.md"}, "a.md odd[1].md new.md", false},
+		{[]string{"odd[1].md", "a.md", "./a.md"}, "odd[1].md a.md", false},
+		{[]string{"none/*.md"}, "", true},
+		{[]string{"assets"}, "", true},
+		{[]string{"a.md:3", "ch/?.md"}, "a.md ch/1.md ch/2.md", false},
+	}
+	for _, tc := range cases {
+		opts, err := parseArgs(tc.args)
+		if (err != nil) != tc.fail || (err == nil && strings.Join(opts.filenames, " ") != tc.want) {
+			t.Errorf("parseArgs(%v) = %q, %v; want %q", tc.args, opts.filenames, err, tc.want)
+		}
+	}
+}
+
+func TestCompareRows(t *testing.T) {
+	a := []string{"same", "old", "gone", "same 2", "end"}
+	b := []string{"same", "new", "same 2", "extra", "end"}
+	var got []string
+	for _, row := range compareRows(a, b) {
+		got = append(got, fmt.Sprintf("%d%c%d", row.left, row.kind, row.right))
+	}
+	if strings.Join(got, " ") != "0 0 1~1 2--1 3 2 -1+3 4 4" {
+		t.Errorf("Unexpected rows %q", got)
+	}
+	if starts := differenceStarts(compareRows(a, b)); fmt.Sprint(starts) != "[1 4]" {
+		t.Errorf("Expected differences starting at rows 1 and 4, got %v", starts)
+	}
+
+	if _, err := parseArgs([]string{"--diff", "a.md"}); err == nil {
+		t.Error("Expected --diff with one file to fail")
+	}
+	if opts, err := parseArgs([]string{"--diff", "a.md", "b.md"}); err != nil || !opts.diff {
+		t.Errorf("Expected --diff with two files accepted, got %+v, %v", opts, err)
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	lines := []string{
+		"Intro",
+		"<<<<<<< HEAD",
+		"our line",
+		"||||||| base",
+		"old line",
+		"=======",
+		"their line",
+		">>>>>>> feature",
+		"Middle",
+		"<<<<<<<",
+		"a",
+		"=======",
+		"b",
+		">>>>>>>",
+		"<<<<<<< never closed",
+		"=======",
+	}
+	conflicts := findConflicts(lines)
+	if fmt.Sprint(conflicts) != "[{1 3 5 7} {9 -1 11 13}]" {
+		t.Fatalf("Unexpected conflicts %v", conflicts)
+	}
+	if got := conflicts[0].ours(lines); fmt.Sprint(got) != "[our line]" {
+		t.Errorf("Expected ours without the base section, got %q", got)
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.lines = lines
+	editor.jumpConflict(1)
+	editor.jumpConflict(1)
+	if editor.cursorY != 9 || editor.statusMessage != "Conflict 2 of 2 (Alt+= to resolve)" {
+		t.Errorf("Expected the second conflict, got line %d: %q", editor.cursorY, editor.statusMessage)
+	}
+	editor.jumpConflict(-1)
+	if editor.cursorY != 1 {
+		t.Errorf("Expected the previous conflict, got line %d", editor.cursorY)
+	}
+
+	// Both keeps ours then theirs in place of the markers
+	editor.cursorY = 4
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'b', tcell.ModNone))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	editor.resolveConflict()
+	if got := strings.Join(editor.lines[:5], "|"); got != "Intro|our line|their line|Middle|<<<<<<<" {
+		t.Errorf("Unexpected resolved lines %q", got)
+	}
+	if editor.statusMessage != "Kept both sides; 1 conflicts left" {
+		t.Errorf("Unexpected status %q", editor.statusMessage)
+	}
+}
+
+func TestWorkspaces(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("HOME", dir)
+	a, b := filepath.Join(dir, "a.md"), filepath.Join(dir, "b.md")
+	os.WriteFile(a, []byte(strings.Repeat("line\n", 50)), 0644)
+	os.WriteFile(b, []byte("one\ntwo\nthree\n"), 0644)
+
+	editor, err := createTestEditor(a)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.cursorY, editor.cursorX, editor.offsetY = 40, 2, 30
+	if err := editor.openBuffer(b); err != nil {
+		t.Fatalf("openBuffer: %v", err)
+	}
+	editor.cursorY = 2
+	editor.openBuffer("")
+	editor.switchBuffer(1)
+	editor.softWrap = true
+
+	editor.saveWorkspace("thesis")
+	if editor.statusMessage != `Saved workspace "thesis" (2 files); 1 unnamed buffers left out` {
+		t.Errorf("Unexpected status %q", editor.statusMessage)
+	}
+	if names := workspaceNames(); fmt.Sprint(names) != "[thesis]" {
+		t.Errorf("Expected the thesis workspace listed, got %v", names)
+	}
+	if err := checkWorkspaceName("../x"); err == nil {
+		t.Error("Expected a name with a slash to be refused")
+	}
+
+	// Opening it elsewhere brings back the files, positions and view
+	other, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer other.screen.Fini()
+	ws, err := readWorkspace("thesis")
+	if err != nil {
+		t.Fatalf("readWorkspace: %v", err)
+	}
+	os.Remove(b)
+	os.WriteFile(filepath.Join(dir, "c.md"), nil, 0644)
+	ws.files = append(ws.files, workspaceFile{path: filepath.Join(dir, "c.md"), line: 1, col: 1, top: 1})
+	missing, err := other.openWorkspace(ws)
+	if err != nil || missing != 1 {
+		t.Fatalf("openWorkspace: %d missing, %v", missing, err)
+	}
+	if other.bufferCount() != 2 || other.filename != a || !other.softWrap {
+		t.Fatalf("Expected a.md active of 2 buffers with soft wrap, got %q of %d", other.filename, other.bufferCount())
+	}
+	if other.cursorY != 40 || other.cursorX != 2 || other.offsetY != 30 {
+		t.Errorf("Expected a.md at line 41 col 3 from line 31, got %d:%d from %d", other.cursorY, other.cursorX, other.offsetY)
+	}
+
+	if _, err := parseArgs([]string{"--workspace", "thesis", "a.md"}); err == nil {
+		t.Error("Expected --workspace with filenames to fail")
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(path, []byte("one\ntwo\n"), 0644)
+	editor, err := createTestEditor(path)
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	if err := editor.loadFile(); err != nil {
+		t.Fatalf("loadFile: %v", err)
+	}
+	editor.toggleFollow()
+	if !editor.following() || !editor.readOnly || editor.cursorY != 1 {
+		t.Fatalf("Expected to follow read-only from the last line, at %d", editor.cursorY)
+	}
+	appendTo := func(text string) {
+		file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		file.WriteString(text)
+		file.Close()
+		editor.followFile()
+	}
+
+	// New lines are added, an unfinished one completed when the rest arrives
+	appendTo("three\r\nfo")
+	appendTo("ur\nfive\n")
+	if got := strings.Join(editor.lines, "|"); got != "one|two|three|four|five" {
+		t.Fatalf("Expected the appended lines, got %q", got)
+	}
+	if editor.cursorY != 4 || editor.modified {
+		t.Errorf("Expected the cursor pinned to the end of an unmodified buffer, at %d", editor.cursorY)
+	}
+
+	// Scrolled away from the end, the view stays where it is
+	for i := 0; i < 40; i++ {
+		appendTo(fmt.Sprintf("line %d\n", i))
+	}
+	if editor.cursorY != len(editor.lines)-1 || editor.offsetY == 0 {
+		t.Fatalf("Expected the view to follow the end, cursor %d top %d", editor.cursorY, editor.offsetY)
+	}
+	editor.offsetY = 0
+	appendTo("more\n")
+	if editor.cursorY != len(editor.lines)-2 || editor.offsetY != 0 {
+		t.Errorf("Expected the scrolled-up view left alone, cursor %d top %d", editor.cursorY, editor.offsetY)
+	}
+
+	// A rotated log is read again from the start
+	os.Remove(path)
+	os.WriteFile(path, []byte("fresh\n"), 0644)
+	editor.followFile()
+	if got := strings.Join(editor.lines, "|"); got != "fresh" {
+		t.Errorf("Expected the replaced file read again, got %q", got)
+	}
+
+	// Lines appended while a prompt is open are added by the prompt's loop
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("late\n")
+	file.Close()
+	editor.screen.PostEvent(tcell.NewEventInterrupt(followRequest{}))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	editor.prompt("Search: ")
+	if got := strings.Join(editor.lines, "|"); got != "fresh|late" {
+		t.Errorf("Expected the line added during the prompt, got %q", got)
+	}
+
+	// A request that is lost is made again while the file differs
+	file, _ = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("again\n")
+	file.Close()
+	events := make(chan tcell.Event)
+	go func() {
+		for {
+			ev := editor.screen.PollEvent()
+			if ev == nil {
+				return
+			}
+			events <- ev
+		}
+	}()
+	for lost := false; ; {
+		var ev tcell.Event
+		select {
+		case ev = <-events:
+		case <-time.After(10 * followInterval):
+			t.Fatal("Expected the follow request made again")
+		}
+		if _, ok := ev.(*tcell.EventInterrupt); !ok {
+			continue
+		}
+		if lost {
+			editor.handleInterrupt(ev.(*tcell.EventInterrupt).Data())
+			break
+		}
+		lost = true
+	}
+	if got := strings.Join(editor.lines, "|"); got != "fresh|late|again" {
+		t.Errorf("Expected the line read after the lost request, got %q", got)
+	}
+
+	editor.toggleFollow()
+	if editor.following() || editor.readOnly {
+		t.Error("Expected following to stop and the buffer to be editable again")
+	}
+
+	// A long file is followed from its last chunk
+	os.WriteFile(path, []byte("a\nb\nc\nd\ne"), 0644)
+	file, _ = os.Open(path)
+	defer file.Close()
+	f := &follower{}
+	lines, err := f.readLines(file, nil, 2)
+	if err != nil || strings.Join(lines, "|") != "e" || f.chunk != 2 || !f.partial || f.offset != 8 {
+		t.Errorf("Expected the unfinished last line in chunk 2, got %q in %d (offset %d, %v)", lines, f.chunk, f.offset, err)
+	}
+
+	// Growing past the end of a chunk moves on to the next, so saving after
+	// following writes each line once
+	os.WriteFile(path, []byte("1\n2\n3\n4\n5\n"), 0644)
+	editor.maxLines = 3
+	if err := editor.loadFile(); err != nil {
+		t.Fatalf("loadFile: %v", err)
+	}
+	editor.toggleFollow()
+	appendTo("6\n7\n8\n")
+	if got := strings.Join(editor.lines, "|"); got != "7|8" || editor.currentChunk != 2 {
+		t.Fatalf("Expected chunk 2 to hold 7|8, got %q in chunk %d", got, editor.currentChunk)
+	}
+	editor.toggleFollow()
+	editor.lines[1] = "eight"
+	editor.modified = true
+	if err := editor.saveFile(); err != nil {
+		t.Fatalf("saveFile: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "1\n2\n3\n4\n5\n6\n7\neight" {
+		t.Errorf("Expected every line written once, got %q", data)
+	}
+}
+
+func TestWordGoal(t *testing.T) {
+	for text, want := range map[string]string{"2000": "2000 false", "+500": "500 true", "1,500": "1500 false", "0": "0 false", "-5": "error", "lots": "error"} {
+		words, session, err := parseWordGoal(text)
+		got := fmt.Sprint(words, session)
+		if err != nil {
+			got = "error"
+		}
+		if got != want {
+			t.Errorf("parseWordGoal(%q) = %s, want %s", text, got, want)
+		}
+	}
+
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.lines = []string{"one two three"}
+	editor.wordGoal = 4
+	editor.checkWordGoal()
+	if editor.goalStatus() != "3/4 — 75%" || editor.statusMessage != "" {
+		t.Errorf("Expected quiet progress of 3/4, got %q (%q)", editor.goalStatus(), editor.statusMessage)
+	}
+
+	// Reaching the goal by editing is celebrated once
+	editor.lines[0] += " four"
+	editor.modified = true
+	editor.invalidateWordCount()
+	editor.checkWordGoal()
+	if !strings.HasPrefix(editor.statusMessage, "✓ Word goal reached: 4 words") || editor.goalStatus() != "4/4 — 100% ✓" {
+		t.Errorf("Expected the goal celebrated, got %q (%q)", editor.statusMessage, editor.goalStatus())
+	}
+	editor.statusMessage = ""
+	editor.checkWordGoal()
+	if editor.statusMessage != "" {
+		t.Errorf("Expected one celebration, got %q", editor.statusMessage)
+	}
+
+	// A session goal counts the words added since opening
+	editor.sessionGoal, editor.wordGoal = true, 2
+	editor.wordsAdded, editor.wordsRemoved = 3, 2
+	editor.goalMet = false
+	if editor.goalStatus() != "+1/2 — 50%" {
+		t.Errorf("Expected session progress of +1/2, got %q", editor.goalStatus())
+	}
+
+	var cfg config
+	if err := cfg.set("word_goal", "+750"); err != nil || cfg.wordGoal != 750 || !cfg.sessionGoal {
+		t.Errorf("Expected a session goal of 750 from the config, got %d %v (%v)", cfg.wordGoal, cfg.sessionGoal, err)
+	}
+}
+
+func TestSessionWords(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	edit := func(line string) {
+		editor.lines = []string{line}
+		editor.modified = true
+		editor.invalidateWordCount()
+		editor.trackWords()
+	}
+
+	// Words already there when the buffer is loaded are not counted as written
+	editor.lines = []string{"one two three"}
+	editor.invalidateWordCount()
+	editor.trackWords()
+	edit("one two three four five")
+	edit("one two three four")
+	if editor.wordsAdded != 2 || editor.wordsRemoved != 1 || signedWords(editor.sessionWords()) != "+1" {
+		t.Errorf("Expected 2 added and 1 removed, got %d and %d", editor.wordsAdded, editor.wordsRemoved)
+	}
+
+	// Saving moves the baseline for the change since the last save
+	editor.modified = false
+	editor.trackWords()
+	edit("one")
+	if got := signedWords(editor.wordCount() - editor.savedWords); got != "-3" {
+		t.Errorf("Expected -3 since the save, got %s", got)
+	}
+	if signedWords(-1240) != "-1,240" || signedWords(0) != "+0" {
+		t.Errorf("Unexpected signed counts %s, %s", signedWords(-1240), signedWords(0))
+	}
+}
+
+func TestReadability(t *testing.T) {
+	lines := []string{
+		"# Title",
+		"The cat sat on the mat. It was seen by [the dog](http://example.com).",
+		"",
+		"- Dr. Smith wrote *this* list item",
+		"```go",
+		"code here. More code.",
+		"```",
+	}
+	r := measureReadability(lines)
+	if r.sentences != 3 || r.words != 18 || r.syllables != 19 || r.passive != 1 || r.longest != 6 {
+		t.Errorf("Expected 3 sentences, 18 words, 19 syllables, 1 passive, longest 6; got %+v", r)
+	}
+	if grade := fmt.Sprintf("%.1f", r.grade()); grade != "-0.8" {
+		t.Errorf("Expected a grade of -0.8, got %s", grade)
+	}
+
+	for word, want := range map[string]int{"make": 1, "little": 2, "readability": 5, "see": 1, "rhythm": 1} {
+		if got := countSyllables(word); got != want {
+			t.Errorf("countSyllables(%q) = %d, want %d", word, got, want)
+		}
+	}
+	for sentence, want := range map[string]bool{
+		"the report was written by the team":    true,
+		"mistakes were clearly made":            true,
+		"the team is writing the report":        false,
+		"she was happy":                         false,
+		"the files are not yet indexed":         false,
+		"the results have been widely reported": true,
+	} {
+		if got := passiveSentence(strings.Fields(sentence)); got != want {
+			t.Errorf("passiveSentence(%q) = %v, want %v", sentence, got, want)
+		}
+	}
+
+	// The result is kept until the text changes
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.lines = []string{"One sentence."}
+	if editor.documentReadability().sentences != 1 {
+		t.Fatal("Expected one sentence")
+	}
+	editor.lines = []string{"One sentence. Two."}
+	if editor.documentReadability().sentences != 1 {
+		t.Error("Expected the cached result before the text is marked changed")
+	}
+	editor.invalidateWordCount()
+	if editor.documentReadability().sentences != 2 {
+		t.Error("Expected the changed text measured again")
+	}
+}
+
+func TestDocumentStructure(t *testing.T) {
+	lines := []string{
+		"---",
+		"title: Draft",
+		"---",
+		"Opening words here.",
+		"# Part one",
+		"Text with a [link](a.md), ![a picture](p.png) and <https://example.com>.[^1]",
+		"## Chapter",
+		"- first item",
+		"- second [[Wiki]] item",
+		"* * *",
+		"```",
+		"# not a heading [nor](link)",
+		"```",
+		"# Part two",
+		"Short.",
+		"[^1]: A note.",
+	}
+	s := measureStructure(lines)
+	if s.headings != [6]int{2, 1} || s.listItems != 2 || s.codeBlocks != 1 || s.links != 3 || s.images != 1 || s.footnotes != 1 || s.footnoteRefs != 1 {
+		t.Errorf("Unexpected counts %+v", s)
+	}
+	var got []string
+	for _, sec := range s.sections {
+		got = append(got, fmt.Sprintf("%d %s %d/%d", sec.heading.level, sec.heading.text, sec.words, sec.total))
+	}
+	want := "0  7/7|1 Part one 11/30|2 Chapter 19/19|1 Part two 7/7"
+	if strings.Join(got, "|") != want {
+		t.Errorf("Expected sections %q, got %q", want, strings.Join(got, "|"))
+	}
+
+	report := s.report()
+	if report[1] != " Headings           3  (H1 2, H2 1)" {
+		t.Errorf("Unexpected headings row %q", report[1])
+	}
+	if last := report[len(report)-1]; !strings.HasPrefix(last, " Part two") || !strings.HasSuffix(last, "      7  █████") {
+		t.Errorf("Unexpected section row %q", last)
+	}
+}
+
+func TestWritingTimer(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	editor.config.timerFocus = true
+	editor.lines = []string{"one two"}
+	editor.invalidateWordCount()
+	editor.trackWords()
+
+	start := time.Now()
+	editor.startTimer(10*time.Minute, start)
+	if editor.timer == nil || !editor.focusMode {
+		t.Fatalf("Expected a running timer in focus mode")
+	}
+	if left := editor.timer.timeLeft(start.Add(90 * time.Second)); left != "8:30" {
+		t.Errorf("Expected 8:30 left, got %s", left)
+	}
+
+	editor.lines = []string{"one two three four five"}
+	editor.modified = true
+	editor.invalidateWordCount()
+	editor.trackWords()
+
+	// The session keeps going until its time is up, then sums up the words
+	editor.checkTimer(start.Add(9 * time.Minute))
+	if editor.timer == nil {
+		t.Fatalf("Expected the timer to still be running")
+	}
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	editor.checkTimer(start.Add(10 * time.Minute))
+	if editor.timer != nil || editor.focusMode {
+		t.Errorf("Expected the timer to end and leave focus mode")
+	}
+	if want := "Writing session done: +3 words in 10 minutes"; editor.statusMessage != want {
+		t.Errorf("Expected %q, got %q", want, editor.statusMessage)
+	}
+}
+
+func TestWritingLog(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	editor, err := createTestEditor(filepath.Join(t.TempDir(), "novel.md"))
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	edit := func(line string, pause time.Duration) {
+		editor.lastWritten = time.Now().Add(-pause)
+		editor.lines = []string{line}
+		editor.modified = true
+		editor.invalidateWordCount()
+		editor.trackWords()
+	}
+
+	// Pauses count as writing time up to writingPause
+	editor.trackWords()
+	edit("one two", 2*time.Minute)
+	edit("one two three four", time.Hour)
+	if editor.writingTime.Round(time.Minute) != 7*time.Minute {
+		t.Errorf("Expected 7 minutes of writing, got %v", editor.writingTime)
+	}
+
+	now := time.Date(2026, 10, 16, 14, 32, 0, 0, time.Local)
+	if err := editor.logWriting(now); err != nil {
+		t.Fatalf("logWriting failed: %v", err)
+	}
+	edit("one two three", time.Second)
+	editor.logWriting(now.Add(time.Hour))
+	editor.logWriting(now.Add(2 * time.Hour))
+	data, err := os.ReadFile(writingLogPath())
+	if err != nil {
+		t.Fatalf("Failed to read the log: %v", err)
+	}
+	want := "2026-10-16 14:32\t4\t0\t420\t" + editor.filename + "\n" +
+		"2026-10-16 15:32\t0\t1\t1\t" + editor.filename + "\n"
+	if string(data) != want {
+		t.Errorf("Expected log:\n%q\ngot:\n%q", want, string(data))
+	}
+	entry, ok := parseLogEntry("2026-10-16 14:32\t4\t0\t420\t/notes/a\tb.md")
+	if !ok || entry.path != "/notes/a\tb.md" || entry.spent != 7*time.Minute || !entry.when.Equal(now) {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+
+	// The history adds up the days, latest first, and the streak up to yesterday
+	entries := []logEntry{
+		{when: now.AddDate(0, 0, -3), path: "/a.md", added: 100, spent: time.Hour},
+		{when: now.AddDate(0, 0, -1), path: "/a.md", added: 50, removed: 10, spent: 20 * time.Minute},
+		{when: now.AddDate(0, 0, -2), path: "/b.md", added: 30, spent: 5 * time.Minute},
+		{when: now.AddDate(0, 0, -1), path: "/b.md", added: 5, spent: 5 * time.Minute},
+	}
+	lines := writingHistory(entries, now)
+	for _, want := range []string{
+		" Last 7 days       +175 words   1h 30m",
+		" Streak         3 days",
+		"Thu 15 Oct 2026     +45 words      25m",
+		"   a.md             +40 words      20m  (50 added, 10 removed)",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("Expected the line %q in:\n%s", want, strings.Join(lines, "\n"))
+		}
+	}
+	if lines[5] != "Thu 15 Oct 2026     +45 words      25m" {
+		t.Errorf("Expected the latest day first, got %q", lines[5])
+	}
+}
+
+// TestChunkHistoryBudget checks that the undo history of the chunks not loaded
+// shares one budget, losing its oldest entries whichever chunk they belong to
+func TestChunkHistoryBudget(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+	start := time.Now()
+	entry := func(minute int, text string) undoState {
+		return undoState{lines: []string{text}, time: start.Add(time.Duration(minute) * time.Minute), size: 100}
+	}
+	editor.undoBudget = 250
+	editor.chunkHistories = map[int]chunkHistory{
+		1: {undoStack: []undoState{entry(0, "a0"), entry(2, "a2")}, undoBytes: 200},
+		2: {undoStack: []undoState{entry(3, "b3")}, redoStack: []undoState{entry(1, "b1")}, undoBytes: 100, redoBytes: 100},
+	}
+	editor.currentChunk = 3
+	editor.stashChunkHistory()
+
+	first, second := editor.chunkHistories[1], editor.chunkHistories[2]
+	if len(first.undoStack) != 1 || first.undoStack[0].lines[0] != "a2" || first.undoBytes != 100 {
+		t.Errorf("Expected only the newer entry of chunk 1 kept, got %+v", first)
+	}
+	if len(second.redoStack) != 0 || second.redoBytes != 0 || len(second.undoStack) != 1 {
+		t.Errorf("Expected the old redo entry of chunk 2 dropped, got %+v", second)
+	}
+}
+
+// TestModalInterrupts checks that a prompt handles the interrupts the main loop
+// does, so a writing session ends on time while a prompt is open, and that the
+// image preview waits in the same loop
+func TestModalInterrupts(t *testing.T) {
+	editor, err := createTestEditor("")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	defer editor.screen.Fini()
+
+	editor.startTimer(time.Minute, time.Now().Add(-2*time.Minute))
+	editor.screen.PostEvent(tcell.NewEventInterrupt(timerRequest{}))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone)) // Closes the summary
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone)) // Closes the prompt
+	editor.prompt("Search: ")
+	if editor.timer != nil || !strings.HasPrefix(editor.statusMessage, "Writing session done") {
+		t.Errorf("Expected the writing session to end during the prompt, got %q", editor.statusMessage)
+	}
+
+	var tty strings.Builder
+	editor.screen.PostEvent(tcell.NewEventInterrupt(nil))
+	editor.screen.PostEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	editor.showGraphics(&tty, "cat.png", "IMAGE", graphicsKitty)
+	if got := tty.String(); got != "\x1b[2;2HIMAGE\x1b_Ga=d,q=2\x1b\\" {
+		t.Errorf("Expected the image written once and removed, got %q", got)
+	}
+}
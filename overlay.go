@@ -0,0 +1,445 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// pickFromList shows a modal list over the editor and returns the chosen index,
+// or -1 if the user cancelled. Without a preview the list is a dialog in the
+// middle of the editor; if preview is non-nil, the list takes the screen and its
+// lower half shows the lines preview returns for the highlighted item.
+func (e *Editor) pickFromList(title string, items []string, preview func(int) []string) int {
+	choice, _ := e.pickList(title, items, preview, false)
+	return choice
+}
+
+// pickSearchable is pickFromList with a search line: typing narrows the list to
+// the items containing every word typed, in any order and case. Enter when
+// nothing matches returns -1 with the search, so callers can act on it.
+func (e *Editor) pickSearchable(title string, items []string) (int, string) {
+	return e.pickList(title, items, nil, true)
+}
+
+// matchesQuery reports whether item contains every space-separated word of query,
+// ignoring case
+func matchesQuery(item, query string) bool {
+	item = strings.ToLower(item)
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(item, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// pickList runs the list overlay behind pickFromList and pickSearchable
+func (e *Editor) pickList(title string, items []string, preview func(int) []string, searchable bool) (int, string) {
+	if len(items) == 0 {
+		return -1, ""
+	}
+
+	selected := 0
+	top := 0
+	query := []rune{}
+	shown := make([]int, len(items)) // Indices of the items matching the query
+	for i := range shown {
+		shown[i] = i
+	}
+	titleStyle := e.theme.prompt
+	itemStyle := e.theme.text
+	selectedStyle := e.theme.picked
+	previewStyle := e.theme.dim
+
+	// A list without a preview is drawn in a dialog as wide as its longest item
+	dialogWidth := displayWidth(title) + 12
+	for _, item := range items {
+		dialogWidth = max(dialogWidth, displayWidth(item)+2)
+	}
+
+	redraw := func() {
+		heading := fmt.Sprintf("%s (%d/%d)", title, min(selected+1, len(shown)), len(shown))
+		if preview == nil {
+			x, y, w, h := e.drawDialog(heading, dialogWidth, len(items))
+			top = max(min(top, selected), selected-h+1)
+			for row := 0; row < h; row++ {
+				style := itemStyle
+				text := ""
+				if top+row < len(shown) {
+					text = " " + items[shown[top+row]]
+				}
+				if top+row == selected {
+					style = selectedStyle
+				}
+				e.drawClipped(x, y+row, w, text, style)
+			}
+		} else {
+			// Split the screen between the list and the preview pane
+			e.screen.Clear()
+			listRows := max(1, (e.height-2)/2)
+			top = max(min(top, selected), selected-listRows+1)
+
+			e.fillRow(0, titleStyle)
+			e.drawText(0, 0, " "+heading, titleStyle)
+			for row := 0; row < listRows && top+row < len(shown); row++ {
+				style := itemStyle
+				if top+row == selected {
+					style = selectedStyle
+					e.fillRow(row+1, style)
+				}
+				e.drawText(1, row+1, items[shown[top+row]], style)
+			}
+
+			if len(shown) > 0 {
+				e.fillRow(listRows+1, titleStyle)
+				e.drawText(0, listRows+1, " Preview", titleStyle)
+				for i, line := range preview(shown[selected]) {
+					y := listRows + 2 + i
+					if y >= e.height-1 {
+						break
+					}
+					e.drawText(1, y, line, previewStyle)
+				}
+			}
+		}
+
+		e.fillRow(e.height-1, titleStyle)
+		if searchable {
+			e.drawText(0, e.height-1, " Search: "+string(query), titleStyle)
+			hints := "Up/Down: select | Enter: choose | Esc: cancel "
+			if x := e.fullWidth() - displayWidth(hints); x > displayWidth(" Search: "+string(query))+1 {
+				e.drawText(x, e.height-1, hints, titleStyle)
+			}
+			e.screen.ShowCursor(displayWidth(" Search: "+string(query)), e.height-1)
+		} else {
+			e.drawText(0, e.height-1, " Up/Down: select | Enter: choose | Esc: cancel", titleStyle)
+			e.screen.HideCursor()
+		}
+		e.screen.Show()
+	}
+
+	choice, answer := -1, ""
+	e.modal(redraw, func(ev *tcell.EventKey) bool {
+		switch ev.Key() {
+		case tcell.KeyEnter:
+			if len(shown) > 0 {
+				choice = shown[selected]
+			}
+			answer = string(query)
+			return true
+		case tcell.KeyEscape:
+			return true
+		case tcell.KeyUp:
+			if selected > 0 {
+				selected--
+			}
+		case tcell.KeyDown:
+			if selected < len(shown)-1 {
+				selected++
+			}
+		case tcell.KeyPgUp:
+			selected -= e.height / 2
+			if selected < 0 {
+				selected = 0
+			}
+		case tcell.KeyPgDn:
+			selected += e.height / 2
+			if selected >= len(shown) {
+				selected = max(0, len(shown)-1)
+			}
+		case tcell.KeyHome:
+			selected = 0
+		case tcell.KeyEnd:
+			selected = max(0, len(shown)-1)
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if searchable && len(query) > 0 {
+				query = query[:len(query)-1]
+				selected = 0
+			}
+		case tcell.KeyRune:
+			if searchable {
+				query = append(query, ev.Rune())
+				selected = 0
+			}
+		}
+		if searchable {
+			shown = shown[:0]
+			for i, item := range items {
+				if matchesQuery(item, string(query)) {
+					shown = append(shown, i)
+				}
+			}
+		}
+		return false
+	})
+	return choice, answer
+}
+
+// fillRow paints an entire screen row with the given style
+func (e *Editor) fillRow(y int, style tcell.Style) {
+	for x := 0; x < e.fullWidth(); x++ {
+		e.screen.SetContent(x, y, ' ', nil, style)
+	}
+}
+
+// drawDialog draws the editor with a bordered box over its middle, the title in
+// the top border, holding width by height cells (less on a small screen). It
+// returns the top-left inner cell and the inner size.
+func (e *Editor) drawDialog(title string, width, height int) (x, y, w, h int) {
+	e.draw()
+	w = max(1, min(width, e.fullWidth()-4))
+	h = max(1, min(height, e.height-3))
+	x = (e.fullWidth() - w) / 2
+	y = (e.height - 1 - h) / 2
+	border := e.theme.prompt
+
+	for row := y - 1; row <= y+h; row++ {
+		for col := x - 1; col <= x+w; col++ {
+			r, style := ' ', e.theme.text
+			switch {
+			case row == y-1 || row == y+h:
+				r, style = '─', border
+			case col == x-1 || col == x+w:
+				r, style = '│', border
+			}
+			e.screen.SetContent(col, row, r, nil, style)
+		}
+	}
+	e.screen.SetContent(x-1, y-1, '┌', nil, border)
+	e.screen.SetContent(x+w, y-1, '┐', nil, border)
+	e.screen.SetContent(x-1, y+h, '└', nil, border)
+	e.screen.SetContent(x+w, y+h, '┘', nil, border)
+	e.drawClipped(x, y-1, min(w, displayWidth(title)+2), " "+title+" ", border)
+	return x, y, w, h
+}
+
+// drawClipped draws text from column x, cut off after width columns and padded
+// with spaces to fill them
+func (e *Editor) drawClipped(x, y, width int, text string, style tcell.Style) {
+	col := 0
+	for _, r := range text {
+		if col+displayWidthRune(r) > width {
+			break
+		}
+		e.screen.SetContent(x+col, y, r, nil, style)
+		col += displayWidthRune(r)
+	}
+	for ; col < width; col++ {
+		e.screen.SetContent(x+col, y, ' ', nil, style)
+	}
+}
+
+// messageBox shows lines in a dialog until a key is pressed
+func (e *Editor) messageBox(title string, lines []string) {
+	width := displayWidth(title) + 4
+	for _, line := range lines {
+		width = max(width, displayWidth(line)+2)
+	}
+	redraw := func() {
+		x, y, w, h := e.drawDialog(title, width, len(lines))
+		for row := 0; row < h && row < len(lines); row++ {
+			e.drawClipped(x, y+row, w, " "+lines[row], e.theme.text)
+		}
+		e.fillRow(e.height-1, e.theme.prompt)
+		e.drawText(0, e.height-1, " Press any key to close", e.theme.prompt)
+		e.screen.HideCursor()
+		e.screen.Show()
+	}
+
+	e.modal(redraw, func(*tcell.EventKey) bool { return true })
+}
+
+// confirm asks question in a dialog offering choices and returns the index of
+// the one picked, or -1 when Escape cancels. Left/Right and Tab/Shift+Tab move
+// between the choices, starting at the first, and Enter picks one. Typing a
+// choice's first letter moves to it, and y and n move to the first and second.
+func (e *Editor) confirm(question string, choices ...string) int {
+	selected := 0
+	buttonsWidth := 0
+	for _, c := range choices {
+		buttonsWidth += displayWidth(c) + 4
+	}
+	width := max(displayWidth(question)+2, buttonsWidth)
+
+	redraw := func() {
+		x, y, w, h := e.drawDialog("Confirm", width, 3)
+		e.drawClipped(x, y, w, " "+question, e.theme.text)
+		if h == 3 {
+			col := x + max(0, (w-buttonsWidth)/2)
+			for i, c := range choices {
+				style := e.theme.text
+				if i == selected {
+					style = e.theme.picked
+				}
+				e.drawClipped(col+1, y+2, displayWidth(c)+2, " "+c+" ", style)
+				col += displayWidth(c) + 4
+			}
+		}
+		e.fillRow(e.height-1, e.theme.prompt)
+		e.drawText(0, e.height-1, " Left/Right: select | Enter: choose | Esc: cancel", e.theme.prompt)
+		e.screen.HideCursor()
+		e.screen.Show()
+	}
+
+	choice := -1
+	e.modal(redraw, func(ev *tcell.EventKey) bool {
+		switch ev.Key() {
+		case tcell.KeyEnter:
+			choice = selected
+			return true
+		case tcell.KeyEscape:
+			return true
+		case tcell.KeyRight, tcell.KeyTab:
+			selected = (selected + 1) % len(choices)
+		case tcell.KeyLeft, tcell.KeyBacktab:
+			selected = (selected + len(choices) - 1) % len(choices)
+		case tcell.KeyRune:
+			r := unicode.ToLower(ev.Rune())
+			switch {
+			case r == 'y':
+				selected = 0
+			case r == 'n' && len(choices) > 1:
+				selected = 1
+			default:
+				for i, c := range choices {
+					if first, _ := utf8.DecodeRuneInString(c); unicode.ToLower(first) == r {
+						selected = i
+						break
+					}
+				}
+			}
+		}
+		return false
+	})
+	return choice
+}
+
+// formField is one labelled input of a form and its starting value
+type formField struct {
+	label string
+	value string
+}
+
+// form shows fields in a dialog to be filled in together. Tab, Shift+Tab,
+// Up and Down move between the fields, each edited like a prompt line; Enter
+// accepts and Escape cancels. It returns the values and whether they were
+// accepted.
+func (e *Editor) form(title string, fields []formField) ([]string, bool) {
+	lines := make([]*promptLine, len(fields))
+	labelWidth := 0
+	for i, f := range fields {
+		lines[i] = newPromptLine(f.value)
+		labelWidth = max(labelWidth, displayWidth(f.label))
+	}
+	current := 0
+
+	redraw := func() {
+		x, y, w, h := e.drawDialog(title, max(50, displayWidth(title)+4), len(fields))
+		for row := 0; row < h && row < len(fields); row++ {
+			label := " " + fields[row].label + strings.Repeat(" ", labelWidth-displayWidth(fields[row].label)) + ": "
+			e.drawClipped(x, y+row, w, label, e.theme.prompt)
+			if lw := displayWidth(label); lw < w {
+				// Long values scroll so the cursor stays in view
+				value := lines[row].String()
+				before := displayWidth(lines[row].beforeCursor())
+				skip := max(0, before-(w-lw-1))
+				e.drawClipped(x+lw, y+row, w-lw, dropColumns(value, skip), e.theme.text)
+				if row == current {
+					e.screen.ShowCursor(x+lw+before-skip, y+row)
+				}
+			}
+		}
+		e.fillRow(e.height-1, e.theme.prompt)
+		e.drawText(0, e.height-1, " Tab/Up/Down: next field | Enter: accept | Esc: cancel", e.theme.prompt)
+		e.screen.Show()
+	}
+
+	var values []string
+	e.modal(redraw, func(ev *tcell.EventKey) bool {
+		switch ev.Key() {
+		case tcell.KeyEnter:
+			values = make([]string, len(lines))
+			for i, line := range lines {
+				values[i] = line.String()
+			}
+			return true
+		case tcell.KeyEscape:
+			return true
+		case tcell.KeyTab, tcell.KeyDown:
+			current = (current + 1) % len(fields)
+		case tcell.KeyBacktab, tcell.KeyUp:
+			current = (current + len(fields) - 1) % len(fields)
+		default:
+			lines[current].edit(ev)
+		}
+		return false
+	})
+	return values, values != nil
+}
+
+// dropColumns removes the first n screen columns of text
+func dropColumns(text string, n int) string {
+	col := 0
+	for i, r := range text {
+		if col >= n {
+			return text[i:]
+		}
+		col += displayWidthRune(r)
+	}
+	return ""
+}
+
+// viewLines shows read-only text in a scrollable full-screen overlay until the user
+// presses Escape, Enter or q. styleFor picks the style of each line.
+func (e *Editor) viewLines(title string, lines []string, styleFor func(string) tcell.Style) {
+	top := 0
+	titleStyle := e.theme.prompt
+
+	redraw := func() {
+		e.screen.Clear()
+		rows := e.height - 2
+		if top > len(lines)-rows {
+			top = len(lines) - rows
+		}
+		if top < 0 {
+			top = 0
+		}
+
+		e.fillRow(0, titleStyle)
+		e.drawText(0, 0, fmt.Sprintf(" %s (%d lines)", title, len(lines)), titleStyle)
+		for row := 0; row < rows && top+row < len(lines); row++ {
+			e.drawText(0, row+1, lines[top+row], styleFor(lines[top+row]))
+		}
+
+		e.fillRow(e.height-1, titleStyle)
+		e.drawText(0, e.height-1, " Up/Down/PgUp/PgDn: scroll | Esc: close", titleStyle)
+		e.screen.HideCursor()
+		e.screen.Show()
+	}
+
+	e.modal(redraw, func(ev *tcell.EventKey) bool {
+		switch ev.Key() {
+		case tcell.KeyEscape, tcell.KeyEnter:
+			return true
+		case tcell.KeyUp:
+			top--
+		case tcell.KeyDown:
+			top++
+		case tcell.KeyPgUp:
+			top -= e.height - 2
+		case tcell.KeyPgDn:
+			top += e.height - 2
+		case tcell.KeyHome:
+			top = 0
+		case tcell.KeyEnd:
+			top = len(lines)
+		case tcell.KeyRune:
+			return ev.Rune() == 'q'
+		}
+		return false
+	})
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default address for --serve; the port grip and similar previewers use
+const defaultPreviewAddr = "127.0.0.1:6419"
+
+// previewServer serves the buffer rendered as HTML and pushes re-rendered content
+// to open browser tabs with server-sent events
+type previewServer struct {
+	url     string
+	title   string
+	path    string // Absolute path of the buffer previewed, "" for an untitled one
+	server  *http.Server
+	updates chan []string
+	sent    []string // The lines last queued, only used on the event loop
+
+	mu      sync.Mutex
+	body    string
+	clients map[chan string]bool
+}
+
+const previewPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { max-width: 46em; margin: 2em auto; padding: 0 1em; font: 16px/1.6 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; }
+pre { background: #f6f8fa; padding: 1em; overflow-x: auto; }
+code { background: #f6f8fa; padding: 0.1em 0.3em; }
+pre code { padding: 0; }
+blockquote { margin: 0; padding-left: 1em; border-left: 4px solid #ddd; color: #555; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 0.3em 0.7em; }
+img { max-width: 100%%; }
+</style>
+</head>
+<body>
+<div id="content">%s</div>
+<script>
+new EventSource("/events").onmessage = function (e) {
+  document.getElementById("content").innerHTML = e.data;
+};
+</script>
+</body>
+</html>
+`
+
+// previewHost reports whether host, the Host header of a request, names the
+// preview server listening on addr. A web page can point a name of its own at
+// the loopback address, so only "localhost" and IP addresses are accepted, on
+// the server's port.
+func previewHost(host string, addr *net.TCPAddr) bool {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = host, "80"
+	}
+	if port != strconv.Itoa(addr.Port) {
+		return false
+	}
+	if name == "localhost" {
+		return addr.IP.IsLoopback() || addr.IP.IsUnspecified()
+	}
+	ip := net.ParseIP(strings.Trim(name, "[]"))
+	return ip != nil && (ip.Equal(addr.IP) || addr.IP.IsUnspecified())
+}
+
+// hiddenPath reports whether a URL path leads into or to a dotfile, such as
+// .git/config or .env
+func hiddenPath(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// startPreviewServer listens on addr and serves the preview page, the event stream,
+// and files from docDir so relative images resolve. Requests for another host
+// name and for hidden files are refused.
+func startPreviewServer(addr, docDir, title string) (*previewServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &previewServer{
+		url:     "http://" + listener.Addr().String() + "/",
+		title:   title,
+		updates: make(chan []string, 1),
+		clients: map[chan string]bool{},
+	}
+
+	mux := http.NewServeMux()
+	files := http.FileServer(http.Dir(docDir))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			if hiddenPath(r.URL.Path) {
+				http.NotFound(w, r)
+				return
+			}
+			files.ServeHTTP(w, r)
+			return
+		}
+		p.mu.Lock()
+		body := p.body
+		p.mu.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, previewPage, html.EscapeString(p.title), body)
+	})
+	mux.HandleFunc("/events", p.serveEvents)
+
+	tcpAddr := listener.Addr().(*net.TCPAddr)
+	p.server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !previewHost(r.Host, tcpAddr) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})}
+	go p.server.Serve(listener)
+	go p.renderLoop()
+	return p, nil
+}
+
+// update queues the buffer for rendering without blocking the editor. Only the
+// newest queued version is kept.
+func (p *previewServer) update(lines []string) {
+	snapshot := make([]string, len(lines))
+	copy(snapshot, lines)
+	p.sent = snapshot
+	for {
+		select {
+		case p.updates <- snapshot:
+			return
+		default:
+			select {
+			case <-p.updates:
+			default:
+			}
+		}
+	}
+}
+
+// renderLoop renders queued buffers, waiting briefly for typing to settle, and
+// broadcasts changed output to every connected browser
+func (p *previewServer) renderLoop() {
+	for lines := range p.updates {
+		settle := time.After(50 * time.Millisecond)
+	collect:
+		for {
+			select {
+			case newer, ok := <-p.updates:
+				if !ok {
+					return
+				}
+				lines = newer
+			case <-settle:
+				break collect
+			}
+		}
+
+		body := markdownToHTML(strings.Join(lines, "\n"))
+		p.mu.Lock()
+		if body != p.body {
+			p.body = body
+			for client := range p.clients {
+				select {
+				case client <- body:
+				default: // A slow client catches up with the next change
+				}
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// serveEvents streams rendered HTML to one browser tab as server-sent events
+func (p *previewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	client := make(chan string, 1)
+	p.mu.Lock()
+	p.clients[client] = true
+	body := p.body
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, client)
+		p.mu.Unlock()
+	}()
+
+	for {
+		// Every line of a multi-line message needs its own data: prefix
+		fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(body, "\n", "\ndata: "))
+		flusher.Flush()
+		select {
+		case body = <-client:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// close stops the server and the render loop
+func (p *previewServer) close() {
+	p.server.Close()
+	close(p.updates)
+}
+
+// startPreview starts the live preview server for this buffer and shows its URL.
+// The preview stays with this buffer when others are switched to.
+func (e *Editor) startPreview(addr string) error {
+	title, path := "mkmd preview", ""
+	if e.filename != "" {
+		title = filepath.Base(e.filename)
+		var err error
+		if path, err = filepath.Abs(e.filename); err != nil {
+			return err
+		}
+	}
+	preview, err := startPreviewServer(addr, e.documentDir(), title)
+	if err != nil {
+		return err
+	}
+	preview.path = path
+	e.preview = preview
+	e.updatePreview()
+	e.statusMessage = "Live preview at " + preview.url
+	return nil
+}
+
+// previewedLines returns the lines of the buffer the preview was started for,
+// or nil when it is no longer open. A preview started for an untitled buffer
+// shows the active buffer while it has no name.
+func (e *Editor) previewedLines() []string {
+	if e.preview.path == "" {
+		if e.filename != "" {
+			return nil
+		}
+		return e.lines
+	}
+	index := e.bufferIndex(e.preview.path)
+	switch {
+	case index < 0:
+		return nil
+	case index == e.activeBuffer:
+		return e.lines
+	default:
+		return e.buffers[index].lines
+	}
+}
+
+// updatePreview queues the previewed buffer for rendering when it differs from
+// what was last queued. The event loop calls it after every event, so typing,
+// pastes, mouse edits, undo and lines arriving in a followed file all show.
+func (e *Editor) updatePreview() {
+	if e.preview == nil {
+		return
+	}
+	if lines := e.previewedLines(); lines != nil && !slices.Equal(lines, e.preview.sent) {
+		e.preview.update(lines)
+	}
+}
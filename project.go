@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxProjectFiles caps how many files a project-wide command reads
+const maxProjectFiles = 5000
+
+// markdownExtensions are the file name extensions of the documents project-wide
+// commands look through
+var markdownExtensions = map[string]bool{
+	".md": true, ".markdown": true, ".mdown": true, ".mkd": true, ".mdx": true,
+}
+
+// maxProjectFileBytes caps the size of a file project-wide commands read from
+// disk; larger files are left out
+const maxProjectFileBytes = 16 * 1024 * 1024
+
+// errNotText is returned for files that look binary or are too large to search
+var errNotText = errors.New("not a text file")
+
+// projectFiles returns the markdown files under root, sorted by path, leaving
+// out hidden files and folders (such as .git)
+func projectFiles(root string) ([]string, error) {
+	return walkProject(root, func(path string) bool {
+		return markdownExtensions[strings.ToLower(filepath.Ext(path))]
+	})
+}
+
+// searchFiles returns the files under root that project-wide search looks
+// through. In a git repository they are the tracked and untracked files git
+// does not ignore, so .gitignore is respected; elsewhere they are all files
+// that are not hidden.
+func searchFiles(root string) ([]string, error) {
+	if _, err := exec.LookPath("git"); err == nil {
+		if out, err := runGit(root, "ls-files", "-z", "--cached", "--others", "--exclude-standard"); err == nil {
+			var files []string
+			for _, name := range strings.Split(out, "\x00") {
+				if name == "" {
+					continue
+				}
+				if len(files) == maxProjectFiles {
+					break
+				}
+				files = append(files, filepath.Join(root, filepath.FromSlash(name)))
+			}
+			sort.Strings(files)
+			return files, nil
+		}
+	}
+	return walkProject(root, func(string) bool { return true })
+}
+
+// walkProject returns the files under root that keep accepts, sorted by path,
+// leaving out hidden files and folders
+func walkProject(root string, keep func(path string) bool) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable folder is left out rather than ending the walk
+			if d != nil && d.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && keep(path) {
+			if len(files) == maxProjectFiles {
+				return fs.SkipAll
+			}
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// bufferIndex returns the index of the open buffer holding path, or -1. With a
+// single buffer, the active one is index 0.
+func (e *Editor) bufferIndex(path string) int {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return -1
+	}
+	same := func(filename string) bool {
+		other, err := filepath.Abs(filename)
+		return filename != "" && err == nil && other == abs
+	}
+	if len(e.buffers) == 0 {
+		if same(e.filename) {
+			return 0
+		}
+		return -1
+	}
+	for i, b := range e.buffers {
+		filename := b.filename
+		if i == e.activeBuffer {
+			filename = e.filename
+		}
+		if same(filename) {
+			return i
+		}
+	}
+	return -1
+}
+
+// projectLines returns the lines of path: from its buffer when it is open, so
+// unsaved changes count, and from disk otherwise. Binary and very large files
+// give errNotText.
+func (e *Editor) projectLines(path string) ([]string, error) {
+	switch i := e.bufferIndex(path); {
+	case i < 0:
+	case len(e.buffers) == 0 || i == e.activeBuffer:
+		return e.lines, nil
+	default:
+		return e.buffers[i].lines, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxProjectFileBytes {
+		return nil, errNotText
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// A NUL byte near the start marks a binary file, as it does for git and grep
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return nil, errNotText
+	}
+	var lines []string
+	scanner := newLineScanner(bytes.NewReader(data), maxLineBytes, nil)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// projectHit is one place a project-wide command found: the file ("" for an
+// unnamed buffer), the rune position and line number, and the line's text
+type projectHit struct {
+	path string
+	x, y int
+	line string
+}
+
+// hitLabel is how hit is listed: "file:line  text"
+func hitLabel(hit projectHit) string {
+	return fmt.Sprintf("%s:%d  %s", hitName(hit), hit.y+1, strings.TrimSpace(hit.line))
+}
+
+// hitName is the name of hit's file as lists show it
+func hitName(hit projectHit) string {
+	if hit.path == "" {
+		return bufferName("")
+	}
+	return projectPath(hit.path)
+}
+
+// pickHit lists hits with the lines around the highlighted one in a preview
+// pane, then opens the chosen one with the cursor on it. It reports whether one
+// was chosen.
+func (e *Editor) pickHit(title string, hits []projectHit) bool {
+	items := make([]string, len(hits))
+	for i, hit := range hits {
+		items[i] = hitLabel(hit)
+	}
+	cache := make(map[string][]string)
+	preview := func(i int) []string {
+		hit := hits[i]
+		lines, ok := cache[hit.path]
+		if !ok {
+			if hit.path == "" {
+				lines = e.lines
+			} else {
+				lines, _ = e.projectLines(hit.path)
+			}
+			cache[hit.path] = lines
+		}
+		var out []string
+		for y := max(0, hit.y-3); y < len(lines) && y < hit.y+e.height/2; y++ {
+			mark := " "
+			if y == hit.y {
+				mark = ">"
+			}
+			out = append(out, fmt.Sprintf("%s%5d  %s", mark, y+1, lines[y]))
+		}
+		return out
+	}
+
+	choice := e.pickFromList(title, items, preview)
+	if choice < 0 {
+		return false
+	}
+	hit := hits[choice]
+	if hit.path == "" {
+		e.clearSelection()
+		e.cursorY, e.cursorX = hit.y, hit.x
+		e.ensureCursorVisible()
+		return true
+	}
+	if err := e.gotoFile(hit.path, hit.x, hit.y); err != nil {
+		e.reportError("Opening "+projectPath(hit.path), err)
+		return false
+	}
+	return true
+}
+
+// gotoFile makes path the active buffer, opening it when it is not open yet, and
+// puts the cursor at (x, y)
+func (e *Editor) gotoFile(path string, x, y int) error {
+	if i := e.bufferIndex(path); i >= 0 {
+		e.switchBuffer(i)
+	} else {
+		if err := e.openBuffer(path); err != nil {
+			return err
+		}
+		e.claimFile()
+	}
+	e.clearSelection()
+	e.cursorY = max(0, min(y, len(e.lines)-1))
+	e.cursorX = max(0, min(x, runeLen(e.lines[e.cursorY])))
+	e.ensureCursorVisible()
+	return nil
+}
+
+// projectPath returns path relative to the working directory for lists and
+// messages, or as it is when it lies elsewhere
+func projectPath(path string) string {
+	if rel, err := filepath.Rel(".", path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// rewriteFile changes the lines of path with edit and reports whether the file
+// was open. An open file is changed in its buffer as one undo step and left
+// unsaved; a read-only buffer is refused. Otherwise the file is rewritten on
+// disk through writeLines, split on newlines alone so carriage returns and the
+// final newline stay as they were. An error from edit leaves the file alone.
+func (e *Editor) rewriteFile(path string, edit func(lines []string) ([]string, error)) (bool, error) {
+	index := e.bufferIndex(path)
+	if path == "" || index >= 0 {
+		start := e.activeBuffer
+		if index >= 0 {
+			e.switchBuffer(index)
+		}
+		defer e.switchBuffer(start)
+		if e.readOnly {
+			return true, errors.New("read-only")
+		}
+		lines, err := edit(e.lines)
+		if err != nil {
+			return true, err
+		}
+		e.pushUndoState()
+		e.clearSearch()
+		e.invalidateWordCount()
+		e.lines = lines
+		e.modified = true
+		e.adjustCursorPosition()
+		return true, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	lines, err := edit(strings.Split(string(data), "\n"))
+	if err != nil {
+		return false, err
+	}
+	return false, writeLines(path, lines)
+}
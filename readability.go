@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// Inline markup reduced to the text a reader sees: links and images to their
+	// text, code spans to a single word, footnote references and HTML tags to
+	// nothing
+	inlineLinkText   = regexp.MustCompile(`!?\[([^\]]*)\](?:\([^)]*\)|\[[^\]]*\])`)
+	footnoteRefText  = regexp.MustCompile(`\[\^[^\]]*\]`)
+	codeSpanText     = regexp.MustCompile("`+[^`]+`+")
+	htmlTagText      = regexp.MustCompile(`<[^>]*>`)
+	proseAbbreviated = map[string]bool{
+		"mr": true, "mrs": true, "ms": true, "dr": true, "st": true, "vs": true, "etc": true,
+		"e.g": true, "i.e": true, "cf": true, "fig": true, "vol": true, "p": true, "pp": true,
+	}
+	// Forms of "to be" that start a passive construction, and irregular past
+	// participles that do not end in -ed
+	beVerbs             = map[string]bool{"am": true, "is": true, "are": true, "was": true, "were": true, "be": true, "been": true, "being": true}
+	irregularParticiple = map[string]bool{
+		"begun": true, "bitten": true, "blown": true, "born": true, "bought": true, "brought": true, "built": true,
+		"caught": true, "chosen": true, "done": true, "drawn": true, "driven": true, "eaten": true, "fallen": true,
+		"felt": true, "forgotten": true, "forgiven": true, "found": true, "given": true, "gone": true, "grown": true,
+		"heard": true, "held": true, "hidden": true, "hit": true, "hung": true, "kept": true, "known": true,
+		"laid": true, "led": true, "left": true, "lost": true, "made": true, "meant": true, "met": true, "paid": true,
+		"put": true, "read": true, "ridden": true, "run": true, "said": true, "seen": true, "sent": true, "set": true,
+		"shaken": true, "shown": true, "shut": true, "sold": true, "spent": true, "spoken": true, "stolen": true,
+		"struck": true, "sung": true, "taken": true, "taught": true, "thought": true, "thrown": true, "told": true,
+		"understood": true, "won": true, "worn": true, "woven": true, "written": true,
+	}
+)
+
+// readability holds the counts the readability statistics are worked out from
+type readability struct {
+	sentences int
+	words     int
+	syllables int
+	passive   int // Sentences that look passive
+	longest   int // Words in the longest sentence
+}
+
+// proseParagraphs returns the prose of lines as paragraphs of plain text, each a
+// list of words. Front matter, code, display math, headings, tables and rules
+// are left out, and each list item is a paragraph of its own.
+func proseParagraphs(lines []string) [][]string {
+	literal := reflowLiteral(lines)
+	var paragraphs [][]string
+	var current []string
+	flush := func() {
+		if len(current) > 0 {
+			paragraphs = append(paragraphs, current)
+			current = nil
+		}
+	}
+	for y, line := range lines {
+		if literal[y] || !reflowable(line) {
+			flush()
+			continue
+		}
+		line = line[len(quotePrefixPattern.FindString(line)):]
+		if marker := listMarkerPattern.FindString(line); marker != "" {
+			flush()
+			line = line[len(marker):]
+		}
+		line = inlineLinkText.ReplaceAllString(line, "$1")
+		line = footnoteRefText.ReplaceAllString(line, "")
+		line = codeSpanText.ReplaceAllString(line, "code")
+		line = htmlTagText.ReplaceAllString(line, "")
+		current = append(current, strings.Fields(line)...)
+	}
+	flush()
+	return paragraphs
+}
+
+// proseWord returns token without the punctuation and emphasis around it, or ""
+// when it holds no letter or digit
+func proseWord(token string) string {
+	word := strings.TrimFunc(token, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if strings.Contains(token, "://") {
+		return ""
+	}
+	return word
+}
+
+// endsSentence reports whether token closes a sentence: it ends in ., ! or ?,
+// perhaps inside quotes, brackets or emphasis, and is not an abbreviation or
+// an initial
+func endsSentence(token string) bool {
+	trimmed := strings.TrimRight(token, `"'”’)]*_`)
+	if !strings.HasSuffix(trimmed, ".") && !strings.HasSuffix(trimmed, "!") && !strings.HasSuffix(trimmed, "?") {
+		return false
+	}
+	if strings.HasSuffix(trimmed, "...") {
+		return false
+	}
+	if body, ok := strings.CutSuffix(trimmed, "."); ok {
+		body = strings.TrimLeft(body, `"'“‘([*_`)
+		runes := []rune(body)
+		if proseAbbreviated[strings.ToLower(body)] || len(runes) == 1 && unicode.IsUpper(runes[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// countSyllables estimates the syllables of an English word: its groups of
+// vowels, less a silent final e, and at least one
+func countSyllables(word string) int {
+	word = strings.ToLower(word)
+	count, vowel := 0, false
+	for _, r := range word {
+		isVowel := strings.ContainsRune("aeiouy", r)
+		if isVowel && !vowel {
+			count++
+		}
+		vowel = isVowel
+	}
+	if strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") && !strings.HasSuffix(word, "ee") && count > 1 {
+		count--
+	}
+	return max(1, count)
+}
+
+// passiveSentence reports whether the words of a sentence, lower-cased, hold a
+// form of "to be" followed by a past participle, perhaps with an adverb between
+func passiveSentence(words []string) bool {
+	for i, word := range words {
+		if !beVerbs[word] {
+			continue
+		}
+		for j := i + 1; j < len(words) && j <= i+2; j++ {
+			next := words[j]
+			if irregularParticiple[next] || len(next) > 3 && strings.HasSuffix(next, "ed") {
+				return true
+			}
+			if !strings.HasSuffix(next, "ly") && next != "not" {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// measureReadability counts the sentences, words and syllables of the prose in
+// lines, and the sentences that look passive. The end of a paragraph or list
+// item ends a sentence too.
+func measureReadability(lines []string) readability {
+	var r readability
+	for _, paragraph := range proseParagraphs(lines) {
+		var sentence []string
+		end := func() {
+			if len(sentence) == 0 {
+				return
+			}
+			r.sentences++
+			r.longest = max(r.longest, len(sentence))
+			if passiveSentence(sentence) {
+				r.passive++
+			}
+			sentence = nil
+		}
+		for _, token := range paragraph {
+			if word := proseWord(token); word != "" {
+				r.words++
+				r.syllables += countSyllables(word)
+				sentence = append(sentence, strings.ToLower(word))
+			}
+			if endsSentence(token) {
+				end()
+			}
+		}
+		end()
+	}
+	return r
+}
+
+// grade is the Flesch-Kincaid grade level: roughly the years of schooling needed
+// to follow the text
+func (r readability) grade() float64 {
+	return 0.39*float64(r.words)/float64(r.sentences) + 11.8*float64(r.syllables)/float64(r.words) - 15.59
+}
+
+// ease is the Flesch reading ease, from 100 (very easy) down to 0 (very hard)
+func (r readability) ease() float64 {
+	return 206.835 - 1.015*float64(r.words)/float64(r.sentences) - 84.6*float64(r.syllables)/float64(r.words)
+}
+
+// report returns the rows of the statistics popup for r
+func (r readability) report(row func(label, value string) string) []string {
+	if r.sentences == 0 || r.words == 0 {
+		return []string{row("Readability", "no prose to measure")}
+	}
+	return []string{
+		row("Sentences", groupDigits(r.sentences)),
+		row("Words per sentence", fmt.Sprintf("%.1f (longest %s)", float64(r.words)/float64(r.sentences), groupDigits(r.longest))),
+		row("Flesch-Kincaid grade", fmt.Sprintf("%.1f", r.grade())),
+		row("Reading ease", fmt.Sprintf("%.0f of 100", max(0, min(100, r.ease())))),
+		row("Passive voice", fmt.Sprintf("%s sentences (%d%%)", groupDigits(r.passive), r.passive*100/r.sentences)),
+	}
+}
+
+// documentReadability measures the whole buffer when the statistics are shown,
+// reusing the last result until the text changes, so typing never waits for it
+func (e *Editor) documentReadability() readability {
+	if !e.readabilityValid {
+		e.cachedReadability = measureReadability(e.lines)
+		e.readabilityValid = true
+	}
+	return e.cachedReadability
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// When mkmd is told to stop from outside (the terminal window closed, the
+// system shutting down), unsaved work is written to recovery copies rather than
+// lost, and the terminal is put back as it was before exiting. A bug that makes
+// mkmd panic is handled the same way.
+
+// shutdownRequest is the payload of the interrupt posted when mkmd is sent
+// SIGTERM or SIGHUP, so the shutdown happens on the event loop
+type shutdownRequest struct {
+	signal os.Signal
+}
+
+// exitProcess ends mkmd with a status code once it has shut down
+var exitProcess = os.Exit
+
+// shutdownTimeout is how long the event loop has to act on a shutdown request
+// before the signal handler shuts down by itself, in case the loop is busy
+// waiting for a command to finish
+const shutdownTimeout = 2 * time.Second
+
+// recoveryFolder returns the path of name in the recovery folder of the settings,
+// where copies go when they cannot go beside their file
+func recoveryFolder(name string, now time.Time) string {
+	return settingsFile(filepath.Join("recovery", now.Format("20060102-150405")+"-"+name))
+}
+
+// writeRecoveryFiles writes every modified buffer to a recovery copy and returns
+// the paths written. The copy goes beside the file as NAME.recovered, or into
+// the recovery folder for a buffer never saved or a folder that cannot be
+// written. For a file loaded in chunks only the loaded chunk is in memory, so
+// that is what its copy holds.
+func (e *Editor) writeRecoveryFiles() (paths []string, err error) {
+	buffers := []bufferState{e.captureBuffer()}
+	if len(e.buffers) > 1 {
+		buffers = append([]bufferState(nil), e.buffers...)
+		buffers[e.activeBuffer] = e.captureBuffer()
+	}
+
+	now := time.Now()
+	for i, b := range buffers {
+		if !b.modified {
+			continue
+		}
+		data := []byte(strings.Join(b.lines, "\n") + "\n")
+		path := recoveryFolder(fmt.Sprintf("untitled-%d.md", i+1), now)
+		if b.filename != "" {
+			path = b.filename + ".recovered"
+			if os.WriteFile(path, data, 0600) == nil {
+				paths = append(paths, path)
+				continue
+			}
+			path = recoveryFolder(filepath.Base(b.filename), now)
+		}
+		if path == "" {
+			err = fmt.Errorf("no settings folder for the recovery copy of buffer %d", i+1)
+			continue
+		}
+		if mkErr := os.MkdirAll(filepath.Dir(path), 0700); mkErr != nil {
+			err = mkErr
+			continue
+		}
+		if writeErr := os.WriteFile(path, data, 0600); writeErr != nil {
+			err = writeErr
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, err
+}
+
+// watchShutdownSignals asks the event loop to shut down when mkmd is sent
+// SIGTERM or SIGHUP, and shuts down itself if the loop has not done so in time
+func (e *Editor) watchShutdownSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-signals
+		e.screen.PostEvent(tcell.NewEventInterrupt(shutdownRequest{sig}))
+		time.Sleep(shutdownTimeout)
+		e.shutdown(sig)
+	}()
+}
+
+// shutdown exits on sig through exitSaving, with 128 plus the signal number as
+// the status
+func (e *Editor) shutdown(sig os.Signal) {
+	code := 1
+	if s, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(s)
+	}
+	e.exitSaving(fmt.Sprint(sig), code)
+}
+
+// recoverPanic, deferred around the event loop, catches a panic from a bug and
+// shuts down as for a signal, so the terminal is not left in raw mode and the
+// unsaved work is kept. The panic and where it happened are printed once the
+// terminal is back to normal.
+func (e *Editor) recoverPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	e.exitSaving(fmt.Sprintf("panic: %v\n\n%s", r, debug.Stack()), 2)
+}
+
+// exitSaving writes recovery copies of the modified buffers, restores the
+// terminal, prints reason and where the copies went, and exits with code. It
+// runs only once, whichever of the event loop, the signal handler and a panic
+// gets to it first.
+func (e *Editor) exitSaving(reason string, code int) {
+	e.shutdownOnce.Do(func() {
+		paths, err := e.writeRecoveryFiles()
+		e.releaseLocks()
+		e.screen.Fini()
+		fmt.Fprintf(os.Stderr, "mkmd: %s\n", reason)
+		for _, path := range paths {
+			fmt.Fprintf(os.Stderr, "mkmd: unsaved changes written to %s\n", path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mkmd: could not write a recovery copy: %v\n", err)
+		}
+		exitProcess(code)
+	})
+}
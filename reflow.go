@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// Quote markers, then an optional list marker (and task box)
+	reflowPrefixPattern = regexp.MustCompile(`^(?:[ \t]*>[ \t]?)*[ \t]*(?:(?:[-*+]|\d+[.)])[ \t]+(?:\[[ xX]\][ \t]+)?)?`)
+	quotePrefixPattern  = regexp.MustCompile(`^(?:[ \t]*>[ \t]?)+`)
+	listMarkerPattern   = regexp.MustCompile(`^[ \t]*(?:[-*+]|\d+[.)])(?:[ \t]|$)`)
+	// Thematic breaks and setext heading underlines
+	ruleLinePattern = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,}|=+[ \t]*)$`)
+)
+
+// reflowPrefixes returns the prefix of a paragraph's first line and the prefix its
+// continuation lines get: quote markers repeat, a list marker becomes spaces.
+func reflowPrefixes(line string) (first, rest string) {
+	first = reflowPrefixPattern.FindString(line)
+	quote := quotePrefixPattern.FindString(first)
+	rest = first[len(quote):]
+	if strings.TrimSpace(rest) != "" {
+		rest = strings.Repeat(" ", runeLen(rest))
+	}
+	return first, quote + rest
+}
+
+// quoteDepth counts the block quote markers at the start of line
+func quoteDepth(line string) int {
+	return strings.Count(quotePrefixPattern.FindString(line), ">")
+}
+
+// reflowable reports whether line is paragraph text that may be rewrapped, as
+// opposed to a blank line, heading, table row or rule
+func reflowable(line string) bool {
+	content := strings.TrimSpace(line[len(quotePrefixPattern.FindString(line)):])
+	if content == "" || ruleLinePattern.MatchString(content) {
+		return false
+	}
+	return content[0] != '#' && content[0] != '|'
+}
+
+// reflowLiteral marks the lines reflow leaves alone: front matter, fenced code
+// and display math
+func reflowLiteral(lines []string) []bool {
+	literal := codeLiteral(lines)
+	_, block, _ := scanMath(lines, literal)
+	for i := range literal {
+		literal[i] = literal[i] || block[i]
+	}
+	return literal
+}
+
+// codeLiteral marks the lines of front matter and fenced code
+func codeLiteral(lines []string) []bool {
+	literal := make([]bool, len(lines))
+	for i := 0; i <= frontMatterEnd(lines); i++ {
+		literal[i] = true
+	}
+	inFence := false
+	for i := frontMatterEnd(lines) + 1; i < len(lines); i++ {
+		if isCodeFence(lines[i]) {
+			inFence = !inFence
+			literal[i] = true
+			continue
+		}
+		literal[i] = inFence
+	}
+	return literal
+}
+
+// paragraphBounds returns the paragraph holding line y. A list item or a change of
+// quote depth starts a new paragraph.
+func paragraphBounds(lines []string, literal []bool, y int) (start, end int, ok bool) {
+	if literal[y] || !reflowable(lines[y]) {
+		return 0, 0, false
+	}
+	joins := func(i int) bool {
+		return !literal[i] && reflowable(lines[i]) && !listMarkerPattern.MatchString(lines[i][len(quotePrefixPattern.FindString(lines[i])):]) &&
+			quoteDepth(lines[i]) == quoteDepth(lines[i-1])
+	}
+	start, end = y, y
+	for start > 0 && joins(start) && !literal[start-1] && reflowable(lines[start-1]) {
+		start--
+	}
+	for end+1 < len(lines) && joins(end+1) {
+		end++
+	}
+	return start, end, true
+}
+
+// paragraphContent returns line with its paragraph prefix removed: the first-line
+// prefix on the first line, quote markers and indentation on the others
+func paragraphContent(line string, isFirst bool, first string) string {
+	if isFirst {
+		return strings.TrimPrefix(line, first)
+	}
+	return strings.TrimLeft(line[len(quotePrefixPattern.FindString(line)):], " \t")
+}
+
+// reflowLines rewraps one paragraph to width columns. Hard line breaks (two
+// trailing spaces or a backslash) are kept; a word wider than the line gets a
+// line of its own.
+func reflowLines(lines []string, width int) []string {
+	first, rest := reflowPrefixes(lines[0])
+	var out, words []string
+	prefix := first
+	flush := func(hardBreak string) {
+		line, empty := prefix, true
+		for _, word := range words {
+			if !empty && displayWidth(line)+1+displayWidth(word) > width {
+				out = append(out, line)
+				line, empty = rest, true
+			}
+			if !empty {
+				line += " "
+			}
+			line += word
+			empty = false
+		}
+		out = append(out, line+hardBreak)
+		prefix, words = rest, nil
+	}
+
+	for i, line := range lines {
+		content := paragraphContent(line, i == 0, first)
+		hardBreak := ""
+		if strings.HasSuffix(content, "  ") {
+			hardBreak = "  "
+		} else if strings.HasSuffix(content, "\\") {
+			hardBreak = "\\"
+			content = strings.TrimSuffix(content, "\\")
+		}
+		words = append(words, strings.Fields(content)...)
+		if hardBreak != "" && i < len(lines)-1 {
+			flush(hardBreak)
+		}
+	}
+	flush("")
+	return out
+}
+
+// countText counts the non-space runes of s
+func countText(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// textPosition finds the position after the target-th non-space rune of a
+// reflowed paragraph, skipping line prefixes, so the cursor stays on the same
+// character. With onText the position moves on to the next non-space rune, for a
+// cursor that was on a word rather than on the space after one.
+func textPosition(lines []string, target int, onText bool) (x, y int) {
+	first, rest := reflowPrefixes(lines[0])
+	if target == 0 && !onText {
+		return runeLen(first), 0
+	}
+	for y, line := range lines {
+		prefix := rest
+		if y == 0 {
+			prefix = first
+		}
+		runes := []rune(line)
+		for x = runeLen(prefix); x < len(runes); x++ {
+			if unicode.IsSpace(runes[x]) {
+				continue
+			}
+			if target == 0 && onText {
+				return x, y
+			}
+			target--
+			if target == 0 && !onText {
+				return x + 1, y
+			}
+		}
+		if target <= 0 && !onText {
+			return x, y
+		}
+	}
+	return runeLen(lines[len(lines)-1]), len(lines) - 1
+}
+
+// reflow rewraps the paragraph at the cursor, or every paragraph touching the
+// selection, to the configured wrap column
+func (e *Editor) reflow() {
+	literal := reflowLiteral(e.lines)
+	startY, endY := e.selectedLines()
+
+	// Paragraphs are collected top-down and replaced bottom-up so indices stay valid
+	var paragraphs [][2]int
+	for y := startY; y <= endY && y < len(e.lines); y++ {
+		if start, end, ok := paragraphBounds(e.lines, literal, y); ok {
+			paragraphs = append(paragraphs, [2]int{start, end})
+			y = end
+		}
+	}
+	if len(paragraphs) == 0 {
+		e.statusMessage = "No paragraph to reflow"
+		return
+	}
+
+	// Remember the cursor as a count of text characters into its paragraph
+	cursorTarget, onText := -1, false
+	if !e.selectionStart {
+		line := []rune(e.lines[e.cursorY])
+		onText = e.cursorX < len(line) && !unicode.IsSpace(line[e.cursorX])
+		start := paragraphs[0][0]
+		first, _ := reflowPrefixes(e.lines[start])
+		cursorTarget = 0
+		for y := start; y <= e.cursorY; y++ {
+			content := paragraphContent(e.lines[y], y == start, first)
+			if y == e.cursorY {
+				skip := runeLen(e.lines[y]) - runeLen(content)
+				content = runeSubstring(content, 0, e.cursorX-skip)
+			}
+			cursorTarget += countText(content)
+		}
+	}
+
+	linesCopy := make([]string, len(e.lines))
+	copy(linesCopy, e.lines)
+	var reflowed []string
+	for i := len(paragraphs) - 1; i >= 0; i-- {
+		start, end := paragraphs[i][0], paragraphs[i][1]
+		reflowed = reflowLines(linesCopy[start:end+1], e.config.wrapColumn)
+		linesCopy = append(linesCopy[:start], append(reflowed, linesCopy[end+1:]...)...)
+	}
+	if linesEqual(linesCopy, e.lines) {
+		e.statusMessage = "Already wrapped"
+		return
+	}
+
+	e.pushUndoState()
+	e.clearSearch()
+	e.invalidateWordCount()
+	e.lines = linesCopy
+	e.modified = true
+	if cursorTarget >= 0 {
+		// reflowed holds the only paragraph here
+		x, y := textPosition(reflowed, cursorTarget, onText)
+		e.cursorX, e.cursorY = x, paragraphs[0][0]+y
+	} else {
+		e.clearSelection()
+		e.cursorY = startY
+		e.cursorX = 0
+	}
+	e.adjustCursorPosition()
+	e.ensureCursorVisible()
+	if len(paragraphs) > 1 {
+		e.statusMessage = fmt.Sprintf("Reflowed %d paragraphs to %d columns", len(paragraphs), e.config.wrapColumn)
+	}
+}
+
+// wrapAsYouType breaks the cursor line after the last word that fits the wrap
+// column when typing at its end pushes it past, continuing with the line's prefix
+func (e *Editor) wrapAsYouType() {
+	if e.cursorY >= len(e.lines) {
+		return
+	}
+	line := e.lines[e.cursorY]
+	if e.cursorX != runeLen(line) || displayWidth(line) <= e.config.wrapColumn ||
+		!reflowable(line) || isCodeFence(line) || e.inFencedBlock(e.cursorY) {
+		return
+	}
+
+	first, rest := reflowPrefixes(line)
+	runes := []rune(line)
+	breakAt, width := -1, 0
+	for i, r := range runes {
+		if width > e.config.wrapColumn {
+			break
+		}
+		if r == ' ' && i > runeLen(first) {
+			breakAt = i
+		}
+		width += displayWidthRune(r)
+	}
+	if breakAt < 0 {
+		return
+	}
+
+	e.pushUndoState()
+	head := strings.TrimRight(string(runes[:breakAt]), " ")
+	tail := rest + strings.TrimLeft(string(runes[breakAt:]), " ")
+	e.lines[e.cursorY] = head
+	e.lines = append(e.lines[:e.cursorY+1], append([]string{tail}, e.lines[e.cursorY+1:]...)...)
+	e.cursorY++
+	e.cursorX = runeLen(tail)
+	e.ensureCursorVisible()
+}
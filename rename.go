@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// inlineLinkPattern matches the destination of an inline link or image,
+	// "](dest", in angle brackets or not
+	inlineLinkPattern = regexp.MustCompile(`\]\((<[^>]*>|[^)\s]*)`)
+	// referenceLinkPattern matches a link reference definition, "[id]: dest"
+	referenceLinkPattern = regexp.MustCompile(`^ {0,3}\[[^\]]+\]:[ \t]*(<[^>]*>|\S+)`)
+	// wikiLinkPattern matches the target of a wiki link, "[[target#heading|text]]"
+	wikiLinkPattern = regexp.MustCompile(`\[\[([^\]|#]+)`)
+)
+
+// relinker rewrites the links in one document that point at a renamed file
+type relinker struct {
+	docDir   string // Folder of the document holding the links
+	from, to string // Absolute paths of the file before and after the rename
+}
+
+// line returns line with its links to r.from pointing at r.to instead, and how
+// many it changed
+func (r relinker) line(line string) (string, int) {
+	changed := 0
+	replace := func(pattern *regexp.Regexp, rewrite func(string) (string, bool)) {
+		matches := pattern.FindAllStringSubmatchIndex(line, -1)
+		for i := len(matches) - 1; i >= 0; i-- {
+			start, end := matches[i][2], matches[i][3]
+			if dest, ok := rewrite(line[start:end]); ok {
+				line = line[:start] + dest + line[end:]
+				changed++
+			}
+		}
+	}
+	replace(inlineLinkPattern, r.destination)
+	replace(referenceLinkPattern, r.destination)
+	replace(wikiLinkPattern, r.wikiTarget)
+	return line, changed
+}
+
+// destination returns the new form of a link destination that points at the
+// renamed file, keeping any #fragment or ?query, and whether it does
+func (r relinker) destination(dest string) (string, bool) {
+	path := strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	if path == "" || isURL(path) || strings.HasPrefix(path, "/") {
+		return "", false
+	}
+	suffix := ""
+	if i := strings.IndexAny(path, "#?"); i >= 0 {
+		path, suffix = path[:i], path[i:]
+	}
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+	target, err := filepath.Abs(resolvePath(r.docDir, filepath.FromSlash(path)))
+	if err != nil || target != r.from {
+		return "", false
+	}
+	rel, err := filepath.Rel(r.docDir, r.to)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel) + suffix
+	if strings.ContainsAny(rel, " ()<>") {
+		return "<" + rel + ">", true
+	}
+	return rel, true
+}
+
+// wikiTarget returns the new target of a wiki link naming the renamed file,
+// with or without its extension and ignoring case, and whether it does
+func (r relinker) wikiTarget(target string) (string, bool) {
+	name := strings.TrimSpace(target)
+	oldBase, newBase := filepath.Base(r.from), filepath.Base(r.to)
+	switch {
+	case strings.EqualFold(name, oldBase):
+		return newBase, true
+	case strings.EqualFold(name, strings.TrimSuffix(oldBase, filepath.Ext(oldBase))):
+		return strings.TrimSuffix(newBase, filepath.Ext(newBase)), true
+	}
+	return "", false
+}
+
+// renameFile renames the current file on disk, then offers to update the
+// markdown and wiki links to it in the other markdown files: those under the
+// working directory when the file lies there, otherwise those in its folder.
+func (e *Editor) renameFile() {
+	if e.filename == "" {
+		e.statusMessage = "The buffer has no file to rename; save it first"
+		return
+	}
+	if e.readOnly {
+		e.statusMessage = "Read-only: not renamed"
+		return
+	}
+	oldName := e.filename
+	newName := e.promptFilename("Rename to", oldName)
+	if newName == "" || newName == oldName {
+		return
+	}
+	from, err := filepath.Abs(oldName)
+	if err != nil {
+		e.reportError("Renaming", err)
+		return
+	}
+	to, err := filepath.Abs(newName)
+	if err != nil {
+		e.reportError("Renaming", err)
+		return
+	}
+	if _, err := os.Stat(newName); err == nil && !strings.EqualFold(from, to) {
+		e.statusMessage = fmt.Sprintf("%s already exists; not renamed", newName)
+		return
+	}
+
+	if err := moveFile(from, to); err != nil {
+		e.reportError("Renaming "+filepath.Base(oldName), err)
+		return
+	}
+	e.releaseLock(oldName)
+	e.filename = newName
+	e.claimFile()
+	renamed := fmt.Sprintf("Renamed %s to %s", filepath.Base(oldName), filepath.Base(newName))
+
+	root := "."
+	if rel, err := filepath.Rel(".", filepath.Dir(from)); err != nil || strings.HasPrefix(rel, "..") {
+		root = filepath.Dir(from)
+	}
+	files, err := projectFiles(root)
+	if err != nil {
+		e.statusMessage = renamed + "; links not checked: " + explainError(err)
+		return
+	}
+	counts, total := e.countLinks(files, from, to)
+	if total == 0 {
+		e.statusMessage = renamed
+		return
+	}
+	question := fmt.Sprintf("%d links to %s in %d files.", total, filepath.Base(oldName), len(counts))
+	if e.confirm(question, "Update links", "Leave them") != 0 {
+		e.statusMessage = renamed + "; links left as they were"
+		return
+	}
+	e.statusMessage = renamed + "; " + e.updateLinks(files, counts, from, to)
+}
+
+// moveFile renames from to to, creating the folders to hold it. A file that
+// was never saved has nothing to move.
+func moveFile(from, to string) error {
+	if _, err := os.Stat(from); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// countLinks returns how many links to the renamed file each of files holds,
+// leaving out those with none, and the total. Fenced code is skipped.
+func (e *Editor) countLinks(files []string, from, to string) (map[string]int, int) {
+	counts := make(map[string]int)
+	total := 0
+	for _, path := range files {
+		lines, err := e.projectLines(path)
+		if err != nil {
+			continue
+		}
+		r := relinker{docDir: documentFolder(path), from: from, to: to}
+		literal := codeLiteral(lines)
+		for y, line := range lines {
+			if !literal[y] {
+				_, n := r.line(line)
+				counts[path] += n
+				total += n
+			}
+		}
+		if counts[path] == 0 {
+			delete(counts, path)
+		}
+	}
+	return counts, total
+}
+
+// updateLinks rewrites the links to the renamed file in the files counts names
+// and describes what it did. Open buffers are changed and left unsaved.
+func (e *Editor) updateLinks(files []string, counts map[string]int, from, to string) string {
+	var updated, unsaved, failed []string
+	links := 0
+	for _, path := range files {
+		if counts[path] == 0 {
+			continue
+		}
+		r := relinker{docDir: documentFolder(path), from: from, to: to}
+		n := 0
+		inBuffer, err := e.rewriteFile(path, func(lines []string) ([]string, error) {
+			out := make([]string, len(lines))
+			literal := codeLiteral(lines)
+			for y, line := range lines {
+				out[y] = line
+				if !literal[y] {
+					var changed int
+					out[y], changed = r.line(line)
+					n += changed
+				}
+			}
+			return out, nil
+		})
+		if err != nil {
+			failed = append(failed, projectPath(path)+": "+explainError(err))
+			continue
+		}
+		links += n
+		updated = append(updated, projectPath(path))
+		if inBuffer {
+			unsaved = append(unsaved, projectPath(path))
+		}
+	}
+
+	msg := fmt.Sprintf("updated %d links in %s", links, strings.Join(updated, ", "))
+	if len(updated) == 0 {
+		msg = "no links updated"
+	}
+	if len(unsaved) > 0 {
+		msg += " (unsaved: " + strings.Join(unsaved, ", ") + ")"
+	}
+	if len(failed) > 0 {
+		msg += "; not changed: " + strings.Join(failed, ", ")
+	}
+	return msg
+}
+
+// documentFolder returns the absolute folder of path, against which its
+// relative links resolve
+func documentFolder(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return filepath.Dir(path)
+	}
+	return dir
+}
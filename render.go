@@ -2,8 +2,12 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/gdamore/tcell/v2"
 )
@@ -20,7 +24,7 @@ func (e *Editor) advanceToDisplayOffset(runes []rune, y, startX, offsetCols int)
 	colOffset := offsetCols
 
 	for startRuneIdx < len(runes) && colOffset > 0 {
-		w := displayWidthRune(runes[startRuneIdx])
+		w := e.runeCells(runes, startRuneIdx, offsetCols-colOffset)
 		if colOffset >= w {
 			colOffset -= w
 			startRuneIdx++
@@ -28,7 +32,7 @@ func (e *Editor) advanceToDisplayOffset(runes []rune, y, startX, offsetCols int)
 		}
 		blanks := w - colOffset
 		for i := 0; i < blanks && displayX < e.width; i++ {
-			e.screen.SetContent(displayX, y, ' ', nil, tcell.StyleDefault)
+			e.screen.SetContent(displayX, y, ' ', nil, e.theme.text)
 			displayX++
 		}
 		colOffset = 0
@@ -41,9 +45,7 @@ func (e *Editor) advanceToDisplayOffset(runes []rune, y, startX, offsetCols int)
 // drawPlainRun draws runes starting at runeIdx until the row fills.
 func (e *Editor) drawPlainRun(runes []rune, runeIdx, y, displayX int) {
 	for runeIdx < len(runes) && displayX < e.width {
-		ch := runes[runeIdx]
-		e.screen.SetContent(displayX, y, ch, nil, tcell.StyleDefault)
-		displayX += displayWidthRune(ch)
+		displayX += e.drawRune(displayX, y, displayX+e.offsetX, runes, runeIdx, e.theme.text)
 		runeIdx++
 	}
 }
@@ -61,20 +63,16 @@ func (e *Editor) drawWithSearchHighlight(line string, runes []rune, runeIdx, y,
 			matchEnd := runeIndexToByteIndex(line, runeIdx+searchLen)
 			if matchStart < len(lowerLine) && matchEnd <= len(lowerLine) &&
 				strings.HasPrefix(lowerLine[matchStart:], lowerSearch) {
-				style := tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack)
+				style := e.theme.search
 				for i := 0; i < searchLen && runeIdx+i < len(runes) && displayX < e.width; i++ {
-					ch := runes[runeIdx+i]
-					e.screen.SetContent(displayX, y, ch, nil, style)
-					displayX += displayWidthRune(ch)
+					displayX += e.drawRune(displayX, y, displayX+e.offsetX, runes, runeIdx+i, style)
 				}
 				runeIdx += searchLen
 				continue
 			}
 		}
 
-		ch := runes[runeIdx]
-		e.screen.SetContent(displayX, y, ch, nil, tcell.StyleDefault)
-		displayX += displayWidthRune(ch)
+		displayX += e.drawRune(displayX, y, displayX+e.offsetX, runes, runeIdx, e.theme.text)
 		runeIdx++
 	}
 }
@@ -82,6 +80,10 @@ func (e *Editor) drawWithSearchHighlight(line string, runes []rune, runeIdx, y,
 func (e *Editor) drawLineWithHighlight(line string, startX, y int) {
 	// Convert to runes for proper Unicode handling
 	runes := []rune(line)
+	if hasRTL(runes) {
+		e.drawBidiLine(line, runes, startX, y)
+		return
+	}
 
 	// Apply horizontal scrolling as display-column based offset (not rune index)
 	runeIdx, displayX := e.advanceToDisplayOffset(runes, y, startX, e.offsetX)
@@ -95,6 +97,24 @@ func (e *Editor) drawLineWithHighlight(line string, startX, y int) {
 	e.drawWithSearchHighlight(line, runes, runeIdx, y, displayX)
 }
 
+// drawBidiLine draws a line holding right-to-left text in display order, each
+// character at its reordered column with search matches highlighted
+func (e *Editor) drawBidiLine(line string, runes []rune, startX, y int) {
+	cols, glyphs := e.bidiLayout(runes, 0, len(runes), startX)
+	matches := e.searchMatchMask(line)
+	for i := range runes {
+		sx := cols[i] - e.offsetX
+		if sx < startX || sx >= e.width {
+			continue
+		}
+		style := e.theme.text
+		if matches != nil && matches[i] {
+			style = e.theme.search
+		}
+		e.drawRune(sx, y, cols[i], glyphs, i, style)
+	}
+}
+
 func (e *Editor) drawSelection() {
 	if !e.selectionStart {
 		return
@@ -109,11 +129,16 @@ func (e *Editor) drawSelection() {
 		startY, endY = endY, startY
 	}
 
-	selectionStyle := tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite)
+	selectionStyle := e.theme.selection
+
+	if e.blockSelection {
+		e.drawBlockSelection(selectionStyle)
+		return
+	}
 
 	if startY == endY {
 		// Single line selection
-		screenY := startY - e.offsetY
+		screenY := e.screenRowOf(startY)
 		if screenY >= 0 && screenY < e.height-1 && startY < len(e.lines) {
 			line := e.lines[startY]
 			runes := []rune(line)
@@ -127,20 +152,17 @@ func (e *Editor) drawSelection() {
 			}
 
 			// Apply selection highlight with proper Unicode positioning
-			displayX := 0
-			for runeIdx := 0; runeIdx < len(runes) && displayX < e.width; runeIdx++ {
-				screenX := displayX - e.offsetX
-				if runeIdx >= startX && runeIdx < endX && screenX >= 0 && screenX < e.width {
-					ch := runes[runeIdx]
-					e.screen.SetContent(screenX, screenY, ch, nil, selectionStyle)
+			cols, glyphs := e.bidiLayout(runes, 0, len(runes), 0)
+			for runeIdx := startX; runeIdx < endX; runeIdx++ {
+				if screenX := cols[runeIdx] - e.offsetX; screenX >= 0 && screenX < e.width {
+					e.drawRune(screenX, screenY, cols[runeIdx], glyphs, runeIdx, selectionStyle)
 				}
-				displayX += displayWidthRune(runes[runeIdx])
 			}
 		}
 	} else {
 		// Multi-line selection
 		for y := startY; y <= endY; y++ {
-			screenY := y - e.offsetY
+			screenY := e.screenRowOf(y)
 			if screenY >= 0 && screenY < e.height-1 && y < len(e.lines) {
 				line := e.lines[y]
 				runes := []rune(line)
@@ -166,52 +188,321 @@ func (e *Editor) drawSelection() {
 				}
 
 				// Apply selection highlight with proper Unicode positioning
-				displayX := 0
-				for runeIdx := 0; runeIdx < len(runes) && displayX < e.width; runeIdx++ {
-					screenX := displayX - e.offsetX
-					if runeIdx >= lineStartX && runeIdx < lineEndX && screenX >= 0 && screenX < e.width {
-						ch := runes[runeIdx]
-						e.screen.SetContent(screenX, screenY, ch, nil, selectionStyle)
+				cols, glyphs := e.bidiLayout(runes, 0, len(runes), 0)
+				for runeIdx := lineStartX; runeIdx < lineEndX; runeIdx++ {
+					if screenX := cols[runeIdx] - e.offsetX; screenX >= 0 && screenX < e.width {
+						e.drawRune(screenX, screenY, cols[runeIdx], glyphs, runeIdx, selectionStyle)
 					}
-					displayX += displayWidthRune(runes[runeIdx])
 				}
 			}
 		}
 	}
 }
 
+// displayColumn returns the display column at which the rune at runeIdx starts
+func (e *Editor) displayColumn(line string, runeIdx int) int {
+	col := 0
+	runes := []rune(line)
+	for i := 0; i < runeIdx && i < len(runes); i++ {
+		col += e.runeCells(runes, i, col)
+	}
+	return col
+}
+
+// controlPlaceholder returns what is drawn in place of a control character: ^X
+// for the ASCII ones and DEL, the replacement character for the rest. It returns
+// "" for printable characters and tabs.
+func controlPlaceholder(r rune) string {
+	switch {
+	case r == '\t' || !unicode.Is(unicode.Cc, r):
+		return ""
+	case r < 0x20 || r == 0x7f:
+		return string([]rune{'^', r ^ 0x40})
+	}
+	return string(unicode.ReplacementChar)
+}
+
+// drawRune draws runes[i] at screen column x, where col is its display column in
+// the line, and returns its width. The rest of a grapheme cluster is drawn in the
+// same cell as its first rune and takes no columns of its own. Tabs are drawn as
+// blanks and control characters as placeholders, in the control style unless
+// the cell is highlighted.
+func (e *Editor) drawRune(x, y, col int, runes []rune, i int, style tcell.Style) int {
+	w := e.runeCells(runes, i, col)
+	if placeholder := controlPlaceholder(runes[i]); placeholder != "" {
+		if style == e.theme.text {
+			style = e.theme.control
+		}
+		for j, r := range placeholder {
+			if x+j < e.width {
+				e.screen.SetContent(x+j, y, r, nil, style)
+			}
+		}
+		return w
+	}
+	if runes[i] != '\t' {
+		if runes[i] == unicode.ReplacementChar && style == e.theme.text {
+			style = e.theme.control
+		}
+		if !continuesGrapheme(runes, i) {
+			var combining []rune
+			if end := graphemeEnd(runes, i); end > i+1 {
+				combining = runes[i+1 : end]
+			}
+			e.screen.SetContent(x, y, runes[i], combining, style)
+		}
+		return w
+	}
+	for i := 0; i < w && x+i < e.width; i++ {
+		e.screen.SetContent(x+i, y, ' ', nil, style)
+	}
+	return w
+}
+
+// drawBracketMatch highlights the bracket at the cursor and its matching partner,
+// only scanning as far as the visible screen.
+func (e *Editor) drawBracketMatch() {
+	x, y, ok := e.bracketAtCursor()
+	if !ok {
+		return
+	}
+	mx, my, found := e.findMatchingBracket(x, y, e.height)
+	if !found {
+		return
+	}
+
+	style := e.theme.bracket
+	for _, pos := range [][2]int{{x, y}, {mx, my}} {
+		screenY := e.screenRowOf(pos[1])
+		col := e.visualColumn(e.lines[pos[1]], pos[0])
+		screenX := col - e.offsetX
+		if screenY >= 0 && screenY < e.height-1 && screenX >= 0 && screenX < e.width {
+			e.drawRune(screenX, screenY, col, []rune(e.lines[pos[1]]), pos[0], style)
+		}
+	}
+}
+
+// drawBlockSelection highlights the same rune columns on every line of a block selection.
+func (e *Editor) drawBlockSelection(style tcell.Style) {
+	startX, endX, startY, endY := e.blockBounds()
+
+	for y := startY; y <= endY; y++ {
+		screenY := e.screenRowOf(y)
+		if screenY < 0 || screenY >= e.height-1 {
+			continue
+		}
+		runes := []rune(e.lines[y])
+
+		cols, glyphs := e.bidiLayout(runes, 0, len(runes), 0)
+		for runeIdx := startX; runeIdx < len(runes) && runeIdx < endX; runeIdx++ {
+			if screenX := cols[runeIdx] - e.offsetX; screenX >= 0 && screenX < e.width {
+				e.drawRune(screenX, screenY, cols[runeIdx], glyphs, runeIdx, style)
+			}
+		}
+	}
+}
+
+// forEachVisibleRune calls fn for every character drawn on screen with its
+// buffer position (x, y), its display column in the line, and its screen cell.
+// Runes that continue a grapheme cluster are skipped.
+func (e *Editor) forEachVisibleRune(fn func(x, y, col, sx, sy int, runes []rune)) {
+	screenRow := 0
+	if e.softWrap {
+		screenRow = -e.wrapTopSkip()
+	}
+	hidden := e.foldedLines()
+	for y := e.offsetY; y < len(e.lines) && screenRow < e.height-1; y++ {
+		if lineHidden(hidden, y) {
+			continue
+		}
+		runes := []rune(e.lines[y])
+		if !e.softWrap {
+			cols, glyphs := e.bidiLayout(runes, 0, len(runes), 0)
+			for x := range runes {
+				if sx := cols[x] - e.offsetX; sx >= 0 && sx < e.width && !continuesGrapheme(runes, x) {
+					fn(x, y, cols[x], sx, screenRow, glyphs)
+				}
+			}
+			screenRow++
+			continue
+		}
+
+		col := 0
+		for _, row := range e.wrapRows(e.lines[y]) {
+			cols, glyphs := e.bidiLayout(runes, row.start, row.end, row.indent)
+			for x := row.start; x < row.end; x++ {
+				if sx := cols[x-row.start]; screenRow >= 0 && sx < e.width && !continuesGrapheme(runes, x) {
+					fn(x, y, col, sx, screenRow, glyphs)
+				}
+				col += e.runeCells(runes, x, col)
+			}
+			screenRow++
+		}
+	}
+}
+
+// invisibleSymbols are drawn in place of whitespace when invisibles are shown
+var invisibleSymbols = map[rune]rune{
+	' ':      '·',
+	'\t':     '→',
+	'\u00a0': '␣', // Non-breaking space
+	'\u202f': '␣', // Narrow non-breaking space
+}
+
+// drawInvisibles redraws whitespace on screen as faint symbols, with trailing
+// whitespace (such as a two-space line break) in the trailing style. Highlighted
+// cells keep their highlight.
+func (e *Editor) drawInvisibles() {
+	trailingFrom, trailingLine := 0, -1
+	e.forEachVisibleRune(func(x, y, col, sx, sy int, runes []rune) {
+		symbol, ok := invisibleSymbols[runes[x]]
+		if !ok {
+			return
+		}
+		if y != trailingLine {
+			trailingFrom = runeLen(strings.TrimRightFunc(e.lines[y], unicode.IsSpace))
+			trailingLine = y
+		}
+		_, _, style, _ := e.screen.GetContent(sx, sy)
+		if style == e.theme.text {
+			style = e.theme.dim
+			if x >= trailingFrom {
+				style = e.theme.trailing
+			}
+		}
+		e.screen.SetContent(sx, sy, symbol, nil, style)
+	})
+}
+
+// drawRuler draws a faint vertical guide at the ruler column through blank cells
+// and, when enabled, highlights text past it
+func (e *Editor) drawRuler() {
+	column := e.config.rulerColumn
+	if e.rulerOverflow {
+		e.forEachVisibleRune(func(x, y, col, sx, sy int, runes []rune) {
+			if _, _, style, _ := e.screen.GetContent(sx, sy); col >= column && style == e.theme.text {
+				e.drawRune(sx, sy, col, runes, x, e.theme.overflow)
+			}
+		})
+	}
+	if !e.showRuler {
+		return
+	}
+	sx := column - e.offsetX
+	if sx < 0 || sx >= e.width {
+		return
+	}
+	for sy := 0; sy < e.height-1; sy++ {
+		if r, _, style, width := e.screen.GetContent(sx, sy); r == ' ' && width == 1 && style == e.theme.text {
+			e.screen.SetContent(sx, sy, '│', nil, e.theme.dim)
+		}
+	}
+}
+
+// drawCursorColumn highlights the cursor's screen column from top to bottom,
+// through text and blank cells alike. Highlighted cells and the second half of
+// wide characters are left alone.
+func (e *Editor) drawCursorColumn() {
+	if !e.cursorColumn || e.cursorY >= len(e.lines) {
+		return
+	}
+	line := e.lines[e.cursorY]
+	sx := e.visualColumn(line, e.cursorX) - e.offsetX
+	if e.softWrap {
+		_, sx = e.wrapLocate(line, e.wrapRows(line), e.cursorX)
+	}
+	if sx < 0 || sx >= e.width {
+		return
+	}
+	for sy := 0; sy < e.height-1; sy++ {
+		if sx > 0 {
+			if _, _, _, width := e.screen.GetContent(sx-1, sy); width == 2 {
+				continue
+			}
+		}
+		if r, combining, style, _ := e.screen.GetContent(sx, sy); style == e.theme.text {
+			e.screen.SetContent(sx, sy, r, combining, e.theme.column)
+		}
+	}
+}
+
 // drawSelectionWrapped is removed - no longer needed for horizontal scrolling
 
 func (e *Editor) draw() {
 	e.screen.Clear()
 
+	if e.focusMode {
+		e.drawFocused(e.drawTextArea)
+	} else {
+		e.drawTextArea()
+	}
+
+	e.drawScrollbar()
+	e.drawMinimap()
+
+	// Draw status bar
+	e.drawStatusBar()
+
+	e.screen.Show()
+}
+
+// drawTextArea draws the visible lines with their highlights and places the cursor
+func (e *Editor) drawTextArea() {
+	if e.softWrap {
+		e.drawWrapped()
+		e.drawCodeHighlights()
+		e.drawMath()
+		e.drawConflicts()
+		if e.spellCheck {
+			e.drawMisspellings()
+		}
+		if e.showInvisibles {
+			e.drawInvisibles()
+		}
+		e.drawRuler()
+		e.drawCursorColumn()
+		return
+	}
+
 	// Draw visible lines with horizontal scrolling
 	screenRow := 0
+	hidden := e.foldedLines()
 	for lineIdx := e.offsetY; lineIdx < len(e.lines) && screenRow < e.height-1; lineIdx++ {
+		if lineHidden(hidden, lineIdx) {
+			continue
+		}
 		line := e.lines[lineIdx]
 		e.drawLineWithHighlight(line, 0, screenRow)
+		e.drawFoldPlaceholder(lineIdx, e.displayColumn(line, runeLen(line))-e.offsetX, screenRow)
 		screenRow++
 	}
 
+	// Highlight the bracket under the cursor and its partner
+	e.drawBracketMatch()
+
 	// Draw selection
 	e.drawSelection()
 
-	// Draw status bar
-	e.drawStatusBar()
+	e.drawCodeHighlights()
+	e.drawMath()
+	e.drawConflicts()
+	if e.spellCheck {
+		e.drawMisspellings()
+	}
+	if e.showInvisibles {
+		e.drawInvisibles()
+	}
+	e.drawRuler()
+	e.drawCursorColumn()
 
 	// Calculate cursor screen position with horizontal scrolling
-	screenCursorY := e.cursorY - e.offsetY
+	screenCursorY := e.screenRowOf(e.cursorY)
 	screenCursorX := 0
 
 	// Calculate display width of text before cursor for proper positioning
 	if e.cursorY < len(e.lines) {
-		line := e.lines[e.cursorY]
-		runes := []rune(line)
-
-		// Calculate cursor position accounting for Unicode display widths
-		for i := 0; i < e.cursorX && i < len(runes); i++ {
-			screenCursorX += displayWidthRune(runes[i])
-		}
+		// Cursor position accounting for Unicode display widths and tabs
+		screenCursorX = e.visualColumn(e.lines[e.cursorY], e.cursorX)
 
 		// Apply horizontal offset
 		screenCursorX -= e.offsetX
@@ -225,35 +516,137 @@ func (e *Editor) draw() {
 		// Hide cursor when it's off-screen
 		e.screen.HideCursor()
 	}
+}
 
-	e.screen.Show()
+// scrollbarSpan returns the first line in view and the number of lines, both
+// counted over the whole file when it is loaded in chunks
+func (e *Editor) scrollbarSpan() (top, total int) {
+	start := e.currentChunk * e.maxLines
+	total = start + len(e.lines)
+	if e.truncated {
+		if e.fileLineCount == 0 {
+			e.fileLineCount = countFileLines(e.filename)
+		}
+		// Unsaved edits change the chunk's length
+		total = max(total, e.fileLineCount-len(e.savedLines)+len(e.lines))
+	}
+	return start + e.offsetY, total
+}
+
+// scrollbarThumb returns the rows covered by the scrollbar thumb in a text area
+// of rows rows, or false when the scrollbar is off, the minimap stands in for it,
+// or everything fits
+func (e *Editor) scrollbarThumb(rows int) (start, end int, ok bool) {
+	top, total := e.scrollbarSpan()
+	if !e.config.scrollbar || e.minimapShown() || total <= rows || rows < 2 {
+		return 0, 0, false
+	}
+	size := max(1, rows*rows/total)
+	start = min(top*(rows-size)/(total-rows), rows-size)
+	return start, start + size, true
+}
+
+// drawScrollbar draws a thin scrollbar in the rightmost column showing which
+// part of the file is in view
+func (e *Editor) drawScrollbar() {
+	rows := e.height - 1
+	start, end, ok := e.scrollbarThumb(rows)
+	if !ok {
+		return
+	}
+	for sy := 0; sy < rows; sy++ {
+		if sy >= start && sy < end {
+			e.screen.SetContent(e.width-1, sy, '┃', nil, e.theme.text)
+		} else {
+			e.screen.SetContent(e.width-1, sy, '│', nil, e.theme.dim)
+		}
+	}
+}
+
+// scrollToScrollbarRow scrolls so the view's position in the file matches screen
+// row sy of the scrollbar: the top row shows the start and the bottom row the end.
+// Positions outside the loaded chunk go to its start or end.
+func (e *Editor) scrollToScrollbarRow(sy int) {
+	rows := e.height - 1
+	_, total := e.scrollbarSpan()
+	sy = max(0, min(sy, rows-1))
+	line := sy * (total - rows) / (rows - 1)
+	e.offsetY = max(0, min(line-e.currentChunk*e.maxLines, len(e.lines)-rows))
+	e.scrollMomentum = 0
+}
+
+// offsetForCursorRow returns the top line that puts the cursor on screen row want,
+// as near as whole wrapped lines allow
+func (e *Editor) offsetForCursorRow(want int) int {
+	if !e.softWrap {
+		return e.linesBack(e.cursorY, want)
+	}
+	line := e.lines[e.cursorY]
+	row, _ := e.wrapLocate(line, e.wrapRows(line), e.cursorX)
+	y := e.cursorY
+	for y > 0 && row+len(e.shownRows(y-1)) <= want {
+		y--
+		row += len(e.shownRows(y))
+	}
+	return y
+}
+
+// recenter scrolls so the cursor line sits in the middle of the window, or at the
+// top or bottom when it is already in the middle or at the top, like Emacs'
+// recenter (vim's zz, zt and zb). The cursor itself does not move.
+func (e *Editor) recenter() {
+	if e.cursorY >= len(e.lines) {
+		return
+	}
+	rows := e.height - 1
+	margin := e.scrollMargin()
+	center := e.offsetForCursorRow((rows - 1) / 2)
+	top := e.offsetForCursorRow(margin)
+	switch e.offsetY {
+	case center:
+		e.offsetY = top
+	case top:
+		e.offsetY = e.offsetForCursorRow(rows - 1 - margin)
+	default:
+		e.offsetY = center
+	}
+	e.scrollMomentum = 0
+}
+
+// scrollMargin is the configured scroll-off, capped so the cursor can still reach
+// the middle of a small window
+func (e *Editor) scrollMargin() int {
+	return max(0, min(e.config.scrollOff, (e.height-2)/2))
 }
 
 // ensureCursorVisible adjusts the viewport to keep the cursor visible
 // Only call this when the cursor actually moves (keyboard, click, text editing)
 // NOT during mouse wheel scrolling (which should be independent)
 func (e *Editor) ensureCursorVisible() {
-	// Vertical scrolling - ensure cursor line is visible
-	if e.cursorY < e.offsetY {
-		e.offsetY = e.cursorY
+	e.unfoldAtCursor()
+	if e.softWrap {
+		e.ensureWrappedCursorVisible()
+		return
+	}
+
+	// Vertical scrolling - keep the cursor line visible, scroll-off lines from the edges
+	// (rows counted over the lines folds leave shown)
+	margin := e.scrollMargin()
+	if e.screenRowOf(e.cursorY) < margin {
+		e.offsetY = e.linesBack(e.cursorY, margin)
 	}
-	if e.cursorY >= e.offsetY+e.height-1 {
-		e.offsetY = e.cursorY - (e.height - 2)
-		if e.offsetY < 0 {
-			e.offsetY = 0
+	if e.screenRowOf(e.cursorY) >= e.height-1-margin {
+		e.offsetY = e.linesBack(e.cursorY, e.height-2-margin)
+		// The bottom margin never scrolls past the end of the document
+		if last := e.linesBack(len(e.lines)-1, e.height-2); e.offsetY > last {
+			e.offsetY = max(last, e.linesBack(e.cursorY, e.height-2))
 		}
 	}
 
 	// Horizontal scrolling - ensure cursor is visible horizontally
 	if e.cursorY < len(e.lines) {
-		line := e.lines[e.cursorY]
-		runes := []rune(line)
-
 		// Calculate cursor display position
-		cursorDisplayX := 0
-		for i := 0; i < e.cursorX && i < len(runes); i++ {
-			cursorDisplayX += displayWidthRune(runes[i])
-		}
+		cursorDisplayX := e.visualColumn(e.lines[e.cursorY], e.cursorX)
 
 		// Adjust horizontal offset to keep cursor visible with a 5-column margin
 		const margin = 5
@@ -275,19 +668,71 @@ func (e *Editor) ensureCursorVisible() {
 	}
 }
 
+// statusTimeout is how long a status message stays up without a key press
+const statusTimeout = 4 * time.Second
+
+// expireStatusMessage clears the status message once it has been shown for
+// statusTimeout, and arranges a redraw for when a new one is due to go
+func (e *Editor) expireStatusMessage() {
+	if e.statusMessage != e.statusShown {
+		e.statusShown = e.statusMessage
+		e.statusSince = time.Now()
+		if e.statusMessage != "" {
+			screen := e.screen
+			time.AfterFunc(statusTimeout, func() {
+				screen.PostEvent(tcell.NewEventInterrupt(nil))
+			})
+		}
+	} else if e.statusMessage != "" && time.Since(e.statusSince) >= statusTimeout {
+		e.statusMessage = ""
+		e.statusShown = ""
+	}
+}
+
 func (e *Editor) drawStatusBar() {
-	statusStyle := tcell.StyleDefault.Background(tcell.ColorGray).Foreground(tcell.ColorWhite)
+	statusStyle := e.theme.status
+	e.expireStatusMessage()
+
+	// Focus mode hides the status bar, leaving the row for messages and prompts
+	if e.focusMode {
+		if e.statusMessage != "" {
+			e.drawText(0, e.height-1, " "+e.statusMessage, e.theme.dim)
+		}
+		if e.timer != nil {
+			// The countdown sits in the corner
+			left := e.timer.timeLeft(time.Now()) + " "
+			e.drawText(e.fullWidth()-displayWidth(left), e.height-1, left, e.theme.dim)
+		}
+		return
+	}
 
 	// Clear the status bar line
-	for x := 0; x < e.width; x++ {
+	for x := 0; x < e.fullWidth(); x++ {
 		e.screen.SetContent(x, e.height-1, ' ', nil, statusStyle)
 	}
 
 	filename := filepath.Base(e.filename)
+	if e.bufferCount() > 1 {
+		filename = fmt.Sprintf("[%d/%d] %s", e.activeBuffer+1, e.bufferCount(), filename)
+	}
 	modified := ""
 	if e.modified {
 		modified = " [Modified]"
 	}
+	if e.following() {
+		modified += " [Following]"
+	} else if e.readOnly {
+		modified += " [Read-only]"
+	}
+	if e.draftMode {
+		modified += " [Drafting]"
+	}
+	if e.overtype {
+		modified += " [OVR]"
+	}
+	if n := e.controlCharCount(); n > 0 {
+		modified += fmt.Sprintf(" [%d control chars]", n)
+	}
 	truncated := ""
 	if e.truncated {
 		if e.currentChunk > 0 {
@@ -298,8 +743,26 @@ func (e *Editor) drawStatusBar() {
 	} else if e.currentChunk > 0 {
 		truncated = " [Chunk view - Ctrl+B for prev]"
 	}
-	wordCount := e.wordCount()
-	status := fmt.Sprintf(" %s%s%s | Ln %d/%d, Col %d | Words: %d", filename, modified, truncated, e.cursorY+1, len(e.lines), e.cursorX+1, wordCount)
+	words := fmt.Sprint(e.wordCount())
+	if e.wordGoal > 0 && !e.sessionGoal {
+		words = e.goalStatus()
+	}
+	if e.wordsAdded+e.wordsRemoved > 0 && (e.wordGoal == 0 || !e.sessionGoal) {
+		// Words written since opening, which a session goal shows already
+		words += " (" + signedWords(e.sessionWords()) + ")"
+	}
+	status := fmt.Sprintf(" %s%s%s | Ln %d/%d, Col %d | Words: %s", filename, modified, truncated, e.cursorY+1, len(e.lines), e.cursorX+1, words)
+	if e.wordGoal > 0 && e.sessionGoal {
+		status += " | Session: " + e.goalStatus()
+	}
+	if e.timer != nil {
+		status += " | Writing: " + e.timer.timeLeft(time.Now()) + " left"
+	}
+
+	// A pending message replaces the status until the next key press
+	if e.statusMessage != "" {
+		status = " " + e.statusMessage
+	}
 
 	e.drawText(0, e.height-1, status, statusStyle)
 }
@@ -309,105 +772,375 @@ func (e *Editor) drawText(x, y int, text string, style tcell.Style) {
 	for _, r := range text {
 		e.screen.SetContent(col, y, r, nil, style)
 		col += displayWidthRune(r)
-		if col >= e.width {
+		if col >= e.fullWidth() {
 			break
 		}
 	}
 }
 
-func (e *Editor) prompt(prompt string) string {
-	// Draw the prompt
-	e.drawStatusBar()
-	e.drawText(0, e.height-1, prompt, tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite))
-	e.screen.Show()
+// promptLine is the text typed into a prompt and the cursor's rune position in it
+type promptLine struct {
+	input  []rune
+	cursor int
+	// Earlier inputs recalled with Up/Down, the one shown (len(history) for the
+	// line being typed) and what was typed before recalling
+	history  []string
+	recalled int
+	draft    string
+}
+
+func newPromptLine(initial string) *promptLine {
+	input := []rune(initial)
+	return &promptLine{input: input, cursor: len(input)}
+}
+
+// withHistory lets Up and Down recall the earlier inputs in history
+func (p *promptLine) withHistory(history []string) *promptLine {
+	p.history = history
+	p.recalled = len(history)
+	return p
+}
+
+// set replaces the input with text, the cursor at its end
+func (p *promptLine) set(text string) {
+	p.input = []rune(text)
+	p.cursor = len(p.input)
+}
+
+// recall steps through the history: back towards older inputs when step is
+// negative, and forward to the line being typed
+func (p *promptLine) recall(step int) {
+	to := p.recalled + step
+	if to < 0 || to > len(p.history) {
+		return
+	}
+	if p.recalled == len(p.history) {
+		p.draft = p.String()
+	}
+	p.recalled = to
+	if to == len(p.history) {
+		p.set(p.draft)
+	} else {
+		p.set(p.history[to])
+	}
+}
+
+func (p *promptLine) String() string {
+	return string(p.input)
+}
+
+// beforeCursor returns the input left of the cursor
+func (p *promptLine) beforeCursor() string {
+	return string(p.input[:p.cursor])
+}
+
+// wordLeft returns the start of the word before the cursor
+func (p *promptLine) wordLeft() int {
+	i := p.cursor
+	for i > 0 && !isWordRune(p.input[i-1]) {
+		i--
+	}
+	for i > 0 && isWordRune(p.input[i-1]) {
+		i--
+	}
+	return i
+}
+
+// wordRight returns the start of the word after the cursor
+func (p *promptLine) wordRight() int {
+	i := p.cursor
+	for i < len(p.input) && isWordRune(p.input[i]) {
+		i++
+	}
+	for i < len(p.input) && !isWordRune(p.input[i]) {
+		i++
+	}
+	return i
+}
+
+// remove deletes the input between rune positions from and to, leaving the
+// cursor at from
+func (p *promptLine) remove(from, to int) {
+	p.input = append(p.input[:from], p.input[to:]...)
+	p.cursor = from
+}
 
-	// Wait for user input (Unicode-aware accumulation)
-	input := []rune("")
+// edit applies a line-editing key to the input and reports whether ev was one:
+// Left/Right (by word with Ctrl), Home/End and Ctrl+A/Ctrl+E, Backspace and
+// Delete (by word with Ctrl or Alt, or Ctrl+W), Ctrl+U and Ctrl+K to delete to the
+// start or end, typed characters, inserted at the cursor, and Up/Down to recall
+// earlier inputs when the prompt keeps a history
+func (p *promptLine) edit(ev *tcell.EventKey) bool {
+	byWord := ev.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0
+	switch ev.Key() {
+	case tcell.KeyUp, tcell.KeyDown:
+		if p.history == nil {
+			return false
+		}
+		if ev.Key() == tcell.KeyUp {
+			p.recall(-1)
+		} else {
+			p.recall(1)
+		}
+	case tcell.KeyLeft:
+		if byWord {
+			p.cursor = p.wordLeft()
+		} else if p.cursor > 0 {
+			p.cursor--
+		}
+	case tcell.KeyRight:
+		if byWord {
+			p.cursor = p.wordRight()
+		} else if p.cursor < len(p.input) {
+			p.cursor++
+		}
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		p.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		p.cursor = len(p.input)
+	case tcell.KeyCtrlW:
+		p.remove(p.wordLeft(), p.cursor)
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if byWord {
+			p.remove(p.wordLeft(), p.cursor)
+		} else if p.cursor > 0 {
+			p.remove(p.cursor-1, p.cursor)
+		}
+	case tcell.KeyDelete:
+		if byWord {
+			p.input = append(p.input[:p.cursor], p.input[p.wordRight():]...)
+		} else if p.cursor < len(p.input) {
+			p.input = append(p.input[:p.cursor], p.input[p.cursor+1:]...)
+		}
+	case tcell.KeyCtrlU:
+		p.remove(0, p.cursor)
+	case tcell.KeyCtrlK:
+		p.input = p.input[:p.cursor]
+	case tcell.KeyRune:
+		p.input = append(p.input[:p.cursor], append([]rune{ev.Rune()}, p.input[p.cursor:]...)...)
+		p.cursor++
+	default:
+		return false
+	}
+	return true
+}
+
+// modal runs a prompt or dialog until key, given each key press, reports that
+// it is done. Every prompt and dialog shares this loop, so the editor behind
+// them keeps working: a resize lays the text out again, interrupts are handled
+// as in the main loop (a followed file keeps growing, the writing timer keeps
+// counting), and timers such as the status message's still wake it. draw puts
+// the modal on screen at the start and after each key; after any other event it
+// goes over a freshly drawn editor.
+func (e *Editor) modal(draw func(), key func(ev *tcell.EventKey) bool) {
+	draw()
 	for {
-		ev := e.screen.PollEvent()
-		switch ev := ev.(type) {
+		switch ev := e.screen.PollEvent().(type) {
+		case nil:
+			// The screen was closed
+			return
 		case *tcell.EventKey:
-			switch ev.Key() {
-			case tcell.KeyEnter:
-				return string(input)
-			case tcell.KeyEscape:
-				return ""
-			case tcell.KeyBackspace, tcell.KeyBackspace2:
-				if len(input) > 0 {
-					input = input[:len(input)-1]
-				}
-			default:
-				if ev.Rune() != 0 {
-					input = append(input, ev.Rune())
-				}
+			if key(ev) {
+				return
 			}
+		case *tcell.EventResize:
+			e.handleResize()
+			e.scroll()
+			e.draw()
+		case *tcell.EventInterrupt:
+			e.handleInterrupt(ev.Data())
+			e.applyScrollMomentum()
+			e.scroll()
+			e.draw()
+		default:
+			e.applyScrollMomentum()
+			e.draw()
 		}
-		// Update the prompt with user input
-		e.drawStatusBar()
-		e.drawText(0, e.height-1, prompt+string(input), tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite))
-		e.screen.Show()
+		draw()
 	}
 }
 
-// promptFilename provides a simple filename prompt
-func (e *Editor) promptFilename(title, initial string) string {
-	e.drawStatusBar()
-	input := []rune(initial)
-	cursor := len(input)
-	baseStyle := tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite)
+func (e *Editor) prompt(prompt string) string {
+	return e.promptWithHistory("", prompt)
+}
 
-	redraw := func() {
-		text := fmt.Sprintf("%s: %s", title, string(input))
-		e.renderPromptLine(baseStyle, text, "")
+// promptWithHistory is a prompt whose answers are remembered under kind, across
+// sessions, and recalled with Up/Down. An empty kind keeps no history.
+func (e *Editor) promptWithHistory(kind, prompt string) string {
+	line := newPromptLine("")
+	if kind != "" {
+		line.withHistory(e.promptHistoryOf(kind))
 	}
+	answer := ""
+	e.modal(func() {
+		e.renderPromptLine(e.theme.prompt, prompt+line.String(), "", prompt+line.beforeCursor())
+	}, func(ev *tcell.EventKey) bool {
+		switch ev.Key() {
+		case tcell.KeyEnter:
+			answer = line.String()
+			e.rememberPrompt(kind, answer)
+			return true
+		case tcell.KeyEscape:
+			return true
+		}
+		line.edit(ev)
+		return false
+	})
+	return answer
+}
 
-	redraw()
+// promptFilename asks for a file name, starting from initial, with Tab completion
+// relative to the working directory
+func (e *Editor) promptFilename(title, initial string) string {
+	return e.promptPathFrom(title, initial, ".")
+}
 
-	for {
-		ev := e.screen.PollEvent()
-		switch ev := ev.(type) {
-		case *tcell.EventKey:
-			switch ev.Key() {
-			case tcell.KeyEnter:
-				return string(input)
-			case tcell.KeyEscape:
-				return ""
-			case tcell.KeyBackspace, tcell.KeyBackspace2:
-				if cursor > 0 {
-					input = append(input[:cursor-1], input[cursor:]...)
-					cursor--
+// promptPath is a filename prompt with Tab completion of paths relative to baseDir.
+func (e *Editor) promptPath(title, baseDir string) string {
+	return e.promptPathFrom(title, "", baseDir)
+}
+
+// promptPathFrom is a path prompt starting from initial. Tab completes as far as
+// the matching names agree and lists them on the right of the prompt; when they
+// agree no further, Tab and Shift+Tab step through them, the current one marked.
+func (e *Editor) promptPathFrom(title, initial, baseDir string) string {
+	line := newPromptLine(initial).withHistory(e.promptHistoryOf("file"))
+	hint := ""
+	baseStyle := e.theme.prompt
+	var cycle []string // Candidates being stepped through, nil when not cycling
+	current := 0
+
+	answer := ""
+	e.modal(func() {
+		text := fmt.Sprintf("%s: %s", title, line)
+		e.renderPromptLine(baseStyle, text, hint, title+": "+line.beforeCursor())
+	}, func(ev *tcell.EventKey) bool {
+		hint = ""
+		switch ev.Key() {
+		case tcell.KeyEnter:
+			answer = line.String()
+			e.rememberPrompt("file", answer)
+			return true
+		case tcell.KeyEscape:
+			return true
+		case tcell.KeyTab, tcell.KeyBacktab:
+			back := ev.Key() == tcell.KeyBacktab
+			if cycle != nil {
+				step := 1
+				if back {
+					step = len(cycle) - 1
+				}
+				current = (current + step) % len(cycle)
+			} else if completed, matches := completePath(baseDir, line.String()); len(matches) > 1 && completed == line.String() {
+				cycle, current = matches, 0
+				if back {
+					current = len(cycle) - 1
 				}
-			default:
-				if r := ev.Rune(); r != 0 {
-					input = append(input[:cursor], append([]rune{r}, input[cursor:]...)...)
-					cursor++
+			} else {
+				line.set(completed)
+				if len(matches) > 1 {
+					hint = strings.Join(matches, " ")
 				}
+				return false
+			}
+			dirPart, _ := filepath.Split(line.String())
+			line.set(dirPart + cycle[current])
+			marked := make([]string, len(cycle))
+			copy(marked, cycle)
+			marked[current] = "[" + marked[current] + "]"
+			hint = strings.Join(marked, " ")
+			return false
+		}
+		line.edit(ev)
+		cycle = nil
+		return false
+	})
+	return answer
+}
+
+// promptHistoryLimit is how many answers are kept for each kind of prompt
+const promptHistoryLimit = 100
+
+// promptHistoryOf returns the earlier answers to prompts of kind, oldest first.
+// The answers of all kinds are kept in one file, as "kind<TAB>answer" lines.
+func (e *Editor) promptHistoryOf(kind string) []string {
+	if e.promptHistory == nil {
+		e.promptHistory = make(map[string][]string)
+		data, _ := os.ReadFile(settingsFile("prompt-history"))
+		for _, line := range strings.Split(string(data), "\n") {
+			if k, answer, ok := strings.Cut(line, "\t"); ok && answer != "" {
+				e.promptHistory[k] = append(e.promptHistory[k], answer)
 			}
 		}
-		redraw()
 	}
+	return e.promptHistory[kind]
 }
 
-// promptYesNo asks a yes/no question and returns true for yes, false for no
-func (e *Editor) promptYesNo(question string) bool {
-	response := e.prompt(question + " (y/n): ")
-	return response == "y" || response == "Y"
+// rememberPrompt adds answer to the end of the history of kind, dropping an
+// earlier copy and the oldest answers past the limit, and saves the history
+func (e *Editor) rememberPrompt(kind, answer string) {
+	if kind == "" || strings.TrimSpace(answer) == "" {
+		return
+	}
+	var answers []string
+	for _, a := range e.promptHistoryOf(kind) {
+		if a != answer {
+			answers = append(answers, a)
+		}
+	}
+	answers = append(answers, answer)
+	if len(answers) > promptHistoryLimit {
+		answers = answers[len(answers)-promptHistoryLimit:]
+	}
+	e.promptHistory[kind] = answers
+
+	kinds := make([]string, 0, len(e.promptHistory))
+	for k := range e.promptHistory {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	var b strings.Builder
+	for _, k := range kinds {
+		for _, a := range e.promptHistory[k] {
+			b.WriteString(k + "\t" + a + "\n")
+		}
+	}
+	path := settingsFile("prompt-history")
+	if path == "" {
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(b.String()), 0644)
+	}
+	if err != nil {
+		e.reportError("Saving the prompt history", err)
+	}
 }
 
-// Helper used by prompt rendering to place main text and optional right-side hint
-func (e *Editor) renderPromptLine(style tcell.Style, text, extra string) {
+// Helper used by prompt rendering to place main text and optional right-side hint,
+// with the cursor just after beforeCursor
+func (e *Editor) renderPromptLine(style tcell.Style, text, extra, beforeCursor string) {
 	e.drawStatusBar()
 	e.drawText(0, e.height-1, text, style)
 	if extra != "" {
-		startX := e.width - displayWidth(extra) - 1
+		startX := e.fullWidth() - displayWidth(extra) - 1
 		textWidth := displayWidth(text)
 		if startX < textWidth+1 {
 			startX = textWidth + 1
 		}
-		if startX < e.width {
+		if startX < e.fullWidth() {
 			e.drawText(startX, e.height-1, extra, style)
 		}
 	}
+	e.showPromptCursor(beforeCursor)
 	e.screen.Show()
 }
 
+// showPromptCursor puts the terminal cursor in the bottom row just after
+// beforeCursor, where the prompt's input goes. Input methods draw the text being
+// composed at the terminal cursor, so CJK composition shows in the prompt rather
+// than in the document behind it.
+func (e *Editor) showPromptCursor(beforeCursor string) {
+	e.screen.ShowCursor(min(displayWidth(beforeCursor), e.fullWidth()-1), e.height-1)
+}
@@ -0,0 +1,739 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// dictionary is the set of correctly spelled words, with hunspell affixes
+// already expanded
+type dictionary struct {
+	words map[string]bool
+}
+
+// affixRule is one hunspell prefix or suffix rule: strip is removed from the
+// stem and add put in its place when the stem matches condition
+type affixRule struct {
+	strip, add string
+	condition  *regexp.Regexp
+	cross      bool // May combine with a rule of the other kind
+}
+
+// affixFile holds the parts of a hunspell .aff file that expand .dic stems
+type affixFile struct {
+	flagType string // "", "long", "num" or "UTF-8"
+	aliases  []string
+	prefixes map[string][]affixRule
+	suffixes map[string][]affixRule
+	latin1   bool // Files are ISO8859-1 rather than UTF-8
+}
+
+// dictionaryDirs lists where hunspell dictionaries are looked for
+func dictionaryDirs() []string {
+	dirs := filepath.SplitList(os.Getenv("DICPATH"))
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".local", "share", "hunspell"), filepath.Join(home, "Library", "Spelling"))
+	}
+	return append(dirs, "/usr/share/hunspell", "/usr/share/myspell", "/usr/share/myspell/dicts",
+		"/usr/local/share/hunspell", "/opt/homebrew/share/hunspell", "/Library/Spelling")
+}
+
+// findDictionary returns the path of the dictionary for lang, such as en_GB or
+// de: a hunspell .dic file, or for English the system word list when no hunspell
+// one is installed
+func findDictionary(lang string) (string, error) {
+	for _, dir := range dictionaryDirs() {
+		path := filepath.Join(dir, lang+".dic")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		// A bare language such as "de" takes the first regional dictionary
+		if matches, _ := filepath.Glob(filepath.Join(dir, lang+"_*.dic")); !strings.Contains(lang, "_") && len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+	if strings.HasPrefix(lang, "en") {
+		for _, path := range []string{"/usr/share/dict/words", "/usr/dict/words"} {
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no %s dictionary found (install hunspell-%s or set dictionary in the config)", lang, strings.SplitN(lang, "_", 2)[0])
+}
+
+// loadDictionary reads a hunspell .dic file, expanding its affixes with the .aff
+// file beside it, or a plain list of one word per line
+func loadDictionary(path string) (*dictionary, error) {
+	d := &dictionary{words: make(map[string]bool)}
+	aff := &affixFile{}
+	hunspell := strings.HasSuffix(path, ".dic")
+	if hunspell {
+		var err error
+		if aff, err = loadAffixFile(strings.TrimSuffix(path, ".dic") + ".aff"); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 0; scanner.Scan(); lineNum++ {
+		line := aff.decode(scanner.Text())
+		if !hunspell {
+			if word := strings.TrimSpace(line); word != "" {
+				d.words[word] = true
+			}
+			continue
+		}
+		// The first line of a .dic file is the word count
+		if _, err := strconv.Atoi(strings.TrimSpace(line)); lineNum == 0 && err == nil {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			word, flags, _ := strings.Cut(fields[0], "/")
+			for _, form := range aff.expand(word, flags) {
+				d.words[form] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(d.words) == 0 {
+		return nil, fmt.Errorf("%s has no words", path)
+	}
+	return d, nil
+}
+
+// loadAffixFile reads the flag format, flag aliases and affix rules of a .aff file
+func loadAffixFile(path string) (*affixFile, error) {
+	aff := &affixFile{prefixes: make(map[string][]affixRule), suffixes: make(map[string][]affixRule)}
+	file, err := os.Open(path)
+	if err != nil {
+		return aff, err
+	}
+	defer file.Close()
+
+	crosses := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(aff.decode(scanner.Text()))
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "SET":
+			aff.latin1 = strings.EqualFold(fields[1], "ISO8859-1")
+		case "FLAG":
+			aff.flagType = fields[1]
+		case "AF":
+			// "AF count" starts the list; each "AF flags" after it is an alias
+			if _, err := strconv.Atoi(fields[1]); err != nil || len(aff.aliases) > 0 {
+				aff.aliases = append(aff.aliases, fields[1])
+			}
+		case "PFX", "SFX":
+			flag := fields[1]
+			if len(fields) == 4 {
+				// Header: "SFX flag cross count"
+				crosses[fields[0]+flag] = fields[2] == "Y"
+				continue
+			}
+			if len(fields) < 5 {
+				continue
+			}
+			rule := affixRule{strip: fields[2], cross: crosses[fields[0]+flag]}
+			rule.add, _, _ = strings.Cut(fields[3], "/")
+			if rule.strip == "0" {
+				rule.strip = ""
+			}
+			if rule.add == "0" {
+				rule.add = ""
+			}
+			pattern := "^(?:" + fields[4] + ")"
+			if fields[0] == "SFX" {
+				pattern = "(?:" + fields[4] + ")$"
+			}
+			if rule.condition, err = regexp.Compile(pattern); err != nil {
+				continue
+			}
+			if fields[0] == "PFX" {
+				aff.prefixes[flag] = append(aff.prefixes[flag], rule)
+			} else {
+				aff.suffixes[flag] = append(aff.suffixes[flag], rule)
+			}
+		}
+	}
+	return aff, scanner.Err()
+}
+
+// decode converts a line of an ISO8859-1 dictionary to UTF-8
+func (a *affixFile) decode(line string) string {
+	if !a.latin1 || utf8.ValidString(line) {
+		return line
+	}
+	runes := make([]rune, len(line))
+	for i := 0; i < len(line); i++ {
+		runes[i] = rune(line[i])
+	}
+	return string(runes)
+}
+
+// splitFlags splits the flag field of a .dic entry by the file's flag format
+func (a *affixFile) splitFlags(flags string) []string {
+	if n, err := strconv.Atoi(flags); err == nil && len(a.aliases) > 0 && a.flagType != "num" {
+		if n < 1 || n > len(a.aliases) {
+			return nil
+		}
+		flags = a.aliases[n-1]
+	}
+	switch a.flagType {
+	case "long":
+		var split []string
+		for i := 0; i+1 < len(flags); i += 2 {
+			split = append(split, flags[i:i+2])
+		}
+		return split
+	case "num":
+		return strings.Split(flags, ",")
+	default:
+		return strings.Split(flags, "")
+	}
+}
+
+// expand returns word and every form its affix flags allow. A prefix is also
+// applied to suffixed forms when both rules allow combining.
+func (a *affixFile) expand(word, flags string) []string {
+	forms := []string{word}
+	if flags == "" {
+		return forms
+	}
+	var crossable []string
+	for _, flag := range a.splitFlags(flags) {
+		for _, rule := range a.suffixes[flag] {
+			if strings.HasSuffix(word, rule.strip) && rule.condition.MatchString(word) {
+				form := word[:len(word)-len(rule.strip)] + rule.add
+				forms = append(forms, form)
+				if rule.cross {
+					crossable = append(crossable, form)
+				}
+			}
+		}
+	}
+	for _, flag := range a.splitFlags(flags) {
+		for _, rule := range a.prefixes[flag] {
+			if !strings.HasPrefix(word, rule.strip) || !rule.condition.MatchString(word) {
+				continue
+			}
+			forms = append(forms, rule.add+word[len(rule.strip):])
+			if !rule.cross {
+				continue
+			}
+			for _, form := range crossable {
+				if strings.HasPrefix(form, rule.strip) {
+					forms = append(forms, rule.add+form[len(rule.strip):])
+				}
+			}
+		}
+	}
+	return forms
+}
+
+// check reports whether word is spelled correctly. Capitalised and upper-case
+// forms of dictionary words are accepted, and so is a trailing possessive 's.
+func (d *dictionary) check(word string) bool {
+	word = strings.ReplaceAll(word, "’", "'")
+	for {
+		if d.words[word] || d.words[strings.ToLower(word)] {
+			return true
+		}
+		// "Paris" in the dictionary also allows "PARIS", but not "paris"
+		if strings.ToUpper(word) == word {
+			runes := []rune(strings.ToLower(word))
+			runes[0] = unicode.ToUpper(runes[0])
+			if d.words[string(runes)] {
+				return true
+			}
+		}
+		trimmed := strings.TrimSuffix(strings.TrimSuffix(word, "'s"), "'S")
+		if trimmed == word || trimmed == "" {
+			return false
+		}
+		word = trimmed
+	}
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// neighbouring runes that turn a into b
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// matchCase writes suggestion in the capitalisation of word. A capital the
+// suggestion has of its own, as in a name, is kept.
+func matchCase(word, suggestion string) string {
+	if utf8.RuneCountInString(word) > 1 && strings.ToUpper(word) == word {
+		return strings.ToUpper(suggestion)
+	}
+	first, _ := utf8.DecodeRuneInString(word)
+	if unicode.IsUpper(first) {
+		runes := []rune(suggestion)
+		runes[0] = unicode.ToUpper(runes[0])
+		return string(runes)
+	}
+	return suggestion
+}
+
+// suggest returns up to limit dictionary words closest to word, nearest first
+func (d *dictionary) suggest(word string, limit int) []string {
+	target := []rune(strings.ToLower(strings.ReplaceAll(word, "’", "'")))
+	type candidate struct {
+		word     string
+		distance int
+	}
+	var candidates []candidate
+	for w := range d.words {
+		runes := []rune(strings.ToLower(w))
+		if abs(len(runes)-len(target)) > 2 {
+			continue
+		}
+		if dist := editDistance(target, runes); dist <= 2 {
+			candidates = append(candidates, candidate{w, dist})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		// Prefer words that keep the first letter, as it is rarely the typo
+		aFirst, bFirst := strings.HasPrefix(strings.ToLower(a.word), string(target[0])), strings.HasPrefix(strings.ToLower(b.word), string(target[0]))
+		if aFirst != bFirst {
+			return aFirst
+		}
+		return a.word < b.word
+	})
+
+	var suggestions []string
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		s := matchCase(word, c.word)
+		if !seen[strings.ToLower(s)] {
+			seen[strings.ToLower(s)] = true
+			suggestions = append(suggestions, s)
+		}
+		if len(suggestions) == limit {
+			break
+		}
+	}
+	return suggestions
+}
+
+// wordSpans returns the rune ranges of the prose words in line. Code spans, HTML
+// tags, link destinations, URLs, and words mixing in digits, underscores or
+// inner capitals (identifiers and acronyms) are left out.
+func wordSpans(line string) [][2]int {
+	runes := []rune(line)
+	plain := make([]bool, len(runes))
+	ctx := punctContext{}
+	for i, r := range runes {
+		ctx.feed(r)
+		plain[i] = ctx.plain()
+	}
+	math, _, _ := mathInLine(runes, false)
+	for _, span := range math {
+		for i := span[0]; i < span[1]; i++ {
+			plain[i] = false
+		}
+	}
+	// Blank out whole tokens that look like addresses
+	for start := 0; start < len(runes); {
+		end := start
+		for end < len(runes) && !unicode.IsSpace(runes[end]) {
+			end++
+		}
+		if token := string(runes[start:end]); strings.Contains(token, "://") || strings.Contains(token, "@") || strings.HasPrefix(token, "www.") {
+			for i := start; i < end; i++ {
+				plain[i] = false
+			}
+		}
+		start = end + 1
+	}
+
+	var spans [][2]int
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.Is(unicode.Mn, runes[i]) ||
+			(runes[i] == '\'' || runes[i] == '’') && i+1 < len(runes) && unicode.IsLetter(runes[i+1])) {
+			i++
+		}
+		skip := i-start < 2 ||
+			start > 0 && (unicode.IsDigit(runes[start-1]) || runes[start-1] == '_') ||
+			i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '_')
+		for j := start; j < i && !skip; j++ {
+			skip = !plain[j] || j > start && unicode.IsUpper(runes[j])
+		}
+		if !skip {
+			spans = append(spans, [2]int{start, i})
+		}
+	}
+	return spans
+}
+
+// frontMatterLanguage returns the language a document declares with "lang:" or
+// "language:" in its front matter, as a dictionary name such as en_GB
+func frontMatterLanguage(lines []string) string {
+	for _, line := range lines[:frontMatterEnd(lines)+1] {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && (key == "lang" || key == "language") {
+			return strings.ReplaceAll(strings.Trim(strings.TrimSpace(value), `"'`), "-", "_")
+		}
+	}
+	return ""
+}
+
+// spellDictionary returns the dictionary for the active document: the language
+// its front matter declares, or else the configured dictionary or language. Each
+// is loaded once; a failure is reported the first time and then remembered.
+func (e *Editor) spellDictionary() (*dictionary, error) {
+	key, path := frontMatterLanguage(e.lines), ""
+	if key == "" {
+		key, path = e.config.spellLanguage, e.config.dictionary
+		if path != "" {
+			key = path
+		}
+	}
+	if d, ok := e.dictionaries[key]; ok {
+		if d == nil {
+			return nil, fmt.Errorf("no %s dictionary", key)
+		}
+		return d, nil
+	}
+
+	var err error
+	if path == "" {
+		path, err = findDictionary(key)
+	}
+	var d *dictionary
+	if err == nil {
+		if d, err = loadDictionary(path); err != nil {
+			err = fmt.Errorf("failed to load dictionary: %v", err)
+		}
+	}
+	if e.dictionaries == nil {
+		e.dictionaries = make(map[string]*dictionary)
+	}
+	e.dictionaries[key] = d
+	if err != nil {
+		e.statusMessage = err.Error()
+	}
+	return d, err
+}
+
+// personalDictionaryPath is the user's own word list, shared by all documents
+func (e *Editor) personalDictionaryPath() string {
+	if e.config.personalDictionary != "" {
+		return e.config.personalDictionary
+	}
+	return settingsFile("dictionary")
+}
+
+// readWords reads a list of one word per line. A missing file is an empty list.
+func readWords(path string) (*dictionary, error) {
+	d := &dictionary{words: make(map[string]bool)}
+	if path == "" {
+		return d, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return d, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if word := strings.TrimSpace(line); word != "" {
+			d.words[word] = true
+		}
+	}
+	return d, nil
+}
+
+// appendLine adds line to the end of the file at path, creating it as needed
+func appendLine(path, line string) error {
+	if path == "" {
+		return fmt.Errorf("no settings folder to save to")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// documentIgnores returns the words ignored in the active document. The lists of
+// all documents are kept in one file, as "path<TAB>word" lines.
+func (e *Editor) documentIgnores() *dictionary {
+	if e.ignoredWords != nil {
+		return e.ignoredWords
+	}
+	e.ignoredWords = &dictionary{words: make(map[string]bool)}
+	if e.filename == "" {
+		return e.ignoredWords
+	}
+	abs, _ := filepath.Abs(e.filename)
+	data, _ := os.ReadFile(settingsFile("spell-ignore"))
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, word, ok := strings.Cut(line, "\t"); ok && path == abs {
+			e.ignoredWords.words[word] = true
+		}
+	}
+	return e.ignoredWords
+}
+
+// addToDictionary saves word to the personal dictionary
+func (e *Editor) addToDictionary(word string) {
+	if err := appendLine(e.personalDictionaryPath(), word); err != nil {
+		e.reportError("Saving to the personal dictionary", err)
+		return
+	}
+	e.personalWords.words[word] = true
+	e.statusMessage = fmt.Sprintf("Added %q to the personal dictionary", word)
+}
+
+// ignoreWord stops word being marked in the active document, remembering it for
+// the next time the document is opened
+func (e *Editor) ignoreWord(word string) {
+	e.documentIgnores().words[word] = true
+	e.statusMessage = fmt.Sprintf("Ignoring %q in this document", word)
+	if e.filename == "" {
+		return
+	}
+	abs, _ := filepath.Abs(e.filename)
+	if err := appendLine(settingsFile("spell-ignore"), abs+"\t"+word); err != nil {
+		e.reportError("Saving the ignored word", err)
+	}
+}
+
+// knownWord reports whether word is in d, the personal dictionary, or the
+// document's ignore list
+func (e *Editor) knownWord(d *dictionary, word string) bool {
+	return d.check(word) || e.personalWords.check(word) || e.documentIgnores().check(word)
+}
+
+// setSpellCheck turns spell checking on or off, loading the dictionaries the
+// first time they are needed
+func (e *Editor) setSpellCheck(on bool) error {
+	if on {
+		if _, err := e.spellDictionary(); err != nil {
+			return err
+		}
+		if e.personalWords == nil {
+			words, err := readWords(e.personalDictionaryPath())
+			if err != nil {
+				return fmt.Errorf("failed to read personal dictionary: %v", err)
+			}
+			e.personalWords = words
+		}
+	}
+	e.spellCheck = on
+	return nil
+}
+
+// toggleSpellCheck switches underlining of misspelled words on or off
+func (e *Editor) toggleSpellCheck() {
+	if err := e.setSpellCheck(!e.spellCheck); err != nil {
+		e.statusMessage = err.Error()
+		return
+	}
+	if e.spellCheck {
+		e.statusMessage = "Spell check on"
+	} else {
+		e.statusMessage = "Spell check off"
+	}
+}
+
+// misspellings returns the rune ranges of the words on line y that d and the
+// user's word lists do not know, given the lines reflowLiteral marks as literal
+func (e *Editor) misspellings(d *dictionary, y int, literal []bool) [][2]int {
+	if literal[y] {
+		return nil
+	}
+	var bad [][2]int
+	for _, span := range wordSpans(e.lines[y]) {
+		if !e.knownWord(d, runeSubstring(e.lines[y], span[0], span[1])) {
+			bad = append(bad, span)
+		}
+	}
+	return bad
+}
+
+// drawMisspellings underlines the misspelled words on screen. Highlighted cells
+// keep their highlight.
+func (e *Editor) drawMisspellings() {
+	d, err := e.spellDictionary()
+	if err != nil {
+		return
+	}
+	literal := reflowLiteral(e.lines)
+	lineY := -1
+	var bad [][2]int
+	e.forEachVisibleRune(func(x, y, col, sx, sy int, runes []rune) {
+		if y != lineY {
+			bad, lineY = e.misspellings(d, y, literal), y
+		}
+		for _, span := range bad {
+			if x >= span[0] && x < span[1] {
+				if _, _, style, _ := e.screen.GetContent(sx, sy); style == e.theme.text {
+					e.drawRune(sx, sy, col, runes, x, e.theme.spelling)
+				}
+				return
+			}
+		}
+	})
+}
+
+// startSpellCheck turns spell checking on if it is off and returns the
+// document's dictionary, reporting in the status bar when there is none
+func (e *Editor) startSpellCheck() (*dictionary, bool) {
+	if err := e.setSpellCheck(true); err != nil {
+		e.statusMessage = err.Error()
+		return nil, false
+	}
+	d, err := e.spellDictionary()
+	return d, err == nil
+}
+
+// jumpMisspelling moves the cursor to the start of the next misspelled word in
+// direction delta (1 or -1), wrapping around the document
+func (e *Editor) jumpMisspelling(delta int) {
+	d, ok := e.startSpellCheck()
+	if !ok {
+		return
+	}
+	literal := reflowLiteral(e.lines)
+	n := len(e.lines)
+	// Step i visits the line i lines away; step n is the cursor line again, for
+	// the words on the far side of the cursor
+	for i := 0; i <= n; i++ {
+		y := ((e.cursorY+delta*i)%n + n) % n
+		bad := e.misspellings(d, y, literal)
+		if delta < 0 {
+			for j, k := 0, len(bad)-1; j < k; j, k = j+1, k-1 {
+				bad[j], bad[k] = bad[k], bad[j]
+			}
+		}
+		for _, span := range bad {
+			ahead := span[0] > e.cursorX
+			if delta < 0 {
+				ahead = span[0] < e.cursorX
+			}
+			if (i == 0 && ahead) || (i > 0 && i < n) || (i == n && !ahead) {
+				e.cursorY, e.cursorX = y, span[0]
+				e.clearSelection()
+				e.ensureCursorVisible()
+				return
+			}
+		}
+	}
+	e.statusMessage = "No misspellings"
+}
+
+// wordAtCursor returns the rune range of the prose word under or just before
+// the cursor
+func (e *Editor) wordAtCursor() (start, end int, ok bool) {
+	if e.cursorY >= len(e.lines) {
+		return 0, 0, false
+	}
+	for _, span := range wordSpans(e.lines[e.cursorY]) {
+		if e.cursorX >= span[0] && e.cursorX <= span[1] {
+			return span[0], span[1], true
+		}
+	}
+	return 0, 0, false
+}
+
+// spellingSuggestions offers replacements for the word at the cursor and puts
+// the chosen one in its place. The word can also be added to the personal
+// dictionary or ignored in this document.
+func (e *Editor) spellingSuggestions() {
+	d, ok := e.startSpellCheck()
+	if !ok {
+		return
+	}
+	start, end, ok := e.wordAtCursor()
+	if !ok {
+		e.statusMessage = "No word at cursor"
+		return
+	}
+	word := strings.ReplaceAll(runeSubstring(e.lines[e.cursorY], start, end), "’", "'")
+	if e.knownWord(d, word) {
+		e.statusMessage = fmt.Sprintf("%q is spelled correctly", word)
+		return
+	}
+	suggestions := d.suggest(word, 10)
+	title := fmt.Sprintf("Suggestions for %q", word)
+	if len(suggestions) == 0 {
+		title = fmt.Sprintf("No suggestions for %q", word)
+	}
+	items := append(suggestions, fmt.Sprintf("Add %q to dictionary", word), fmt.Sprintf("Ignore %q in this document", word))
+	choice := e.pickFromList(title, items, nil)
+	switch {
+	case choice < 0:
+		return
+	case choice == len(suggestions):
+		e.addToDictionary(word)
+		return
+	case choice == len(suggestions)+1:
+		e.ignoreWord(word)
+		return
+	}
+	e.pushUndoState()
+	e.clearSearch()
+	e.invalidateWordCount()
+	line := runeDelete(e.lines[e.cursorY], start, end)
+	e.lines[e.cursorY] = runeInsert(line, start, suggestions[choice])
+	e.cursorX = start + runeLen(suggestions[choice])
+	e.modified = true
+	e.ensureCursorVisible()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// signedWords formats a change in the word count with its sign and commas,
+// such as "+1,240" or "-45"
+func signedWords(n int) string {
+	if n < 0 {
+		return "-" + groupDigits(-n)
+	}
+	return "+" + groupDigits(n)
+}
+
+// trackWords adds the change in the word count since it last ran to the words
+// added or removed in the active buffer; it runs after every event. While the
+// buffer matches the file on disk (just opened, saved, loading a chunk or
+// following) nothing is being written, and the count only moves the baselines.
+// The time between changes counts as time spent writing, up to writingPause.
+func (e *Editor) trackWords() {
+	count := e.wordCount()
+	if !e.modified {
+		e.lastWords, e.savedWords = count, count
+		return
+	}
+	if count == e.lastWords {
+		return
+	}
+	if count > e.lastWords {
+		e.wordsAdded += count - e.lastWords
+	} else {
+		e.wordsRemoved += e.lastWords - count
+	}
+	e.lastWords = count
+	now := time.Now()
+	if !e.lastWritten.IsZero() {
+		e.writingTime += min(now.Sub(e.lastWritten), writingPause)
+	}
+	e.lastWritten = now
+}
+
+// sessionWords returns the net change in the active buffer's words since it was
+// opened
+func (e *Editor) sessionWords() int {
+	return e.wordsAdded - e.wordsRemoved
+}
+
+// showStatistics shows the word count of the buffer with the words written in it
+// since it was opened and since it was last saved, the session's words in all
+// open buffers when there are several, and the readability of the text. With a
+// selection, it shows the words and readability of the selected text.
+func (e *Editor) showStatistics() {
+	e.trackWords()
+	row := func(label, value string) string {
+		return fmt.Sprintf("%-22s %s", label, value)
+	}
+	if text := e.getSelectedText(); text != "" {
+		selected := strings.Split(text, "\n")
+		math, _, _ := scanMath(selected, codeLiteral(selected))
+		words := 0
+		for y, line := range selected {
+			words += countWordsOutside(line, math[y])
+		}
+		lines := append([]string{row("Words", groupDigits(words)), ""}, measureReadability(selected).report(row)...)
+		e.messageBox("Statistics: selection", lines)
+		return
+	}
+
+	lines := []string{
+		row("Words", groupDigits(e.wordCount())),
+		row("Since opening", fmt.Sprintf("%s (%s added, %s removed)", signedWords(e.sessionWords()), groupDigits(e.wordsAdded), groupDigits(e.wordsRemoved))),
+		row("Since the last save", signedWords(e.wordCount()-e.savedWords)),
+	}
+	if len(e.buffers) > 1 {
+		added, removed := e.wordsWritten()
+		lines = append(lines, row(fmt.Sprintf("All %d buffers", len(e.buffers)), fmt.Sprintf("%s (%s added, %s removed)", signedWords(added-removed), groupDigits(added), groupDigits(removed))))
+	}
+	lines = append(append(lines, ""), e.documentReadability().report(row)...)
+	e.messageBox("Statistics: "+bufferName(e.filename), lines)
+}
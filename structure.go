@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// autolinkPattern matches a URL in angle brackets, "<https://...>"
+var autolinkPattern = regexp.MustCompile(`<[a-zA-Z][a-zA-Z0-9+.-]*://[^>\s]*>`)
+
+// section is a heading and the text under it, up to the next heading
+type section struct {
+	heading heading // Level 0 for the text before the first heading
+	words   int     // Words of the heading and its own text
+	total   int     // Words including the subsections
+}
+
+// documentStructure counts the markdown elements of a document
+type documentStructure struct {
+	headings     [6]int // By level, H1 first
+	listItems    int
+	codeBlocks   int
+	links        int
+	images       int
+	footnotes    int // Definitions
+	footnoteRefs int
+	sections     []section
+}
+
+// measureStructure counts the headings, list items, code blocks, links, images
+// and footnotes of lines, leaving out front matter and the inside of code
+// blocks, and the words of each section. The sections' own words add up to the
+// document's word count.
+func measureStructure(lines []string) documentStructure {
+	var s documentStructure
+	literal := codeLiteral(lines)
+	math, _, _ := scanMath(lines, literal)
+	fences := 0
+	current := section{}
+	for y, line := range lines {
+		words := countWordsOutside(line, math[y])
+		if isCodeFence(line) && y > frontMatterEnd(lines) {
+			fences++
+		}
+		if literal[y] {
+			current.words += words
+			continue
+		}
+		if level, text, ok := parseHeading(line); ok {
+			if current.words > 0 || current.heading.level > 0 {
+				s.sections = append(s.sections, current)
+			}
+			current = section{heading: heading{line: y, level: level, text: text}}
+			s.headings[level-1]++
+		}
+		current.words += words
+
+		content := line[len(quotePrefixPattern.FindString(line)):]
+		if listMarkerPattern.MatchString(content) && !ruleLinePattern.MatchString(strings.TrimSpace(content)) {
+			s.listItems++
+		}
+		content = codeSpanText.ReplaceAllString(content, "")
+		for _, m := range inlineLinkText.FindAllString(content, -1) {
+			if strings.HasPrefix(m, "!") {
+				s.images++
+			} else {
+				s.links++
+			}
+		}
+		s.links += len(wikiLinkPattern.FindAllString(content, -1)) + len(autolinkPattern.FindAllString(content, -1))
+		if footnoteDefPattern.MatchString(line) {
+			s.footnotes++
+			content = content[len(footnoteDefPattern.FindString(content)):]
+		}
+		s.footnoteRefs += len(footnoteRefPattern.FindAllString(content, -1))
+	}
+	s.codeBlocks = (fences + 1) / 2
+	if current.words > 0 || current.heading.level > 0 {
+		s.sections = append(s.sections, current)
+	}
+
+	for i := range s.sections {
+		s.sections[i].total = s.sections[i].words
+		for j := i + 1; j < len(s.sections) && s.sections[i].heading.level > 0 && s.sections[j].heading.level > s.sections[i].heading.level; j++ {
+			s.sections[i].total += s.sections[j].words
+		}
+	}
+	return s
+}
+
+// report returns the lines of the structure report: the counts, then each
+// section with its words, subsections included, and a bar comparing it with
+// the largest section of the same level
+func (s documentStructure) report() []string {
+	headings := 0
+	var levels []string
+	for i, n := range s.headings {
+		if n > 0 {
+			headings += n
+			levels = append(levels, fmt.Sprintf("H%d %d", i+1, n))
+		}
+	}
+	count := func(label string, n int, detail string) string {
+		line := fmt.Sprintf(" %-12s %7s", label, groupDigits(n))
+		if detail != "" {
+			line += "  (" + detail + ")"
+		}
+		return line
+	}
+	refs := ""
+	if s.footnoteRefs > 0 {
+		refs = fmt.Sprintf("%s references", groupDigits(s.footnoteRefs))
+	}
+	lines := []string{
+		"Counts",
+		count("Headings", headings, strings.Join(levels, ", ")),
+		count("List items", s.listItems, ""),
+		count("Code blocks", s.codeBlocks, ""),
+		count("Links", s.links, ""),
+		count("Images", s.images, ""),
+		count("Footnotes", s.footnotes, refs),
+		"",
+		"Words by section (with subsections)",
+	}
+
+	top, largest := 6, map[int]int{}
+	for _, sec := range s.sections {
+		if sec.heading.level > 0 {
+			top = min(top, sec.heading.level)
+		}
+		largest[sec.heading.level] = max(largest[sec.heading.level], sec.total)
+	}
+	const nameWidth, barWidth = 44, 20
+	for _, sec := range s.sections {
+		name := "(before the first heading)"
+		indent := 1
+		if sec.heading.level > 0 {
+			name = sec.heading.text
+			indent += 2 * (sec.heading.level - top)
+		}
+		name = runewidth.Truncate(strings.Repeat(" ", indent)+name, nameWidth, "…")
+		bar := ""
+		if largest[sec.heading.level] > 0 {
+			bar = strings.Repeat("█", (sec.total*barWidth+largest[sec.heading.level]-1)/largest[sec.heading.level])
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %7s  %s", name, strings.Repeat(" ", nameWidth-displayWidth(name)), groupDigits(sec.total), bar))
+	}
+	if len(s.sections) == 0 {
+		lines = append(lines, " (no text)")
+	}
+	return lines
+}
+
+// showStructure shows the structure report of the buffer
+func (e *Editor) showStructure() {
+	e.viewLines("Structure: "+bufferName(e.filename), measureStructure(e.lines).report(), func(line string) tcell.Style {
+		if line != "" && !strings.HasPrefix(line, " ") {
+			return e.theme.hunk
+		}
+		return e.theme.text
+	})
+}
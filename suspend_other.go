@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// stopProcess has no shell job control to hand over to on this system
+var stopProcess = func() error {
+	return errors.New("not supported on this system")
+}
+
+// watchSuspendSignals has no signals to watch on this system
+func (e *Editor) watchSuspendSignals() {}
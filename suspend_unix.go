@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// stopProcess stops mkmd until the shell continues it (fg). SIGSTOP cannot be
+// caught, so it returns only once the process runs again.
+var stopProcess = func() error {
+	return syscall.Kill(syscall.Getpid(), syscall.SIGSTOP)
+}
+
+// watchSuspendSignals turns a SIGTSTP sent from outside (kill -TSTP) into a
+// suspend, rather than letting it stop mkmd with the terminal left in raw mode
+func (e *Editor) watchSuspendSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTSTP)
+	go func() {
+		for range signals {
+			e.screen.PostEvent(tcell.NewEventInterrupt(suspendRequest{}))
+		}
+	}()
+}
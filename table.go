@@ -0,0 +1,279 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tableAt returns the first and last lines of the pipe table holding line y: a
+// header row, a delimiter row such as |---|:--:|, and the body rows after it
+func tableAt(lines []string, y int) (start, end int, ok bool) {
+	isRow := func(i int) bool {
+		return strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != ""
+	}
+	if y >= len(lines) || !isRow(y) {
+		return 0, 0, false
+	}
+	start, end = y, y
+	for start > 0 && isRow(start-1) {
+		start--
+	}
+	for end+1 < len(lines) && isRow(end+1) {
+		end++
+	}
+	if start+1 > end || !tableDelimPattern.MatchString(lines[start+1]) {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// tableCells returns the rune ranges of a table row's cells, between its pipes.
+// Pipes escaped as \| do not separate cells, and nothing before a leading pipe
+// or after a trailing one is a cell.
+func tableCells(line string) [][2]int {
+	runes := []rune(line)
+	var cells [][2]int
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, [2]int{start, i})
+			start = i + 1
+		}
+	}
+	cells = append(cells, [2]int{start, len(runes)})
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "|") {
+		cells = cells[1:]
+	}
+	if strings.HasSuffix(trimmed, "|") && !strings.HasSuffix(trimmed, "\\|") && len(cells) > 0 {
+		cells = cells[:len(cells)-1]
+	}
+	return cells
+}
+
+// cellTextStart returns where the cursor goes in a cell: on its first
+// non-blank character, or one space in when the cell is empty
+func cellTextStart(line string, cell [2]int) int {
+	runes := []rune(line)
+	for x := cell[0]; x < cell[1]; x++ {
+		if !unicode.IsSpace(runes[x]) {
+			return x
+		}
+	}
+	return min(cell[0]+1, cell[1])
+}
+
+// tableCellIndex returns the index of the cell holding rune x, -1 when x is
+// before the first cell
+func tableCellIndex(cells [][2]int, x int) int {
+	index := -1
+	for i, cell := range cells {
+		if x >= cell[0] {
+			index = i
+		}
+	}
+	return index
+}
+
+// moveTableCell moves the cursor to the next cell of the table it is in
+// (delta 1) or the previous one (delta -1), passing over the delimiter row.
+// Moving on from the last cell adds an empty row. It reports false when the
+// cursor is not in a table.
+func (e *Editor) moveTableCell(delta int) bool {
+	if e.inFencedBlock(e.cursorY) {
+		return false
+	}
+	start, end, ok := tableAt(e.lines, e.cursorY)
+	if !ok {
+		return false
+	}
+
+	y := e.cursorY
+	cells := tableCells(e.lines[y])
+	index := tableCellIndex(cells, e.cursorX) + delta
+	for index < 0 || index >= len(cells) {
+		y += delta
+		if y == start+1 {
+			y += delta
+		}
+		if y < start {
+			return true
+		}
+		if y > end {
+			e.addTableRow(start, end)
+			end++
+		}
+		cells = tableCells(e.lines[y])
+		index = 0
+		if delta < 0 {
+			index = len(cells) - 1
+		}
+	}
+	e.clearSelection()
+	e.cursorY, e.cursorX = y, cellTextStart(e.lines[y], cells[index])
+	e.ensureCursorVisible()
+	return true
+}
+
+// addTableRow adds an empty row below the table from start to end, shaped like
+// its delimiter row
+func (e *Editor) addTableRow(start, end int) {
+	e.pushUndoState()
+	e.clearSearch()
+	e.invalidateWordCount()
+	row := strings.Map(func(r rune) rune {
+		if r == '|' || unicode.IsSpace(r) {
+			return r
+		}
+		return ' '
+	}, e.lines[start+1])
+	e.lines = append(e.lines[:end+1], append([]string{row}, e.lines[end+1:]...)...)
+	e.modified = true
+}
+
+// tabularData parses pasted text as rows of tab- or comma-separated values, as
+// spreadsheets copy them. It returns nil unless the text has at least two rows
+// with the same number of columns, and at least two columns.
+func tabularData(text string) (rows [][]string, separator rune) {
+	text = strings.TrimRight(text, "\n")
+	lines := strings.Split(text, "\n")
+	if len(lines) < 2 || tableDelimPattern.MatchString(lines[1]) {
+		return nil, 0
+	}
+	for _, separator = range "\t," {
+		r := csv.NewReader(strings.NewReader(text))
+		r.Comma = separator
+		// Tab-separated cells hold quotes freely, as in 5" screen
+		r.LazyQuotes = separator == '\t'
+		if !strings.ContainsRune(lines[0], separator) {
+			continue
+		}
+		rows, err := r.ReadAll()
+		if err == nil && len(rows) >= 2 && len(rows[0]) >= 2 {
+			return rows, separator
+		}
+	}
+	return nil, 0
+}
+
+// markdownTable lays rows out as a pipe table with padded columns, the first
+// row as its header
+func markdownTable(rows [][]string) string {
+	cell := strings.NewReplacer("|", "\\|", "\r\n", " ", "\n", " ")
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i := range row {
+			row[i] = cell.Replace(strings.TrimSpace(row[i]))
+			widths[i] = max(widths[i], displayWidth(row[i]), 3)
+		}
+	}
+	var b strings.Builder
+	writeRow := func(row []string) {
+		b.WriteString("|")
+		for i, text := range row {
+			b.WriteString(" " + text + strings.Repeat(" ", widths[i]-displayWidth(text)) + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(rows[0])
+	delimiter := make([]string, len(widths))
+	for i, w := range widths {
+		delimiter[i] = strings.Repeat("-", w)
+	}
+	writeRow(delimiter)
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return b.String()
+}
+
+// tableNumber reads a table cell as a number, ignoring thousands separators,
+// currency signs and a percent sign
+func tableNumber(text string) (float64, bool) {
+	n, err := strconv.ParseFloat(numberNoise.Replace(text), 64)
+	return n, err == nil
+}
+
+var numberNoise = strings.NewReplacer(",", "", " ", "", "$", "", "€", "", "£", "", "¥", "", "%", "")
+
+// sortTable sorts the body rows of the table at the cursor by a column picked
+// from its header: as numbers when every filled cell in the column is one, and
+// as text ignoring case otherwise. Sorting a column already in ascending order
+// sorts it descending. The header and delimiter rows stay where they are.
+func (e *Editor) sortTable() {
+	start, end, ok := tableAt(e.lines, e.cursorY)
+	if !ok || e.inFencedBlock(e.cursorY) {
+		e.statusMessage = "Not in a table"
+		return
+	}
+	if end-start < 3 {
+		e.statusMessage = "The table has nothing to sort"
+		return
+	}
+
+	header := e.lines[start]
+	cells := tableCells(header)
+	names := make([]string, len(cells))
+	for i, cell := range cells {
+		names[i] = strings.TrimSpace(runeSubstring(header, cell[0], cell[1]))
+		if names[i] == "" {
+			names[i] = fmt.Sprintf("Column %d", i+1)
+		}
+	}
+	column := e.pickFromList("Sort table by", names, nil)
+	if column < 0 {
+		return
+	}
+
+	rows := append([]string(nil), e.lines[start+2:end+1]...)
+	keys := make([]string, len(rows))
+	numbers := make([]float64, len(rows))
+	numeric := true
+	for i, row := range rows {
+		if cells := tableCells(row); column < len(cells) {
+			keys[i] = strings.TrimSpace(runeSubstring(row, cells[column][0], cells[column][1]))
+		}
+		if keys[i] == "" {
+			numbers[i] = math.Inf(-1)
+			continue
+		}
+		n, ok := tableNumber(keys[i])
+		numbers[i], numeric = n, numeric && ok
+	}
+	compare := func(a, b int) int {
+		if numeric {
+			return cmp.Compare(numbers[a], numbers[b])
+		}
+		return strings.Compare(strings.ToLower(keys[a]), strings.ToLower(keys[b]))
+	}
+	direction, order := 1, "ascending"
+	if sort.SliceIsSorted(rows, func(i, j int) bool { return compare(i, j) < 0 }) {
+		direction, order = -1, "descending"
+	}
+	index := make([]int, len(rows))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool { return direction*compare(index[i], index[j]) < 0 })
+
+	e.pushUndoState()
+	e.clearSearch()
+	for i, from := range index {
+		e.lines[start+2+i] = rows[from]
+	}
+	e.modified = true
+	kind := "text"
+	if numeric {
+		kind = "numbers"
+	}
+	e.statusMessage = fmt.Sprintf("Sorted by %s (%s, %s)", names[column], kind, order)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// tagPattern matches a #tag at the start of a line or after a space or opening
+// bracket. Tags may hold letters, digits, _, - and / (for nested tags such as
+// #project/mkmd) but need a letter, so #1 and #42 are not tags.
+var tagPattern = regexp.MustCompile(`(?:^|[\s(\[])#([\p{L}\p{N}_][\p{L}\p{N}_/-]*)`)
+
+// lineTags returns the tags on a line with the rune position of each #,
+// leaving out those in code spans
+func lineTags(line string) (tags []string, xs []int) {
+	if !strings.Contains(line, "#") {
+		return nil, nil
+	}
+	runes := []rune(line)
+	code := make([]bool, len(runes)+1)
+	ctx := punctContext{}
+	for i, r := range runes {
+		ctx.feed(r)
+		code[i] = ctx.code
+	}
+	for _, m := range tagPattern.FindAllStringSubmatchIndex(line, -1) {
+		tag := strings.TrimRight(line[m[2]:m[3]], "/-")
+		x := runeLen(line[:m[2]]) - 1
+		if code[x] || !strings.ContainsFunc(tag, unicode.IsLetter) {
+			continue
+		}
+		tags = append(tags, tag)
+		xs = append(xs, x)
+	}
+	return tags, xs
+}
+
+// tagIndex collects the tags in the markdown files under the working directory
+// and in the active buffer, by tag in lower case. Fenced code and front matter
+// are skipped.
+func (e *Editor) tagIndex() (map[string][]projectHit, error) {
+	files, err := projectFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	// The document being edited counts even when it is elsewhere or unnamed
+	if !containsPath(files, e.filename) {
+		files = append([]string{e.filename}, files...)
+	}
+	index := make(map[string][]projectHit)
+	for _, path := range files {
+		var lines []string
+		if path == "" {
+			lines = e.lines
+		} else if lines, err = e.projectLines(path); err != nil {
+			continue
+		}
+		literal := codeLiteral(lines)
+		for y, line := range lines {
+			if literal[y] {
+				continue
+			}
+			tags, xs := lineTags(line)
+			for i, tag := range tags {
+				key := strings.ToLower(tag)
+				index[key] = append(index[key], projectHit{path, xs[i], y, line})
+			}
+		}
+	}
+	return index, nil
+}
+
+// containsPath reports whether files holds path, comparing absolute paths
+func containsPath(files []string, path string) bool {
+	abs, err := filepath.Abs(path)
+	if path == "" || err != nil {
+		return false
+	}
+	for _, f := range files {
+		if other, err := filepath.Abs(f); err == nil && other == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// tagAtCursor returns the tag under the cursor, or ""
+func (e *Editor) tagAtCursor() string {
+	if e.cursorY >= len(e.lines) {
+		return ""
+	}
+	tags, xs := lineTags(e.lines[e.cursorY])
+	for i, tag := range tags {
+		if e.cursorX >= xs[i] && e.cursorX <= xs[i]+1+runeLen(tag) {
+			return tag
+		}
+	}
+	return ""
+}
+
+// browseTags lists the tags in the project, then the places the chosen one is
+// used, and jumps to the one picked. With the cursor on a tag its places are
+// listed straight away.
+func (e *Editor) browseTags() {
+	index, err := e.tagIndex()
+	if err != nil {
+		e.reportError("Indexing tags", err)
+		return
+	}
+	if len(index) == 0 {
+		e.statusMessage = "No #tags in the markdown files here"
+		return
+	}
+
+	key := strings.ToLower(e.tagAtCursor())
+	if index[key] == nil {
+		keys := make([]string, 0, len(index))
+		for k := range index {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			hits := index[k]
+			items[i] = fmt.Sprintf("#%s  (%d)", tagSpelling(hits[0]), len(hits))
+		}
+		choice, _ := e.pickSearchable(fmt.Sprintf("Tags (%d)", len(keys)), items)
+		if choice < 0 {
+			return
+		}
+		key = keys[choice]
+	}
+
+	hits := index[key]
+	e.pickHit(fmt.Sprintf("#%s", tagSpelling(hits[0])), hits)
+}
+
+// tagSpelling returns the tag as written at hit
+func tagSpelling(hit projectHit) string {
+	tags, xs := lineTags(hit.line)
+	for i, x := range xs {
+		if x == hit.x {
+			return tags[i]
+		}
+	}
+	return ""
+}
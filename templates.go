@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultDateFormats are offered when the config sets no date_format
+var defaultDateFormats = []string{"%Y-%m-%d", "%Y-%m-%d %H:%M", "%A, %B %e, %Y", "%H:%M"}
+
+// strftimeLayouts maps strftime directives to Go time layouts
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "2", 'j': "002",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'A': "Monday", 'a': "Mon", 'B': "January", 'b': "Jan", 'Z': "MST", 'z': "-0700",
+}
+
+// formatDate formats t with strftime-style directives such as %Y-%m-%d. %e is
+// the day without padding. Unknown directives are kept as written.
+func formatDate(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if layout, ok := strftimeLayouts[format[i]]; ok {
+			b.WriteString(t.Format(layout))
+		} else if format[i] == '%' {
+			b.WriteByte('%')
+		} else {
+			b.WriteString(format[i-1 : i+1])
+		}
+	}
+	return b.String()
+}
+
+// dateFormats returns the configured date formats, or the defaults
+func (e *Editor) dateFormats() []string {
+	if len(e.config.dateFormats) > 0 {
+		return e.config.dateFormats
+	}
+	return defaultDateFormats
+}
+
+// insertDate inserts the current date or time at the cursor, letting the user
+// pick the format when there is more than one
+func (e *Editor) insertDate() {
+	now := time.Now()
+	formats := e.dateFormats()
+	items := make([]string, len(formats))
+	for i, format := range formats {
+		items[i] = formatDate(now, format)
+	}
+	choice := 0
+	if len(items) > 1 {
+		if choice = e.pickFromList("Insert date/time", items, nil); choice < 0 {
+			return
+		}
+	}
+	e.insertInline(items[choice])
+}
+
+// docTemplate is a named snippet inserted at the cursor. Built-in templates have
+// their text here; configured ones are read from path when used.
+type docTemplate struct {
+	name, path, text string
+}
+
+var builtinTemplates = []docTemplate{
+	{name: "Daily journal", text: "# {date:%A, %B %e, %Y}\n\n{cursor}\n"},
+	{name: "Meeting notes", text: "# Meeting notes: {date}\n\nAttendees: \n\n## Agenda\n\n- {cursor}\n\n## Notes\n\n## Action items\n\n- [ ] \n"},
+}
+
+// templatePlaceholder matches {date}, {time} and {title}, with an optional
+// strftime format after a colon for the first two
+var templatePlaceholder = regexp.MustCompile(`\{(date|time|title)(?::([^}]*))?\}`)
+
+// expandTemplate fills in a template's placeholders. {cursor} is left in place
+// for the caller.
+func (e *Editor) expandTemplate(text string, now time.Time) string {
+	return templatePlaceholder.ReplaceAllStringFunc(text, func(match string) string {
+		parts := templatePlaceholder.FindStringSubmatch(match)
+		format := parts[2]
+		switch parts[1] {
+		case "date":
+			if format == "" {
+				format = e.dateFormats()[0]
+			}
+		case "time":
+			if format == "" {
+				format = "%H:%M"
+			}
+		case "title":
+			if e.filename == "" {
+				return "Untitled"
+			}
+			return strings.TrimSuffix(filepath.Base(e.filename), filepath.Ext(e.filename))
+		}
+		return formatDate(now, format)
+	})
+}
+
+// templates lists the built-in templates followed by the configured ones by
+// name. A configured template replaces a built-in one of the same name.
+func (e *Editor) templates() []docTemplate {
+	var list []docTemplate
+	for _, t := range builtinTemplates {
+		if _, ok := e.config.templates[t.name]; !ok {
+			list = append(list, t)
+		}
+	}
+	names := make([]string, 0, len(e.config.templates))
+	for name := range e.config.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		list = append(list, docTemplate{name: name, path: e.config.templates[name]})
+	}
+	return list
+}
+
+// templateText returns the text of t, reading it from its file if it has one
+func (t docTemplate) templateText() (string, error) {
+	if t.path == "" {
+		return t.text, nil
+	}
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
+
+// insertTemplate lets the user pick a template, with a preview, and inserts it at
+// the cursor. The cursor goes to the template's {cursor} mark, if it has one.
+func (e *Editor) insertTemplate() {
+	now := time.Now()
+	list := e.templates()
+	names := make([]string, len(list))
+	for i, t := range list {
+		names[i] = t.name
+	}
+	preview := func(i int) []string {
+		text, err := list[i].templateText()
+		if err != nil {
+			return []string{err.Error()}
+		}
+		return strings.Split(strings.ReplaceAll(e.expandTemplate(text, now), "{cursor}", ""), "\n")
+	}
+	choice := e.pickFromList("Insert template", names, preview)
+	if choice < 0 {
+		return
+	}
+	text, err := list[choice].templateText()
+	if err != nil {
+		e.statusMessage = fmt.Sprintf("Failed to read template: %v", err)
+		return
+	}
+
+	before, after, hasCursor := strings.Cut(e.expandTemplate(text, now), "{cursor}")
+	e.clearSelection()
+	startX, startY := e.cursorX, e.cursorY
+	e.insertInline(before + after)
+	if hasCursor {
+		beforeLines := strings.Split(before, "\n")
+		e.cursorY = startY + len(beforeLines) - 1
+		e.cursorX = runeLen(beforeLines[len(beforeLines)-1])
+		if len(beforeLines) == 1 {
+			e.cursorX += startX
+		}
+		e.ensureCursorVisible()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// writingTimer is a timed writing session, pomodoro style
+type writingTimer struct {
+	start          time.Time
+	length         time.Duration
+	added, removed int           // Words written in all buffers when it started
+	focus          bool          // The session turned focus mode on
+	stop           chan struct{} // Closed to end tickTimer
+}
+
+// timerRequest is the payload of the interrupt posted every second while a
+// writing session runs, so the status bar counts down and the session ends on
+// the event loop
+type timerRequest struct{}
+
+// tickTimer asks the event loop to redraw every second until stop is closed
+func tickTimer(screen tcell.Screen, stop chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			screen.PostEvent(tcell.NewEventInterrupt(timerRequest{}))
+		}
+	}
+}
+
+// wordsWritten returns the words added and removed in all open buffers since
+// they were opened
+func (e *Editor) wordsWritten() (added, removed int) {
+	if len(e.buffers) == 0 {
+		return e.wordsAdded, e.wordsRemoved
+	}
+	e.buffers[e.activeBuffer] = e.captureBuffer()
+	for _, b := range e.buffers {
+		added += b.wordsAdded
+		removed += b.wordsRemoved
+	}
+	return added, removed
+}
+
+// timeLeft returns the time left in the writing session as "18:42"
+func (t *writingTimer) timeLeft(now time.Time) string {
+	left := max(0, t.length-now.Sub(t.start)+time.Second-1)
+	return fmt.Sprintf("%d:%02d", int(left.Minutes()), int(left.Seconds())%60)
+}
+
+// toggleTimer starts a writing session, asking how many minutes it lasts, or
+// offers to end the one running
+func (e *Editor) toggleTimer() {
+	if e.timer != nil {
+		choice := e.confirm(fmt.Sprintf("Writing session: %s left.", e.timer.timeLeft(time.Now())), "End it now", "Keep writing")
+		if choice == 0 {
+			e.endTimer(time.Now())
+		}
+		return
+	}
+	answer := strings.TrimSpace(e.promptWithHistory("timer", fmt.Sprintf("Minutes of writing (Enter for %d): ", e.config.timerMinutes)))
+	minutes := e.config.timerMinutes
+	if answer != "" {
+		n, err := strconv.Atoi(answer)
+		if err != nil || n < 1 {
+			e.statusMessage = fmt.Sprintf("A writing session lasts a whole number of minutes, got %q", answer)
+			return
+		}
+		minutes = n
+	}
+	e.startTimer(time.Duration(minutes)*time.Minute, time.Now())
+}
+
+// startTimer starts a writing session of length from now, in focus mode when
+// the timer_focus setting is on
+func (e *Editor) startTimer(length time.Duration, now time.Time) {
+	e.trackWords()
+	t := &writingTimer{start: now, length: length, stop: make(chan struct{})}
+	t.added, t.removed = e.wordsWritten()
+	if e.config.timerFocus && !e.focusMode {
+		t.focus = true
+		e.toggleFocus()
+	}
+	e.timer = t
+	go tickTimer(e.screen, t.stop)
+	e.statusMessage = fmt.Sprintf("Writing for %d minutes (Alt+Shift+Y to end early)", int(length.Minutes()))
+}
+
+// endTimer ends the writing session, leaving the focus mode it turned on, and
+// sums up the words written in it
+func (e *Editor) endTimer(now time.Time) {
+	t := e.timer
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	e.timer = nil
+	if t.focus && e.focusMode {
+		e.toggleFocus()
+	}
+	e.trackWords()
+	added, removed := e.wordsWritten()
+	added, removed = added-t.added, removed-t.removed
+	elapsed := min(now.Sub(t.start), t.length)
+	minutes := max(1, int(elapsed.Round(time.Minute).Minutes()))
+	summary := []string{
+		fmt.Sprintf("%-14s %d minutes", "Time", minutes),
+		fmt.Sprintf("%-14s %s (%s added, %s removed)", "Words", signedWords(added-removed), groupDigits(added), groupDigits(removed)),
+		fmt.Sprintf("%-14s %.1f", "Words a minute", float64(added-removed)/float64(minutes)),
+	}
+	title := "Writing session done"
+	if elapsed < t.length {
+		title = "Writing session ended early"
+	}
+	e.statusMessage = fmt.Sprintf("%s: %s words in %d minutes", title, signedWords(added-removed), minutes)
+	e.screen.Beep()
+	e.messageBox(title, summary)
+}
+
+// checkTimer ends the writing session once its time is up
+func (e *Editor) checkTimer(now time.Time) {
+	if e.timer != nil && now.Sub(e.timer.start) >= e.timer.length {
+		e.endTimer(now)
+	}
+}
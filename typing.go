@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// abbreviationAt finds an abbreviation ending at rune end of line: the whole run
+// of non-space characters before end (so "btw/" works), or else the word before
+// end. It returns where the abbreviation starts and its expansion, matching the
+// capitalisation it was typed in.
+func abbreviationAt(table map[string]string, line string, end int) (start int, expansion string, ok bool) {
+	runes := []rune(line)[:end]
+	tokenStart := end
+	for tokenStart > 0 && !unicode.IsSpace(runes[tokenStart-1]) {
+		tokenStart--
+	}
+	wordStart := end
+	for wordStart > 0 && isWordRune(runes[wordStart-1]) {
+		wordStart--
+	}
+	if token := string(runes[tokenStart:]); strings.Contains(token, "://") || strings.Contains(token, "@") {
+		return 0, "", false
+	}
+
+	for _, start := range []int{tokenStart, wordStart} {
+		if start == end {
+			continue
+		}
+		abbrev := string(runes[start:])
+		if expansion, ok := table[abbrev]; ok {
+			return start, expansion, true
+		}
+		if expansion, ok := table[strings.ToLower(abbrev)]; ok {
+			return start, matchCase(abbrev, expansion), true
+		}
+	}
+	return 0, "", false
+}
+
+// expandAbbreviation replaces an abbreviation that ends at rune end of line y,
+// just before the word boundary that was typed. Code and front matter are left
+// alone, as is the abbreviation after keepNextAbbreviation. The expansion is its
+// own undo step, so one undo brings back exactly what was typed.
+func (e *Editor) expandAbbreviation(y, end int) {
+	if e.keepAbbreviation {
+		e.keepAbbreviation = false
+		return
+	}
+	if len(e.config.abbreviations) == 0 || y >= len(e.lines) || end < 1 || end > runeLen(e.lines[y]) ||
+		e.inFencedBlock(y) || y <= frontMatterEnd(e.lines) {
+		return
+	}
+	line := e.lines[y]
+	start, expansion, ok := abbreviationAt(e.config.abbreviations, line, end)
+	if !ok {
+		return
+	}
+	ctx := punctContext{}
+	for _, r := range []rune(line)[:start] {
+		ctx.feed(r)
+	}
+	if !ctx.plain() {
+		return
+	}
+
+	e.pushUndoState()
+	e.lines[y] = runeInsert(runeDelete(line, start, end), start, expansion)
+	if e.cursorY == y && e.cursorX >= end {
+		e.cursorX += runeLen(expansion) - (end - start)
+	}
+	e.ensureCursorVisible()
+}
+
+// keepNextAbbreviation leaves the abbreviation before the next word boundary
+// typed as it is, for the times "teh" is meant
+func (e *Editor) keepNextAbbreviation() {
+	if !e.abbreviate {
+		return
+	}
+	e.keepAbbreviation = true
+	e.statusMessage = "The next abbreviation stays as typed"
+}
+
+// toggleAbbreviations switches automatic abbreviation expansion on or off
+func (e *Editor) toggleAbbreviations() {
+	e.abbreviate = !e.abbreviate
+	switch {
+	case !e.abbreviate:
+		e.statusMessage = "Abbreviations off"
+	case len(e.config.abbreviations) == 0:
+		e.statusMessage = `Abbreviations on, but none are defined (add "abbrev teh = the" to the config)`
+	default:
+		e.statusMessage = fmt.Sprintf("Abbreviations on (%d defined)", len(e.config.abbreviations))
+	}
+}
+
+// autoPairs maps the characters that are closed automatically to their closers.
+// The emphasis markers are paired by pairEmphasis.
+var autoPairs = map[rune]rune{'(': ')', '[': ']', '"': '"', '`': '`', '*': '*', '_': '_'}
+
+// pairBoundary reports whether a pair may be opened before next: a space, the
+// end of the line or closing punctuation
+func pairBoundary(next rune) bool {
+	return next == 0 || unicode.IsSpace(next) || strings.ContainsRune(")]}\"`*_.,;:!?", next)
+}
+
+// pairChar handles a typed character when auto-pairing is on: typing a closer
+// that is already under the cursor steps over it, and an opener gets its closer
+// after the cursor in the same undo step. It reports whether it handled r.
+func (e *Editor) pairChar(r rune) bool {
+	if e.overtype || e.cursorY >= len(e.lines) {
+		return false
+	}
+	runes := []rune(e.lines[e.cursorY])
+	x := min(e.cursorX, len(runes))
+	var prev, next rune
+	if x > 0 {
+		prev = runes[x-1]
+	}
+	if x < len(runes) {
+		next = runes[x]
+	}
+
+	if r == '*' || r == '_' {
+		return e.pairEmphasis(r, runes, x)
+	}
+	isCloser := r == ')' || r == ']' || r == '"' || r == '`'
+	if isCloser && next == r {
+		e.cursorX = x + 1
+		return true
+	}
+	closer, ok := autoPairs[r]
+	if !ok || (r == '"' && e.smartPunctuation) {
+		return false
+	}
+	// Pair only before a space, the end of the line or a closer, and not a quote
+	// or backtick straight after a word (5" or a third backtick of a fence)
+	if !pairBoundary(next) {
+		return false
+	}
+	if closer == r && (isWordRune(prev) || prev == r) {
+		return false
+	}
+	e.insertChar(r)
+	e.lines[e.cursorY] = runeInsert(e.lines[e.cursorY], e.cursorX, string(closer))
+	return true
+}
+
+// pairEmphasis handles * and _ for pairChar, outside code and front matter. _
+// opens a pair after a space or at the start of a line, and ** does (a single *
+// is more likely a bullet). Typing a marker inside an empty pair grows both
+// sides, so ** becomes *** and _ becomes __, and typing the closer after the
+// emphasised text steps over it.
+func (e *Editor) pairEmphasis(r rune, runes []rune, x int) bool {
+	if e.inFencedBlock(e.cursorY) || e.cursorY <= frontMatterEnd(e.lines) {
+		return false
+	}
+	ctx := punctContext{}
+	for _, c := range runes[:x] {
+		ctx.feed(c)
+	}
+	if !ctx.plain() {
+		return false
+	}
+	at := func(i int) rune {
+		if i < 0 || i >= len(runes) {
+			return 0
+		}
+		return runes[i]
+	}
+	left, right := 0, 0
+	for at(x-1-left) == r {
+		left++
+	}
+	for at(x+right) == r {
+		right++
+	}
+
+	switch {
+	case left > 0 && left == right && !isWordRune(at(x-1-left)):
+		// Inside an empty pair
+		e.insertChar(r)
+		e.lines[e.cursorY] = runeInsert(e.lines[e.cursorY], e.cursorX, string(r))
+	case right > 0:
+		e.cursorX = x + 1
+	case !pairBoundary(at(x)):
+		return false
+	case r == '_' && left == 0 && !isWordRune(at(x-1)):
+		e.insertChar(r)
+		e.lines[e.cursorY] = runeInsert(e.lines[e.cursorY], e.cursorX, "_")
+	case r == '*' && left == 1 && !isWordRune(at(x-2)):
+		e.insertChar(r)
+		e.lines[e.cursorY] = runeInsert(e.lines[e.cursorY], e.cursorX, "**")
+	default:
+		return false
+	}
+	return true
+}
+
+// closeFence adds a closing fence below the cursor line when a backtick has just
+// completed an opening one. The closer is its own undo step, so Ctrl+Z declines it.
+func (e *Editor) closeFence() {
+	y := e.cursorY
+	if y >= len(e.lines) || strings.TrimLeft(e.lines[y], " ") != "```" || e.cursorX != runeLen(e.lines[y]) ||
+		e.inFencedBlock(y) || y <= frontMatterEnd(e.lines) {
+		return
+	}
+	indent := e.lines[y][:len(e.lines[y])-3]
+	e.pushUndoState()
+	e.lines = append(e.lines[:y+1], append([]string{indent + "```"}, e.lines[y+1:]...)...)
+	e.modified = true
+	e.statusMessage = "Closed the code fence (Ctrl+Z to leave it open)"
+}
+
+// wrapSelection puts r and its closer around the selected text, keeping the text
+// selected. It reports false when r is not a pair or nothing suitable is selected.
+func (e *Editor) wrapSelection(r rune) bool {
+	closer, ok := autoPairs[r]
+	if !ok || !e.selectionStart || e.blockSelection {
+		return false
+	}
+	text := e.getSelectedText()
+	if text == "" {
+		return false
+	}
+	startX, startY := e.selectionStartX, e.selectionStartY
+	if e.cursorY < startY || (e.cursorY == startY && e.cursorX < startX) {
+		startX, startY = e.cursorX, e.cursorY
+	}
+	e.insertInline(string(r) + text + string(closer))
+	e.selectionStart = true
+	e.selectionStartX, e.selectionStartY = startX+1, startY
+	e.cursorX--
+	return true
+}
+
+// deleteEmptyPair removes an auto-paired opener and closer together when
+// backspacing between them. It reports whether it did.
+func (e *Editor) deleteEmptyPair() bool {
+	if e.selectionStart || e.cursorY >= len(e.lines) || e.cursorX < 1 {
+		return false
+	}
+	runes := []rune(e.lines[e.cursorY])
+	x := e.cursorX
+	if x >= len(runes) {
+		return false
+	}
+	if closer, ok := autoPairs[runes[x-1]]; !ok || runes[x] != closer {
+		return false
+	}
+	e.pushUndoState()
+	e.clearSearch()
+	e.invalidateWordCount()
+	e.lines[e.cursorY] = runeDelete(e.lines[e.cursorY], x-1, x+1)
+	e.cursorX--
+	e.modified = true
+	e.ensureCursorVisible()
+	return true
+}
+
+// toggleAutoPair switches automatic closing of brackets and quotes on or off
+func (e *Editor) toggleAutoPair() {
+	e.autoPair = !e.autoPair
+	if e.autoPair {
+		e.statusMessage = "Auto-pairing on"
+	} else {
+		e.statusMessage = "Auto-pairing off"
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A workspace is a named set of open files with the cursor and scroll position
+// in each, the buffer that was active, and the view toggles. Each is a file in
+// the workspaces folder of the settings folder, one "kind<TAB>fields" line per
+// entry:
+//
+//	active	2
+//	view	soft_wrap	true
+//	file	/home/me/thesis/intro.md	120	5	100
+//
+// File lines hold the absolute path, then the cursor's line and column and the
+// top line of the window, all from 1 and counted in the whole file.
+
+// workspaceFile is one file of a workspace and where it was being read
+type workspaceFile struct {
+	path      string
+	line, col int
+	top       int
+}
+
+// workspace is a saved set of buffers
+type workspace struct {
+	files  []workspaceFile
+	active int             // Index into files of the active buffer
+	view   map[string]bool // View toggles by config name
+}
+
+// workspacePath returns the file a workspace is kept in, or "" when there is
+// no settings folder
+func workspacePath(name string) string {
+	return settingsFile(filepath.Join("workspaces", name))
+}
+
+// checkWorkspaceName rejects names that could not be a file name of their own
+func checkWorkspaceName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid workspace name %q", name)
+	}
+	return nil
+}
+
+// workspaceNames returns the names of the saved workspaces, most recently
+// saved first
+func workspaceNames() []string {
+	entries, _ := os.ReadDir(filepath.Dir(workspacePath("x")))
+	var names []string
+	saved := make(map[string]time.Time)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+			saved[entry.Name()] = info.ModTime()
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool { return saved[names[i]].After(saved[names[j]]) })
+	return names
+}
+
+// captureWorkspace records the open buffers that have a file. Unnamed buffers
+// are left out and counted in skipped.
+func (e *Editor) captureWorkspace() (ws workspace, skipped int) {
+	buffers := []bufferState{e.captureBuffer()}
+	active := 0
+	if len(e.buffers) > 0 {
+		e.buffers[e.activeBuffer] = e.captureBuffer()
+		buffers, active = e.buffers, e.activeBuffer
+	}
+	for i, b := range buffers {
+		if b.filename == "" {
+			skipped++
+			continue
+		}
+		path, err := filepath.Abs(b.filename)
+		if err != nil {
+			path = b.filename
+		}
+		if i == active {
+			ws.active = len(ws.files)
+		}
+		first := b.currentChunk * e.maxLines
+		ws.files = append(ws.files, workspaceFile{
+			path: path,
+			line: first + b.cursorY + 1,
+			col:  b.cursorX + 1,
+			top:  first + b.offsetY + 1,
+		})
+	}
+	ws.view = map[string]bool{
+		"soft_wrap":  e.softWrap,
+		"focus_mode": e.focusMode,
+		"minimap":    e.minimap,
+	}
+	return ws, skipped
+}
+
+// writeWorkspace saves ws under name, replacing any workspace of that name
+func writeWorkspace(name string, ws workspace) error {
+	path := workspacePath(name)
+	if path == "" {
+		return errors.New("no settings folder to save to")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	lines := []string{"active\t" + strconv.Itoa(ws.active+1)}
+	keys := make([]string, 0, len(ws.view))
+	for key := range ws.view {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("view\t%s\t%t", key, ws.view[key]))
+	}
+	for _, f := range ws.files {
+		lines = append(lines, fmt.Sprintf("file\t%s\t%d\t%d\t%d", f.path, f.line, f.col, f.top))
+	}
+	return writeLines(path, append(lines, ""))
+}
+
+// readWorkspace loads the workspace saved under name
+func readWorkspace(name string) (workspace, error) {
+	ws := workspace{view: make(map[string]bool)}
+	if err := checkWorkspaceName(name); err != nil {
+		return ws, err
+	}
+	data, err := os.ReadFile(workspacePath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ws, fmt.Errorf("no workspace named %q", name)
+	}
+	if err != nil {
+		return ws, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, "\t")
+		switch {
+		case fields[0] == "active" && len(fields) == 2:
+			n, _ := strconv.Atoi(fields[1])
+			ws.active = max(0, n-1)
+		case fields[0] == "view" && len(fields) == 3:
+			ws.view[fields[1]] = fields[2] == "true"
+		case fields[0] == "file" && len(fields) == 5:
+			f := workspaceFile{path: fields[1]}
+			f.line, _ = strconv.Atoi(fields[2])
+			f.col, _ = strconv.Atoi(fields[3])
+			f.top, _ = strconv.Atoi(fields[4])
+			ws.files = append(ws.files, f)
+		}
+	}
+	if len(ws.files) == 0 {
+		return ws, fmt.Errorf("workspace %q has no files", name)
+	}
+	ws.active = min(ws.active, len(ws.files)-1)
+	return ws, nil
+}
+
+// openWorkspace replaces the open buffers with the files of ws, each at its
+// saved position, and applies its view toggles. Files that no longer exist are
+// left out; it fails, changing nothing, when none of them do.
+func (e *Editor) openWorkspace(ws workspace) (missing int, err error) {
+	var files []workspaceFile
+	active := 0
+	for i, f := range ws.files {
+		if _, err := os.Stat(f.path); err != nil {
+			missing++
+			continue
+		}
+		if i <= ws.active {
+			active = len(files)
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return missing, errors.New("none of its files exist any more")
+	}
+
+	e.stopFollowing()
+	e.logAllBuffers(time.Now())
+	e.releaseLocks()
+	e.buffers = nil
+	e.activeBuffer = 0
+	// The buffer that was open stays until the first file has taken its place
+	e.restoreBuffer(bufferState{lines: []string{""}, tabWidth: e.config.tabWidth, useTabs: e.config.useTabs})
+	for _, f := range files {
+		if err := e.openBuffer(f.path); err != nil {
+			e.reportError("Opening "+filepath.Base(f.path), err)
+			continue
+		}
+		e.claimFile()
+		e.openAt(f.line, f.col)
+		first := e.currentChunk * e.maxLines
+		e.offsetY = max(0, min(f.top-1-first, e.cursorY))
+		e.ensureCursorVisible()
+	}
+	if len(e.buffers) < 2 {
+		e.buffers = nil
+		return missing, errors.New("none of its files could be opened")
+	}
+	e.buffers = e.buffers[1:]
+	e.activeBuffer--
+	if len(e.buffers) == 1 {
+		e.buffers = nil
+	} else {
+		e.switchBuffer(min(active, len(e.buffers)-1))
+	}
+
+	e.softWrap = ws.view["soft_wrap"]
+	e.focusMode = ws.view["focus_mode"]
+	e.minimap = ws.view["minimap"]
+	e.layoutText()
+	e.ensureCursorVisible()
+	return missing, nil
+}
+
+// saveWorkspace records the open buffers under name, asking before replacing
+// a workspace of that name
+func (e *Editor) saveWorkspace(name string) {
+	if err := checkWorkspaceName(name); err != nil {
+		e.statusMessage = err.Error()
+		return
+	}
+	if _, err := os.Stat(workspacePath(name)); err == nil {
+		if e.confirm(fmt.Sprintf("Workspace %q exists.", name), "Replace", "Cancel") != 0 {
+			return
+		}
+	}
+	ws, skipped := e.captureWorkspace()
+	if len(ws.files) == 0 {
+		e.statusMessage = "No saved files to keep in a workspace"
+		return
+	}
+	if err := writeWorkspace(name, ws); err != nil {
+		e.reportError("Saving workspace "+name, err)
+		return
+	}
+	e.statusMessage = fmt.Sprintf("Saved workspace %q (%d files)", name, len(ws.files))
+	if skipped > 0 {
+		e.statusMessage += fmt.Sprintf("; %d unnamed buffers left out", skipped)
+	}
+}
+
+// restoreWorkspace offers to save modified buffers, then opens the workspace
+// saved under name in their place
+func (e *Editor) restoreWorkspace(name string) {
+	ws, err := readWorkspace(name)
+	if err != nil {
+		e.reportError("Opening workspace", err)
+		return
+	}
+	if err := e.offerToSave(); err != nil {
+		e.reportError("Save", err)
+		return
+	}
+	missing, err := e.openWorkspace(ws)
+	if err != nil {
+		e.reportError("Opening workspace "+name, err)
+		return
+	}
+	e.statusMessage = fmt.Sprintf("Opened workspace %q (%d files)", name, e.bufferCount())
+	if missing > 0 {
+		e.statusMessage += fmt.Sprintf("; %d files no longer exist", missing)
+	}
+}
+
+// pickWorkspace lists the saved workspaces to open one, with a first entry to
+// save the open buffers as a new one. Typing a name that matches nothing and
+// pressing Enter saves under that name.
+func (e *Editor) pickWorkspace() {
+	names := workspaceNames()
+	items := append([]string{"Save the open buffers as a workspace..."}, names...)
+	choice, query := e.pickSearchable("Workspaces", items)
+	switch {
+	case choice == 0:
+		if name := strings.TrimSpace(e.promptWithHistory("workspace", "Workspace name: ")); name != "" {
+			e.saveWorkspace(name)
+		}
+	case choice > 0:
+		e.restoreWorkspace(names[choice-1])
+	case strings.TrimSpace(query) != "":
+		e.saveWorkspace(strings.TrimSpace(query))
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// wrapRow is one screen row of a soft-wrapped line: runes [start, end) drawn
+// after indent blank columns
+type wrapRow struct {
+	start, end int
+	indent     int
+}
+
+// Leading whitespace plus a list or block quote marker
+var wrapIndentPattern = regexp.MustCompile(`^[ \t]*(?:(?:[-*+]|\d+[.)])[ \t]+(?:\[[ xX]\][ \t]+)?|(?:>[ \t]?)+)?`)
+
+// continuationIndent is how far rows after the first are indented, so wrapped list
+// items and quotes stay aligned with their text. Very deep indents are dropped so
+// rows keep room for text.
+func (e *Editor) continuationIndent(line string) int {
+	prefix := wrapIndentPattern.FindString(line)
+	width := e.displayColumn(prefix, runeLen(prefix))
+	if width > e.width/2 {
+		return 0
+	}
+	return width
+}
+
+// wrapRows splits line into screen rows, breaking after the last space that fits.
+// A word longer than the row is broken at the edge. Spaces at a break stay at the
+// end of the row they follow, even past the edge, so no row starts with one.
+func (e *Editor) wrapRows(line string) []wrapRow {
+	runes := []rune(line)
+	indent := e.continuationIndent(line)
+	rows := []wrapRow{}
+	start := 0
+	for {
+		rowIndent := 0
+		if len(rows) > 0 {
+			rowIndent = indent
+		}
+		col, end, lastBreak := rowIndent, start, -1
+		for end < len(runes) {
+			w := e.runeCells(runes, end, col)
+			if col+w > e.width && end > start {
+				break
+			}
+			col += w
+			end++
+			if runes[end-1] == ' ' || runes[end-1] == '\t' {
+				lastBreak = end
+			}
+		}
+		if end < len(runes) {
+			if runes[end] == ' ' {
+				for end < len(runes) && runes[end] == ' ' {
+					end++
+				}
+			} else if lastBreak > start {
+				end = lastBreak
+			}
+		}
+		rows = append(rows, wrapRow{start: start, end: end, indent: rowIndent})
+		if end >= len(runes) {
+			return rows
+		}
+		start = end
+	}
+}
+
+// wrapLocate returns the row holding rune x and the screen column it starts at.
+// A position at the end of a row belongs to the next row, except on the last.
+func (e *Editor) wrapLocate(line string, rows []wrapRow, x int) (row, col int) {
+	row = len(rows) - 1
+	for i, r := range rows {
+		if x < r.end {
+			row = i
+			break
+		}
+	}
+	runes := []rune(line)
+	if r := rows[row]; x < r.end && hasRTL(runes[r.start:r.end]) {
+		cols, _ := e.bidiLayout(runes, r.start, r.end, r.indent)
+		return row, cols[x-r.start]
+	}
+	col = rows[row].indent
+	for i := rows[row].start; i < x && i < len(runes); i++ {
+		col += e.runeCells(runes, i, col)
+	}
+	return row, col
+}
+
+// wrapColumnToRune returns the rune in row drawn at screen column target, or the
+// row's end when target is past it
+func (e *Editor) wrapColumnToRune(line string, rows []wrapRow, row, target int) int {
+	runes := []rune(line)
+	r := rows[row]
+	col, x := r.indent, r.start
+	if hasRTL(runes[r.start:r.end]) {
+		x = e.bidiRuneAt(runes, r.start, r.end, r.indent, target)
+	} else {
+		for i := r.start; i < r.end; i++ {
+			col += e.runeCells(runes, i, col)
+			if col > target {
+				break
+			}
+			x = i + 1
+		}
+	}
+	// The end of a row is the start of the next one
+	if row < len(rows)-1 && x >= r.end {
+		x = r.end - 1
+	}
+	return x
+}
+
+// wrapTopSkip returns how many rows of the top line are scrolled off screen. This
+// only happens when the cursor's own line is taller than the screen.
+func (e *Editor) wrapTopSkip() int {
+	if e.cursorY != e.offsetY || e.cursorY >= len(e.lines) {
+		return 0
+	}
+	line := e.lines[e.cursorY]
+	row, _ := e.wrapLocate(line, e.wrapRows(line), e.cursorX)
+	if skip := row - (e.height - 2); skip > 0 {
+		return skip
+	}
+	return 0
+}
+
+// ensureWrappedCursorVisible scrolls so every row from the top line down to the
+// cursor's row, plus the scroll-off rows below it, fits on screen
+func (e *Editor) ensureWrappedCursorVisible() {
+	e.offsetX = 0
+	if e.cursorY >= len(e.lines) {
+		return
+	}
+	margin := e.scrollMargin()
+	if top := e.linesBack(e.cursorY, margin); top < e.offsetY {
+		e.offsetY = top
+		return
+	}
+	line := e.lines[e.cursorY]
+	lineRows := e.wrapRows(line)
+	row, _ := e.wrapLocate(line, lineRows, e.cursorX)
+
+	// Rows below the cursor that must stay visible, up to the end of the document
+	below := len(lineRows) - 1 - row
+	for y := e.cursorY + 1; below < margin && y < len(e.lines); y++ {
+		below += len(e.shownRows(y))
+	}
+	rows := row + 1 + min(below, margin)
+	for y := e.cursorY - 1; y >= e.offsetY; y-- {
+		n := len(e.shownRows(y))
+		if rows+n > e.height-1 {
+			e.offsetY = y + 1
+			return
+		}
+		rows += n
+	}
+}
+
+// shownRows returns the screen rows of line y, none when a fold hides it
+func (e *Editor) shownRows(y int) []wrapRow {
+	if lineHidden(e.foldedLines(), y) {
+		return nil
+	}
+	return e.wrapRows(e.lines[y])
+}
+
+// moveVisualRow moves the cursor up or down one screen row, keeping its column
+func (e *Editor) moveVisualRow(delta int) {
+	if e.cursorY >= len(e.lines) {
+		return
+	}
+	y := e.cursorY
+	rows := e.wrapRows(e.lines[y])
+	row, col := e.wrapLocate(e.lines[y], rows, e.cursorX)
+	row += delta
+	if row < 0 || row >= len(rows) {
+		if y = e.nextShownLine(y, delta); y < 0 {
+			return
+		}
+		rows = e.wrapRows(e.lines[y])
+		row = 0
+		if delta < 0 {
+			row = len(rows) - 1
+		}
+	}
+	e.cursorY = y
+	e.cursorX = e.wrapColumnToRune(e.lines[y], rows, row, col)
+}
+
+// wrapScreenToBuffer maps a screen cell to the buffer position shown there
+func (e *Editor) wrapScreenToBuffer(sx, sy int) (x, y int, ok bool) {
+	screenRow := -e.wrapTopSkip()
+	for y = e.offsetY; y < len(e.lines); y++ {
+		rows := e.shownRows(y)
+		if sy < screenRow+len(rows) {
+			return e.wrapColumnToRune(e.lines[y], rows, sy-screenRow, sx), y, true
+		}
+		screenRow += len(rows)
+	}
+	return 0, 0, false
+}
+
+// inSelection reports whether the rune at (x, y) is selected
+func (e *Editor) inSelection(x, y int) bool {
+	if !e.selectionStart {
+		return false
+	}
+	if e.blockSelection {
+		startX, endX, startY, endY := e.blockBounds()
+		return y >= startY && y <= endY && x >= startX && x < endX
+	}
+	startX, startY := e.selectionStartX, e.selectionStartY
+	endX, endY := e.cursorX, e.cursorY
+	if startY > endY || (startY == endY && startX > endX) {
+		startX, endX = endX, startX
+		startY, endY = endY, startY
+	}
+	if y < startY || y > endY {
+		return false
+	}
+	return (y > startY || x >= startX) && (y < endY || x < endX)
+}
+
+// searchMatchMask marks the runes of line that are part of a search match, or
+// returns nil when there is no search
+func (e *Editor) searchMatchMask(line string) []bool {
+	searchLen := runeLen(e.searchTerm)
+	if searchLen == 0 {
+		return nil
+	}
+	lowerLine := strings.ToLower(line)
+	lowerSearch := strings.ToLower(e.searchTerm)
+	mask := make([]bool, runeLen(line))
+	for i := 0; i+searchLen <= len(mask); i++ {
+		start := runeIndexToByteIndex(line, i)
+		if start < len(lowerLine) && strings.HasPrefix(lowerLine[start:], lowerSearch) {
+			for j := i; j < i+searchLen; j++ {
+				mask[j] = true
+			}
+			i += searchLen - 1
+		}
+	}
+	return mask
+}
+
+// drawWrapped draws the visible lines soft-wrapped at the window edge, with the
+// same highlights as the unwrapped view, and places the cursor
+func (e *Editor) drawWrapped() {
+	e.screen.HideCursor()
+
+	var brackets [][2]int
+	if bx, by, ok := e.bracketAtCursor(); ok {
+		if mx, my, found := e.findMatchingBracket(bx, by, e.height); found {
+			brackets = [][2]int{{bx, by}, {mx, my}}
+		}
+	}
+
+	screenRow := -e.wrapTopSkip()
+	hidden := e.foldedLines()
+	for y := e.offsetY; y < len(e.lines) && screenRow < e.height-1; y++ {
+		if lineHidden(hidden, y) {
+			continue
+		}
+		line := e.lines[y]
+		runes := []rune(line)
+		rows := e.wrapRows(line)
+		matches := e.searchMatchMask(line)
+
+		cursorRow, cursorCol := -1, 0
+		if y == e.cursorY {
+			cursorRow, cursorCol = e.wrapLocate(line, rows, e.cursorX)
+		}
+
+		for i, row := range rows {
+			if screenRow >= 0 && screenRow < e.height-1 {
+				cols, glyphs := e.bidiLayout(runes, row.start, row.end, row.indent)
+				for x := row.start; x < row.end; x++ {
+					col := cols[x-row.start]
+					if col >= e.width {
+						continue
+					}
+					style := e.theme.text
+					switch {
+					case e.inSelection(x, y):
+						style = e.theme.selection
+					case len(brackets) > 0 && (brackets[0] == [2]int{x, y} || brackets[1] == [2]int{x, y}):
+						style = e.theme.bracket
+					case matches != nil && matches[x]:
+						style = e.theme.search
+					}
+					e.drawRune(col, screenRow, col, glyphs, x, style)
+				}
+				if i == len(rows)-1 {
+					col := row.indent
+					if n := row.end - row.start; n > 0 {
+						col = cols[n-1] + e.runeCells(runes, row.end-1, cols[n-1])
+					}
+					e.drawFoldPlaceholder(y, col, screenRow)
+				}
+				if i == cursorRow {
+					e.screen.ShowCursor(min(cursorCol, e.width-1), screenRow)
+				}
+			}
+			screenRow++
+		}
+	}
+}
+
+// toggleSoftWrap switches between wrapping long lines and scrolling sideways
+func (e *Editor) toggleSoftWrap() {
+	e.softWrap = !e.softWrap
+	if e.softWrap {
+		e.statusMessage = "Soft wrap on"
+	} else {
+		e.statusMessage = "Soft wrap off"
+	}
+	e.ensureCursorVisible()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// writingPause is the longest gap between edits counted as time spent writing;
+// a longer one is a break
+const writingPause = 5 * time.Minute
+
+// logEntry is a line of the writing log: the writing done in a file since the
+// last entry for it
+type logEntry struct {
+	when           time.Time
+	path           string
+	added, removed int
+	spent          time.Duration
+}
+
+// String returns the entry as a line of the log, tab-separated with the path
+// last: "2026-10-16 14:32	430	18	1500	/home/me/novel.md"
+func (l logEntry) String() string {
+	return fmt.Sprintf("%s\t%d\t%d\t%d\t%s", l.when.Format("2006-01-02 15:04"), l.added, l.removed, int(l.spent.Seconds()), l.path)
+}
+
+// parseLogEntry reads a line of the writing log, reporting false for a line it
+// cannot make sense of
+func parseLogEntry(line string) (logEntry, bool) {
+	fields := strings.SplitN(line, "\t", 5)
+	if len(fields) != 5 {
+		return logEntry{}, false
+	}
+	when, err := time.ParseInLocation("2006-01-02 15:04", fields[0], time.Local)
+	if err != nil {
+		return logEntry{}, false
+	}
+	var numbers [3]int
+	for i := range numbers {
+		if numbers[i], err = strconv.Atoi(fields[i+1]); err != nil {
+			return logEntry{}, false
+		}
+	}
+	return logEntry{when: when, added: numbers[0], removed: numbers[1], spent: time.Duration(numbers[2]) * time.Second, path: fields[4]}, true
+}
+
+// writingLogPath is the log of writing sessions, shared by all documents
+func writingLogPath() string {
+	return settingsFile("writing-log")
+}
+
+// logWriting appends the writing done in the active buffer since its last
+// entry to the writing log. Nothing is logged when no words were written, or
+// when the writing_log setting is off.
+func (e *Editor) logWriting(now time.Time) error {
+	if !e.config.writingLog {
+		return nil
+	}
+	e.trackWords()
+	entry := logEntry{
+		when:    now,
+		path:    "(untitled)",
+		added:   e.wordsAdded - e.logged.added,
+		removed: e.wordsRemoved - e.logged.removed,
+		spent:   e.writingTime - e.logged.spent,
+	}
+	if entry.added == 0 && entry.removed == 0 {
+		return nil
+	}
+	if e.filename != "" {
+		entry.path, _ = filepath.Abs(e.filename)
+	}
+	if err := appendLine(writingLogPath(), entry.String()); err != nil {
+		return err
+	}
+	e.logged = logEntry{added: e.wordsAdded, removed: e.wordsRemoved, spent: e.writingTime}
+	return nil
+}
+
+// logAllBuffers logs the writing done in every open buffer, before they close
+func (e *Editor) logAllBuffers(now time.Time) error {
+	return e.forEachBuffer(func() error { return e.logWriting(now) })
+}
+
+// formatSpent formats time spent writing as "1h 12m" or "25m"
+func formatSpent(d time.Duration) string {
+	minutes := int(d.Round(time.Minute).Minutes())
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
+
+// writingHistory returns the lines of the writing log view: the totals of the
+// last 7 and 30 days and the streak of days with words written up to now, then
+// each day's writing by file, the latest day first
+func writingHistory(entries []logEntry, now time.Time) []string {
+	type total struct {
+		added, removed int
+		spent          time.Duration
+	}
+	add := func(t *total, l logEntry) {
+		t.added += l.added
+		t.removed += l.removed
+		t.spent += l.spent
+	}
+	days := map[string]*total{}
+	files := map[string]map[string]*total{}
+	var week, month total
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, l := range entries {
+		day := l.when.Format("2006-01-02")
+		if days[day] == nil {
+			days[day] = &total{}
+			files[day] = map[string]*total{}
+		}
+		if files[day][l.path] == nil {
+			files[day][l.path] = &total{}
+		}
+		add(days[day], l)
+		add(files[day][l.path], l)
+		if !l.when.Before(today.AddDate(0, 0, -6)) {
+			add(&week, l)
+		}
+		if !l.when.Before(today.AddDate(0, 0, -29)) {
+			add(&month, l)
+		}
+	}
+
+	// The streak still counts when nothing has been written yet today
+	streak := 0
+	day := today
+	if days[day.Format("2006-01-02")] == nil {
+		day = day.AddDate(0, 0, -1)
+	}
+	for t := days[day.Format("2006-01-02")]; t != nil && t.added > 0; t = days[day.Format("2006-01-02")] {
+		streak++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	words := func(t total) string {
+		return fmt.Sprintf("%7s words  %7s", signedWords(t.added-t.removed), formatSpent(t.spent))
+	}
+	plural := "s"
+	if streak == 1 {
+		plural = ""
+	}
+	lines := []string{
+		"Recent writing",
+		fmt.Sprintf(" %-14s %s", "Last 7 days", words(week)),
+		fmt.Sprintf(" %-14s %s", "Last 30 days", words(month)),
+		fmt.Sprintf(" %-14s %d day%s", "Streak", streak, plural),
+	}
+	keys := make([]string, 0, len(days))
+	for key := range days {
+		keys = append(keys, key)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	const shownDays = 30
+	for _, key := range keys[:min(len(keys), shownDays)] {
+		date, _ := time.Parse("2006-01-02", key)
+		lines = append(lines, "", fmt.Sprintf("%-15s %s", date.Format("Mon 2 Jan 2006"), words(*days[key])))
+		paths := make([]string, 0, len(files[key]))
+		for path := range files[key] {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			t := files[key][path]
+			lines = append(lines, fmt.Sprintf("   %-12s %s  (%s added, %s removed)", filepath.Base(path), words(*t), groupDigits(t.added), groupDigits(t.removed)))
+		}
+	}
+	if len(keys) > shownDays {
+		lines = append(lines, "", fmt.Sprintf(" … and %d earlier days in %s", len(keys)-shownDays, writingLogPath()))
+	}
+	if len(keys) == 0 {
+		lines = append(lines, "", " Nothing logged yet: writing is logged when you save and when you quit")
+	}
+	return lines
+}
+
+// showWritingLog shows the recent history of the writing log
+func (e *Editor) showWritingLog() {
+	data, err := os.ReadFile(writingLogPath())
+	if err != nil && !os.IsNotExist(err) {
+		e.reportError("Reading the writing log", err)
+		return
+	}
+	var entries []logEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry, ok := parseLogEntry(line); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if !e.config.writingLog && len(entries) == 0 {
+		e.statusMessage = "The writing log is off (writing_log in the config file)"
+		return
+	}
+	e.viewLines("Writing log", writingHistory(entries, time.Now()), func(line string) tcell.Style {
+		if line != "" && !strings.HasPrefix(line, " ") {
+			return e.theme.hunk
+		}
+		return e.theme.text
+	})
+}